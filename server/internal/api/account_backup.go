@@ -1,13 +1,21 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rpbox/server/internal/database"
 	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
 	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
 )
 
 // listAccountBackups 获取用户所有账号备份
@@ -16,7 +24,7 @@ func (s *Server) listAccountBackups(c *gin.Context) {
 
 	var backups []model.AccountBackup
 	database.DB.Where("user_id = ?", userID).
-		Select("id, user_id, account_id, profiles_count, tools_count, runtime_size_kb, checksum, version, created_at, updated_at").
+		Select("id, user_id, account_id, profiles_count, tools_count, runtime_size_kb, checksum, version, encrypted, key_id, cipher, created_at, updated_at").
 		Find(&backups)
 
 	// 调试日志
@@ -41,31 +49,68 @@ func (s *Server) getAccountBackup(c *gin.Context) {
 	c.JSON(http.StatusOK, backup)
 }
 
+// accountBackupPayload 客户端上传的账号备份内容
+type accountBackupPayload struct {
+	AccountID     string `json:"account_id" binding:"required"`
+	ProfilesData  string `json:"profiles_data"`
+	ProfilesCount int    `json:"profiles_count"`
+	ToolsData     string `json:"tools_data"`
+	ToolsCount    int    `json:"tools_count"`
+	RuntimeData   string `json:"runtime_data"`
+	RuntimeSizeKB int    `json:"runtime_size_kb"`
+	ConfigData    string `json:"config_data"`
+	ExtraData     string `json:"extra_data"`
+	RawTrp3Lua    string `json:"raw_trp3_lua"`
+	RawTrp3Data   string `json:"raw_trp3_data_lua"`
+	RawTrp3Ext    string `json:"raw_trp3_extended_lua"`
+	Checksum      string `json:"checksum" binding:"required"`
+
+	Encryption *accountBackupEnvelope `json:"encryption"` // 加密模式的信封，为空时为明文备份
+}
+
+// applyTo 把上传内容写入备份记录（不修改版本号）
+func (p *accountBackupPayload) applyTo(backup *model.AccountBackup) {
+	backup.ProfilesData = model.CompressedText(p.ProfilesData)
+	backup.ProfilesCount = p.ProfilesCount
+	backup.ToolsData = model.CompressedText(p.ToolsData)
+	backup.ToolsCount = p.ToolsCount
+	backup.RuntimeData = model.CompressedText(p.RuntimeData)
+	backup.RuntimeSizeKB = p.RuntimeSizeKB
+	backup.ConfigData = model.CompressedText(p.ConfigData)
+	backup.ExtraData = model.CompressedText(p.ExtraData)
+	backup.RawTrp3Lua = model.CompressedText(p.RawTrp3Lua)
+	backup.RawTrp3Data = model.CompressedText(p.RawTrp3Data)
+	backup.RawTrp3Ext = model.CompressedText(p.RawTrp3Ext)
+	backup.Checksum = p.Checksum
+	p.applyEncryption(backup)
+}
+
+// accountBackupUpsertRequest 创建或更新账号备份的请求（直接上传或分片上传拼接后）
+type accountBackupUpsertRequest struct {
+	accountBackupPayload
+	BaseVersion  int    `json:"base_version"`  // 客户端同步时基于的版本，为 0 时不检查
+	BaseChecksum string `json:"base_checksum"` // 客户端同步时基于的校验和，为空时不检查
+}
+
 // upsertAccountBackup 创建或更新账号备份
 func (s *Server) upsertAccountBackup(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
-	var req struct {
-		AccountID     string `json:"account_id" binding:"required"`
-		ProfilesData  string `json:"profiles_data" binding:"required"`
-		ProfilesCount int    `json:"profiles_count"`
-		ToolsData     string `json:"tools_data"`
-		ToolsCount    int    `json:"tools_count"`
-		RuntimeData   string `json:"runtime_data"`
-		RuntimeSizeKB int    `json:"runtime_size_kb"`
-		ConfigData    string `json:"config_data"`
-		ExtraData     string `json:"extra_data"`
-		RawTrp3Lua    string `json:"raw_trp3_lua"`
-		RawTrp3Data   string `json:"raw_trp3_data_lua"`
-		RawTrp3Ext    string `json:"raw_trp3_extended_lua"`
-		Checksum      string `json:"checksum" binding:"required"`
-	}
-
+	var req accountBackupUpsertRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
 		return
 	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	s.saveAccountBackup(c, userID, &req)
+}
+
+// saveAccountBackup 写入账号备份：不存在时创建，内容变化时保存历史版本后更新
+func (s *Server) saveAccountBackup(c *gin.Context, userID uint, req *accountBackupUpsertRequest) {
 	// 调试日志：打印接收到的数据长度
 	log.Printf("[AccountBackup] upsert - account=%s, profiles=%d, tools_data_len=%d, tools_count=%d, runtime_data_len=%d, runtime_kb=%d, raw_trp3_len=%d, raw_data_len=%d, raw_ext_len=%d",
 		req.AccountID, req.ProfilesCount, len(req.ToolsData), req.ToolsCount, len(req.RuntimeData), req.RuntimeSizeKB, len(req.RawTrp3Lua), len(req.RawTrp3Data), len(req.RawTrp3Ext))
@@ -78,22 +123,11 @@ func (s *Server) upsertAccountBackup(c *gin.Context) {
 	if err != nil {
 		// 创建新备份
 		backup := model.AccountBackup{
-			UserID:        userID,
-			AccountID:     req.AccountID,
-			ProfilesData:  req.ProfilesData,
-			ProfilesCount: req.ProfilesCount,
-			ToolsData:     req.ToolsData,
-			ToolsCount:    req.ToolsCount,
-			RuntimeData:   req.RuntimeData,
-			RuntimeSizeKB: req.RuntimeSizeKB,
-			ConfigData:    req.ConfigData,
-			ExtraData:     req.ExtraData,
-			RawTrp3Lua:    req.RawTrp3Lua,
-			RawTrp3Data:   req.RawTrp3Data,
-			RawTrp3Ext:    req.RawTrp3Ext,
-			Checksum:      checksum,
-			Version:       1,
+			UserID:    userID,
+			AccountID: req.AccountID,
+			Version:   1,
 		}
+		req.applyTo(&backup)
 		if err := database.DB.Create(&backup).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
 			return
@@ -108,38 +142,24 @@ func (s *Server) upsertAccountBackup(c *gin.Context) {
 		return
 	}
 
+	if isStaleBase(req.BaseVersion, req.BaseChecksum, existing.Version, existing.Checksum) {
+		respondAccountBackupConflict(c, &existing, req.BaseVersion, req.BaseChecksum, checksum)
+		return
+	}
+
 	// 保存版本历史
-	version := model.AccountBackupVersion{
-		BackupID:     existing.ID,
-		Version:      existing.Version,
-		ProfilesData: existing.ProfilesData,
-		ToolsData:    existing.ToolsData,
-		RuntimeData:  existing.RuntimeData,
-		ConfigData:   existing.ConfigData,
-		ExtraData:    existing.ExtraData,
-		RawTrp3Lua:   existing.RawTrp3Lua,
-		RawTrp3Data:  existing.RawTrp3Data,
-		RawTrp3Ext:   existing.RawTrp3Ext,
-		Checksum:     existing.Checksum,
-	}
-	database.DB.Create(&version)
-	cleanOldBackupVersions(existing.ID)
+	version := snapshotAccountBackup(&existing)
 
 	// 更新备份
-	existing.ProfilesData = req.ProfilesData
-	existing.ProfilesCount = req.ProfilesCount
-	existing.ToolsData = req.ToolsData
-	existing.ToolsCount = req.ToolsCount
-	existing.RuntimeData = req.RuntimeData
-	existing.RuntimeSizeKB = req.RuntimeSizeKB
-	existing.ConfigData = req.ConfigData
-	existing.ExtraData = req.ExtraData
-	existing.RawTrp3Lua = req.RawTrp3Lua
-	existing.RawTrp3Data = req.RawTrp3Data
-	existing.RawTrp3Ext = req.RawTrp3Ext
-	existing.Checksum = checksum
+	baseVersion := existing.Version
+	req.applyTo(&existing)
 	existing.Version++
-	if err := database.DB.Save(&existing).Error; err != nil {
+	if err := s.saveAccountBackupRevision(&existing, baseVersion, &version); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.First(&existing, existing.ID)
+			respondAccountBackupConflict(c, &existing, baseVersion, req.BaseChecksum, checksum)
+			return
+		}
 		log.Printf("[AccountBackup] Save error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 		return
@@ -148,17 +168,204 @@ func (s *Server) upsertAccountBackup(c *gin.Context) {
 	c.JSON(http.StatusOK, existing)
 }
 
+// resolveAccountBackupConflict 解决多设备同步冲突：保留本地、保留服务器或写入合并结果，落选的一方存为历史版本
+func (s *Server) resolveAccountBackupConflict(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	accountID := c.Param("account_id")
+
+	var req struct {
+		accountBackupPayload
+		Strategy string `json:"strategy" binding:"required,oneof=mine theirs merged"`
+		Version  int    `json:"version" binding:"required"` // 解决冲突时看到的服务器版本
+	}
+	// 账号 ID 以路径为准
+	req.AccountID = accountID
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing model.AccountBackup
+	if err := database.DB.Where("user_id = ? AND account_id = ?", userID, accountID).First(&existing).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	if existing.Version != req.Version {
+		respondAccountBackupConflict(c, &existing, req.Version, "", req.Checksum)
+		return
+	}
+
+	// 落选的一方占用当前版本号存入历史，服务器版本号前进一位
+	archived := snapshotAccountBackup(&existing)
+	switch req.Strategy {
+	case conflictKeepTheirs:
+		var local model.AccountBackup
+		req.applyTo(&local)
+		archived = snapshotAccountBackup(&local)
+		archived.BackupID = existing.ID
+		archived.Version = existing.Version
+		archived.ChangeLog = "冲突解决：未采用的本地版本"
+	case conflictKeepMine, conflictMerged:
+		if req.Strategy == conflictMerged {
+			archived.ChangeLog = "冲突解决：合并前的服务器版本"
+		} else {
+			archived.ChangeLog = "冲突解决：被本地版本覆盖"
+		}
+		req.applyTo(&existing)
+	}
+	existing.Version++
+
+	if err := s.saveAccountBackupRevision(&existing, req.Version, &archived); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.First(&existing, existing.ID)
+			respondAccountBackupConflict(c, &existing, req.Version, "", req.Checksum)
+			return
+		}
+		log.Printf("[AccountBackup] resolve error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解决冲突失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"backup": existing,
+		"archived": gin.H{
+			"version":    archived.Version,
+			"checksum":   archived.Checksum,
+			"change_log": archived.ChangeLog,
+			"created_at": archived.CreatedAt,
+		},
+	})
+}
+
+// snapshotAccountBackup 以备份当前内容生成历史版本
+func snapshotAccountBackup(backup *model.AccountBackup) model.AccountBackupVersion {
+	return model.AccountBackupVersion{
+		BackupID:     backup.ID,
+		Version:      backup.Version,
+		ProfilesData: backup.ProfilesData,
+		ToolsData:    backup.ToolsData,
+		RuntimeData:  backup.RuntimeData,
+		ConfigData:   backup.ConfigData,
+		ExtraData:    backup.ExtraData,
+		RawTrp3Lua:   backup.RawTrp3Lua,
+		RawTrp3Data:  backup.RawTrp3Data,
+		RawTrp3Ext:   backup.RawTrp3Ext,
+		Checksum:     backup.Checksum,
+
+		ProfilesCount: backup.ProfilesCount,
+		ToolsCount:    backup.ToolsCount,
+		RuntimeSizeKB: backup.RuntimeSizeKB,
+
+		Encrypted:  backup.Encrypted,
+		KeyID:      backup.KeyID,
+		Cipher:     backup.Cipher,
+		Nonce:      backup.Nonce,
+		Ciphertext: backup.Ciphertext,
+	}
+}
+
+// saveAccountBackupRevision 在同一事务中写入历史版本并按版本号条件更新备份，版本号不匹配时返回 errSyncConflict
+func (s *Server) saveAccountBackupRevision(backup *model.AccountBackup, baseVersion int, archived *model.AccountBackupVersion) error {
+	backup.UpdatedAt = time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.AccountBackup{}).
+			Where("id = ? AND version = ?", backup.ID, baseVersion).
+			Updates(map[string]interface{}{
+				"profiles_data":   backup.ProfilesData,
+				"profiles_count":  backup.ProfilesCount,
+				"tools_data":      backup.ToolsData,
+				"tools_count":     backup.ToolsCount,
+				"runtime_data":    backup.RuntimeData,
+				"runtime_size_kb": backup.RuntimeSizeKB,
+				"config_data":     backup.ConfigData,
+				"extra_data":      backup.ExtraData,
+				"raw_trp3_lua":    backup.RawTrp3Lua,
+				"raw_trp3_data":   backup.RawTrp3Data,
+				"raw_trp3_ext":    backup.RawTrp3Ext,
+				"checksum":        backup.Checksum,
+				"version":         backup.Version,
+				"updated_at":      backup.UpdatedAt,
+				"encrypted":       backup.Encrypted,
+				"key_id":          backup.KeyID,
+				"cipher":          backup.Cipher,
+				"nonce":           backup.Nonce,
+				"ciphertext":      backup.Ciphertext,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSyncConflict
+		}
+		return service.CreateBackupVersion(tx, archived)
+	})
+	if err != nil {
+		return err
+	}
+	if err := service.ApplyBackupRetention(backup.ID, s.backupRetentionPolicy()); err != nil {
+		log.Printf("[AccountBackup] retention error: backup=%d err=%v", backup.ID, err)
+	}
+	return nil
+}
+
+// respondAccountBackupConflict 返回 409，附带服务器当前版本与客户端基准版本的元数据
+func respondAccountBackupConflict(c *gin.Context, backup *model.AccountBackup, baseVersion int, baseChecksum, checksum string) {
+	server := syncRevisionMeta(backup.Version, backup.Checksum, backup.UpdatedAt)
+	server["profiles_count"] = backup.ProfilesCount
+	server["tools_count"] = backup.ToolsCount
+
+	base := conflictBaseMeta(baseVersion, baseChecksum, checksum)
+	var baseRevision model.AccountBackupVersion
+	if baseVersion > 0 && database.DB.Select("id, version, checksum, created_at").
+		Where("backup_id = ? AND version = ?", backup.ID, baseVersion).First(&baseRevision).Error == nil {
+		base["created_at"] = baseRevision.CreatedAt
+		if baseChecksum == "" {
+			base["base_checksum"] = baseRevision.Checksum
+		}
+	}
+
+	c.JSON(http.StatusConflict, gin.H{
+		"error": "账号备份已在其他设备更新，请先解决冲突",
+		"conflict": gin.H{
+			"server": server,
+			"client": base,
+		},
+	})
+}
+
 // deleteAccountBackup 删除账号备份
 func (s *Server) deleteAccountBackup(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	accountID := c.Param("account_id")
 
-	result := database.DB.Where("user_id = ? AND account_id = ?", userID, accountID).Delete(&model.AccountBackup{})
-	if result.RowsAffected == 0 {
+	var backup model.AccountBackup
+	if err := database.DB.Select("id").Where("user_id = ? AND account_id = ?", userID, accountID).First(&backup).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
 		return
 	}
 
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		hashes, err := service.LoadBackupVersionHashes(tx, []uint{backup.ID})
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("backup_id = ?", backup.ID).Delete(&model.AccountBackupVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&backup).Error; err != nil {
+			return err
+		}
+		return service.DeleteUnreferencedBackupBlobs(tx, hashes)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
@@ -167,6 +374,11 @@ func (s *Server) getAccountBackupVersions(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	accountID := c.Param("account_id")
 
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
 	var backup model.AccountBackup
 	if err := database.DB.Where("user_id = ? AND account_id = ?", userID, accountID).First(&backup).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
@@ -174,18 +386,192 @@ func (s *Server) getAccountBackupVersions(c *gin.Context) {
 	}
 
 	var versions []model.AccountBackupVersion
-	database.DB.Where("backup_id = ?", backup.ID).Order("version DESC").Limit(10).Find(&versions)
+	database.DB.Where("backup_id = ?", backup.ID).Order("version DESC").Limit(limit).Find(&versions)
+	if err := service.LoadBackupVersionData(database.DB, versions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取版本数据失败"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"versions": versions})
 }
 
-// cleanOldBackupVersions 清理旧版本，保留最近10个
-func cleanOldBackupVersions(backupID uint) {
-	var count int64
-	database.DB.Model(&model.AccountBackupVersion{}).Where("backup_id = ?", backupID).Count(&count)
-	if count > 10 {
-		var oldest model.AccountBackupVersion
-		database.DB.Where("backup_id = ?", backupID).Order("version ASC").First(&oldest)
-		database.DB.Delete(&oldest)
+// restoreAccountBackupVersion 把历史版本恢复为当前备份，恢复前的内容存为新的历史版本
+func (s *Server) restoreAccountBackupVersion(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	accountID := c.Param("account_id")
+
+	var existing model.AccountBackup
+	if err := database.DB.Where("user_id = ? AND account_id = ?", userID, accountID).First(&existing).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	version, ok := loadAccountBackupVersion(c, existing.ID)
+	if !ok {
+		return
+	}
+
+	archived := snapshotAccountBackup(&existing)
+	archived.ChangeLog = fmt.Sprintf("恢复版本 %d 前的备份", version.Version)
+
+	baseVersion := existing.Version
+	existing.ProfilesData = version.ProfilesData
+	existing.ToolsData = version.ToolsData
+	existing.RuntimeData = version.RuntimeData
+	existing.ConfigData = version.ConfigData
+	existing.ExtraData = version.ExtraData
+	existing.RawTrp3Lua = version.RawTrp3Lua
+	existing.RawTrp3Data = version.RawTrp3Data
+	existing.RawTrp3Ext = version.RawTrp3Ext
+	existing.Encrypted = version.Encrypted
+	existing.KeyID = version.KeyID
+	existing.Cipher = version.Cipher
+	existing.Nonce = version.Nonce
+	existing.Ciphertext = version.Ciphertext
+	if version.Encrypted {
+		// 密文无法统计，沿用版本生成时客户端上报的数字
+		existing.ProfilesCount, existing.ToolsCount, existing.RuntimeSizeKB = version.ProfilesCount, version.ToolsCount, version.RuntimeSizeKB
+	} else {
+		existing.ProfilesCount, existing.ToolsCount, existing.RuntimeSizeKB = service.BackupStats(accountBackupVersionContent(version))
+	}
+	existing.Checksum = version.Checksum
+	existing.Version++
+
+	if err := s.saveAccountBackupRevision(&existing, baseVersion, &archived); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.First(&existing, existing.ID)
+			respondAccountBackupConflict(c, &existing, baseVersion, "", version.Checksum)
+			return
+		}
+		log.Printf("[AccountBackup] restore error: backup=%d version=%d err=%v", existing.ID, version.Version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"backup":        existing,
+		"restored_from": version.Version,
+		"archived": gin.H{
+			"version":    archived.Version,
+			"checksum":   archived.Checksum,
+			"change_log": archived.ChangeLog,
+			"created_at": archived.CreatedAt,
+		},
+	})
+}
+
+// downloadAccountBackup 以 zip 下载当前备份重建的 SavedVariables 文件
+func (s *Server) downloadAccountBackup(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	accountID := c.Param("account_id")
+
+	var backup model.AccountBackup
+	if err := database.DB.Where("user_id = ? AND account_id = ?", userID, accountID).First(&backup).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	if rejectEncryptedBackup(c, backup.Encrypted) {
+		return
+	}
+
+	content := service.BackupContent{
+		ProfilesData: string(backup.ProfilesData),
+		ToolsData:    string(backup.ToolsData),
+		RuntimeData:  string(backup.RuntimeData),
+		ConfigData:   string(backup.ConfigData),
+		ExtraData:    string(backup.ExtraData),
+		RawTrp3Lua:   string(backup.RawTrp3Lua),
+		RawTrp3Data:  string(backup.RawTrp3Data),
+		RawTrp3Ext:   string(backup.RawTrp3Ext),
+	}
+	sendSavedVariablesZip(c, content, backup.AccountID, backup.Version)
+}
+
+// downloadAccountBackupVersion 以 zip 下载历史版本重建的 SavedVariables 文件
+func (s *Server) downloadAccountBackupVersion(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	accountID := c.Param("account_id")
+
+	var backup model.AccountBackup
+	if err := database.DB.Select("id, account_id").Where("user_id = ? AND account_id = ?", userID, accountID).First(&backup).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "备份不存在"})
+		return
+	}
+	version, ok := loadAccountBackupVersion(c, backup.ID)
+	if !ok {
+		return
+	}
+	if rejectEncryptedBackup(c, version.Encrypted) {
+		return
+	}
+
+	sendSavedVariablesZip(c, accountBackupVersionContent(version), backup.AccountID, version.Version)
+}
+
+// loadAccountBackupVersion 按路径中的版本号读取历史版本并还原去重存储的数据
+func loadAccountBackupVersion(c *gin.Context, backupID uint) (*model.AccountBackupVersion, bool) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return nil, false
+	}
+
+	versions := make([]model.AccountBackupVersion, 1)
+	if err := database.DB.Where("backup_id = ? AND version = ?", backupID, number).First(&versions[0]).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return nil, false
+	}
+	if err := service.LoadBackupVersionData(database.DB, versions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取版本数据失败"})
+		return nil, false
+	}
+	return &versions[0], true
+}
+
+func accountBackupVersionContent(version *model.AccountBackupVersion) service.BackupContent {
+	return service.BackupContent{
+		ProfilesData: string(version.ProfilesData),
+		ToolsData:    string(version.ToolsData),
+		RuntimeData:  string(version.RuntimeData),
+		ConfigData:   string(version.ConfigData),
+		ExtraData:    string(version.ExtraData),
+		RawTrp3Lua:   string(version.RawTrp3Lua),
+		RawTrp3Data:  string(version.RawTrp3Data),
+		RawTrp3Ext:   string(version.RawTrp3Ext),
+	}
+}
+
+// sendSavedVariablesZip 重建 SavedVariables 文件并作为 zip 附件返回，解压后可直接放入 WTF 目录
+func sendSavedVariablesZip(c *gin.Context, content service.BackupContent, accountID string, version int) {
+	files, err := service.BuildSavedVariables(content)
+	if err != nil {
+		log.Printf("[AccountBackup] build saved variables error: account=%s version=%d err=%v", accountID, version, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "备份数据无法还原为 SavedVariables 文件"})
+		return
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "备份中没有可下载的数据"})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := service.WriteSavedVariablesZip(&buf, files); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成压缩包失败"})
+		return
+	}
+
+	filename := fmt.Sprintf("trp3-%s-v%d.zip", accountID, version)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// backupRetentionPolicy 账号备份历史版本的保留策略
+func (s *Server) backupRetentionPolicy() service.BackupRetentionPolicy {
+	retention := s.cfg.AccountBackup.Retention
+	return service.BackupRetentionPolicy{
+		KeepLast: retention.KeepLast,
+		Hourly:   retention.Hourly,
+		Daily:    retention.Daily,
+		Weekly:   retention.Weekly,
+		Monthly:  retention.Monthly,
 	}
 }
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/model"
+)
+
+// backupCiphers 加密备份支持的算法（服务器不解密，只用于客户端识别）
+var backupCiphers = map[string]struct{}{
+	"aes-256-gcm":        {},
+	"xchacha20-poly1305": {},
+}
+
+// accountBackupEnvelope 加密备份的信封：客户端用自己的密钥加密整份备份，服务器只保存密文
+type accountBackupEnvelope struct {
+	KeyID      string `json:"key_id"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"` // base64
+	SHA256     string `json:"sha256"`     // 密文（解码后字节）的 SHA-256
+}
+
+// validate 检查信封字段并校验密文完整性
+func (e *accountBackupEnvelope) validate() error {
+	e.Cipher = strings.ToLower(strings.TrimSpace(e.Cipher))
+	e.SHA256 = strings.ToLower(strings.TrimSpace(e.SHA256))
+	if strings.TrimSpace(e.KeyID) == "" || len(e.KeyID) > 128 {
+		return errors.New("密钥标识无效")
+	}
+	if _, ok := backupCiphers[e.Cipher]; !ok {
+		return errors.New("不支持的加密算法")
+	}
+	if strings.TrimSpace(e.Nonce) == "" || len(e.Nonce) > 128 {
+		return errors.New("加密随机数无效")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(e.Ciphertext)
+	if err != nil || len(ciphertext) == 0 {
+		return errors.New("密文格式错误")
+	}
+	sum := sha256.Sum256(ciphertext)
+	if hex.EncodeToString(sum[:]) != e.SHA256 {
+		return errors.New("密文校验失败")
+	}
+	return nil
+}
+
+// validate 检查上传内容：明文模式必须包含人物卡数据，加密模式不能夹带明文
+func (p *accountBackupPayload) validate() error {
+	if p.Encryption == nil {
+		if p.ProfilesData == "" {
+			return errors.New("profiles_data不能为空")
+		}
+		return nil
+	}
+	if p.ProfilesData != "" || p.ToolsData != "" || p.RuntimeData != "" || p.ConfigData != "" ||
+		p.ExtraData != "" || p.RawTrp3Lua != "" || p.RawTrp3Data != "" || p.RawTrp3Ext != "" {
+		return errors.New("加密备份不能同时上传明文数据")
+	}
+	return p.Encryption.validate()
+}
+
+// applyEncryption 写入（或清除）备份的加密信封
+func (p *accountBackupPayload) applyEncryption(backup *model.AccountBackup) {
+	if p.Encryption == nil {
+		backup.Encrypted = false
+		backup.KeyID = ""
+		backup.Cipher = ""
+		backup.Nonce = ""
+		backup.Ciphertext = ""
+		return
+	}
+	backup.Encrypted = true
+	backup.KeyID = p.Encryption.KeyID
+	backup.Cipher = p.Encryption.Cipher
+	backup.Nonce = p.Encryption.Nonce
+	backup.Ciphertext = model.CompressedText(p.Encryption.Ciphertext)
+}
+
+// rejectEncryptedBackup 加密备份无法执行需要明文的操作（解析、重建文件、比较差异），返回 422
+func rejectEncryptedBackup(c *gin.Context, encrypted bool) bool {
+	if !encrypted {
+		return false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":     "加密备份只能在客户端解密后处理",
+		"encrypted": true,
+	})
+	return true
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestAccountBackupConflictAndResolve(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.AccountBackup{}, &model.AccountBackupVersion{}, &model.BackupBlob{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	upload := func(data, checksum string, baseVersion int) int {
+		resp := performRequest(server.router, http.MethodPost, "/api/v1/account-backups", map[string]interface{}{
+			"account_id":    "ACCOUNT",
+			"profiles_data": data,
+			"checksum":      checksum,
+			"base_version":  baseVersion,
+		}, token)
+		return resp.Code
+	}
+
+	if code := upload(`{"a":1}`, "v1", 0); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if code := upload(`{"a":2}`, "pc-a", 1); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := upload(`{"a":3}`, "pc-b", 1); code != http.StatusConflict {
+		t.Fatalf("expected stale upload to 409, got %d", code)
+	}
+	// 内容与服务器一致时不视为冲突
+	if code := upload(`{"a":2}`, "pc-a", 1); code != http.StatusOK {
+		t.Fatalf("expected identical upload to succeed, got %d", code)
+	}
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/account-backups/ACCOUNT/resolve", map[string]interface{}{
+		"strategy":      "mine",
+		"version":       2,
+		"profiles_data": `{"a":3}`,
+		"checksum":      "pc-b",
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var backup model.AccountBackup
+	db.First(&backup, "account_id = ?", "ACCOUNT")
+	if backup.Version != 3 || backup.Checksum != "pc-b" || backup.ProfilesData != `{"a":3}` {
+		t.Fatalf("expected local side to win, got %+v", backup)
+	}
+	var archived model.AccountBackupVersion
+	if err := db.Where("backup_id = ? AND version = ?", backup.ID, 2).First(&archived).Error; err != nil {
+		t.Fatalf("load archived version: %v", err)
+	}
+	if archived.Checksum != "pc-a" || archived.ChangeLog != "冲突解决：被本地版本覆盖" {
+		t.Fatalf("expected overwritten server side archived, got %+v", archived)
+	}
+}
+
+func performRawRequest(router http.Handler, method, path string, body []byte, headers map[string]string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestAccountBackupCompressedBodyIsStoredCompressed(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.AccountBackup{}, &model.AccountBackupVersion{}, &model.BackupBlob{},
+		&model.Profile{}, &model.ProfileVersion{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	tools := strings.Repeat(`["item"] = { ["name"] = "Sword" },`, 2000)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"account_id":            "ACCOUNT",
+		"profiles_data":         `{"a":1}`,
+		"raw_trp3_extended_lua": tools,
+		"checksum":              "v1",
+	})
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(payload)
+	_ = w.Close()
+
+	resp := performRawRequest(server.router, http.MethodPost, "/api/v1/account-backups", gz.Bytes(), map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+	}, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var stored string
+	db.Raw("SELECT raw_trp3_ext FROM account_backups WHERE account_id = ?", "ACCOUNT").Scan(&stored)
+	if !strings.HasPrefix(stored, "zstd+base64:") || len(stored) >= len(tools) {
+		t.Fatalf("expected field to be compressed at rest, got %d bytes", len(stored))
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/account-backups/ACCOUNT", nil, token)
+	var backup model.AccountBackup
+	if err := json.Unmarshal(resp.Body.Bytes(), &backup); err != nil {
+		t.Fatalf("decode backup: %v", err)
+	}
+	if string(backup.RawTrp3Ext) != tools {
+		t.Fatalf("expected transparent decompression, got %d bytes", len(backup.RawTrp3Ext))
+	}
+
+	resp = performRawRequest(server.router, http.MethodPost, "/api/v1/account-backups", payload, map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "br",
+	}, token)
+	if resp.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected unsupported encoding to be rejected, got %d", resp.Code)
+	}
+
+	// 其他接口不解压请求体
+	gz.Reset()
+	w = gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(`{"id":"profile-1","raw_lua":"{}","checksum":"v1"}`))
+	_ = w.Close()
+	resp = performRawRequest(server.router, http.MethodPost, "/api/v1/profiles", gz.Bytes(), map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+	}, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected compressed body on other routes to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestAccountBackupChunkedUploadResumes(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.AccountBackup{}, &model.AccountBackupVersion{}, &model.BackupBlob{},
+		&model.AccountBackupUpload{}, &model.AccountBackupUploadChunk{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	// 随机数据的十六进制压缩后约为原来的一半，保证压缩后的请求体跨越两个分片
+	random := make([]byte, backupUploadChunkSize+backupUploadChunkSize/4)
+	rand.New(rand.NewSource(1)).Read(random)
+	runtime := hex.EncodeToString(random)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"account_id":    "ACCOUNT",
+		"profiles_data": `{"a":1}`,
+		"runtime_data":  runtime,
+		"checksum":      "v1",
+	})
+	enc, _ := zstd.NewWriter(nil)
+	body := enc.EncodeAll(payload, nil)
+	encoding := "zstd"
+	sum := sha256.Sum256(body)
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/account-backup-uploads", map[string]interface{}{
+		"account_id":       "ACCOUNT",
+		"total_size":       len(body),
+		"content_encoding": encoding,
+		"sha256":           hex.EncodeToString(sum[:]),
+	}, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var session struct {
+		Upload struct {
+			ID          string `json:"upload_id"`
+			TotalChunks int    `json:"total_chunks"`
+		} `json:"upload"`
+		Missing []int `json:"missing"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode session: %v", err)
+	}
+	if session.Upload.TotalChunks != 2 || len(session.Missing) != 2 {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	base := "/api/v1/account-backup-uploads/" + session.Upload.ID
+	chunk := func(index int) []byte {
+		start := index * backupUploadChunkSize
+		end := start + backupUploadChunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+		return body[start:end]
+	}
+
+	resp = performRawRequest(server.router, http.MethodPut, base+"/chunks/1", chunk(1), nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodPost, base+"/complete", nil, token)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected incomplete upload to be rejected, got %d", resp.Code)
+	}
+
+	// 续传：查询缺失分片后补传
+	resp = performRequest(server.router, http.MethodGet, base, nil, token)
+	if err := json.Unmarshal(resp.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode session: %v", err)
+	}
+	if len(session.Missing) != 1 || session.Missing[0] != 0 {
+		t.Fatalf("expected chunk 0 missing, got %v", session.Missing)
+	}
+	resp = performRawRequest(server.router, http.MethodPut, base+"/chunks/0", chunk(0)[:10], nil, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected short chunk to be rejected, got %d", resp.Code)
+	}
+	resp = performRawRequest(server.router, http.MethodPut, base+"/chunks/0", chunk(0), nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	resp = performRequest(server.router, http.MethodPost, base+"/complete", nil, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var backup model.AccountBackup
+	db.First(&backup, "account_id = ?", "ACCOUNT")
+	if string(backup.RuntimeData) != runtime {
+		t.Fatalf("expected assembled runtime data, got %d bytes", len(backup.RuntimeData))
+	}
+	var remaining int64
+	db.Model(&model.AccountBackupUploadChunk{}).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("expected chunks to be cleaned up, got %d", remaining)
+	}
+}
+
+func TestAccountBackupRestoreAndDownloadVersion(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.AccountBackup{}, &model.AccountBackupVersion{}, &model.BackupBlob{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	for i, data := range []string{`{"p1":{"profileName":"Old"}}`, `{"p1":{},"p2":{}}`} {
+		resp := performRequest(server.router, http.MethodPost, "/api/v1/account-backups", map[string]interface{}{
+			"account_id":    "ACCOUNT",
+			"profiles_data": data,
+			"tools_data":    `{"t1":{}}`,
+			"checksum":      []string{"v1", "v2"}[i],
+			"base_version":  i,
+		}, token)
+		if resp.Code >= 300 {
+			t.Fatalf("upload %d: %d %s", i, resp.Code, resp.Body.String())
+		}
+	}
+
+	resp := performRequest(server.router, http.MethodGet, "/api/v1/account-backups/ACCOUNT/versions/1/download", nil, token)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected zip download, got %d %s", resp.Code, resp.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	names := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "totalRP3.lua,totalRP3_Extended.lua" {
+		t.Fatalf("unexpected zip entries %v", names)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/account-backups/ACCOUNT/versions/1/restore", nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var backup model.AccountBackup
+	db.First(&backup, "account_id = ?", "ACCOUNT")
+	if backup.Version != 3 || backup.Checksum != "v1" || backup.ProfilesData != `{"p1":{"profileName":"Old"}}` {
+		t.Fatalf("expected version 1 restored, got %+v", backup)
+	}
+	if backup.ProfilesCount != 1 || backup.ToolsCount != 1 {
+		t.Fatalf("expected counts recomputed, got profiles=%d tools=%d", backup.ProfilesCount, backup.ToolsCount)
+	}
+	var archived model.AccountBackupVersion
+	if err := db.Where("backup_id = ? AND version = ?", backup.ID, 2).First(&archived).Error; err != nil {
+		t.Fatalf("expected pre-restore state archived: %v", err)
+	}
+	if archived.Checksum != "v2" {
+		t.Fatalf("unexpected archived checksum %q", archived.Checksum)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/account-backups/ACCOUNT/versions/9/restore", nil, token)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing version, got %d", resp.Code)
+	}
+}
+
+func TestAccountBackupEncryptedMode(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.AccountBackup{}, &model.AccountBackupVersion{}, &model.BackupBlob{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	envelope := func(ciphertext string) map[string]interface{} {
+		sum := sha256.Sum256([]byte(ciphertext))
+		return map[string]interface{}{
+			"key_id":     "device-key-1",
+			"cipher":     "aes-256-gcm",
+			"nonce":      "bm9uY2U=",
+			"ciphertext": base64.StdEncoding.EncodeToString([]byte(ciphertext)),
+			"sha256":     hex.EncodeToString(sum[:]),
+		}
+	}
+	upload := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		body["account_id"] = "ACCOUNT"
+		return performRequest(server.router, http.MethodPost, "/api/v1/account-backups", body, token)
+	}
+
+	bad := envelope("sealed-1")
+	bad["sha256"] = strings.Repeat("0", 64)
+	if resp := upload(map[string]interface{}{"checksum": "c1", "encryption": bad}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected checksum mismatch to 400, got %d", resp.Code)
+	}
+	if resp := upload(map[string]interface{}{"checksum": "c1", "profiles_data": `{"a":1}`, "encryption": envelope("sealed-1")}); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected mixed plaintext to 400, got %d", resp.Code)
+	}
+
+	if resp := upload(map[string]interface{}{"checksum": "c1", "profiles_count": 3, "encryption": envelope("sealed-1")}); resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	if resp := upload(map[string]interface{}{"checksum": "c2", "base_version": 1, "encryption": envelope("sealed-2")}); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var backup model.AccountBackup
+	db.First(&backup, "account_id = ?", "ACCOUNT")
+	if !backup.Encrypted || backup.KeyID != "device-key-1" || backup.ProfilesData != "" ||
+		string(backup.Ciphertext) != base64.StdEncoding.EncodeToString([]byte("sealed-2")) {
+		t.Fatalf("unexpected stored backup %+v", backup)
+	}
+
+	resp := performRequest(server.router, http.MethodGet, "/api/v1/account-backups/ACCOUNT/download", nil, token)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected download of encrypted backup to 422, got %d", resp.Code)
+	}
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/account-backups/ACCOUNT/versions/1/download", nil, token)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected download of encrypted version to 422, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/account-backups/ACCOUNT/versions/1/restore", nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected restore to 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	db.First(&backup, backup.ID)
+	if backup.Checksum != "c1" || backup.ProfilesCount != 3 ||
+		string(backup.Ciphertext) != base64.StdEncoding.EncodeToString([]byte("sealed-1")) {
+		t.Fatalf("expected encrypted version 1 restored, got %+v", backup)
+	}
+}
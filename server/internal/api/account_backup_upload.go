@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/rpbox/server/internal/config"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/codec"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+const (
+	// backupUploadThreshold 请求体超过该大小时客户端应改用分片上传
+	backupUploadThreshold = 16 << 20
+	// backupUploadChunkSize 分片大小（最后一片可以更小）
+	backupUploadChunkSize = 4 << 20
+	// backupUploadTTL 上传会话在最后一次活动后的保留时间
+	backupUploadTTL = 24 * time.Hour
+)
+
+// createAccountBackupUpload 创建分片上传会话
+func (s *Server) createAccountBackupUpload(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		AccountID       string `json:"account_id" binding:"required"`
+		TotalSize       int64  `json:"total_size" binding:"required,gt=0"`
+		ContentEncoding string `json:"content_encoding"`
+		SHA256          string `json:"sha256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	encoding := codec.NormalizeEncoding(req.ContentEncoding)
+	if !codec.Supported(encoding) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的压缩格式"})
+		return
+	}
+	if req.TotalSize > maxDecompressedBodyBytes(s.cfg) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "备份数据过大"})
+		return
+	}
+
+	cleanExpiredBackupUploads()
+
+	id, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+		return
+	}
+	upload := model.AccountBackupUpload{
+		ID:              id,
+		UserID:          userID,
+		AccountID:       req.AccountID,
+		TotalSize:       req.TotalSize,
+		ChunkSize:       backupUploadChunkSize,
+		TotalChunks:     int((req.TotalSize + backupUploadChunkSize - 1) / backupUploadChunkSize),
+		ContentEncoding: encoding,
+		SHA256:          strings.ToLower(strings.TrimSpace(req.SHA256)),
+		ExpiresAt:       time.Now().Add(backupUploadTTL),
+	}
+	if err := database.DB.Create(&upload).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传会话失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, backupUploadStatus(&upload, nil))
+}
+
+// getAccountBackupUpload 查询上传进度，客户端据此续传缺失的分片
+func (s *Server) getAccountBackupUpload(c *gin.Context) {
+	upload, ok := loadAccountBackupUpload(c)
+	if !ok {
+		return
+	}
+
+	var received []int
+	database.DB.Model(&model.AccountBackupUploadChunk{}).
+		Where("upload_id = ?", upload.ID).
+		Order("chunk_index ASC").
+		Pluck("chunk_index", &received)
+
+	c.JSON(http.StatusOK, backupUploadStatus(upload, received))
+}
+
+// putAccountBackupUploadChunk 上传单个分片（请求体为分片原始字节，重复上传同一分片会覆盖）
+func (s *Server) putAccountBackupUploadChunk(c *gin.Context) {
+	upload, ok := loadAccountBackupUpload(c)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= upload.TotalChunks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分片序号"})
+		return
+	}
+	expected := upload.ChunkSize
+	if index == upload.TotalChunks-1 {
+		expected = upload.TotalSize - upload.ChunkSize*int64(upload.TotalChunks-1)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, expected+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取分片失败"})
+		return
+	}
+	if int64(len(data)) != expected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片大小不正确"})
+		return
+	}
+
+	chunk := model.AccountBackupUploadChunk{
+		UploadID:   upload.ID,
+		ChunkIndex: index,
+		Data:       data,
+		Size:       len(data),
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id = ? AND chunk_index = ?", upload.ID, index).
+			Delete(&model.AccountBackupUploadChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&chunk).Error; err != nil {
+			return err
+		}
+		return tx.Model(upload).Update("expires_at", time.Now().Add(backupUploadTTL)).Error
+	})
+	if err != nil {
+		log.Printf("[AccountBackup] save chunk error: upload=%s index=%d err=%v", upload.ID, index, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存分片失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"chunk_index": index, "size": len(data)})
+}
+
+// completeAccountBackupUpload 拼接全部分片并按普通上传写入账号备份
+func (s *Server) completeAccountBackupUpload(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	upload, ok := loadAccountBackupUpload(c)
+	if !ok {
+		return
+	}
+
+	var chunks []model.AccountBackupUploadChunk
+	database.DB.Where("upload_id = ?", upload.ID).Order("chunk_index ASC").Find(&chunks)
+	if len(chunks) != upload.TotalChunks {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "分片尚未上传完整",
+			"received": len(chunks),
+			"total":    upload.TotalChunks,
+		})
+		return
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0, upload.TotalSize))
+	for _, chunk := range chunks {
+		body.Write(chunk.Data)
+	}
+	if upload.SHA256 != "" {
+		sum := sha256.Sum256(body.Bytes())
+		if hex.EncodeToString(sum[:]) != upload.SHA256 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "上传数据校验失败"})
+			return
+		}
+	}
+
+	req, err := decodeBackupUploadBody(upload.ContentEncoding, body, maxDecompressedBodyBytes(s.cfg))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if req.AccountID != upload.AccountID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "账号与上传会话不一致"})
+		return
+	}
+
+	s.saveAccountBackup(c, userID, req)
+
+	// 写入成功或出现需要客户端处理的冲突时，上传会话不再需要
+	if c.Writer.Status() < http.StatusInternalServerError {
+		deleteAccountBackupUpload(upload.ID)
+	}
+}
+
+// deleteAccountBackupUploadHandler 放弃分片上传
+func (s *Server) deleteAccountBackupUploadHandler(c *gin.Context) {
+	upload, ok := loadAccountBackupUpload(c)
+	if !ok {
+		return
+	}
+	deleteAccountBackupUpload(upload.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// decodeBackupUploadBody 解压拼接后的请求体并解析为账号备份请求
+func decodeBackupUploadBody(encoding string, body io.Reader, maxBytes int64) (*accountBackupUpsertRequest, error) {
+	reader, err := codec.NewReader(encoding, body)
+	if err != nil {
+		return nil, errors.New("上传数据解压失败")
+	}
+	defer reader.Close()
+
+	limited := &io.LimitedReader{R: reader, N: maxBytes + 1}
+	var req accountBackupUpsertRequest
+	if err := json.NewDecoder(limited).Decode(&req); err != nil {
+		if limited.N <= 0 {
+			return nil, errors.New("备份数据过大")
+		}
+		return nil, errors.New("上传数据格式错误")
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, err
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func backupUploadStatus(upload *model.AccountBackupUpload, received []int) gin.H {
+	done := make(map[int]struct{}, len(received))
+	for _, index := range received {
+		done[index] = struct{}{}
+	}
+	missing := make([]int, 0, upload.TotalChunks-len(done))
+	for i := 0; i < upload.TotalChunks; i++ {
+		if _, ok := done[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	if received == nil {
+		received = []int{}
+	}
+
+	return gin.H{
+		"upload":    upload,
+		"received":  received,
+		"missing":   missing,
+		"threshold": backupUploadThreshold,
+	}
+}
+
+func loadAccountBackupUpload(c *gin.Context) (*model.AccountBackupUpload, bool) {
+	userID := c.GetUint("user_id")
+
+	var upload model.AccountBackupUpload
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Param("upload_id"), userID).First(&upload).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "上传会话不存在"})
+		return nil, false
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		deleteAccountBackupUpload(upload.ID)
+		c.JSON(http.StatusGone, gin.H{"error": "上传会话已过期"})
+		return nil, false
+	}
+	return &upload, true
+}
+
+func deleteAccountBackupUpload(uploadID string) {
+	database.DB.Where("upload_id = ?", uploadID).Delete(&model.AccountBackupUploadChunk{})
+	database.DB.Where("id = ?", uploadID).Delete(&model.AccountBackupUpload{})
+}
+
+// cleanExpiredBackupUploads 清理过期的上传会话及其分片
+func cleanExpiredBackupUploads() {
+	var expired []string
+	database.DB.Model(&model.AccountBackupUpload{}).Where("expires_at < ?", time.Now()).Pluck("id", &expired)
+	for _, id := range expired {
+		deleteAccountBackupUpload(id)
+	}
+}
+
+// maxDecompressedBodyBytes 解压后请求体的大小上限
+func maxDecompressedBodyBytes(cfg *config.Config) int64 {
+	maxMB := cfg.Server.MaxDecompressedBodyMB
+	if maxMB <= 0 {
+		maxMB = 64
+	}
+	return int64(maxMB) << 20
+}
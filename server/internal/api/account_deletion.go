@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rpbox/server/internal/database"
 	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
 	authpkg "github.com/rpbox/server/pkg/auth"
 	"github.com/rpbox/server/pkg/validator"
 	"gorm.io/gorm"
@@ -228,9 +229,16 @@ func (s *Server) deleteAccountInTx(tx *gorm.DB, user model.User, cleanupPlan *ac
 	commentTargetIDs := uniqueUintValues(userCommentIDs, ownedPostCommentIDs)
 
 	if len(backupIDs) > 0 {
+		blobHashes, err := service.LoadBackupVersionHashes(tx, backupIDs)
+		if err != nil {
+			return err
+		}
 		if err := tx.Where("backup_id IN ?", backupIDs).Delete(&model.AccountBackupVersion{}).Error; err != nil {
 			return err
 		}
+		if err := service.DeleteUnreferencedBackupBlobs(tx, blobHashes); err != nil {
+			return err
+		}
 	}
 	if len(ownedProfileIDs) > 0 {
 		if err := tx.Where("profile_id IN ?", ownedProfileIDs).Delete(&model.ProfileVersion{}).Error; err != nil {
@@ -346,6 +354,9 @@ func (s *Server) deleteAccountInTx(tx *gorm.DB, user model.User, cleanupPlan *ac
 	}
 
 	if len(ownedStoryIDs) > 0 {
+		if err := deleteNotificationsByTarget(tx, "story", ownedStoryIDs); err != nil {
+			return err
+		}
 		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryBookmark{}).Error; err != nil {
 			return err
 		}
@@ -355,6 +366,36 @@ func (s *Server) deleteAccountInTx(tx *gorm.DB, user model.User, cleanupPlan *ac
 		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryTag{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryCollaborator{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryActivity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryShareLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryShareVisit{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryShareReferrer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryEntryRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryAnnotation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryChapter{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryParticipant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryRedactionRequest{}).Error; err != nil {
+			return err
+		}
 		if err := tx.Where("story_id IN ?", ownedStoryIDs).Delete(&model.StoryEntry{}).Error; err != nil {
 			return err
 		}
@@ -451,15 +492,48 @@ func (s *Server) deleteAccountInTx(tx *gorm.DB, user model.User, cleanupPlan *ac
 	if err := tx.Where("user_id = ?", userID).Delete(&model.StoryBookmark{}).Error; err != nil {
 		return err
 	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.StoryCollaborator{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.StoryActivity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("parent_id IN (?)", tx.Model(&model.StoryAnnotation{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&model.StoryAnnotation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.StoryAnnotation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.StoryParticipant{}).Error; err != nil {
+		return err
+	}
+	// 已批准的隐去请求保留，注销后公开剧情中该用户的发言仍保持隐去
+	if err := tx.Where("user_id = ? AND status <> ?", userID, storyRedactionApproved).Delete(&model.StoryRedactionRequest{}).Error; err != nil {
+		return err
+	}
 	if err := tx.Where("user_id = ?", userID).Delete(&model.Profile{}).Error; err != nil {
 		return err
 	}
 	if err := tx.Where("user_id = ?", userID).Delete(&model.AccountBackup{}).Error; err != nil {
 		return err
 	}
+	if err := tx.Where("upload_id IN (?)", tx.Model(&model.AccountBackupUpload{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&model.AccountBackupUploadChunk{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.AccountBackupUpload{}).Error; err != nil {
+		return err
+	}
 	if err := tx.Where("user_id = ?", userID).Delete(&model.Character{}).Error; err != nil {
 		return err
 	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.CharacterMerge{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&model.CharacterVersion{}).Error; err != nil {
+		return err
+	}
 	if err := tx.Where("user_id = ?", userID).Delete(&model.UserDailyActivity{}).Error; err != nil {
 		return err
 	}
@@ -20,10 +20,25 @@ func TestDeleteAccount(t *testing.T) {
 		&model.ProfileVersion{},
 		&model.AccountBackup{},
 		&model.AccountBackupVersion{},
+		&model.BackupBlob{},
+		&model.AccountBackupUpload{},
+		&model.AccountBackupUploadChunk{},
 		&model.Story{},
 		&model.StoryEntry{},
+		&model.StoryEntryRevision{},
+		&model.StoryAnnotation{},
+		&model.StoryChapter{},
+		&model.StoryParticipant{},
+		&model.StoryRedactionRequest{},
 		&model.StoryBookmark{},
+		&model.StoryCollaborator{},
+		&model.StoryActivity{},
+		&model.StoryShareLink{},
+		&model.StoryShareVisit{},
+		&model.StoryShareReferrer{},
 		&model.Character{},
+		&model.CharacterMerge{},
+		&model.CharacterVersion{},
 		&model.Tag{},
 		&model.StoryTag{},
 		&model.Guild{},
@@ -6,11 +6,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rpbox/server/internal/database"
 	"github.com/rpbox/server/internal/model"
 	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
 )
 
 func (s *Server) migrateLegacyCharacterCustomAvatarIfNeeded(c *gin.Context, character *model.Character) {
@@ -101,8 +103,12 @@ func (s *Server) listCharacters(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 		return
 	}
+	render := wantTRP3Rendered(c)
 	for i := range characters {
 		s.migrateLegacyCharacterCustomAvatarIfNeeded(c, &characters[i])
+		if render {
+			characters[i].Rendered = s.renderCharacterMarkup(&characters[i])
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"characters": characters})
@@ -118,6 +124,9 @@ func (s *Server) getCharacter(c *gin.Context) {
 		return
 	}
 	s.migrateLegacyCharacterCustomAvatarIfNeeded(c, &character)
+	if wantTRP3Rendered(c) {
+		character.Rendered = s.renderCharacterMarkup(&character)
+	}
 
 	c.JSON(http.StatusOK, character)
 }
@@ -337,11 +346,13 @@ func (s *Server) deleteCharacter(c *gin.Context) {
 	}
 
 	database.DB.Delete(&character)
+	database.DB.Where("character_id = ?", character.ID).Delete(&model.CharacterVersion{})
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
-// findOrCreateCharacter 查找或创建角色（内部使用，用于归档时）
-func findOrCreateCharacter(userID uint, refID, gameID, rawTRP3Data string, isNPC bool) *model.Character {
+// findOrCreateCharacter 查找或创建角色（内部使用，用于归档时），并返回 seenAt 时刻生效的角色版本。
+// 导入的 TRP3 数据变化时记录新版本；只有最新的版本才会覆盖角色当前信息，导入旧记录不会改动角色现状。
+func findOrCreateCharacter(db *gorm.DB, userID uint, refID, gameID, rawTRP3Data string, isNPC bool, seenAt time.Time) (*model.Character, *model.CharacterVersion) {
 	var character model.Character
 	var err error
 
@@ -350,21 +361,25 @@ func findOrCreateCharacter(userID uint, refID, gameID, rawTRP3Data string, isNPC
 	if isNPC {
 		// NPC：用 game_id + is_npc 查找
 		if gameID != "" {
-			err = database.DB.Where("user_id = ? AND game_id = ? AND is_npc = ?", userID, gameID, true).First(&character).Error
+			err = db.Where("user_id = ? AND game_id = ? AND is_npc = ?", userID, gameID, true).First(&character).Error
 		} else {
-			return nil
+			return nil, nil
 		}
 	} else {
 		// 玩家：优先用 ref_id 查找
 		if refID != "" {
-			err = database.DB.Where("user_id = ? AND ref_id = ?", userID, refID).First(&character).Error
+			err = db.Where("user_id = ? AND ref_id = ?", userID, refID).First(&character).Error
 		} else if gameID != "" {
-			err = database.DB.Where("user_id = ? AND game_id = ? AND is_npc = ?", userID, gameID, false).First(&character).Error
+			err = db.Where("user_id = ? AND game_id = ? AND is_npc = ?", userID, gameID, false).First(&character).Error
 		} else {
-			return nil
+			return nil, nil
 		}
 	}
 
+	if seenAt.IsZero() {
+		seenAt = time.Now()
+	}
+
 	if err != nil {
 		// 不存在，创建新角色
 		character = model.Character{
@@ -380,17 +395,28 @@ func findOrCreateCharacter(userID uint, refID, gameID, rawTRP3Data string, isNPC
 			parseTRP3DataToCharacter(&character, rawTRP3Data)
 		}
 
-		database.DB.Create(&character)
-	} else {
-		// 存在，更新原始数据
-		if rawTRP3Data != "" && rawTRP3Data != character.RawTRP3Data {
-			character.RawTRP3Data = rawTRP3Data
-			parseTRP3DataToCharacter(&character, rawTRP3Data)
-			database.DB.Save(&character)
+		if err := db.Create(&character).Error; err != nil {
+			return &character, nil
 		}
+		if rawTRP3Data == "" {
+			return &character, nil
+		}
+		return &character, recordCharacterVersion(db, &character, rawTRP3Data, seenAt)
+	}
+
+	if rawTRP3Data == "" {
+		return &character, characterVersionAt(db, character.ID, seenAt)
+	}
+
+	version := recordCharacterVersion(db, &character, rawTRP3Data, seenAt)
+	// 存在，仅当导入的是最新版本时更新角色当前信息
+	if rawTRP3Data != character.RawTRP3Data && isLatestCharacterVersion(db, version) {
+		character.RawTRP3Data = rawTRP3Data
+		parseTRP3DataToCharacter(&character, rawTRP3Data)
+		db.Save(&character)
 	}
 
-	return &character
+	return &character, version
 }
 
 // parseTRP3DataToCharacter 从原始TRP3 JSON解析字段到Character
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+// characterCustomFields 合并前目标角色的自定义字段，撤销时恢复
+type characterCustomFields struct {
+	CustomName   string `json:"custom_name"`
+	CustomAvatar string `json:"custom_avatar"`
+	CustomColor  string `json:"custom_color"`
+}
+
+// mergeCharacters 把多个角色合并到目标角色：剧情条目改挂到目标角色，自定义字段取目标已有值或第一个非空的来源值
+func (s *Server) mergeCharacters(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req struct {
+		TargetID     uint    `json:"target_id" binding:"required"`
+		SourceIDs    []uint  `json:"source_ids" binding:"required,min=1"`
+		CustomName   *string `json:"custom_name"` // 指定合并后的自定义字段，为空时自动合并
+		CustomAvatar *string `json:"custom_avatar"`
+		CustomColor  *string `json:"custom_color"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	sourceIDs := make([]uint, 0, len(req.SourceIDs))
+	seen := map[uint]struct{}{req.TargetID: {}}
+	for _, id := range req.SourceIDs {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			sourceIDs = append(sourceIDs, id)
+		}
+	}
+	if len(sourceIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要合并的其他角色"})
+		return
+	}
+
+	var target model.Character
+	if err := database.DB.Where("id = ? AND user_id = ?", req.TargetID, userID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+	var found []model.Character
+	database.DB.Where("id IN ? AND user_id = ?", sourceIDs, userID).Find(&found)
+	if len(found) != len(sourceIDs) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+	// 按请求顺序排列来源，自定义字段按此顺序取第一个非空值
+	byID := make(map[uint]model.Character, len(found))
+	for _, character := range found {
+		byID[character.ID] = character
+	}
+	sources := make([]model.Character, 0, len(sourceIDs))
+	for _, id := range sourceIDs {
+		sources = append(sources, byID[id])
+	}
+
+	before := characterCustomFields{
+		CustomName:   target.CustomName,
+		CustomAvatar: target.CustomAvatar,
+		CustomColor:  target.CustomColor,
+	}
+	for _, source := range sources {
+		if target.CustomName == "" {
+			target.CustomName = source.CustomName
+		}
+		if target.CustomAvatar == "" {
+			target.CustomAvatar = source.CustomAvatar
+		}
+		if target.CustomColor == "" {
+			target.CustomColor = source.CustomColor
+		}
+	}
+	if req.CustomName != nil {
+		target.CustomName = strings.TrimSpace(*req.CustomName)
+	}
+	if req.CustomColor != nil {
+		target.CustomColor = strings.TrimSpace(*req.CustomColor)
+	}
+	if req.CustomAvatar != nil {
+		target.CustomAvatar = ""
+		if avatar := strings.TrimSpace(*req.CustomAvatar); avatar != "" {
+			normalized, err := s.normalizeAndStoreImageValue(c, avatar, fmt.Sprintf("characters/%d/avatar", userID))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "头像格式无效"})
+				return
+			}
+			target.CustomAvatar = normalized
+		}
+	}
+
+	merge := model.CharacterMerge{
+		UserID:   userID,
+		TargetID: target.ID,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		mapping := make(map[uint][]uint, len(sources))
+		for _, source := range sources {
+			var entryIDs []uint
+			if err := tx.Model(&model.StoryEntry{}).Where("character_id = ?", source.ID).Pluck("id", &entryIDs).Error; err != nil {
+				return err
+			}
+			mapping[source.ID] = entryIDs
+			merge.EntryCount += len(entryIDs)
+		}
+		if err := tx.Model(&model.StoryEntry{}).Where("character_id IN ?", sourceIDs).
+			Update("character_id", target.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&target).Select("custom_name", "custom_avatar", "custom_color").Updates(&target).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", sourceIDs).Delete(&model.Character{}).Error; err != nil {
+			return err
+		}
+
+		snapshot, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		entryMapping, err := json.Marshal(mapping)
+		if err != nil {
+			return err
+		}
+		targetBefore, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		merge.SourceIDs = joinUintIDs(sourceIDs)
+		merge.Sources = string(snapshot)
+		merge.EntryMapping = string(entryMapping)
+		merge.TargetBefore = string(targetBefore)
+		return tx.Create(&merge).Error
+	})
+	if err != nil {
+		log.Printf("[Character] merge error: user=%d target=%d err=%v", userID, target.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"merge": merge, "character": target})
+}
+
+// listCharacterMerges 获取角色合并记录
+func (s *Server) listCharacterMerges(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var merges []model.CharacterMerge
+	database.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(50).Find(&merges)
+	c.JSON(http.StatusOK, gin.H{"merges": merges})
+}
+
+// undoCharacterMerge 撤销角色合并：恢复被合并的角色，把仍挂在目标角色上的原条目改回，并恢复目标角色的自定义字段
+func (s *Server) undoCharacterMerge(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var merge model.CharacterMerge
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&merge).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "合并记录不存在"})
+		return
+	}
+	if merge.UndoneAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "该合并已撤销"})
+		return
+	}
+
+	var sources []model.Character
+	var mapping map[uint][]uint
+	var before characterCustomFields
+	if json.Unmarshal([]byte(merge.Sources), &sources) != nil ||
+		json.Unmarshal([]byte(merge.EntryMapping), &mapping) != nil ||
+		json.Unmarshal([]byte(merge.TargetBefore), &before) != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并记录已损坏"})
+		return
+	}
+
+	errCharacterExists := errors.New("character exists")
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range sources {
+			var count int64
+			if err := tx.Model(&model.Character{}).Where("id = ?", sources[i].ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errCharacterExists
+			}
+			if err := tx.Create(&sources[i]).Error; err != nil {
+				return err
+			}
+			// 合并后又被改挂到其他角色的条目保持不动
+			if entryIDs := mapping[sources[i].ID]; len(entryIDs) > 0 {
+				if err := tx.Model(&model.StoryEntry{}).
+					Where("id IN ? AND character_id = ?", entryIDs, merge.TargetID).
+					Update("character_id", sources[i].ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Model(&model.Character{}).Where("id = ? AND user_id = ?", merge.TargetID, userID).
+			Updates(map[string]interface{}{
+				"custom_name":   before.CustomName,
+				"custom_avatar": before.CustomAvatar,
+				"custom_color":  before.CustomColor,
+			}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		merge.UndoneAt = &now
+		return tx.Model(&merge).Update("undone_at", now).Error
+	})
+	if err != nil {
+		if errors.Is(err, errCharacterExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "原角色已存在，无法撤销"})
+			return
+		}
+		log.Printf("[Character] undo merge error: merge=%d err=%v", merge.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"merge": merge, "characters": sources})
+}
+
+// suggestDuplicateCharacters 列出疑似重复的角色：RefID 相同、角色名相同或游戏内名字相同（跨服务器、NPC 变体）
+func (s *Server) suggestDuplicateCharacters(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var characters []model.Character
+	database.DB.Where("user_id = ?", userID).Order("id ASC").Find(&characters)
+
+	parent := make([]int, len(characters))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	type groupKey struct{ reason, value string }
+	firstByKey := make(map[groupKey]int)
+	reasons := make(map[[2]int]string) // 记录使两个角色归为一组的原因
+	for i, character := range characters {
+		keys := []groupKey{
+			{"same_ref_id", strings.TrimSpace(character.RefID)},
+			{"same_name", characterNameKey(character)},
+			{"same_game_name", gameNameKey(character.GameID)},
+		}
+		for _, key := range keys {
+			if key.value == "" {
+				continue
+			}
+			first, ok := firstByKey[key]
+			if !ok {
+				firstByKey[key] = i
+				continue
+			}
+			a, b := find(first), find(i)
+			if a != b {
+				parent[b] = a
+			}
+			reasons[[2]int{first, i}] = key.reason
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range characters {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+	groupReasons := make(map[int]map[string]struct{})
+	for pair, reason := range reasons {
+		root := find(pair[0])
+		if groupReasons[root] == nil {
+			groupReasons[root] = make(map[string]struct{})
+		}
+		groupReasons[root][reason] = struct{}{}
+	}
+
+	roots := make([]int, 0, len(groups))
+	for root, members := range groups {
+		if len(members) > 1 {
+			roots = append(roots, root)
+		}
+	}
+	sort.Ints(roots)
+
+	suggestions := make([]gin.H, 0, len(roots))
+	for _, root := range roots {
+		members := make([]model.Character, 0, len(groups[root]))
+		for _, i := range groups[root] {
+			members = append(members, characters[i])
+		}
+		reasonList := make([]string, 0, len(groupReasons[root]))
+		for reason := range groupReasons[root] {
+			reasonList = append(reasonList, reason)
+		}
+		sort.Strings(reasonList)
+		suggestions = append(suggestions, gin.H{"characters": members, "reasons": reasonList})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+func characterNameKey(character model.Character) string {
+	return strings.ToLower(strings.TrimSpace(character.FirstName + " " + character.LastName))
+}
+
+// gameNameKey 游戏内ID（角色名-服务器）中的角色名部分
+func gameNameKey(gameID string) string {
+	name := strings.TrimSpace(gameID)
+	if dash := strings.Index(name, "-"); dash >= 0 {
+		name = name[:dash]
+	}
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func joinUintIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestMergeCharactersAndUndo(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterMerge{}, &model.Story{}, &model.StoryEntry{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	target := model.Character{UserID: user.ID, RefID: "ref-1", GameID: "Aldric-RealmA", FirstName: "Aldric"}
+	renamed := model.Character{UserID: user.ID, GameID: "Aldric-RealmB", FirstName: "Aldric", CustomName: "Al", CustomColor: "ff0000"}
+	npc := model.Character{UserID: user.ID, GameID: "Aldric", IsNPC: true, CustomColor: "00ff00"}
+	other := model.Character{UserID: user.ID, GameID: "Bryn-RealmA", FirstName: "Bryn"}
+	for _, character := range []*model.Character{&target, &renamed, &npc, &other} {
+		if err := db.Create(character).Error; err != nil {
+			t.Fatalf("create character: %v", err)
+		}
+	}
+	story := model.Story{UserID: user.ID, Title: "Scene"}
+	db.Create(&story)
+	entries := []model.StoryEntry{
+		{StoryID: story.ID, CharacterID: &renamed.ID, Content: "a"},
+		{StoryID: story.ID, CharacterID: &npc.ID, Content: "b"},
+		{StoryID: story.ID, CharacterID: &other.ID, Content: "c"},
+	}
+	db.Create(&entries)
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	resp := performRequest(server.router, http.MethodGet, "/api/v1/characters/duplicates", nil, token)
+	var duplicates struct {
+		Suggestions []struct {
+			Characters []model.Character `json:"characters"`
+			Reasons    []string          `json:"reasons"`
+		} `json:"suggestions"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &duplicates); err != nil || len(duplicates.Suggestions) != 1 {
+		t.Fatalf("expected one duplicate group, got %s", resp.Body.String())
+	}
+	if len(duplicates.Suggestions[0].Characters) != 3 {
+		t.Fatalf("expected Aldric variants grouped, got %+v", duplicates.Suggestions[0])
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/characters/merge", map[string]interface{}{
+		"target_id":  target.ID,
+		"source_ids": []uint{renamed.ID, npc.ID},
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var merged struct {
+		Merge     model.CharacterMerge `json:"merge"`
+		Character model.Character      `json:"character"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &merged)
+	if merged.Character.CustomName != "Al" || merged.Character.CustomColor != "ff0000" || merged.Merge.EntryCount != 2 {
+		t.Fatalf("unexpected merge result %+v", merged)
+	}
+
+	var moved int64
+	db.Model(&model.StoryEntry{}).Where("character_id = ?", target.ID).Count(&moved)
+	var remaining int64
+	db.Model(&model.Character{}).Where("id IN ?", []uint{renamed.ID, npc.ID}).Count(&remaining)
+	if moved != 2 || remaining != 0 {
+		t.Fatalf("expected entries moved and sources removed, moved=%d remaining=%d", moved, remaining)
+	}
+
+	undoPath := fmt.Sprintf("/api/v1/characters/merges/%d/undo", merged.Merge.ID)
+	resp = performRequest(server.router, http.MethodPost, undoPath, nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected undo 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var restored model.Character
+	if err := db.First(&restored, renamed.ID).Error; err != nil || restored.CustomName != "Al" {
+		t.Fatalf("expected source restored, got %+v err=%v", restored, err)
+	}
+	var entry model.StoryEntry
+	db.First(&entry, entries[1].ID)
+	if entry.CharacterID == nil || *entry.CharacterID != npc.ID {
+		t.Fatalf("expected entry moved back to npc, got %+v", entry.CharacterID)
+	}
+	var reverted model.Character
+	db.First(&reverted, target.ID)
+	if reverted.CustomName != "" || reverted.CustomColor != "" {
+		t.Fatalf("expected target custom fields restored, got %+v", reverted)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, undoPath, nil, token)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected second undo to 409, got %d", resp.Code)
+	}
+}
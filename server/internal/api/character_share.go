@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/validator"
+)
+
+// characterShareFields 公开角色卡中可以隐藏的字段（名字始终可见）
+var characterShareFields = []string{
+	"last_name", "title", "full_title", "race", "class", "icon", "color", "avatar",
+	"eye_color", "age", "height", "residence", "birthplace", "misc_info", "psycho", "about",
+}
+
+// publishCharacter 发布/取消发布角色卡，可设置隐藏字段和过期时间
+func (s *Server) publishCharacter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var character model.Character
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&character).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+
+	var req struct {
+		IsPublic     bool       `json:"is_public"`
+		HiddenFields []string   `json:"hidden_fields"`
+		ExpiresAt    *time.Time `json:"expires_at"` // 为空表示不过期
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	hidden, ok := normalizeCharacterShareFields(req.HiddenFields)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的隐藏字段"})
+		return
+	}
+	if req.IsPublic && req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间必须晚于当前时间"})
+		return
+	}
+
+	character.IsPublic = req.IsPublic
+	character.ShareHiddenFields = strings.Join(hidden, ",")
+	character.ShareExpiresAt = req.ExpiresAt
+	if req.IsPublic && character.ShareCode == "" {
+		character.ShareCode = generateShareCode()
+	}
+
+	if err := database.DB.Model(&character).
+		Select("is_public", "share_code", "share_expires_at", "share_hidden_fields").
+		Updates(&character).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "发布失败"})
+		return
+	}
+	c.JSON(http.StatusOK, character)
+}
+
+// revokeCharacterShare 撤销角色卡分享，旧分享码立即失效，再次发布会生成新的分享码
+func (s *Server) revokeCharacterShare(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var character model.Character
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&character).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+
+	character.IsPublic = false
+	character.ShareCode = ""
+	character.ShareExpiresAt = nil
+	if err := database.DB.Model(&character).
+		Select("is_public", "share_code", "share_expires_at").
+		Updates(&character).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已撤销分享"})
+}
+
+// getPublicCharacter 获取公开角色卡（无需登录），隐藏字段不会返回
+func (s *Server) getPublicCharacter(c *gin.Context) {
+	code := c.Param("code")
+
+	var character model.Character
+	if code == "" || database.DB.Where("share_code = ? AND is_public = ?", code, true).
+		First(&character).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色卡不存在或未公开"})
+		return
+	}
+	if character.ShareExpiresAt != nil && time.Now().After(*character.ShareExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "分享链接已过期"})
+		return
+	}
+	s.migrateLegacyCharacterCustomAvatarIfNeeded(c, &character)
+
+	var user model.User
+	database.DB.Select("id, username").First(&user, character.UserID)
+
+	sheet := publicCharacterSheet(&character)
+	if wantTRP3Rendered(c) {
+		rendered := s.renderCharacterMarkup(&character)
+		for field := range rendered {
+			if _, shown := sheet[field]; !shown {
+				delete(rendered, field)
+			}
+		}
+		sheet["rendered"] = rendered
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"character": sheet,
+		"author":    user.Username,
+	})
+}
+
+// publicCharacterSheet 生成公开角色卡内容，不包含原始 TRP3 数据和账号信息
+func publicCharacterSheet(character *model.Character) gin.H {
+	sheet := gin.H{
+		"share_code": character.ShareCode,
+		"is_npc":     character.IsNPC,
+		"first_name": character.FirstName,
+		"last_name":  character.LastName,
+		"title":      character.Title,
+		"full_title": character.FullTitle,
+		"race":       character.Race,
+		"class":      character.Class,
+		"icon":       character.Icon,
+		"color":      character.Color,
+		"avatar":     character.CustomAvatar,
+		"eye_color":  character.EyeColor,
+		"age":        character.Age,
+		"height":     character.Height,
+		"residence":  character.Residence,
+		"birthplace": character.Birthplace,
+		"misc_info":  rawJSONOrString(character.MiscInfo),
+		"psycho":     rawJSONOrString(character.Psycho),
+		"about":      rawJSONOrString(character.AboutText),
+		"updated_at": character.UpdatedAt,
+	}
+
+	hidden := splitCharacterShareFields(character.ShareHiddenFields)
+	for _, field := range hidden {
+		delete(sheet, field)
+	}
+
+	name := strings.TrimSpace(character.CustomName)
+	if name == "" {
+		name = character.FirstName
+		if _, shown := sheet["last_name"]; shown && character.LastName != "" {
+			name = strings.TrimSpace(name + " " + character.LastName)
+		}
+	}
+	sheet["name"] = name
+	if _, shown := sheet["color"]; shown && character.CustomColor != "" {
+		sheet["color"] = character.CustomColor
+	}
+	sheet["hidden_fields"] = hidden
+	return sheet
+}
+
+// normalizeCharacterShareFields 校验并去重隐藏字段
+func normalizeCharacterShareFields(fields []string) ([]string, bool) {
+	allowed := make(map[string]struct{}, len(characterShareFields))
+	for _, field := range characterShareFields {
+		allowed[field] = struct{}{}
+	}
+
+	result := make([]string, 0, len(fields))
+	seen := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if _, ok := allowed[field]; !ok {
+			return nil, false
+		}
+		if _, ok := seen[field]; ok {
+			continue
+		}
+		seen[field] = struct{}{}
+		result = append(result, field)
+	}
+	return result, true
+}
+
+func splitCharacterShareFields(value string) []string {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// rawJSONOrString TRP3 的 JSON 字段原样输出，无法解析时按字符串输出
+func rawJSONOrString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	return value
+}
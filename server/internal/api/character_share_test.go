@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestCharacterShareMaskingExpiryAndRevoke(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	character := model.Character{
+		UserID:      user.ID,
+		FirstName:   "Aldric",
+		LastName:    "Voss",
+		Race:        "Human",
+		Psycho:      `[{"LT":"Brave"}]`,
+		MiscInfo:    `{"PE":"secret"}`,
+		AboutText:   `{"TE":1,"T1":{"TX":"{h1}Hello{/h1}<b>x</b>"}}`,
+		RawTRP3Data: `{"FN":"Aldric"}`,
+	}
+	if err := db.Create(&character).Error; err != nil {
+		t.Fatalf("create character: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+	publishPath := "/api/v1/characters/" + fmt.Sprint(character.ID) + "/publish"
+
+	resp := performRequest(server.router, http.MethodPost, publishPath, map[string]interface{}{
+		"is_public":     true,
+		"hidden_fields": []string{"psycho", "unknown"},
+	}, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown field to 400, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, publishPath, map[string]interface{}{
+		"is_public":     true,
+		"hidden_fields": []string{"psycho", "misc_info", "last_name"},
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var published model.Character
+	if err := json.Unmarshal(resp.Body.Bytes(), &published); err != nil || published.ShareCode == "" {
+		t.Fatalf("expected share code, got %s", resp.Body.String())
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/characters/"+published.ShareCode, nil, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var body struct {
+		Character map[string]interface{} `json:"character"`
+		Author    string                 `json:"author"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, field := range []string{"psycho", "misc_info", "last_name", "raw_trp3_data", "user_id"} {
+		if _, ok := body.Character[field]; ok {
+			t.Fatalf("field %s should be hidden: %v", field, body.Character)
+		}
+	}
+	if body.Character["name"] != "Aldric" || body.Character["race"] != "Human" || body.Author != "tester" {
+		t.Fatalf("unexpected public sheet %v author=%s", body.Character, body.Author)
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/characters/"+published.ShareCode+"?render=true", nil, "")
+	var rendered struct {
+		Character struct {
+			Rendered map[string]struct {
+				HTML string `json:"html"`
+				Text string `json:"text"`
+			} `json:"rendered"`
+		} `json:"character"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &rendered); err != nil {
+		t.Fatalf("decode rendered: %v", err)
+	}
+	about := rendered.Character.Rendered["about"]
+	if !strings.Contains(about.HTML, "<h1>Hello</h1>&lt;b&gt;x&lt;/b&gt;") || about.Text != "Hello\n<b>x</b>" {
+		t.Fatalf("unexpected rendered about %+v", about)
+	}
+	if _, ok := rendered.Character.Rendered["psycho"]; ok {
+		t.Fatalf("hidden psycho should not be rendered")
+	}
+
+	// 过期后不可访问
+	db.Model(&model.Character{}).Where("id = ?", character.ID).Update("share_expires_at", time.Now().Add(-time.Minute))
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/characters/"+published.ShareCode, nil, "")
+	if resp.Code != http.StatusGone {
+		t.Fatalf("expected expired share to 410, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodDelete, "/api/v1/characters/"+fmt.Sprint(character.ID)+"/share", nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected revoke 200, got %d", resp.Code)
+	}
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/characters/"+published.ShareCode, nil, "")
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected revoked share to 404, got %d", resp.Code)
+	}
+}
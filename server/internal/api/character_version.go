@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"gorm.io/gorm"
+)
+
+// recordCharacterVersion 返回与 rawTRP3Data 内容一致的角色版本，没有时新建。
+// 同一份数据更早出现时把版本的生效时间提前；角色首次产生版本时先把当前信息存为基线版本。
+func recordCharacterVersion(db *gorm.DB, character *model.Character, rawTRP3Data string, seenAt time.Time) *model.CharacterVersion {
+	hash := characterDataHash(rawTRP3Data)
+
+	var existing model.CharacterVersion
+	if db.Where("character_id = ? AND data_hash = ?", character.ID, hash).First(&existing).Error == nil {
+		if seenAt.Before(existing.ValidFrom) {
+			existing.ValidFrom = seenAt
+			db.Model(&existing).Update("valid_from", seenAt)
+		}
+		return &existing
+	}
+
+	var count int64
+	db.Model(&model.CharacterVersion{}).Where("character_id = ?", character.ID).Count(&count)
+	if count == 0 && character.RawTRP3Data != "" && character.RawTRP3Data != rawTRP3Data {
+		baseline := snapshotCharacterVersion(character)
+		baseline.Version = 1
+		baseline.ValidFrom = character.CreatedAt
+		if err := db.Create(&baseline).Error; err == nil {
+			count++
+		}
+	}
+
+	parsed := model.Character{ID: character.ID, UserID: character.UserID, RawTRP3Data: rawTRP3Data}
+	parseTRP3DataToCharacter(&parsed, rawTRP3Data)
+	version := snapshotCharacterVersion(&parsed)
+	version.Version = int(count) + 1
+	version.ValidFrom = seenAt
+	if err := db.Create(&version).Error; err != nil {
+		return nil
+	}
+	return &version
+}
+
+// characterVersionAt 返回 at 时刻生效的角色版本；早于所有版本时返回最早的版本，没有版本时返回 nil
+func characterVersionAt(db *gorm.DB, characterID uint, at time.Time) *model.CharacterVersion {
+	var version model.CharacterVersion
+	if db.Where("character_id = ? AND valid_from <= ?", characterID, at).
+		Order("valid_from DESC, id DESC").First(&version).Error == nil {
+		return &version
+	}
+	if db.Where("character_id = ?", characterID).
+		Order("valid_from ASC, id ASC").First(&version).Error == nil {
+		return &version
+	}
+	return nil
+}
+
+// isLatestCharacterVersion 版本是否是角色最新生效的版本
+func isLatestCharacterVersion(db *gorm.DB, version *model.CharacterVersion) bool {
+	if version == nil {
+		return true
+	}
+	var newer int64
+	db.Model(&model.CharacterVersion{}).
+		Where("character_id = ? AND valid_from > ?", version.CharacterID, version.ValidFrom).
+		Count(&newer)
+	return newer == 0
+}
+
+func characterDataHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// snapshotCharacterVersion 以角色当前的 TRP3 字段生成版本快照
+func snapshotCharacterVersion(character *model.Character) model.CharacterVersion {
+	return model.CharacterVersion{
+		CharacterID: character.ID,
+		UserID:      character.UserID,
+		DataHash:    characterDataHash(character.RawTRP3Data),
+		Race:        character.Race,
+		Class:       character.Class,
+		FirstName:   character.FirstName,
+		LastName:    character.LastName,
+		FullTitle:   character.FullTitle,
+		Title:       character.Title,
+		Icon:        character.Icon,
+		Color:       character.Color,
+		EyeColor:    character.EyeColor,
+		Age:         character.Age,
+		Height:      character.Height,
+		Residence:   character.Residence,
+		Birthplace:  character.Birthplace,
+		MiscInfo:    character.MiscInfo,
+		Psycho:      character.Psycho,
+		AboutText:   character.AboutText,
+		RawTRP3Data: character.RawTRP3Data,
+	}
+}
+
+// characterAtVersion 用版本快照覆盖角色的 TRP3 字段（自定义字段保持不变）
+func characterAtVersion(character model.Character, version *model.CharacterVersion) model.Character {
+	character.Race = version.Race
+	character.Class = version.Class
+	character.FirstName = version.FirstName
+	character.LastName = version.LastName
+	character.FullTitle = version.FullTitle
+	character.Title = version.Title
+	character.Icon = version.Icon
+	character.Color = version.Color
+	character.EyeColor = version.EyeColor
+	character.Age = version.Age
+	character.Height = version.Height
+	character.Residence = version.Residence
+	character.Birthplace = version.Birthplace
+	character.MiscInfo = version.MiscInfo
+	character.Psycho = version.Psycho
+	character.AboutText = version.AboutText
+	character.RawTRP3Data = version.RawTRP3Data
+	character.Rendered = nil
+	return character
+}
+
+// loadStoryCharacters 读取条目关联的角色（当前信息）以及条目时间点的角色版本（以版本 ID 为键，内容为当时的角色信息）
+func (s *Server) loadStoryCharacters(c *gin.Context, entries []model.StoryEntry) (map[uint]model.Character, map[uint]model.Character) {
+	characterIDs := make([]uint, 0)
+	versionIDs := make([]uint, 0)
+	// 版本按条目当前关联的角色展示（角色合并后版本仍属于原角色）
+	versionOwners := make(map[uint]uint)
+	for _, entry := range entries {
+		if entry.CharacterID != nil {
+			characterIDs = append(characterIDs, *entry.CharacterID)
+			if entry.CharacterVersionID != nil {
+				versionIDs = append(versionIDs, *entry.CharacterVersionID)
+				versionOwners[*entry.CharacterVersionID] = *entry.CharacterID
+			}
+		}
+	}
+
+	render := wantTRP3Rendered(c)
+	charactersMap := make(map[uint]model.Character)
+	if len(characterIDs) > 0 {
+		var characters []model.Character
+		database.DB.Where("id IN ?", characterIDs).Find(&characters)
+		for _, char := range characters {
+			if render {
+				char.Rendered = s.renderCharacterMarkup(&char)
+			}
+			charactersMap[char.ID] = char
+		}
+	}
+
+	versionsMap := make(map[uint]model.Character)
+	if len(versionIDs) > 0 {
+		var versions []model.CharacterVersion
+		database.DB.Where("id IN ?", versionIDs).Find(&versions)
+		for i := range versions {
+			base, ok := charactersMap[versionOwners[versions[i].ID]]
+			if !ok {
+				// 角色已被删除时仍按快照展示
+				base = model.Character{ID: versions[i].CharacterID, UserID: versions[i].UserID}
+			}
+			char := characterAtVersion(base, &versions[i])
+			if render {
+				char.Rendered = s.renderCharacterMarkup(&char)
+			}
+			versionsMap[versions[i].ID] = char
+		}
+	}
+
+	return charactersMap, versionsMap
+}
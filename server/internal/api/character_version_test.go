@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryEntriesKeepCharacterVersionAtTimestamp(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryGuild{}, &model.UserDailyActivity{}, &model.UserActivityLog{},
+		&model.StoryAnnotation{}, &model.StoryChapter{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	story := model.Story{
+		UserID:    user.ID,
+		Title:     "Scene",
+		StartTime: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	db.Create(&story)
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+	path := fmt.Sprintf("/api/v1/stories/%d/entries", story.ID)
+
+	entry := func(title, timestamp string) map[string]interface{} {
+		return map[string]interface{}{
+			"content":   title,
+			"speaker":   "Aldric",
+			"timestamp": timestamp,
+			"ref_id":    "ref-1",
+			"game_id":   "Aldric-RealmA",
+			"trp3_data": fmt.Sprintf(`{"FN":"Aldric","TI":%q}`, title),
+		}
+	}
+	batches := [][]map[string]interface{}{
+		{entry("Knight", "2024-01-01T10:00:00Z"), entry("Lord", "2024-03-01T10:00:00Z")},
+		// 之后导入的旧日志不应覆盖角色当前信息
+		{entry("Squire", "2023-06-01T10:00:00Z")},
+	}
+	for _, batch := range batches {
+		resp := performRequest(server.router, http.MethodPost, path, batch, token)
+		if resp.Code != http.StatusOK && resp.Code != http.StatusCreated {
+			t.Fatalf("add entries: %d body=%s", resp.Code, resp.Body.String())
+		}
+	}
+
+	var character model.Character
+	if err := db.Where("user_id = ? AND ref_id = ?", user.ID, "ref-1").First(&character).Error; err != nil {
+		t.Fatalf("load character: %v", err)
+	}
+	if character.Title != "Lord" {
+		t.Fatalf("expected current character to stay Lord, got %q", character.Title)
+	}
+	var versions int64
+	db.Model(&model.CharacterVersion{}).Where("character_id = ?", character.ID).Count(&versions)
+	if versions != 3 {
+		t.Fatalf("expected 3 versions, got %d", versions)
+	}
+
+	resp := performRequest(server.router, http.MethodGet, fmt.Sprintf("/api/v1/stories/%d", story.ID), nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("get story: %d body=%s", resp.Code, resp.Body.String())
+	}
+	var body struct {
+		Entries           []model.StoryEntry         `json:"entries"`
+		CharacterVersions map[string]model.Character `json:"character_versions"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode story: %v", err)
+	}
+	if len(body.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(body.Entries))
+	}
+	for _, e := range body.Entries {
+		if e.CharacterVersionID == nil {
+			t.Fatalf("entry %q has no character version", e.Content)
+		}
+		version, ok := body.CharacterVersions[fmt.Sprint(*e.CharacterVersionID)]
+		if !ok || version.Title != e.Content || version.ID != character.ID {
+			t.Fatalf("entry %q shows version %+v", e.Content, version)
+		}
+	}
+}
@@ -1,15 +1,20 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rpbox/server/internal/database"
 	"github.com/rpbox/server/internal/model"
 	"github.com/rpbox/server/internal/service"
 	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
 )
 
 func (s *Server) listProfiles(c *gin.Context) {
@@ -32,8 +37,9 @@ func (s *Server) listProfiles(c *gin.Context) {
 	db.Model(&model.Profile{}).Count(&total)
 	db.Offset((page - 1) * pageSize).Limit(pageSize).Find(&profiles)
 
-	for _, p := range profiles {
-		log.Printf("[Profile] 返回: id=%s, name=%s, checksum=%s", p.ID, p.ProfileName, p.Checksum)
+	for i := range profiles {
+		attachProfileCharacteristics(&profiles[i])
+		log.Printf("[Profile] 返回: id=%s, name=%s, checksum=%s", profiles[i].ID, profiles[i].ProfileName, profiles[i].Checksum)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -62,6 +68,12 @@ func (s *Server) createProfile(c *gin.Context) {
 
 	log.Printf("[Profile] createProfile - id=%s, name=%s, checksum=%s", req.ID, req.ProfileName, req.Checksum)
 
+	parsed, err := service.ParseProfileCharacteristics(req.RawLua, req.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "人物卡数据格式错误: " + err.Error()})
+		return
+	}
+
 	profile := model.Profile{
 		ID:          req.ID,
 		UserID:      userID,
@@ -70,6 +82,7 @@ func (s *Server) createProfile(c *gin.Context) {
 		RawLua:      req.RawLua,
 		Checksum:    req.Checksum,
 		Version:     1,
+		Parsed:      parsed,
 	}
 
 	if err := database.DB.Create(&profile).Error; err != nil {
@@ -89,6 +102,7 @@ func (s *Server) getProfile(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "人物卡不存在"})
 		return
 	}
+	attachProfileCharacteristics(&profile)
 
 	c.JSON(http.StatusOK, profile)
 }
@@ -104,9 +118,11 @@ func (s *Server) updateProfile(c *gin.Context) {
 	}
 
 	var req struct {
-		ProfileName string `json:"profile_name"`
-		RawLua      string `json:"raw_lua"`
-		Checksum    string `json:"checksum"`
+		ProfileName  string `json:"profile_name"`
+		RawLua       string `json:"raw_lua"`
+		Checksum     string `json:"checksum"`
+		BaseVersion  int    `json:"base_version"`  // 客户端编辑时基于的版本，为 0 时不检查
+		BaseChecksum string `json:"base_checksum"` // 客户端编辑时基于的校验和，为空时不检查
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -116,6 +132,23 @@ func (s *Server) updateProfile(c *gin.Context) {
 
 	log.Printf("[Profile] updateProfile - id=%s, 旧checksum=%s, 新checksum=%s", id, profile.Checksum, req.Checksum)
 
+	parsed, err := service.ParseProfileCharacteristics(req.RawLua, profile.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "人物卡数据格式错误: " + err.Error()})
+		return
+	}
+
+	if isStaleBase(req.BaseVersion, req.BaseChecksum, profile.Version, profile.Checksum) {
+		if req.Checksum != "" && req.Checksum == profile.Checksum {
+			// 两台设备上传的内容一致，无需处理冲突
+			attachProfileCharacteristics(&profile)
+			c.JSON(http.StatusOK, profile)
+			return
+		}
+		respondProfileConflict(c, &profile, req.BaseVersion, req.BaseChecksum, req.Checksum)
+		return
+	}
+
 	// 保存版本历史
 	version := model.ProfileVersion{
 		ProfileID: profile.ID,
@@ -123,19 +156,160 @@ func (s *Server) updateProfile(c *gin.Context) {
 		RawLua:    profile.RawLua,
 		Checksum:  profile.Checksum,
 	}
-	database.DB.Create(&version)
-	_ = service.CleanOldVersions(profile.ID)
 
 	// 更新 Profile
+	baseVersion := profile.Version
 	profile.ProfileName = req.ProfileName
 	profile.RawLua = req.RawLua
 	profile.Checksum = req.Checksum
 	profile.Version++
 
-	database.DB.Save(&profile)
+	if err := saveProfileRevision(&profile, baseVersion, &version); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.Where("id = ?", profile.ID).First(&profile)
+			respondProfileConflict(c, &profile, baseVersion, req.BaseChecksum, req.Checksum)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	profile.Parsed = parsed
 	c.JSON(http.StatusOK, profile)
 }
 
+// resolveProfileConflict 解决多设备同步冲突：保留本地、保留服务器或写入合并结果，落选的一方存为历史版本
+func (s *Server) resolveProfileConflict(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	id := c.Param("id")
+
+	var req struct {
+		Strategy    string `json:"strategy" binding:"required,oneof=mine theirs merged"`
+		Version     int    `json:"version" binding:"required"` // 解决冲突时看到的服务器版本
+		ProfileName string `json:"profile_name"`
+		RawLua      string `json:"raw_lua"` // mine 为本地数据，merged 为合并结果，theirs 为将被存档的本地数据
+		Checksum    string `json:"checksum"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if strings.TrimSpace(req.RawLua) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少本地人物卡数据"})
+		return
+	}
+
+	var profile model.Profile
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "人物卡不存在"})
+		return
+	}
+	if profile.Version != req.Version {
+		respondProfileConflict(c, &profile, req.Version, "", req.Checksum)
+		return
+	}
+
+	doc, err := service.ParseProfileDocument(req.RawLua, profile.ID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "人物卡数据格式错误: " + err.Error()})
+		return
+	}
+	checksum := req.Checksum
+	if checksum == "" {
+		checksum = service.ProfileChecksum(doc.Profile)
+	}
+
+	// 落选的一方占用当前版本号存入历史，服务器版本号前进一位
+	archived := model.ProfileVersion{
+		ProfileID: profile.ID,
+		Version:   profile.Version,
+		RawLua:    profile.RawLua,
+		Checksum:  profile.Checksum,
+	}
+	switch req.Strategy {
+	case conflictKeepTheirs:
+		archived.RawLua = req.RawLua
+		archived.Checksum = checksum
+		archived.ChangeLog = "冲突解决：未采用的本地版本"
+	case conflictKeepMine, conflictMerged:
+		if req.Strategy == conflictMerged {
+			archived.ChangeLog = "冲突解决：合并前的服务器版本"
+		} else {
+			archived.ChangeLog = "冲突解决：被本地版本覆盖"
+		}
+		if req.ProfileName != "" {
+			profile.ProfileName = req.ProfileName
+		}
+		profile.RawLua = req.RawLua
+		profile.Checksum = checksum
+	}
+	profile.Version++
+
+	if err := saveProfileRevision(&profile, req.Version, &archived); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.Where("id = ?", profile.ID).First(&profile)
+			respondProfileConflict(c, &profile, req.Version, "", checksum)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解决冲突失败"})
+		return
+	}
+	attachProfileCharacteristics(&profile)
+
+	c.JSON(http.StatusOK, gin.H{
+		"profile":  profile,
+		"archived": profileRevisionMeta(&archived),
+	})
+}
+
+// saveProfileRevision 在同一事务中写入历史版本并按版本号条件更新人物卡，版本号不匹配时返回 errSyncConflict
+func saveProfileRevision(profile *model.Profile, baseVersion int, archived *model.ProfileVersion) error {
+	profile.UpdatedAt = time.Now()
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Profile{}).
+			Where("id = ? AND version = ?", profile.ID, baseVersion).
+			Updates(map[string]interface{}{
+				"profile_name": profile.ProfileName,
+				"raw_lua":      profile.RawLua,
+				"checksum":     profile.Checksum,
+				"version":      profile.Version,
+				"updated_at":   profile.UpdatedAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSyncConflict
+		}
+		return tx.Create(archived).Error
+	})
+	if err != nil {
+		return err
+	}
+	_ = service.CleanOldVersions(profile.ID)
+	return nil
+}
+
+// respondProfileConflict 返回 409，附带服务器当前版本与客户端基准版本的元数据
+func respondProfileConflict(c *gin.Context, profile *model.Profile, baseVersion int, baseChecksum, checksum string) {
+	base := conflictBaseMeta(baseVersion, baseChecksum, checksum)
+	var baseRevision model.ProfileVersion
+	if baseVersion > 0 && database.DB.Where("profile_id = ? AND version = ?", profile.ID, baseVersion).
+		First(&baseRevision).Error == nil {
+		base["created_at"] = baseRevision.CreatedAt
+		if baseChecksum == "" {
+			base["base_checksum"] = baseRevision.Checksum
+		}
+	}
+
+	c.JSON(http.StatusConflict, gin.H{
+		"error": "人物卡已在其他设备更新，请先解决冲突",
+		"conflict": gin.H{
+			"server": syncRevisionMeta(profile.Version, profile.Checksum, profile.UpdatedAt),
+			"client": base,
+		},
+	})
+}
+
 func (s *Server) deleteProfile(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	id := c.Param("id")
@@ -171,7 +345,10 @@ func (s *Server) rollbackProfile(c *gin.Context) {
 	id := c.Param("id")
 
 	var req struct {
-		Version int `json:"version" binding:"required"`
+		Version      int      `json:"version" binding:"required"`
+		Fields       []string `json:"fields"`        // 仅回滚指定字段，为空时整体回滚
+		BaseVersion  int      `json:"base_version"`  // 客户端发起回滚时看到的版本，为 0 时不检查
+		BaseChecksum string   `json:"base_checksum"` // 客户端发起回滚时看到的校验和，为空时不检查
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
@@ -185,6 +362,11 @@ func (s *Server) rollbackProfile(c *gin.Context) {
 		return
 	}
 
+	if isStaleBase(req.BaseVersion, req.BaseChecksum, profile.Version, profile.Checksum) {
+		respondProfileConflict(c, &profile, req.BaseVersion, req.BaseChecksum, "")
+		return
+	}
+
 	// 查找目标版本
 	var targetVersion model.ProfileVersion
 	if err := database.DB.Where("profile_id = ? AND version = ?", id, req.Version).First(&targetVersion).Error; err != nil {
@@ -192,6 +374,30 @@ func (s *Server) rollbackProfile(c *gin.Context) {
 		return
 	}
 
+	rawLua, checksum := targetVersion.RawLua, targetVersion.Checksum
+	if len(req.Fields) > 0 {
+		// 部分回滚：以当前数据为基础，只把指定字段恢复为目标版本的值
+		current, err := service.ParseProfileDocument(profile.RawLua, profile.ID)
+		if err != nil || current == nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "当前人物卡数据无法解析，不能部分回滚"})
+			return
+		}
+		target, err := service.ParseProfileDocument(targetVersion.RawLua, profile.ID)
+		if err != nil || target == nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "目标版本数据无法解析，不能部分回滚"})
+			return
+		}
+		if err := service.ApplyProfileFields(current.Profile, target.Profile, req.Fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "回滚字段无效: " + err.Error()})
+			return
+		}
+		if rawLua, err = current.Encode(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚失败"})
+			return
+		}
+		checksum = service.ProfileChecksum(current.Profile)
+	}
+
 	// 保存当前版本
 	currentVersion := model.ProfileVersion{
 		ProfileID: profile.ID,
@@ -200,14 +406,118 @@ func (s *Server) rollbackProfile(c *gin.Context) {
 		Checksum:  profile.Checksum,
 		ChangeLog: "回滚前备份",
 	}
-	database.DB.Create(&currentVersion)
-	_ = service.CleanOldVersions(profile.ID)
 
-	// 回滚
-	profile.RawLua = targetVersion.RawLua
-	profile.Checksum = targetVersion.Checksum
+	// 回滚，与更新一样按版本号条件写入，期间被其他设备修改时返回冲突
+	baseVersion := profile.Version
+	profile.RawLua = rawLua
+	profile.Checksum = checksum
 	profile.Version++
-	database.DB.Save(&profile)
+	if err := saveProfileRevision(&profile, baseVersion, &currentVersion); err != nil {
+		if errors.Is(err, errSyncConflict) {
+			database.DB.Where("id = ?", profile.ID).First(&profile)
+			respondProfileConflict(c, &profile, baseVersion, req.BaseChecksum, checksum)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "回滚失败"})
+		return
+	}
+	attachProfileCharacteristics(&profile)
 
 	c.JSON(http.StatusOK, profile)
 }
+
+func (s *Server) diffProfileVersions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	id := c.Param("id")
+
+	// 验证 Profile 归属
+	var profile model.Profile
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "人物卡不存在"})
+		return
+	}
+
+	from, ok := loadProfileRevision(c, &profile, c.Param("a"))
+	if !ok {
+		return
+	}
+	to, ok := loadProfileRevision(c, &profile, c.Param("b"))
+	if !ok {
+		return
+	}
+
+	fromDoc, err := service.ParseProfileDocument(from.RawLua, profile.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("版本 %d 数据无法解析", from.Version)})
+		return
+	}
+	toDoc, err := service.ParseProfileDocument(to.RawLua, profile.ID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("版本 %d 数据无法解析", to.Version)})
+		return
+	}
+
+	var fromData, toData map[string]interface{}
+	if fromDoc != nil {
+		fromData = fromDoc.Profile
+	}
+	if toDoc != nil {
+		toData = toDoc.Profile
+	}
+	changes := service.DiffProfileData(fromData, toData)
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    profileRevisionMeta(from),
+		"to":      profileRevisionMeta(to),
+		"changes": changes,
+		"summary": service.SummarizeProfileChanges(changes),
+	})
+}
+
+// loadProfileRevision 按版本号查找人物卡的历史版本，"current" 表示当前数据
+func loadProfileRevision(c *gin.Context, profile *model.Profile, param string) (*model.ProfileVersion, bool) {
+	if param == "current" {
+		return &model.ProfileVersion{
+			ProfileID: profile.ID,
+			Version:   profile.Version,
+			RawLua:    profile.RawLua,
+			Checksum:  profile.Checksum,
+			CreatedAt: profile.UpdatedAt,
+		}, true
+	}
+
+	number, err := strconv.Atoi(param)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号"})
+		return nil, false
+	}
+	if number == profile.Version {
+		return loadProfileRevision(c, profile, "current")
+	}
+
+	var version model.ProfileVersion
+	if err := database.DB.Where("profile_id = ? AND version = ?", profile.ID, number).First(&version).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return nil, false
+	}
+	return &version, true
+}
+
+func profileRevisionMeta(v *model.ProfileVersion) gin.H {
+	return gin.H{
+		"version":    v.Version,
+		"checksum":   v.Checksum,
+		"change_log": v.ChangeLog,
+		"created_at": v.CreatedAt,
+	}
+}
+
+// attachProfileCharacteristics 为响应附加解析后的人物卡概要（旧数据无法解析时跳过）
+func attachProfileCharacteristics(profile *model.Profile) {
+	parsed, err := service.ParseProfileCharacteristics(profile.RawLua, profile.ID)
+	if err != nil {
+		log.Printf("[Profile] parse raw_lua failed - id=%s, err=%v", profile.ID, err)
+		return
+	}
+	profile.Parsed = parsed
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestProfileRawLuaIsParsedAndValidated(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Profile{}, &model.ProfileVersion{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/profiles", map[string]string{
+		"id":       "profile-1",
+		"raw_lua":  `{ ["player"] = { ["characteristics"] = { ["FN"] = "Aldric", ["RA"] = "Human" }, }`,
+		"checksum": "abc",
+	}, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected malformed raw_lua to be rejected, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles", map[string]string{
+		"id":       "profile-1",
+		"raw_lua":  `{ ["player"] = { ["characteristics"] = { ["FN"] = "Aldric", ["RA"] = "Human", ["CL"] = "Paladin" } } }`,
+		"checksum": "abc",
+	}, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/profiles/profile-1", nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	var profile model.Profile
+	if err := json.Unmarshal(resp.Body.Bytes(), &profile); err != nil {
+		t.Fatalf("decode profile: %v", err)
+	}
+	if profile.Parsed == nil || profile.Parsed.Name != "Aldric" || profile.Parsed.Class != "Paladin" {
+		t.Fatalf("expected parsed characteristics, got %+v", profile.Parsed)
+	}
+
+	resp = performRequest(server.router, http.MethodPut, "/api/v1/profiles/profile-1", map[string]string{
+		"raw_lua":  `TRP3_Profiles = {`,
+		"checksum": "def",
+	}, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected malformed update to be rejected, got %d", resp.Code)
+	}
+
+	var stored model.Profile
+	db.First(&stored, "id = ?", "profile-1")
+	if stored.Checksum != "abc" || stored.Version != 1 {
+		t.Fatalf("expected rejected update to leave profile untouched, got checksum=%s version=%d", stored.Checksum, stored.Version)
+	}
+}
+
+func TestProfileVersionDiffAndPartialRollback(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Profile{}, &model.ProfileVersion{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/profiles", map[string]string{
+		"id":       "profile-1",
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric","EC":"Blue","RA":"Human"}}}`,
+		"checksum": "v1",
+	}, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodPut, "/api/v1/profiles/profile-1", map[string]string{
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric","EC":"Green","RA":"Dwarf"}}}`,
+		"checksum": "v2",
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/profiles/profile-1/versions/1/diff/current", nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var diff struct {
+		From    struct{ Version int } `json:"from"`
+		To      struct{ Version int } `json:"to"`
+		Changes []struct {
+			Field string `json:"field"`
+			Old   string `json:"old"`
+			New   string `json:"new"`
+		} `json:"changes"`
+		Summary []string `json:"summary"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("decode diff: %v", err)
+	}
+	if diff.From.Version != 1 || diff.To.Version != 2 || len(diff.Changes) != 2 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	if diff.Changes[0].Field != "player.characteristics.EC" || diff.Changes[0].Old != "Blue" || diff.Changes[0].New != "Green" {
+		t.Fatalf("unexpected first change %+v", diff.Changes[0])
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/profiles/profile-1/versions/9/diff/current", nil, token)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected missing version to 404, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles/profile-1/rollback", map[string]interface{}{
+		"version": 1,
+		"fields":  []string{"player.characteristics.EC"},
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var profile model.Profile
+	if err := json.Unmarshal(resp.Body.Bytes(), &profile); err != nil {
+		t.Fatalf("decode profile: %v", err)
+	}
+	if profile.Version != 3 || profile.Parsed == nil || profile.Parsed.Race != "Dwarf" {
+		t.Fatalf("expected race to be kept, got version=%d parsed=%+v", profile.Version, profile.Parsed)
+	}
+	if profile.RawLua != `{"player":{"characteristics":{"EC":"Blue","FN":"Aldric","RA":"Dwarf"}}}` {
+		t.Fatalf("unexpected raw_lua %s", profile.RawLua)
+	}
+
+	var backup model.ProfileVersion
+	if err := db.Where("profile_id = ? AND version = ?", "profile-1", 2).First(&backup).Error; err != nil || backup.ChangeLog != "回滚前备份" {
+		t.Fatalf("expected version 2 to be kept as rollback backup, got %+v err=%v", backup, err)
+	}
+
+	// 基于过期版本的回滚不能覆盖其他设备的修改
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles/profile-1/rollback", map[string]interface{}{
+		"version":      1,
+		"base_version": 2,
+	}, token)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected stale rollback to 409, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var current model.Profile
+	db.Where("id = ?", "profile-1").First(&current)
+	if current.Version != 3 || current.RawLua != profile.RawLua {
+		t.Fatalf("stale rollback should not change the profile, got version=%d", current.Version)
+	}
+}
+
+func TestProfileUpdateDetectsStaleBaseAndResolves(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Profile{}, &model.ProfileVersion{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/profiles", map[string]string{
+		"id":       "profile-1",
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric"}}}`,
+		"checksum": "v1",
+	}, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	// 设备 A 基于版本 1 上传
+	resp = performRequest(server.router, http.MethodPut, "/api/v1/profiles/profile-1", map[string]interface{}{
+		"raw_lua":      `{"player":{"characteristics":{"FN":"Aldric","RA":"Human"}}}`,
+		"checksum":     "device-a",
+		"base_version": 1,
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	// 设备 B 同样基于版本 1 上传，应当冲突
+	resp = performRequest(server.router, http.MethodPut, "/api/v1/profiles/profile-1", map[string]interface{}{
+		"raw_lua":       `{"player":{"characteristics":{"FN":"Aldric","CL":"Paladin"}}}`,
+		"checksum":      "device-b",
+		"base_version":  1,
+		"base_checksum": "v1",
+	}, token)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	var conflict struct {
+		Conflict struct {
+			Server struct {
+				Version  int    `json:"version"`
+				Checksum string `json:"checksum"`
+			} `json:"server"`
+			Client struct {
+				BaseVersion int    `json:"base_version"`
+				Checksum    string `json:"checksum"`
+			} `json:"client"`
+		} `json:"conflict"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &conflict); err != nil {
+		t.Fatalf("decode conflict: %v", err)
+	}
+	if conflict.Conflict.Server.Version != 2 || conflict.Conflict.Server.Checksum != "device-a" ||
+		conflict.Conflict.Client.BaseVersion != 1 || conflict.Conflict.Client.Checksum != "device-b" {
+		t.Fatalf("unexpected conflict payload %s", resp.Body.String())
+	}
+
+	// 使用过期的服务器版本解决冲突同样被拒绝
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles/profile-1/resolve", map[string]interface{}{
+		"strategy": "mine",
+		"version":  1,
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric","CL":"Paladin"}}}`,
+		"checksum": "device-b",
+	}, token)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected stale resolve to 409, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles/profile-1/resolve", map[string]interface{}{
+		"strategy": "theirs",
+		"version":  2,
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric","CL":"Paladin"}}}`,
+		"checksum": "device-b",
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var stored model.Profile
+	db.First(&stored, "id = ?", "profile-1")
+	if stored.Version != 3 || stored.Checksum != "device-a" {
+		t.Fatalf("expected server side kept at version 3, got version=%d checksum=%s", stored.Version, stored.Checksum)
+	}
+	var archived model.ProfileVersion
+	if err := db.Where("profile_id = ? AND version = ?", "profile-1", 2).First(&archived).Error; err != nil {
+		t.Fatalf("load archived version: %v", err)
+	}
+	if archived.Checksum != "device-b" || archived.ChangeLog != "冲突解决：未采用的本地版本" {
+		t.Fatalf("expected losing local side archived, got %+v", archived)
+	}
+
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/profiles/profile-1/resolve", map[string]interface{}{
+		"strategy": "merged",
+		"version":  3,
+		"raw_lua":  `{"player":{"characteristics":{"FN":"Aldric","RA":"Human","CL":"Paladin"}}}`,
+	}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	db.First(&stored, "id = ?", "profile-1")
+	if stored.Version != 4 || stored.Checksum == "device-a" || stored.Checksum == "" {
+		t.Fatalf("expected merged payload with computed checksum, got version=%d checksum=%s", stored.Version, stored.Checksum)
+	}
+}
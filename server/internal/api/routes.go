@@ -48,6 +48,8 @@ func (s *Server) setupRoutes() {
 
 		// 公开剧情（无需登录）
 		v1.GET("/public/stories/:code", s.getPublicStory)
+		v1.GET("/public/stories/:code/export", s.exportPublicStory)
+		v1.GET("/public/characters/:code", s.getPublicCharacter)
 
 		// 图标服务（公开）
 		v1.GET("/icons/:name", s.getIcon)
@@ -87,23 +89,66 @@ func (s *Server) setupRoutes() {
 			auth.PUT("/profiles/:id", s.updateProfile)
 			auth.DELETE("/profiles/:id", s.deleteProfile)
 			auth.GET("/profiles/:id/versions", s.getProfileVersions)
+			auth.GET("/profiles/:id/versions/:a/diff/:b", s.diffProfileVersions)
 			auth.POST("/profiles/:id/rollback", s.rollbackProfile)
+			auth.POST("/profiles/:id/resolve", s.resolveProfileConflict)
 
 			auth.GET("/stories", s.listStories)
 			auth.POST("/stories", s.createStory)
 			auth.POST("/stories/batch-delete", s.batchDeleteStories)
 			auth.POST("/stories/batch-move", s.batchMoveStories)
 			auth.POST("/stories/batch-background", s.batchUpdateBackgroundColor)
+			auth.POST("/stories/merge", s.mergeSceneStories)
+			auth.GET("/stories/search", s.searchStoryEntries)
+			auth.GET("/stories/stats", s.getMyStoryStats)
 			auth.GET("/stories/:id", s.getStory)
+			auth.GET("/stories/:id/export", s.exportStory)
+			auth.GET("/stories/:id/stats", s.getStoryStats)
 			auth.PUT("/stories/:id", s.updateStory)
 			auth.DELETE("/stories/:id", s.deleteStory)
 			auth.POST("/stories/:id/entries", s.addStoryEntries)
 			auth.POST("/stories/:id/entries/batch-background", s.batchUpdateEntryBackgroundColor)
 			auth.POST("/stories/:id/entries/batch-delete", s.batchDeleteEntries)
 			auth.POST("/stories/:id/entries/archive", s.archiveEntriesToStory)
+			auth.POST("/stories/:id/import/preview", s.previewStoryImport)
+			auth.POST("/stories/:id/import", s.importStoryEntries)
+			auth.GET("/stories/:id/sessions", s.proposeStorySessions)
+			auth.POST("/stories/:id/split", s.splitStory)
 			auth.PUT("/stories/:id/entries/:entryId", s.updateStoryEntry)
 			auth.DELETE("/stories/:id/entries/:entryId", s.deleteStoryEntry)
 			auth.POST("/stories/:id/publish", s.publishStory)
+			auth.POST("/stories/:id/share-code/regenerate", s.regenerateStoryShareCode)
+			auth.GET("/stories/:id/share-links", s.listStoryShareLinks)
+			auth.POST("/stories/:id/share-links", s.createStoryShareLink)
+			auth.PUT("/stories/:id/share-links/:linkId", s.updateStoryShareLink)
+			auth.POST("/stories/:id/share-links/:linkId/regenerate", s.regenerateStoryShareLink)
+			auth.DELETE("/stories/:id/share-links/:linkId", s.revokeStoryShareLink)
+			auth.GET("/stories/:id/share-analytics", s.getStoryShareAnalytics)
+			auth.GET("/stories/:id/collaborators", s.listStoryCollaborators)
+			auth.POST("/stories/:id/collaborators", s.addStoryCollaborator)
+			auth.PUT("/stories/:id/collaborators/:userId", s.updateStoryCollaborator)
+			auth.DELETE("/stories/:id/collaborators/:userId", s.removeStoryCollaborator)
+			auth.GET("/stories/:id/activities", s.listStoryActivities)
+			auth.GET("/stories/:id/history", s.listStoryHistory)
+			auth.POST("/stories/:id/history/undo", s.undoStoryBatch)
+			auth.POST("/stories/:id/history/:revisionId/restore", s.restoreStoryRevision)
+			auth.GET("/stories/:id/annotations", s.listStoryAnnotations)
+			auth.POST("/stories/:id/entries/:entryId/annotations", s.createStoryAnnotation)
+			auth.PUT("/stories/:id/annotations/:annotationId", s.updateStoryAnnotation)
+			auth.DELETE("/stories/:id/annotations/:annotationId", s.deleteStoryAnnotation)
+			auth.GET("/stories/:id/chapters", s.listStoryChapters)
+			auth.POST("/stories/:id/chapters", s.createStoryChapter)
+			auth.PUT("/stories/:id/chapters/order", s.reorderStoryChapters)
+			auth.POST("/stories/:id/chapters/move-entries", s.moveStoryChapterEntries)
+			auth.PUT("/stories/:id/chapters/:chapterId", s.updateStoryChapter)
+			auth.DELETE("/stories/:id/chapters/:chapterId", s.deleteStoryChapter)
+			auth.POST("/stories/:id/chapters/:chapterId/split", s.splitStoryChapter)
+			auth.POST("/stories/:id/chapters/:chapterId/merge", s.mergeStoryChapter)
+			auth.GET("/stories/:id/redaction-requests", s.listStoryRedactionRequests)
+			auth.POST("/stories/:id/redaction-requests", s.createStoryRedactionRequest)
+			auth.DELETE("/stories/:id/redaction-requests/:requestId", s.withdrawStoryRedactionRequest)
+			auth.POST("/stories/:id/redaction-requests/:requestId/review", s.reviewStoryRedactionRequest)
+			auth.GET("/user/story-participations", s.listMyStoryParticipations)
 
 			// 剧情书签
 			auth.GET("/stories/:id/bookmarks", s.listBookmarks)
@@ -115,9 +160,15 @@ func (s *Server) setupRoutes() {
 			// 角色管理
 			auth.GET("/characters", s.listCharacters)
 			auth.POST("/characters", s.createOrUpdateCharacter)
+			auth.POST("/characters/merge", s.mergeCharacters)
+			auth.GET("/characters/merges", s.listCharacterMerges)
+			auth.POST("/characters/merges/:id/undo", s.undoCharacterMerge)
+			auth.GET("/characters/duplicates", s.suggestDuplicateCharacters)
 			auth.GET("/characters/:id", s.getCharacter)
 			auth.PUT("/characters/:id", s.updateCharacter)
 			auth.DELETE("/characters/:id", s.deleteCharacter)
+			auth.POST("/characters/:id/publish", s.publishCharacter)
+			auth.DELETE("/characters/:id/share", s.revokeCharacterShare)
 
 			// 道具市场
 			auth.GET("/items", s.listItems)
@@ -145,12 +196,22 @@ func (s *Server) setupRoutes() {
 			auth.GET("/items/:id/images", s.listItemImages)
 			auth.DELETE("/items/:id/images/:imageId", s.deleteItemImage)
 
-			// 账号备份（以账号为单位）
+			// 账号备份（以账号为单位），只有上传备份的接口接受压缩请求体
+			decompress := middleware.DecompressBody(maxDecompressedBodyBytes(s.cfg))
 			auth.GET("/account-backups", s.listAccountBackups)
 			auth.GET("/account-backups/:account_id", s.getAccountBackup)
-			auth.POST("/account-backups", s.upsertAccountBackup)
+			auth.POST("/account-backups", decompress, s.upsertAccountBackup)
+			auth.POST("/account-backups/:account_id/resolve", decompress, s.resolveAccountBackupConflict)
 			auth.DELETE("/account-backups/:account_id", s.deleteAccountBackup)
 			auth.GET("/account-backups/:account_id/versions", s.getAccountBackupVersions)
+			auth.POST("/account-backups/:account_id/versions/:version/restore", s.restoreAccountBackupVersion)
+			auth.GET("/account-backups/:account_id/versions/:version/download", s.downloadAccountBackupVersion)
+			auth.GET("/account-backups/:account_id/download", s.downloadAccountBackup)
+			auth.POST("/account-backup-uploads", s.createAccountBackupUpload)
+			auth.GET("/account-backup-uploads/:upload_id", s.getAccountBackupUpload)
+			auth.PUT("/account-backup-uploads/:upload_id/chunks/:index", s.putAccountBackupUploadChunk)
+			auth.POST("/account-backup-uploads/:upload_id/complete", s.completeAccountBackupUpload)
+			auth.DELETE("/account-backup-uploads/:upload_id", s.deleteAccountBackupUploadHandler)
 
 			// 标签管理
 			auth.GET("/tags", s.listTags)
@@ -195,6 +256,7 @@ func (s *Server) setupRoutes() {
 
 			// 公会剧情归档
 			auth.GET("/guilds/:id/stories", s.listGuildStories)
+			auth.GET("/guilds/:id/story-stats", s.getGuildStoryStats)
 			auth.POST("/guilds/:id/stories/:storyId", s.archiveStoryToGuild)
 			auth.DELETE("/guilds/:id/stories/:storyId", s.removeStoryFromGuild)
 
@@ -27,6 +27,9 @@ type Server struct {
 	ossBucket           *oss.Bucket
 	ossInitOnce         sync.Once
 	ossInitErr          error
+
+	// sharePasswordLimiter 按分享链接与 IP 限制访问密码的尝试次数
+	sharePasswordLimiter *middleware.KeyedRateLimiter
 }
 
 func NewServer(cfg *config.Config) *Server {
@@ -88,6 +91,8 @@ func NewServer(cfg *config.Config) *Server {
 		emailClient:         emailClient,
 		verificationService: verificationService,
 		cache:               cacheClient,
+
+		sharePasswordLimiter: middleware.NewKeyedRateLimiter(storySharePasswordRPS, storySharePasswordBurst),
 	}
 
 	// 设置通知服务的 Hub 引用
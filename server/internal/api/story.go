@@ -1,7 +1,11 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
@@ -14,6 +18,7 @@ import (
 	"github.com/rpbox/server/internal/service"
 	"github.com/rpbox/server/pkg/validator"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateStoryRequest 创建剧情请求
@@ -49,9 +54,15 @@ func (s *Server) listStories(c *gin.Context) {
 	// 构建查询
 	// 如果指定了guild_id，则查询公会剧情（需要检查访问权限）
 	// 否则只查询当前用户的剧情（私有访问）
+	// shared=true 时查询我参与协作的剧情
 	query := database.DB.Model(&model.Story{})
 	if c.Query("guild_id") == "" {
-		query = query.Where("user_id = ?", userID)
+		if c.Query("shared") == "true" {
+			query = query.Where("id IN (?)",
+				database.DB.Model(&model.StoryCollaborator{}).Select("story_id").Where("user_id = ?", userID))
+		} else {
+			query = query.Where("user_id = ?", userID)
+		}
 	}
 
 	// 标签筛选
@@ -346,36 +357,51 @@ func (s *Server) getStory(c *gin.Context) {
 		return
 	}
 
-	// 权限检查：公会剧情公开访问，个人剧情只有作者可见
+	// 权限检查：公会剧情公开访问，个人剧情只有作者和协作者可见
 	var guildCount int64
 	database.DB.Model(&model.StoryGuild{}).Where("story_id = ?", id).Count(&guildCount)
 
 	isGuildStory := guildCount > 0
-	isOwner := story.UserID == userID
+	role := storyRole(&story, userID)
 
-	if !isGuildStory && !isOwner {
+	if !isGuildStory && role == "" {
 		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
 		return
 	}
 
-	// 获取剧情条目
-	var entries []model.StoryEntry
-	database.DB.Where("story_id = ?", id).Order("timestamp, sort_order").Find(&entries)
+	if c.Query("format") == storyEntryFormatNDJSON {
+		s.streamStoryEntries(c, story.ID, gin.H{"story": story, "role": role}, nil)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"story":   story,
-		"entries": entries,
-	})
+	// 获取剧情条目，有章节时按章节顺序排列；带分页参数时只返回一页
+	chapters, entries, page, ok := loadStoryEntries(c, story.ID)
+	if !ok {
+		return
+	}
+
+	// 角色按条目时间点的版本展示
+	charactersMap, versionsMap := s.loadStoryCharacters(c, entries)
+
+	body := gin.H{
+		"story":              story,
+		"entries":            entries,
+		"chapters":           chapters,
+		"characters":         charactersMap,
+		"character_versions": versionsMap,
+		"role":               role,
+		"annotation_counts":  storyAnnotationCounts(&story, userID),
+	}
+	if page != nil {
+		body["page"] = page
+	}
+	respondStoryWithETag(c, body, nil)
 }
 
 func (s *Server) updateStory(c *gin.Context) {
 	userID := c.GetUint("userID")
-	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
 		return
 	}
 
@@ -402,10 +428,11 @@ func (s *Server) updateStory(c *gin.Context) {
 		story.Tags = strings.Join(req.Tags, ",")
 	}
 
-	if err := database.DB.Save(&story).Error; err != nil {
+	if err := database.DB.Save(story).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 		return
 	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionStoryUpdate, 0, 0)
 
 	c.JSON(http.StatusOK, story)
 }
@@ -423,8 +450,20 @@ func (s *Server) deleteStory(c *gin.Context) {
 
 	// 删除剧情条目
 	database.DB.Where("story_id = ?", id).Delete(&model.StoryEntry{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryEntryRevision{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryAnnotation{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryParticipant{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryRedactionRequest{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryChapter{})
 	// 删除剧情标签关联
 	database.DB.Where("story_id = ?", id).Delete(&model.StoryTag{})
+	// 删除协作者与变更记录
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryCollaborator{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryActivity{})
+	// 删除分享链接与访问统计
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryShareLink{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryShareVisit{})
+	database.DB.Where("story_id = ?", id).Delete(&model.StoryShareReferrer{})
 	// 删除剧情
 	database.DB.Delete(&story)
 
@@ -464,8 +503,20 @@ func (s *Server) batchDeleteStories(c *gin.Context) {
 
 	// 删除剧情条目
 	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryEntry{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryEntryRevision{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryAnnotation{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryParticipant{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryRedactionRequest{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryChapter{})
 	// 删除剧情标签关联
 	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryTag{})
+	// 删除协作者与变更记录
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryCollaborator{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryActivity{})
+	// 删除分享链接与访问统计
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryShareLink{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryShareVisit{})
+	database.DB.Where("story_id IN ?", req.IDs).Delete(&model.StoryShareReferrer{})
 	// 删除剧情
 	database.DB.Where("id IN ? AND user_id = ?", req.IDs, userID).Delete(&model.Story{})
 
@@ -512,27 +563,66 @@ func (s *Server) batchMoveStories(c *gin.Context) {
 		return
 	}
 
-	// 获取目标剧情当前最大排序号
-	var maxOrder int
-	database.DB.Model(&model.StoryEntry{}).
-		Where("story_id = ?", req.TargetID).
-		Select("COALESCE(MAX(sort_order), 0)").
-		Scan(&maxOrder)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 来源ID与目标剧情（或其他源剧情）重复的条目先清空来源ID
+		var sourced []model.StoryEntry
+		if err := tx.Select("id", "source_id").Where("story_id IN ? AND source_id <> ''", req.SourceIDs).
+			Order("id ASC").Find(&sourced).Error; err != nil {
+			return err
+		}
+		if err := releaseDuplicateStorySourceIDs(tx, req.TargetID, sourced); err != nil {
+			return err
+		}
+		var released []uint
+		for _, entry := range sourced {
+			if entry.SourceID == "" {
+				released = append(released, entry.ID)
+			}
+		}
+		for start := 0; start < len(released); start += storyEntryIDChunk {
+			if err := tx.Model(&model.StoryEntry{}).Where("id IN ?", released[start:min(start+storyEntryIDChunk, len(released))]).
+				Update("source_id", "").Error; err != nil {
+				return err
+			}
+		}
 
-	// 移动所有条目到目标剧情
-	database.DB.Model(&model.StoryEntry{}).
-		Where("story_id IN ?", req.SourceIDs).
-		Update("story_id", req.TargetID)
+		// 移动所有条目到目标剧情（源剧情的章节不随之移动）
+		if err := tx.Model(&model.StoryEntry{}).
+			Where("story_id IN ?", req.SourceIDs).
+			Updates(map[string]interface{}{"story_id": req.TargetID, "chapter_id": nil}).Error; err != nil {
+			return err
+		}
+		// 书签、批注与修订历史随条目进入目标剧情
+		for _, row := range []interface{}{&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryEntryRevision{}} {
+			if err := tx.Model(row).Where("story_id IN ?", req.SourceIDs).Update("story_id", req.TargetID).Error; err != nil {
+				return err
+			}
+		}
 
-	// 更新排序号（简单处理：按原顺序追加）
-	var entries []model.StoryEntry
-	database.DB.Where("story_id = ? AND sort_order <= ?", req.TargetID, maxOrder).
-		Order("sort_order").Find(&entries)
+		// 参与者已批准的隐去随条目进入目标剧情
+		if err := copyStoryConsent(tx, req.SourceIDs, req.TargetID); err != nil {
+			return err
+		}
 
-	// 删除源剧情的标签关联
-	database.DB.Where("story_id IN ?", req.SourceIDs).Delete(&model.StoryTag{})
-	// 删除源剧情
-	database.DB.Where("id IN ? AND user_id = ?", req.SourceIDs, userID).Delete(&model.Story{})
+		// 删除源剧情的标签、章节与参与者记录；协作者、变更记录、分享链接与访问统计不迁移，与删除剧情一致
+		for _, row := range []interface{}{
+			&model.StoryTag{}, &model.StoryChapter{},
+			&model.StoryParticipant{}, &model.StoryRedactionRequest{},
+			&model.StoryCollaborator{}, &model.StoryActivity{},
+			&model.StoryShareLink{}, &model.StoryShareVisit{}, &model.StoryShareReferrer{},
+		} {
+			if err := tx.Where("story_id IN ?", req.SourceIDs).Delete(row).Error; err != nil {
+				return err
+			}
+		}
+		// 条目全部迁移后才删除源剧情
+		return tx.Where("id IN ? AND user_id = ?", req.SourceIDs, userID).Delete(&model.Story{}).Error
+	})
+	if err != nil {
+		log.Printf("[Story] batch move error: target=%d err=%v", req.TargetID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "移动失败"})
+		return
+	}
 
 	// 更新目标剧情的时间范围
 	type storyEntryStats struct {
@@ -608,15 +698,13 @@ type BatchUpdateEntryGroupRequest struct {
 // batchUpdateEntryBackgroundColor 批量更新剧情条目背景色
 func (s *Server) batchUpdateEntryBackgroundColor(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 
-	// 验证剧情属于当前用户
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", storyID, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	// 编组调整影响全部条目，需要编辑权限
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
 	var req BatchUpdateEntryGroupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -629,15 +717,34 @@ func (s *Server) batchUpdateEntryBackgroundColor(c *gin.Context) {
 		return
 	}
 
-	// 更新编组信息（背景色和组名）
-	database.DB.Model(&model.StoryEntry{}).
-		Where("id IN ? AND story_id = ?", req.EntryIDs, storyID).
-		Updates(map[string]interface{}{
-			"background_color": req.BackgroundColor,
-			"group_name":       req.GroupName,
-		})
+	// 更新编组信息（背景色和组名），修改前的状态记入历史以便撤销
+	batchID := newStoryHistoryBatchID()
+	var updated int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var entries []model.StoryEntry
+		if err := tx.Where("id IN ? AND story_id = ?", req.EntryIDs, storyID).Find(&entries).Error; err != nil {
+			return err
+		}
+		if err := recordStoryEntryRevisions(tx, userID, storyRevisionUpdate, batchID, entries, []string{"background_color", "group_name"}); err != nil {
+			return err
+		}
+		result := tx.Model(&model.StoryEntry{}).
+			Where("id IN ? AND story_id = ?", req.EntryIDs, storyID).
+			Updates(map[string]interface{}{
+				"background_color": req.BackgroundColor,
+				"group_name":       req.GroupName,
+				"updated_by":       userID,
+			})
+		updated = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	recordStoryActivity(database.DB, storyID, userID, storyActionEntryUpdate, 0, int(updated))
 
-	c.JSON(http.StatusOK, gin.H{"message": "更新成功", "count": len(req.EntryIDs)})
+	c.JSON(http.StatusOK, gin.H{"message": "更新成功", "count": len(req.EntryIDs), "batch_id": batchID})
 }
 
 // BatchDeleteEntriesRequest 批量删除条目请求
@@ -648,15 +755,11 @@ type BatchDeleteEntriesRequest struct {
 // batchDeleteEntries 批量删除剧情条目
 func (s *Server) batchDeleteEntries(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-
-	// 验证剧情属于当前用户
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", storyID, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
 	var req BatchDeleteEntriesRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -669,14 +772,44 @@ func (s *Server) batchDeleteEntries(c *gin.Context) {
 		return
 	}
 
-	// 删除条目
-	result := database.DB.Where("id IN ? AND story_id = ?", req.EntryIDs, storyID).
-		Delete(&model.StoryEntry{})
+	// 删除条目，贡献者只能删除自己添加的条目；删除前的条目记入历史，可恢复或整批撤销
+	batchID := newStoryHistoryBatchID()
+	var deleted int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("id IN ? AND story_id = ?", req.EntryIDs, storyID)
+		if !storyRoleAtLeast(role, storyRoleEditor) {
+			query = query.Where("created_by = ?", userID)
+		}
+		var entries []model.StoryEntry
+		if err := query.Find(&entries).Error; err != nil {
+			return err
+		}
+		if err := recordStoryEntryRevisions(tx, userID, storyRevisionDelete, batchID, entries, nil); err != nil {
+			return err
+		}
+		ids := make([]uint, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		for start := 0; start < len(ids); start += storyEntryIDChunk {
+			result := tx.Where("id IN ?", ids[start:min(start+storyEntryIDChunk, len(ids))]).Delete(&model.StoryEntry{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
 
 	// 更新剧情的更新时间
-	database.DB.Model(&story).Update("updated_at", time.Now())
+	database.DB.Model(story).Update("updated_at", time.Now())
+	recordStoryActivity(database.DB, storyID, userID, storyActionEntryDelete, 0, int(deleted))
 
-	c.JSON(http.StatusOK, gin.H{"message": "删除成功", "count": result.RowsAffected})
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功", "count": deleted, "batch_id": batchID})
 }
 
 // ArchiveEntriesRequest 归档条目请求
@@ -732,46 +865,89 @@ func (s *Server) archiveEntriesToStory(c *gin.Context) {
 		return
 	}
 
-	// 获取目标剧情的所有条目，按时间排序
-	var targetEntries []model.StoryEntry
-	database.DB.Where("story_id = ?", req.TargetID).Order("timestamp ASC").Find(&targetEntries)
-
-	if req.Mode == "copy" {
-		// 复制模式：创建新条目
-		for _, entry := range entries {
-			newEntry := model.StoryEntry{
-				StoryID:         req.TargetID,
-				SourceID:        entry.SourceID,
-				Type:            entry.Type,
-				CharacterID:     entry.CharacterID,
-				Speaker:         entry.Speaker,
-				Content:         entry.Content,
-				Channel:         entry.Channel,
-				Timestamp:       entry.Timestamp,
-				BackgroundColor: entry.BackgroundColor,
-			}
-			database.DB.Create(&newEntry)
-			targetEntries = append(targetEntries, newEntry)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 获取目标剧情的所有条目，按时间排序
+		var targetEntries []model.StoryEntry
+		if err := tx.Where("story_id = ?", req.TargetID).Order("timestamp ASC").Find(&targetEntries).Error; err != nil {
+			return err
 		}
-	} else {
-		// 移动模式：更新条目的story_id
-		for _, entry := range entries {
-			database.DB.Model(&entry).Update("story_id", req.TargetID)
-			entry.StoryID = req.TargetID
-			targetEntries = append(targetEntries, entry)
+
+		// 目标剧情已有的来源ID不再随条目带入
+		if err := releaseDuplicateStorySourceIDs(tx, req.TargetID, entries); err != nil {
+			return err
 		}
-		// 更新源剧情的更新时间
-		database.DB.Model(&sourceStory).Update("updated_at", time.Now())
-	}
 
-	// 按时间重新排序所有条目
-	sort.Slice(targetEntries, func(i, j int) bool {
-		return targetEntries[i].Timestamp.Before(targetEntries[j].Timestamp)
-	})
+		if req.Mode == "copy" {
+			// 复制模式：创建新条目
+			for _, entry := range entries {
+				newEntry := model.StoryEntry{
+					StoryID:            req.TargetID,
+					SourceID:           entry.SourceID,
+					ContentHash:        entry.ContentHash,
+					SearchText:         entry.SearchText,
+					MergeSources:       entry.MergeSources,
+					Type:               entry.Type,
+					CharacterID:        entry.CharacterID,
+					CharacterVersionID: entry.CharacterVersionID,
+					Speaker:            entry.Speaker,
+					Content:            entry.Content,
+					Channel:            entry.Channel,
+					Timestamp:          entry.Timestamp,
+					BackgroundColor:    entry.BackgroundColor,
+					CreatedBy:          userID,
+					UpdatedBy:          userID,
+				}
+				if err := tx.Create(&newEntry).Error; err != nil {
+					return err
+				}
+				targetEntries = append(targetEntries, newEntry)
+			}
+		} else {
+			// 移动模式：更新条目的story_id
+			movedIDs := make([]uint, 0, len(entries))
+			for _, entry := range entries {
+				if err := tx.Model(&entry).Updates(map[string]interface{}{"story_id": req.TargetID, "chapter_id": nil, "source_id": entry.SourceID}).Error; err != nil {
+					return err
+				}
+				entry.StoryID = req.TargetID
+				entry.ChapterID = nil
+				targetEntries = append(targetEntries, entry)
+				movedIDs = append(movedIDs, entry.ID)
+			}
+			// 书签、批注与修订历史随条目进入目标剧情
+			for start := 0; start < len(movedIDs); start += storyEntryIDChunk {
+				chunk := movedIDs[start:min(start+storyEntryIDChunk, len(movedIDs))]
+				for _, row := range []interface{}{&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryEntryRevision{}} {
+					if err := tx.Model(row).Where("story_id = ? AND entry_id IN ?", storyID, chunk).
+						Update("story_id", req.TargetID).Error; err != nil {
+						return err
+					}
+				}
+			}
+			// 更新源剧情的更新时间
+			if err := tx.Model(&sourceStory).Update("updated_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+
+		// 按时间重新排序所有条目
+		sort.Slice(targetEntries, func(i, j int) bool {
+			return targetEntries[i].Timestamp.Before(targetEntries[j].Timestamp)
+		})
 
-	// 更新所有条目的 sort_order
-	for i, entry := range targetEntries {
-		database.DB.Model(&model.StoryEntry{}).Where("id = ?", entry.ID).Update("sort_order", i+1)
+		// 更新所有条目的 sort_order
+		for i, entry := range targetEntries {
+			if err := tx.Model(&model.StoryEntry{}).Where("id = ?", entry.ID).Update("sort_order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		// 参与者已批准的隐去随条目进入目标剧情
+		return copyStoryConsent(tx, []uint{uint(storyID)}, req.TargetID)
+	})
+	if err != nil {
+		log.Printf("[Story] archive entries error: story=%d target=%d err=%v", storyID, req.TargetID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "归档失败"})
+		return
 	}
 
 	// 更新目标剧情的更新时间
@@ -782,12 +958,8 @@ func (s *Server) archiveEntriesToStory(c *gin.Context) {
 
 func (s *Server) addStoryEntries(c *gin.Context) {
 	userID := c.GetUint("userID")
-	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
 		return
 	}
 
@@ -801,8 +973,58 @@ func (s *Server) addStoryEntries(c *gin.Context) {
 		return
 	}
 
+	result, err := insertStoryEntries(userID, role, story, entries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "添加成功",
+		"count":    result.Inserted,
+		"inserted": result.Inserted,
+		"skipped":  result.Skipped,
+		"updated":  result.Updated,
+		"denied":   result.Denied,
+		"batch_id": result.BatchID,
+	})
+}
+
+// storyEntryIngestResult 条目写入结果，客户端可据此判断重传是否安全
+type storyEntryIngestResult struct {
+	Inserted int `json:"inserted"` // 新增条目数
+	Skipped  int `json:"skipped"`  // 已存在而跳过的条目数
+	Updated  int `json:"updated"`  // 按来源ID命中且内容有变化而更新的条目数
+	Denied   int `json:"denied"`   // 按来源ID命中但上传者无权修改而跳过的条目数
+
+	BatchID string `json:"batch_id,omitempty"` // 重传覆盖条目时记录修订的批次号，可据此撤销
+}
+
+// storyEntryIDChunk 按来源ID/指纹批量查询时每次 IN 的数量
+const storyEntryIDChunk = 500
+
+// insertStoryEntries 在一个事务中把条目追加到剧情末尾：关联角色版本、补全剧情起止时间并记录归档活跃度。
+// 写入是幂等的：来源ID在同一剧情内唯一，已存在则内容相同时跳过、不同时更新（上传者无权修改该条目时跳过）；
+// 没有来源ID的条目按内容指纹去重，重复上传同一段记录不会产生重复条目
+func insertStoryEntries(userID uint, role string, story *model.Story, entries []CreateStoryEntryRequest) (storyEntryIngestResult, error) {
+	id := story.ID
 	now := time.Now()
-	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+	var result storyEntryIngestResult
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result = storyEntryIngestResult{}
+		batchID := newStoryHistoryBatchID()
+
+		// 锁定剧情，同一剧情的并发上传串行执行，保证去重判断可靠
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").First(&model.Story{}, id).Error; err != nil {
+			return err
+		}
+
+		bySource, hashes, err := loadExistingStoryEntryKeys(tx, id, entries)
+		if err != nil {
+			return err
+		}
+
 		// 获取当前最大排序号
 		var maxOrder int
 		if err := tx.Model(&model.StoryEntry{}).
@@ -814,43 +1036,129 @@ func (s *Server) addStoryEntries(c *gin.Context) {
 
 		var minTimestamp *time.Time
 		var maxTimestamp *time.Time
-		for i, req := range entries {
-			var characterID *uint
-
-			// 如果有角色信息，查找或创建角色
-			if req.RefID != "" || req.GameID != "" {
-				character := findOrCreateCharacter(userID, req.RefID, req.GameID, req.TRP3Data, req.IsNPC)
-				if character != nil {
-					characterID = &character.ID
-				}
+		trackTimestamp := func(t time.Time) {
+			if t.IsZero() {
+				return
+			}
+			if minTimestamp == nil || t.Before(*minTimestamp) {
+				ts := t
+				minTimestamp = &ts
 			}
+			if maxTimestamp == nil || t.After(*maxTimestamp) {
+				ts := t
+				maxTimestamp = &ts
+			}
+		}
 
+		for _, req := range entries {
 			entry := model.StoryEntry{
-				StoryID:     uint(id),
-				SourceID:    req.SourceID,
-				Type:        req.Type,
-				CharacterID: characterID,
-				Speaker:     req.Speaker,
-				Content:     req.Content,
-				Channel:     req.Channel,
-				SortOrder:   maxOrder + i + 1,
+				StoryID:   id,
+				SourceID:  req.SourceID,
+				Type:      req.Type,
+				Speaker:   req.Speaker,
+				Content:   req.Content,
+				Channel:   req.Channel,
+				CreatedBy: userID,
+				UpdatedBy: userID,
+			}
+			if entry.Type == "" {
+				entry.Type = "dialogue"
 			}
 			if req.Timestamp != "" {
 				if t, err := time.Parse(time.RFC3339, req.Timestamp); err == nil {
 					entry.Timestamp = t
-					if minTimestamp == nil || t.Before(*minTimestamp) {
-						ts := t
-						minTimestamp = &ts
-					}
-					if maxTimestamp == nil || t.After(*maxTimestamp) {
-						ts := t
-						maxTimestamp = &ts
-					}
 				}
 			}
+			entry.ContentHash = storyEntryContentHash(&entry)
+			entry.SearchText = entry.SearchDocument()
+
+			var existing *model.StoryEntry
+			if entry.SourceID != "" {
+				existing = bySource[entry.SourceID]
+				if existing != nil && existing.ContentHash == entry.ContentHash {
+					result.Skipped++
+					continue
+				}
+				// 协作者只能通过重传修改自己添加的条目
+				if existing != nil && !canModifyStoryEntry(role, userID, existing) {
+					result.Denied++
+					continue
+				}
+			} else {
+				if _, dup := hashes[entry.ContentHash]; dup {
+					result.Skipped++
+					continue
+				}
+				hashes[entry.ContentHash] = struct{}{}
+			}
+
+			// 如果有角色信息，查找或创建角色，并关联条目时间点的角色版本
+			if req.RefID != "" || req.GameID != "" {
+				character, version := findOrCreateCharacter(tx, userID, req.RefID, req.GameID, req.TRP3Data, req.IsNPC, entry.Timestamp)
+				if character != nil {
+					entry.CharacterID = &character.ID
+				}
+				if version != nil {
+					entry.CharacterVersionID = &version.ID
+				}
+			}
+
+			if existing != nil {
+				// 同一来源的记录被修正后重传，保留原条目的位置、编组与背景色，只更新内容
+				updates := map[string]interface{}{
+					"type":         entry.Type,
+					"speaker":      entry.Speaker,
+					"content":      entry.Content,
+					"channel":      entry.Channel,
+					"timestamp":    entry.Timestamp,
+					"content_hash": entry.ContentHash,
+					"search_text":  entry.SearchText,
+					"updated_by":   userID,
+				}
+				if entry.CharacterID != nil {
+					updates["character_id"] = entry.CharacterID
+					updates["character_version_id"] = entry.CharacterVersionID
+				}
+				after := *existing
+				after.Type, after.Speaker, after.Content, after.Channel = entry.Type, entry.Speaker, entry.Content, entry.Channel
+				after.Timestamp, after.ContentHash, after.SearchText, after.UpdatedBy = entry.Timestamp, entry.ContentHash, entry.SearchText, userID
+				if entry.CharacterID != nil {
+					after.CharacterID, after.CharacterVersionID = entry.CharacterID, entry.CharacterVersionID
+				}
+				// 覆盖前保存快照，整次上传共享一个批次号，可在历史中一并撤销
+				if err := recordStoryEntryRevisions(tx, userID, storyRevisionUpdate, batchID, []model.StoryEntry{*existing}, changedStoryEntryFields(existing, &after)); err != nil {
+					return err
+				}
+				if err := tx.Model(existing).Updates(updates).Error; err != nil {
+					return err
+				}
+				*existing = after
+				trackTimestamp(entry.Timestamp)
+				result.Updated++
+				result.BatchID = batchID
+				continue
+			}
+
+			maxOrder++
+			entry.SortOrder = maxOrder
 			if err := tx.Create(&entry).Error; err != nil {
 				return err
 			}
+			if entry.SourceID != "" {
+				bySource[entry.SourceID] = &entry
+			}
+			trackTimestamp(entry.Timestamp)
+			result.Inserted++
+		}
+
+		if result.Inserted == 0 && result.Updated == 0 {
+			return nil
+		}
+		if result.Inserted > 0 {
+			recordStoryActivity(tx, id, userID, storyActionEntryAdd, 0, result.Inserted)
+		}
+		if result.Updated > 0 {
+			recordStoryActivity(tx, id, userID, storyActionEntryUpdate, 0, result.Updated)
 		}
 
 		updates := map[string]interface{}{
@@ -884,30 +1192,140 @@ func (s *Server) addStoryEntries(c *gin.Context) {
 			}
 		}
 
-		if err := tx.Model(&story).Updates(updates).Error; err != nil {
+		if err := tx.Model(story).Updates(updates).Error; err != nil {
 			return err
 		}
-		if _, err := service.ApplyStoryArchiveProgress(tx, userID, len(entries), now); err != nil {
+		if _, err := service.ApplyStoryArchiveProgress(tx, userID, result.Inserted, now); err != nil {
 			return err
 		}
 		return nil
-	}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加失败"})
-		return
+	})
+	if err != nil {
+		return storyEntryIngestResult{}, err
 	}
+	return result, nil
+}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "添加成功", "count": len(entries)})
+// releaseDuplicateStorySourceIDs 条目复制或移动到目标剧情前，清空目标剧情中已存在（或本批中重复）的来源ID，
+// 保证来源ID在剧情内唯一；条目内容保留，只是不再对应原聊天记录
+func releaseDuplicateStorySourceIDs(tx *gorm.DB, storyID uint, entries []model.StoryEntry) error {
+	sourceIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.SourceID != "" {
+			sourceIDs = append(sourceIDs, entry.SourceID)
+		}
+	}
+	taken := make(map[string]bool, len(sourceIDs))
+	for start := 0; start < len(sourceIDs); start += storyEntryIDChunk {
+		var found []string
+		if err := tx.Model(&model.StoryEntry{}).
+			Where("story_id = ? AND source_id IN ?", storyID, sourceIDs[start:min(start+storyEntryIDChunk, len(sourceIDs))]).
+			Pluck("source_id", &found).Error; err != nil {
+			return err
+		}
+		for _, sourceID := range found {
+			taken[sourceID] = true
+		}
+	}
+	for i := range entries {
+		if entries[i].SourceID == "" {
+			continue
+		}
+		if taken[entries[i].SourceID] {
+			entries[i].SourceID = ""
+			continue
+		}
+		taken[entries[i].SourceID] = true
+	}
+	return nil
+}
+
+// loadExistingStoryEntryKeys 查出本批条目可能命中的已有条目：按来源ID索引的条目，以及剧情内已有的内容指纹。
+// 旧数据没有指纹，首次遇到无来源ID的上传时为该剧情补齐
+func loadExistingStoryEntryKeys(tx *gorm.DB, storyID uint, entries []CreateStoryEntryRequest) (map[string]*model.StoryEntry, map[string]struct{}, error) {
+	bySource := make(map[string]*model.StoryEntry)
+	hashes := make(map[string]struct{})
+
+	sourceIDs := make([]string, 0, len(entries))
+	needHashes := false
+	for _, req := range entries {
+		if req.SourceID != "" {
+			sourceIDs = append(sourceIDs, req.SourceID)
+		} else {
+			needHashes = true
+		}
+	}
+
+	for start := 0; start < len(sourceIDs); start += storyEntryIDChunk {
+		end := min(start+storyEntryIDChunk, len(sourceIDs))
+		var found []model.StoryEntry
+		if err := tx.Where("story_id = ? AND source_id IN ?", storyID, sourceIDs[start:end]).
+			Order("id ASC").Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		for i := range found {
+			entry := &found[i]
+			if _, ok := bySource[entry.SourceID]; ok {
+				continue
+			}
+			if entry.ContentHash == "" {
+				entry.ContentHash = storyEntryContentHash(entry)
+			}
+			bySource[entry.SourceID] = entry
+		}
+	}
+
+	if !needHashes {
+		return bySource, hashes, nil
+	}
+
+	var legacy []model.StoryEntry
+	if err := tx.Select("id", "type", "speaker", "content", "channel", "timestamp").
+		Where("story_id = ? AND (content_hash = '' OR content_hash IS NULL)", storyID).
+		Find(&legacy).Error; err != nil {
+		return nil, nil, err
+	}
+	for i := range legacy {
+		hash := storyEntryContentHash(&legacy[i])
+		if err := tx.Model(&model.StoryEntry{}).Where("id = ?", legacy[i].ID).
+			Update("content_hash", hash).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var existing []string
+	if err := tx.Model(&model.StoryEntry{}).
+		Where("story_id = ?", storyID).
+		Distinct().Pluck("content_hash", &existing).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, hash := range existing {
+		hashes[hash] = struct{}{}
+	}
+	return bySource, hashes, nil
+}
+
+// storyEntryContentHash 条目内容指纹：类型、频道、说话者、时间（精确到毫秒）与内容
+func storyEntryContentHash(entry *model.StoryEntry) string {
+	entryType := entry.Type
+	if entryType == "" {
+		entryType = "dialogue"
+	}
+	timestamp := ""
+	if !entry.Timestamp.IsZero() {
+		timestamp = entry.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		entryType, strings.ToUpper(entry.Channel), entry.Speaker, timestamp, entry.Content,
+	}, "\x1f")))
+	return hex.EncodeToString(sum[:])
 }
 
 // publishStory 发布/取消发布剧情
 func (s *Server) publishStory(c *gin.Context) {
 	userID := c.GetUint("userID")
-	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
 		return
 	}
 
@@ -921,68 +1339,102 @@ func (s *Server) publishStory(c *gin.Context) {
 
 	story.IsPublic = req.IsPublic
 	if req.IsPublic && story.ShareCode == "" {
-		story.ShareCode = generateShareCode()
+		code, err := generateStoryShareCode()
+		if err != nil {
+			log.Printf("[Story] share code error: story=%d err=%v", story.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "发布失败"})
+			return
+		}
+		story.ShareCode = code
 	}
 
-	database.DB.Save(&story)
+	database.DB.Save(story)
+	action := storyActionUnpublish
+	if req.IsPublic {
+		action = storyActionPublish
+	}
+	recordStoryActivity(database.DB, story.ID, userID, action, 0, 0)
+	if req.IsPublic {
+		// 通知剧情中出现的其他 RPBox 用户，他们可以申请隐去或匿名自己的发言
+		notifyStoryParticipants(story, userID)
+	}
 	c.JSON(http.StatusOK, story)
 }
 
-// getPublicStory 获取公开剧情（无需登录）
+// getPublicStory 获取公开剧情（无需登录），code 可以是默认分享码或分享链接
 func (s *Server) getPublicStory(c *gin.Context) {
-	code := c.Param("code")
-
-	var story model.Story
-	if err := database.DB.Where("share_code = ? AND is_public = ?", code, true).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在或未公开"})
+	publicStory, link, ok := s.loadPublicStory(c)
+	if !ok {
 		return
 	}
+	story := *publicStory
 
-	// 增加浏览次数
-	database.DB.Model(&story).Update("view_count", story.ViewCount+1)
+	// 增加浏览次数并记录访问统计（分页继续加载不重复计算）
+	if !storyEntryFollowUpPage(c) {
+		s.recordStoryShareView(c, &story, link)
+		story.ViewCount++
+	}
 
-	// 获取条目
-	var entries []model.StoryEntry
-	database.DB.Where("story_id = ?", story.ID).Order("timestamp, sort_order").Find(&entries)
+	// 获取作者信息
+	var user model.User
+	database.DB.First(&user, story.UserID)
 
-	// 收集所有角色ID
-	characterIDs := make([]uint, 0)
-	for _, entry := range entries {
-		if entry.CharacterID != nil {
-			characterIDs = append(characterIDs, *entry.CharacterID)
-		}
+	// 参与者申请并经作者批准的隐去只作用于公开视图
+	redactions := loadStoryRedactions(story.ID)
+	redactStoryParticipants(&story, redactions)
+
+	if c.Query("format") == storyEntryFormatNDJSON {
+		s.streamStoryEntries(c, story.ID, gin.H{"story": story, "author": user.Username}, redactions)
+		return
 	}
 
-	// 获取角色信息
-	charactersMap := make(map[uint]model.Character)
-	if len(characterIDs) > 0 {
-		var characters []model.Character
-		database.DB.Where("id IN ?", characterIDs).Find(&characters)
-		for _, char := range characters {
-			charactersMap[char.ID] = char
-		}
+	// 获取条目，有章节时按章节顺序排列；带分页参数时只返回一页
+	chapters, entries, page, ok := loadStoryEntries(c, story.ID)
+	if !ok {
+		return
 	}
+	applyStoryRedactions(entries, redactions)
 
-	// 获取作者信息
-	var user model.User
-	database.DB.First(&user, story.UserID)
+	// 获取角色信息及条目时间点的角色版本
+	charactersMap, versionsMap := s.loadStoryCharacters(c, entries)
 
-	c.JSON(http.StatusOK, gin.H{
-		"story":      story,
-		"entries":    entries,
-		"characters": charactersMap,
-		"author":     user.Username,
-	})
+	body := gin.H{
+		"story":              story,
+		"entries":            entries,
+		"chapters":           chapters,
+		"characters":         charactersMap,
+		"character_versions": versionsMap,
+		"author":             user.Username,
+	}
+	if page != nil {
+		body["page"] = page
+	}
+	// 浏览次数每次访问都会变化，不计入 ETag
+	etagStory := story
+	etagStory.ViewCount = 0
+	etagBody := gin.H{}
+	for key, value := range body {
+		etagBody[key] = value
+	}
+	etagBody["story"] = etagStory
+	respondStoryWithETag(c, body, etagBody)
 }
 
-// generateShareCode 生成分享码
+// generateShareCode 生成分享码。分享码即访问凭证，使用 crypto/rand 生成，
+// 并丢弃超出字符表整数倍的随机字节以避免取模偏差
 func generateShareCode() string {
 	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	code := make([]byte, 8)
-	for i := range code {
-		code[i] = chars[time.Now().UnixNano()%int64(len(chars))]
-		time.Sleep(time.Nanosecond)
+	const limit = 256 - 256%len(chars)
+	code := make([]byte, 0, 8)
+	buf := make([]byte, 16)
+	for len(code) < cap(code) {
+		// Go 1.24 起 crypto/rand.Read 不会返回错误
+		rand.Read(buf)
+		for _, b := range buf {
+			if int(b) < limit && len(code) < cap(code) {
+				code = append(code, chars[int(b)%len(chars)])
+			}
+		}
 	}
 	return string(code)
 }
@@ -1000,16 +1452,14 @@ type UpdateStoryEntryRequest struct {
 // updateStoryEntry 更新剧情条目
 func (s *Server) updateStoryEntry(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	entryID, _ := strconv.ParseUint(c.Param("entryId"), 10, 32)
 
-	// 验证剧情所有权
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", storyID, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	// 验证剧情权限
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
 	// 查找条目
 	var entry model.StoryEntry
@@ -1018,6 +1468,10 @@ func (s *Server) updateStoryEntry(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
 		return
 	}
+	if !canModifyStoryEntry(role, userID, &entry) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己添加的条目"})
+		return
+	}
 
 	var req UpdateStoryEntryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1025,6 +1479,8 @@ func (s *Server) updateStoryEntry(c *gin.Context) {
 		return
 	}
 
+	before := entry
+
 	// 更新字段
 	if req.Content != "" {
 		entry.Content = req.Content
@@ -1038,20 +1494,43 @@ func (s *Server) updateStoryEntry(c *gin.Context) {
 	if req.Type != "" {
 		entry.Type = req.Type
 	}
+	characterChanged := req.CharacterID != nil && (entry.CharacterID == nil || *entry.CharacterID != *req.CharacterID)
 	if req.CharacterID != nil {
 		entry.CharacterID = req.CharacterID
 	}
 	if req.Timestamp != nil {
 		entry.Timestamp = *req.Timestamp
 	}
+	// 换了角色或改了时间时，重新关联该时间点生效的角色版本
+	if characterChanged || req.Timestamp != nil {
+		entry.CharacterVersionID = nil
+		if entry.CharacterID != nil {
+			if version := characterVersionAt(database.DB, *entry.CharacterID, entry.Timestamp); version != nil {
+				entry.CharacterVersionID = &version.ID
+			}
+		}
+	}
 
-	if err := database.DB.Save(&entry).Error; err != nil {
+	entry.SearchText = entry.SearchDocument()
+	entry.UpdatedBy = userID
+
+	// 修改前的条目记入历史，内容没有变化时不产生修订
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if fields := changedStoryEntryFields(&before, &entry); len(fields) > 0 {
+			if err := recordStoryEntryRevisions(tx, userID, storyRevisionUpdate, newStoryHistoryBatchID(), []model.StoryEntry{before}, fields); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&entry).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
 		return
 	}
 
 	// 更新剧情的更新时间
-	database.DB.Model(&story).Update("updated_at", time.Now())
+	database.DB.Model(story).Update("updated_at", time.Now())
+	recordStoryActivity(database.DB, storyID, userID, storyActionEntryUpdate, entry.ID, 1)
 
 	c.JSON(http.StatusOK, entry)
 }
@@ -1059,27 +1538,41 @@ func (s *Server) updateStoryEntry(c *gin.Context) {
 // deleteStoryEntry 删除剧情条目
 func (s *Server) deleteStoryEntry(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	entryID, _ := strconv.ParseUint(c.Param("entryId"), 10, 32)
 
-	// 验证剧情所有权
-	var story model.Story
-	if err := database.DB.Where("id = ? AND user_id = ?", storyID, userID).
-		First(&story).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+	// 验证剧情权限
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
-	// 删除条目
-	result := database.DB.Where("id = ? AND story_id = ?", entryID, storyID).
-		Delete(&model.StoryEntry{})
-	if result.RowsAffected == 0 {
+	var entry model.StoryEntry
+	if err := database.DB.Where("id = ? AND story_id = ?", entryID, storyID).
+		First(&entry).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
 		return
 	}
+	if !canModifyStoryEntry(role, userID, &entry) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己添加的条目"})
+		return
+	}
+
+	// 删除条目，删除前的条目记入历史
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordStoryEntryRevisions(tx, userID, storyRevisionDelete, newStoryHistoryBatchID(), []model.StoryEntry{entry}, nil); err != nil {
+			return err
+		}
+		return tx.Delete(&entry).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
 
 	// 更新剧情的更新时间
-	database.DB.Model(&story).Update("updated_at", time.Now())
+	database.DB.Model(story).Update("updated_at", time.Now())
+	recordStoryActivity(database.DB, storyID, userID, storyActionEntryDelete, entry.ID, 1)
 
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
@@ -1089,28 +1582,36 @@ func (s *Server) deleteStoryEntry(c *gin.Context) {
 // listBookmarks 获取剧情书签列表
 func (s *Server) listBookmarks(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
 
 	var bookmarks []model.StoryBookmark
 	// 返回：用户自己的书签 + 公共书签（作者/管理员创建的）
 	database.DB.Where("story_id = ? AND (user_id = ? OR is_public = ?)", storyID, userID, true).
 		Order("is_public DESC, created_at ASC").Find(&bookmarks)
+	fillBookmarkChapters(bookmarks)
 
 	c.JSON(http.StatusOK, gin.H{"bookmarks": bookmarks})
 }
 
 // CreateBookmarkRequest 创建书签请求
 type CreateBookmarkRequest struct {
-	EntryID  uint   `json:"entry_id" binding:"required"`
-	Name     string `json:"name" binding:"required"`
-	Color    string `json:"color"`
-	IsPublic bool   `json:"is_public"` // 是否公共书签（需要作者/管理员权限）
+	EntryID   uint   `json:"entry_id"`   // 与 chapter_id 二选一
+	ChapterID uint   `json:"chapter_id"` // 指定章节时书签定位到章节第一条条目
+	Name      string `json:"name" binding:"required"`
+	Color     string `json:"color"`
+	IsPublic  bool   `json:"is_public"` // 是否公共书签（需要作者/管理员权限）
 }
 
 // createBookmark 创建书签
 func (s *Server) createBookmark(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
 
 	var req CreateBookmarkRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1118,6 +1619,19 @@ func (s *Server) createBookmark(c *gin.Context) {
 		return
 	}
 
+	if req.EntryID == 0 && req.ChapterID != 0 {
+		entryID, ok := chapterFirstEntryID(storyID, req.ChapterID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "章节不存在或没有条目"})
+			return
+		}
+		req.EntryID = entryID
+	}
+	if req.EntryID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定条目或章节"})
+		return
+	}
+
 	// 验证条目存在
 	var entry model.StoryEntry
 	if err := database.DB.Where("id = ? AND story_id = ?", req.EntryID, storyID).
@@ -1126,16 +1640,10 @@ func (s *Server) createBookmark(c *gin.Context) {
 		return
 	}
 
-	// 如果要创建公共书签，检查权限（必须是作者或管理员）
+	// 如果要创建公共书签，检查权限（必须是作者、编辑或管理员）
 	isPublic := false
 	if req.IsPublic {
-		var story model.Story
-		if err := database.DB.First(&story, storyID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
-			return
-		}
-		isModerator := checkModerator(userID)
-		if story.UserID != userID && !isModerator {
+		if !canManagePublicBookmarks(storyID, userID) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "只有作者或管理员可以创建公共书签"})
 			return
 		}
@@ -1156,6 +1664,7 @@ func (s *Server) createBookmark(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
 		return
 	}
+	bookmark.ChapterID = entry.ChapterID
 
 	c.JSON(http.StatusCreated, bookmark)
 }
@@ -1163,7 +1672,10 @@ func (s *Server) createBookmark(c *gin.Context) {
 // deleteBookmark 删除书签
 func (s *Server) deleteBookmark(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
 	bookmarkID, _ := strconv.ParseUint(c.Param("bookmarkId"), 10, 32)
 
 	// 先查询书签
@@ -1175,11 +1687,8 @@ func (s *Server) deleteBookmark(c *gin.Context) {
 
 	// 权限检查
 	if bookmark.IsPublic {
-		// 公共书签只有作者或管理员可以删除
-		var story model.Story
-		database.DB.First(&story, storyID)
-		isModerator := checkModerator(userID)
-		if story.UserID != userID && !isModerator {
+		// 公共书签只有作者、编辑或管理员可以删除
+		if !canManagePublicBookmarks(storyID, userID) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "只有作者或管理员可以删除公共书签"})
 			return
 		}
@@ -1198,7 +1707,10 @@ func (s *Server) deleteBookmark(c *gin.Context) {
 // updateLastViewBookmark 更新"上次浏览"书签
 func (s *Server) updateLastViewBookmark(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
 
 	var req struct {
 		EntryID uint `json:"entry_id" binding:"required"`
@@ -1244,7 +1756,10 @@ type UpdateBookmarkRequest struct {
 // updateBookmark 更新书签
 func (s *Server) updateBookmark(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
 	bookmarkID, _ := strconv.ParseUint(c.Param("bookmarkId"), 10, 32)
 
 	var bookmark model.StoryBookmark
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+// 批注可见范围
+const (
+	annotationVisibilityOwner         = "owner"
+	annotationVisibilityCollaborators = "collaborators"
+	annotationVisibilityGuild         = "guild"
+)
+
+// CreateStoryAnnotationRequest 添加批注请求，parent_id 不为空时为回复（可见范围沿用根批注）
+type CreateStoryAnnotationRequest struct {
+	Content    string `json:"content" binding:"required,max=2000"`
+	Visibility string `json:"visibility" binding:"omitempty,oneof=owner collaborators guild"`
+	ParentID   *uint  `json:"parent_id"`
+}
+
+// UpdateStoryAnnotationRequest 修改批注请求
+type UpdateStoryAnnotationRequest struct {
+	Content string `json:"content" binding:"required,max=2000"`
+}
+
+// storyAnnotationAccess 用户在剧情批注中的身份
+type storyAnnotationAccess struct {
+	userID      uint
+	role        string // 剧情角色，见 storyRole
+	guildMember bool   // 是否为剧情归档公会的成员
+}
+
+// loadStoryAnnotationAccess 计算用户对剧情批注的访问身份
+func loadStoryAnnotationAccess(story *model.Story, userID uint) storyAnnotationAccess {
+	access := storyAnnotationAccess{userID: userID, role: storyRole(story, userID)}
+	if userID != 0 && access.role == "" {
+		var count int64
+		database.DB.Model(&model.GuildMember{}).
+			Where("user_id = ? AND guild_id IN (?)", userID,
+				database.DB.Model(&model.StoryGuild{}).Select("guild_id").Where("story_id = ?", story.ID)).
+			Count(&count)
+		access.guildMember = count > 0
+	}
+	return access
+}
+
+// canAnnotate 作者、协作者与归档公会成员可以添加批注
+func (a storyAnnotationAccess) canAnnotate() bool {
+	return a.role != "" || a.guildMember
+}
+
+// visibilities 用户可以看到的批注可见范围（自己写的批注始终可见）
+func (a storyAnnotationAccess) visibilities() []string {
+	switch {
+	case a.role == storyRoleOwner:
+		return []string{annotationVisibilityOwner, annotationVisibilityCollaborators, annotationVisibilityGuild}
+	case a.role != "":
+		return []string{annotationVisibilityCollaborators, annotationVisibilityGuild}
+	case a.guildMember:
+		return []string{annotationVisibilityGuild}
+	}
+	return nil
+}
+
+// canSee 判断用户能否看到某条批注
+func (a storyAnnotationAccess) canSee(annotation *model.StoryAnnotation) bool {
+	if annotation.UserID == a.userID {
+		return true
+	}
+	for _, visibility := range a.visibilities() {
+		if annotation.Visibility == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// scope 在批注查询上附加可见范围条件
+func (a storyAnnotationAccess) scope(query *gorm.DB) *gorm.DB {
+	if visibilities := a.visibilities(); len(visibilities) > 0 {
+		return query.Where("(visibility IN ? OR user_id = ?)", visibilities, a.userID)
+	}
+	return query.Where("user_id = ?", a.userID)
+}
+
+// storyAnnotationCounts 统计用户可见的每个条目的批注数（含回复）
+func storyAnnotationCounts(story *model.Story, userID uint) map[uint]int {
+	counts := make(map[uint]int)
+	access := loadStoryAnnotationAccess(story, userID)
+	if !access.canAnnotate() {
+		return counts
+	}
+
+	var rows []struct {
+		EntryID uint
+		Count   int
+	}
+	access.scope(database.DB.Model(&model.StoryAnnotation{}).Where("story_id = ?", story.ID)).
+		Select("entry_id, COUNT(*) AS count").
+		Group("entry_id").
+		Scan(&rows)
+	for _, row := range rows {
+		counts[row.EntryID] = row.Count
+	}
+	return counts
+}
+
+// loadAnnotatableStory 加载剧情并计算批注身份；与剧情无关的用户返回 404
+func loadAnnotatableStory(c *gin.Context) (*model.Story, storyAnnotationAccess, bool) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.First(&story, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return nil, storyAnnotationAccess{}, false
+	}
+	access := loadStoryAnnotationAccess(&story, userID)
+	if !access.canAnnotate() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return nil, access, false
+	}
+	return &story, access, true
+}
+
+// listStoryAnnotations 获取剧情中用户可见的批注，按根批注分组返回回复。可选参数：entry_id
+func (s *Server) listStoryAnnotations(c *gin.Context) {
+	story, access, ok := loadAnnotatableStory(c)
+	if !ok {
+		return
+	}
+
+	query := access.scope(database.DB.Where("story_id = ?", story.ID))
+	if entryID, err := strconv.ParseUint(c.Query("entry_id"), 10, 32); err == nil {
+		query = query.Where("entry_id = ?", entryID)
+	}
+	var annotations []model.StoryAnnotation
+	if err := query.Order("created_at ASC, id ASC").Find(&annotations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	userIDs := make([]uint, 0, len(annotations))
+	for _, annotation := range annotations {
+		userIDs = append(userIDs, annotation.UserID)
+	}
+	usernames := make(map[uint]string)
+	if len(userIDs) > 0 {
+		var users []model.User
+		database.DB.Select("id", "username").Where("id IN ?", userIDs).Find(&users)
+		for _, user := range users {
+			usernames[user.ID] = user.Username
+		}
+	}
+
+	threads := make([]gin.H, 0)
+	threadIndex := make(map[uint]int)
+	for _, annotation := range annotations {
+		item := gin.H{
+			"id":         annotation.ID,
+			"entry_id":   annotation.EntryID,
+			"parent_id":  annotation.ParentID,
+			"user_id":    annotation.UserID,
+			"username":   usernames[annotation.UserID],
+			"visibility": annotation.Visibility,
+			"content":    annotation.Content,
+			"created_at": annotation.CreatedAt,
+			"updated_at": annotation.UpdatedAt,
+		}
+		if annotation.ParentID == nil {
+			item["replies"] = []gin.H{}
+			threadIndex[annotation.ID] = len(threads)
+			threads = append(threads, item)
+			continue
+		}
+		if index, ok := threadIndex[*annotation.ParentID]; ok {
+			threads[index]["replies"] = append(threads[index]["replies"].([]gin.H), item)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"annotations": threads})
+}
+
+// createStoryAnnotation 在条目上添加批注或回复，并通知剧情作者与讨论参与者
+func (s *Server) createStoryAnnotation(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, access, ok := loadAnnotatableStory(c)
+	if !ok {
+		return
+	}
+	entryID, _ := strconv.ParseUint(c.Param("entryId"), 10, 32)
+
+	var entry model.StoryEntry
+	if err := database.DB.Select("id", "story_id").Where("id = ? AND story_id = ?", entryID, story.ID).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+		return
+	}
+
+	var req CreateStoryAnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "批注内容不能为空"})
+		return
+	}
+
+	annotation := model.StoryAnnotation{
+		StoryID:    story.ID,
+		EntryID:    entry.ID,
+		UserID:     userID,
+		Visibility: req.Visibility,
+		Content:    content,
+	}
+	var root *model.StoryAnnotation
+	if req.ParentID != nil {
+		var parent model.StoryAnnotation
+		if err := database.DB.Where("id = ? AND story_id = ? AND entry_id = ?", *req.ParentID, story.ID, entry.ID).
+			First(&parent).Error; err != nil || !access.canSee(&parent) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "批注不存在"})
+			return
+		}
+		// 回复统一挂在根批注下，可见范围与根批注一致
+		if parent.ParentID != nil {
+			rootID := *parent.ParentID
+			parent = model.StoryAnnotation{}
+			if err := database.DB.First(&parent, rootID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "批注不存在"})
+				return
+			}
+		}
+		root = &parent
+		annotation.ParentID = &parent.ID
+		annotation.Visibility = parent.Visibility
+	}
+	if annotation.Visibility == "" {
+		annotation.Visibility = annotationVisibilityCollaborators
+	}
+
+	if err := database.DB.Create(&annotation).Error; err != nil {
+		log.Printf("[Story] create annotation error: story=%d entry=%d err=%v", story.ID, entry.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加失败"})
+		return
+	}
+
+	notifyStoryAnnotation(story, &annotation, root)
+	c.JSON(http.StatusCreated, annotation)
+}
+
+// notifyStoryAnnotation 通知剧情作者，回复时另通知根批注作者与此前的回复者；只通知能看到该批注的用户
+func notifyStoryAnnotation(story *model.Story, annotation *model.StoryAnnotation, root *model.StoryAnnotation) {
+	recipients := []uint{story.UserID}
+	content := fmt.Sprintf("在剧情《%s》中添加了批注", story.Title)
+	if root != nil {
+		content = fmt.Sprintf("回复了你在剧情《%s》中参与的批注", story.Title)
+		recipients = append(recipients, root.UserID)
+		var replierIDs []uint
+		database.DB.Model(&model.StoryAnnotation{}).Where("parent_id = ?", root.ID).Distinct("user_id").Pluck("user_id", &replierIDs)
+		recipients = append(recipients, replierIDs...)
+	}
+
+	notified := map[uint]struct{}{annotation.UserID: {}}
+	actorID := annotation.UserID
+	for _, recipient := range recipients {
+		if _, done := notified[recipient]; done {
+			continue
+		}
+		notified[recipient] = struct{}{}
+		if !loadStoryAnnotationAccess(story, recipient).canSee(annotation) {
+			continue
+		}
+		if err := service.CreateNotification(&model.Notification{
+			UserID:     recipient,
+			Type:       "story_annotation",
+			ActorID:    &actorID,
+			TargetType: "story",
+			TargetID:   story.ID,
+			Content:    content,
+		}); err != nil {
+			log.Printf("[Story] annotation notification error: story=%d user=%d err=%v", story.ID, recipient, err)
+		}
+	}
+}
+
+// updateStoryAnnotation 修改自己的批注
+func (s *Server) updateStoryAnnotation(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadAnnotatableStory(c)
+	if !ok {
+		return
+	}
+	annotationID, _ := strconv.ParseUint(c.Param("annotationId"), 10, 32)
+
+	var annotation model.StoryAnnotation
+	if err := database.DB.Where("id = ? AND story_id = ? AND user_id = ?", annotationID, story.ID, userID).
+		First(&annotation).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "批注不存在"})
+		return
+	}
+
+	var req UpdateStoryAnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "批注内容不能为空"})
+		return
+	}
+
+	if err := database.DB.Model(&annotation).Update("content", content).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	c.JSON(http.StatusOK, annotation)
+}
+
+// deleteStoryAnnotation 删除批注，删除根批注时一并删除回复。批注作者与剧情编辑及以上可以删除
+func (s *Server) deleteStoryAnnotation(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, access, ok := loadAnnotatableStory(c)
+	if !ok {
+		return
+	}
+	annotationID, _ := strconv.ParseUint(c.Param("annotationId"), 10, 32)
+
+	var annotation model.StoryAnnotation
+	if err := database.DB.Where("id = ? AND story_id = ?", annotationID, story.ID).First(&annotation).Error; err != nil ||
+		!access.canSee(&annotation) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "批注不存在"})
+		return
+	}
+	if annotation.UserID != userID && !storyRoleAtLeast(access.role, storyRoleEditor) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限执行此操作"})
+		return
+	}
+
+	if err := database.DB.Where("id = ? OR parent_id = ?", annotation.ID, annotation.ID).
+		Delete(&model.StoryAnnotation{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
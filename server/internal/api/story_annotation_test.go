@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryAnnotations(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{},
+		&model.StoryGuild{}, &model.Guild{}, &model.GuildMember{}, &model.StoryAnnotation{}, &model.Notification{}, &model.StoryChapter{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	editor := model.User{Username: "editor", Email: "editor@example.com", PassHash: "hash"}
+	member := model.User{Username: "member", Email: "member@example.com", PassHash: "hash"}
+	stranger := model.User{Username: "stranger", Email: "stranger@example.com", PassHash: "hash"}
+	for _, user := range []*model.User{&owner, &editor, &member, &stranger} {
+		db.Create(user)
+	}
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	story := model.Story{UserID: owner.ID, Title: "夜巡", StartTime: base, EndTime: base.Add(time.Hour)}
+	db.Create(&story)
+	entries := []model.StoryEntry{
+		{StoryID: story.ID, Type: "dialogue", Speaker: "Aldric", Content: "Halt!", SortOrder: 1, Timestamp: base},
+		{StoryID: story.ID, Type: "dialogue", Speaker: "Bryn", Content: "Who goes there?", SortOrder: 2, Timestamp: base.Add(time.Minute)},
+	}
+	for i := range entries {
+		db.Create(&entries[i])
+	}
+	db.Create(&model.StoryCollaborator{StoryID: story.ID, UserID: editor.ID, Role: storyRoleEditor, InvitedBy: owner.ID})
+	guild := model.Guild{Name: "守夜人", OwnerID: owner.ID}
+	db.Create(&guild)
+	db.Create(&model.GuildMember{GuildID: guild.ID, UserID: member.ID, Role: "member", JoinedAt: base})
+	db.Create(&model.StoryGuild{StoryID: story.ID, GuildID: guild.ID, AddedBy: owner.ID})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	editorToken := newTestToken(t, editor)
+	memberToken := newTestToken(t, member)
+	path := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+	annotate := func(entryID uint, body map[string]interface{}, token string) (model.StoryAnnotation, int) {
+		resp := performRequest(server.router, http.MethodPost, fmt.Sprintf("%s/entries/%d/annotations", path, entryID), body, token)
+		var annotation model.StoryAnnotation
+		json.Unmarshal(resp.Body.Bytes(), &annotation)
+		return annotation, resp.Code
+	}
+	type thread struct {
+		ID         uint   `json:"id"`
+		Username   string `json:"username"`
+		Visibility string `json:"visibility"`
+		Replies    []struct {
+			ID       uint   `json:"id"`
+			Username string `json:"username"`
+		} `json:"replies"`
+	}
+	listAnnotations := func(token string) []thread {
+		resp := performRequest(server.router, http.MethodGet, path+"/annotations", nil, token)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("list annotations: %d %s", resp.Code, resp.Body.String())
+		}
+		var result struct {
+			Annotations []thread `json:"annotations"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result.Annotations
+	}
+
+	if _, code := annotate(entries[0].ID, map[string]interface{}{"content": "路人批注"}, newTestToken(t, stranger)); code != http.StatusNotFound {
+		t.Fatalf("stranger should not annotate, got %d", code)
+	}
+	private, code := annotate(entries[0].ID, map[string]interface{}{"content": "这里要改", "visibility": annotationVisibilityOwner}, ownerToken)
+	if code != http.StatusCreated {
+		t.Fatalf("create private annotation: %d", code)
+	}
+	shared, code := annotate(entries[0].ID, map[string]interface{}{"content": "语气可以更强硬"}, editorToken)
+	if code != http.StatusCreated || shared.Visibility != annotationVisibilityCollaborators {
+		t.Fatalf("create collaborator annotation: %d %+v", code, shared)
+	}
+	public, code := annotate(entries[1].ID, map[string]interface{}{"content": "当晚我也在场", "visibility": annotationVisibilityGuild}, memberToken)
+	if code != http.StatusCreated {
+		t.Fatalf("guild member should annotate: %d", code)
+	}
+
+	// 可见范围
+	if threads := listAnnotations(ownerToken); len(threads) != 3 {
+		t.Fatalf("owner should see all annotations, got %+v", threads)
+	}
+	if threads := listAnnotations(editorToken); len(threads) != 2 || threads[0].ID != shared.ID {
+		t.Fatalf("editor should not see owner-only annotations, got %+v", threads)
+	}
+	if threads := listAnnotations(memberToken); len(threads) != 1 || threads[0].ID != public.ID {
+		t.Fatalf("guild member should only see guild annotations, got %+v", threads)
+	}
+	if _, code := annotate(entries[0].ID, map[string]interface{}{"content": "偷看", "parent_id": private.ID}, editorToken); code != http.StatusNotFound {
+		t.Fatalf("replying to an invisible annotation should 404, got %d", code)
+	}
+
+	// 回复挂在根批注下并沿用其可见范围
+	reply, _ := annotate(entries[0].ID, map[string]interface{}{"content": "同意", "parent_id": shared.ID, "visibility": annotationVisibilityGuild}, ownerToken)
+	nested, code := annotate(entries[0].ID, map[string]interface{}{"content": "已修改", "parent_id": reply.ID}, editorToken)
+	if code != http.StatusCreated || nested.ParentID == nil || *nested.ParentID != shared.ID || nested.Visibility != annotationVisibilityCollaborators {
+		t.Fatalf("nested reply should attach to the root: %d %+v", code, nested)
+	}
+	threads := listAnnotations(editorToken)
+	if len(threads[0].Replies) != 2 || threads[0].Replies[0].Username != "owner" || threads[0].Replies[1].ID != nested.ID {
+		t.Fatalf("unexpected thread %+v", threads[0])
+	}
+
+	// 通知：作者收到编辑与公会成员的批注及编辑的回复，编辑收到作者的回复
+	var notifications []model.Notification
+	db.Where("type = ?", "story_annotation").Order("id ASC").Find(&notifications)
+	if len(notifications) != 4 || notifications[0].UserID != owner.ID || notifications[1].UserID != owner.ID ||
+		notifications[2].UserID != editor.ID || notifications[2].TargetID != story.ID || notifications[3].UserID != owner.ID {
+		t.Fatalf("unexpected notifications %+v", notifications)
+	}
+
+	// getStory 返回可见批注数
+	resp := performRequest(server.router, http.MethodGet, path, nil, editorToken)
+	var detail struct {
+		AnnotationCounts map[string]int `json:"annotation_counts"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	if resp.Code != http.StatusOK || detail.AnnotationCounts[fmt.Sprint(entries[0].ID)] != 3 || detail.AnnotationCounts[fmt.Sprint(entries[1].ID)] != 1 {
+		t.Fatalf("unexpected annotation counts: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 修改与删除
+	annotationPath := fmt.Sprintf("%s/annotations/%d", path, shared.ID)
+	if resp := performRequest(server.router, http.MethodPut, annotationPath, map[string]interface{}{"content": "改了"}, ownerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("only the author may edit an annotation, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPut, annotationPath, map[string]interface{}{"content": "语气再强硬些"}, editorToken); resp.Code != http.StatusOK {
+		t.Fatalf("update annotation: %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/annotations/%d", path, shared.ID), nil, memberToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("guild member should not delete invisible annotations, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodDelete, annotationPath, nil, ownerToken); resp.Code != http.StatusOK {
+		t.Fatalf("owner should delete annotations: %d", resp.Code)
+	}
+	var remaining int64
+	db.Model(&model.StoryAnnotation{}).Where("story_id = ?", story.ID).Count(&remaining)
+	if remaining != 2 {
+		t.Fatalf("deleting a root should remove its replies, %d left", remaining)
+	}
+}
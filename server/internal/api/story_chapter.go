@@ -0,0 +1,627 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+// 章节操作的校验错误，在事务中返回后转换为 400/404
+var (
+	errStoryChapterEntryNotFound = errors.New("story chapter entry not found")
+	errStoryChapterBadRange      = errors.New("story chapter entry range is invalid")
+	errStoryChapterBadSplit      = errors.New("story chapter split point is invalid")
+)
+
+// CreateStoryChapterRequest 创建章节请求。指定 from_entry_id 时把从该条目到 to_entry_id（默认到结尾）的条目移入新章节
+type CreateStoryChapterRequest struct {
+	Title       string `json:"title" binding:"required,max=128"`
+	Summary     string `json:"summary" binding:"max=2000"`
+	FromEntryID *uint  `json:"from_entry_id"`
+	ToEntryID   *uint  `json:"to_entry_id"`
+}
+
+// UpdateStoryChapterRequest 修改章节标题与简介
+type UpdateStoryChapterRequest struct {
+	Title   string `json:"title" binding:"required,max=128"`
+	Summary string `json:"summary" binding:"max=2000"`
+}
+
+// ReorderStoryChaptersRequest 调整章节顺序，需包含剧情的全部章节
+type ReorderStoryChaptersRequest struct {
+	ChapterIDs []uint `json:"chapter_ids" binding:"required,min=1"`
+}
+
+// SplitStoryChapterRequest 拆分章节：从 entry_id 开始的条目组成紧随其后的新章节
+type SplitStoryChapterRequest struct {
+	EntryID uint   `json:"entry_id" binding:"required"`
+	Title   string `json:"title" binding:"required,max=128"`
+	Summary string `json:"summary" binding:"max=2000"`
+}
+
+// MergeStoryChapterRequest 把 source_id 章节的条目并入当前章节并删除来源章节
+type MergeStoryChapterRequest struct {
+	SourceID uint `json:"source_id" binding:"required"`
+}
+
+// MoveStoryChapterEntriesRequest 把阅读顺序上 from_entry_id 到 to_entry_id（含两端）的条目移入章节，chapter_id 为空时移出章节
+type MoveStoryChapterEntriesRequest struct {
+	FromEntryID uint  `json:"from_entry_id" binding:"required"`
+	ToEntryID   uint  `json:"to_entry_id" binding:"required"`
+	ChapterID   *uint `json:"chapter_id"`
+}
+
+// arrangeStoryChapters 按章节顺序排列条目（章节内保持原有顺序，未分章的条目排在最后），并填充章节的条目数与第一条条目
+func arrangeStoryChapters(entries []model.StoryEntry, chapters []model.StoryChapter) {
+	if len(chapters) == 0 {
+		return
+	}
+	positions := make(map[uint]int, len(chapters))
+	for i, chapter := range chapters {
+		positions[chapter.ID] = i
+	}
+	position := func(entry *model.StoryEntry) int {
+		if entry.ChapterID != nil {
+			if index, ok := positions[*entry.ChapterID]; ok {
+				return index
+			}
+		}
+		return len(chapters)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return position(&entries[i]) < position(&entries[j])
+	})
+	for i := range entries {
+		index := position(&entries[i])
+		if index == len(chapters) {
+			continue
+		}
+		chapter := &chapters[index]
+		chapter.EntryCount++
+		if chapter.FirstEntryID == nil {
+			id := entries[i].ID
+			chapter.FirstEntryID = &id
+		}
+	}
+}
+
+// loadStoryChapters 按顺序加载剧情章节
+func loadStoryChapters(tx *gorm.DB, storyID uint) ([]model.StoryChapter, error) {
+	chapters := make([]model.StoryChapter, 0)
+	err := tx.Where("story_id = ?", storyID).Order("sort_order, id").Find(&chapters).Error
+	return chapters, err
+}
+
+// loadStoryChapterEntries 加载剧情章节并按阅读顺序排列 entries；可选参数 chapter_id 只返回该章节的条目
+func loadStoryChapterEntries(c *gin.Context, storyID uint, entries []model.StoryEntry) ([]model.StoryChapter, []model.StoryEntry) {
+	chapters, _ := loadStoryChapters(database.DB, storyID)
+	arrangeStoryChapters(entries, chapters)
+
+	chapterID, err := strconv.ParseUint(c.Query("chapter_id"), 10, 32)
+	if err != nil {
+		return chapters, entries
+	}
+	filtered := make([]model.StoryEntry, 0)
+	for _, entry := range entries {
+		if entry.ChapterID != nil && uint64(*entry.ChapterID) == chapterID {
+			filtered = append(filtered, entry)
+		}
+	}
+	return chapters, filtered
+}
+
+// loadStoryReadingOrder 在事务中加载章节与按阅读顺序排列的条目（只含排序所需字段）
+func loadStoryReadingOrder(tx *gorm.DB, storyID uint) ([]model.StoryChapter, []model.StoryEntry, error) {
+	chapters, err := loadStoryChapters(tx, storyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []model.StoryEntry
+	if err := tx.Select("id", "story_id", "chapter_id", "timestamp", "sort_order").
+		Where("story_id = ?", storyID).Order("timestamp, sort_order").Find(&entries).Error; err != nil {
+		return nil, nil, err
+	}
+	arrangeStoryChapters(entries, chapters)
+	return chapters, entries, nil
+}
+
+// storyEntryRange 返回阅读顺序上 fromID 到 toID（含两端）的条目ID
+func storyEntryRange(entries []model.StoryEntry, fromID, toID uint) ([]uint, error) {
+	from, to := -1, -1
+	for i, entry := range entries {
+		if entry.ID == fromID {
+			from = i
+		}
+		if entry.ID == toID {
+			to = i
+		}
+	}
+	if from < 0 || to < 0 {
+		return nil, errStoryChapterEntryNotFound
+	}
+	if from > to {
+		return nil, errStoryChapterBadRange
+	}
+	ids := make([]uint, 0, to-from+1)
+	for _, entry := range entries[from : to+1] {
+		ids = append(ids, entry.ID)
+	}
+	return ids, nil
+}
+
+// assignStoryEntriesChapter 把条目移入章节，chapterID 为空时移出章节
+func assignStoryEntriesChapter(tx *gorm.DB, ids []uint, chapterID *uint) error {
+	for start := 0; start < len(ids); start += storyEntryIDChunk {
+		if err := tx.Model(&model.StoryEntry{}).Where("id IN ?", ids[start:min(start+storyEntryIDChunk, len(ids))]).
+			Update("chapter_id", chapterID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactStoryChapters 把章节顺序重新编号为 1..n
+func compactStoryChapters(tx *gorm.DB, storyID uint) error {
+	chapters, err := loadStoryChapters(tx, storyID)
+	if err != nil {
+		return err
+	}
+	for i, chapter := range chapters {
+		if chapter.SortOrder == i+1 {
+			continue
+		}
+		if err := tx.Model(&model.StoryChapter{}).Where("id = ?", chapter.ID).Update("sort_order", i+1).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadStoryChapter 加载路径中的章节，不属于该剧情时返回 404
+func loadStoryChapter(c *gin.Context, storyID uint) (*model.StoryChapter, bool) {
+	chapterID, _ := strconv.ParseUint(c.Param("chapterId"), 10, 32)
+	var chapter model.StoryChapter
+	if err := database.DB.Where("id = ? AND story_id = ?", chapterID, storyID).First(&chapter).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "章节不存在"})
+		return nil, false
+	}
+	return &chapter, true
+}
+
+// respondStoryChapterError 把章节操作的错误转换为响应
+func respondStoryChapterError(c *gin.Context, storyID uint, err error) {
+	switch {
+	case errors.Is(err, errStoryChapterEntryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+	case errors.Is(err, errStoryChapterBadRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "起始条目需在结束条目之前"})
+	case errors.Is(err, errStoryChapterBadSplit):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "拆分位置需为章节内除第一条以外的条目"})
+	default:
+		log.Printf("[Story] chapter error: story=%d err=%v", storyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+	}
+}
+
+// respondStoryChapters 返回剧情最新的章节列表
+func respondStoryChapters(c *gin.Context, storyID uint, status int) {
+	chapters, entries, err := loadStoryReadingOrder(database.DB, storyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	unassigned := len(entries)
+	for _, chapter := range chapters {
+		unassigned -= chapter.EntryCount
+	}
+	c.JSON(status, gin.H{"chapters": chapters, "unassigned_count": unassigned})
+}
+
+// listStoryChapters 获取剧情章节及每章条目数
+func (s *Server) listStoryChapters(c *gin.Context) {
+	storyID, ok := loadViewableStoryID(c)
+	if !ok {
+		return
+	}
+	respondStoryChapters(c, storyID, http.StatusOK)
+}
+
+// createStoryChapter 在末尾添加章节，可同时移入一段条目
+func (s *Server) createStoryChapter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	var req CreateStoryChapterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "章节标题不能为空"})
+		return
+	}
+	if req.FromEntryID == nil && req.ToEntryID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定起始条目"})
+		return
+	}
+
+	chapter := model.StoryChapter{StoryID: story.ID, Title: title, Summary: strings.TrimSpace(req.Summary), CreatedBy: userID}
+	moved := 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		chapters, entries, err := loadStoryReadingOrder(tx, story.ID)
+		if err != nil {
+			return err
+		}
+		chapter.SortOrder = len(chapters) + 1
+		if err := tx.Create(&chapter).Error; err != nil {
+			return err
+		}
+		if req.FromEntryID == nil {
+			return nil
+		}
+		toID := *req.FromEntryID
+		if req.ToEntryID != nil {
+			toID = *req.ToEntryID
+		} else if len(entries) > 0 {
+			toID = entries[len(entries)-1].ID
+		}
+		ids, err := storyEntryRange(entries, *req.FromEntryID, toID)
+		if err != nil {
+			return err
+		}
+		moved = len(ids)
+		return assignStoryEntriesChapter(tx, ids, &chapter.ID)
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, chapter.ID, moved)
+	respondStoryChapters(c, story.ID, http.StatusCreated)
+}
+
+// updateStoryChapter 修改章节标题与简介
+func (s *Server) updateStoryChapter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	chapter, ok := loadStoryChapter(c, story.ID)
+	if !ok {
+		return
+	}
+
+	var req UpdateStoryChapterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "章节标题不能为空"})
+		return
+	}
+
+	if err := database.DB.Model(chapter).Updates(map[string]interface{}{
+		"title":   title,
+		"summary": strings.TrimSpace(req.Summary),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, chapter.ID, 0)
+	c.JSON(http.StatusOK, chapter)
+}
+
+// deleteStoryChapter 删除章节，条目并入前一章（第一章并入下一章，没有其他章节时变为未分章）
+func (s *Server) deleteStoryChapter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	chapter, ok := loadStoryChapter(c, story.ID)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		chapters, err := loadStoryChapters(tx, story.ID)
+		if err != nil {
+			return err
+		}
+		var target *uint
+		for i := range chapters {
+			if chapters[i].ID != chapter.ID {
+				continue
+			}
+			if i > 0 {
+				target = &chapters[i-1].ID
+			} else if len(chapters) > 1 {
+				target = &chapters[1].ID
+			}
+			break
+		}
+		if err := tx.Model(&model.StoryEntry{}).Where("chapter_id = ?", chapter.ID).Update("chapter_id", target).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(chapter).Error; err != nil {
+			return err
+		}
+		return compactStoryChapters(tx, story.ID)
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, chapter.ID, 0)
+	respondStoryChapters(c, story.ID, http.StatusOK)
+}
+
+// reorderStoryChapters 调整章节顺序
+func (s *Server) reorderStoryChapters(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	var req ReorderStoryChaptersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	chapters, err := loadStoryChapters(database.DB, story.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	existing := make(map[uint]bool, len(chapters))
+	for _, chapter := range chapters {
+		existing[chapter.ID] = true
+	}
+	seen := make(map[uint]bool, len(req.ChapterIDs))
+	for _, id := range req.ChapterIDs {
+		if !existing[id] || seen[id] {
+			break
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(chapters) || len(req.ChapterIDs) != len(chapters) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "章节列表与剧情不一致"})
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range req.ChapterIDs {
+			if err := tx.Model(&model.StoryChapter{}).Where("id = ?", id).Update("sort_order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, 0, 0)
+	respondStoryChapters(c, story.ID, http.StatusOK)
+}
+
+// splitStoryChapter 从指定条目起把章节拆成两章，新章节紧随原章节
+func (s *Server) splitStoryChapter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	chapter, ok := loadStoryChapter(c, story.ID)
+	if !ok {
+		return
+	}
+
+	var req SplitStoryChapterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "章节标题不能为空"})
+		return
+	}
+
+	created := model.StoryChapter{StoryID: story.ID, Title: title, Summary: strings.TrimSpace(req.Summary), CreatedBy: userID}
+	moved := 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, entries, err := loadStoryReadingOrder(tx, story.ID)
+		if err != nil {
+			return err
+		}
+		var chapterEntryIDs []uint
+		for _, entry := range entries {
+			if entry.ChapterID != nil && *entry.ChapterID == chapter.ID {
+				chapterEntryIDs = append(chapterEntryIDs, entry.ID)
+			}
+		}
+		index := -1
+		for i, id := range chapterEntryIDs {
+			if id == req.EntryID {
+				index = i
+				break
+			}
+		}
+		if index <= 0 {
+			return errStoryChapterBadSplit
+		}
+		ids := chapterEntryIDs[index:]
+
+		if err := tx.Model(&model.StoryChapter{}).Where("story_id = ? AND sort_order > ?", story.ID, chapter.SortOrder).
+			Update("sort_order", gorm.Expr("sort_order + 1")).Error; err != nil {
+			return err
+		}
+		created.SortOrder = chapter.SortOrder + 1
+		if err := tx.Create(&created).Error; err != nil {
+			return err
+		}
+		moved = len(ids)
+		if err := assignStoryEntriesChapter(tx, ids, &created.ID); err != nil {
+			return err
+		}
+		return compactStoryChapters(tx, story.ID)
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, created.ID, moved)
+	respondStoryChapters(c, story.ID, http.StatusCreated)
+}
+
+// mergeStoryChapter 把另一章节并入当前章节，条目在章节内按时间排列
+func (s *Server) mergeStoryChapter(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	chapter, ok := loadStoryChapter(c, story.ID)
+	if !ok {
+		return
+	}
+
+	var req MergeStoryChapterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if req.SourceID == chapter.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能与自身合并"})
+		return
+	}
+	var source model.StoryChapter
+	if err := database.DB.Where("id = ? AND story_id = ?", req.SourceID, story.ID).First(&source).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "章节不存在"})
+		return
+	}
+
+	var moved int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.StoryEntry{}).Where("chapter_id = ?", source.ID).Update("chapter_id", chapter.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+		return compactStoryChapters(tx, story.ID)
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, chapter.ID, int(moved))
+	respondStoryChapters(c, story.ID, http.StatusOK)
+}
+
+// moveStoryChapterEntries 把一段连续条目移入章节或移出章节
+func (s *Server) moveStoryChapterEntries(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	var req MoveStoryChapterEntriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	var targetID uint
+	if req.ChapterID != nil {
+		var chapter model.StoryChapter
+		if err := database.DB.Where("id = ? AND story_id = ?", *req.ChapterID, story.ID).First(&chapter).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "章节不存在"})
+			return
+		}
+		targetID = chapter.ID
+	}
+
+	moved := 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, entries, err := loadStoryReadingOrder(tx, story.ID)
+		if err != nil {
+			return err
+		}
+		ids, err := storyEntryRange(entries, req.FromEntryID, req.ToEntryID)
+		if err != nil {
+			return err
+		}
+		moved = len(ids)
+		return assignStoryEntriesChapter(tx, ids, req.ChapterID)
+	})
+	if err != nil {
+		respondStoryChapterError(c, story.ID, err)
+		return
+	}
+
+	recordStoryActivity(database.DB, story.ID, userID, storyActionChapterUpdate, targetID, moved)
+	respondStoryChapters(c, story.ID, http.StatusOK)
+}
+
+// chapterFirstEntryID 返回章节的第一条条目，用于把书签定位到章节开头
+func chapterFirstEntryID(storyID, chapterID uint) (uint, bool) {
+	var count int64
+	database.DB.Model(&model.StoryChapter{}).Where("id = ? AND story_id = ?", chapterID, storyID).Count(&count)
+	if count == 0 {
+		return 0, false
+	}
+	var entry model.StoryEntry
+	if err := database.DB.Select("id").Where("story_id = ? AND chapter_id = ?", storyID, chapterID).
+		Order("timestamp, sort_order").First(&entry).Error; err != nil {
+		return 0, false
+	}
+	return entry.ID, true
+}
+
+// fillBookmarkChapters 填充书签所在条目的章节
+func fillBookmarkChapters(bookmarks []model.StoryBookmark) {
+	if len(bookmarks) == 0 {
+		return
+	}
+	entryIDs := make([]uint, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		entryIDs = append(entryIDs, bookmark.EntryID)
+	}
+	var entries []model.StoryEntry
+	database.DB.Select("id", "chapter_id").Where("id IN ? AND chapter_id IS NOT NULL", entryIDs).Find(&entries)
+	chapters := make(map[uint]*uint, len(entries))
+	for _, entry := range entries {
+		chapters[entry.ID] = entry.ChapterID
+	}
+	for i := range bookmarks {
+		bookmarks[i].ChapterID = chapters[bookmarks[i].EntryID]
+	}
+}
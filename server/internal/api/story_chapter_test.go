@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryChapters(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryGuild{},
+		&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryChapter{}, &model.StoryShareLink{},
+		&model.StoryShareVisit{}, &model.StoryShareReferrer{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	viewer := model.User{Username: "viewer", Email: "viewer@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&viewer)
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	story := model.Story{UserID: owner.ID, Title: "远征", StartTime: base, EndTime: base.Add(time.Hour), IsPublic: true, ShareCode: "chapters"}
+	db.Create(&story)
+	db.Create(&model.StoryCollaborator{StoryID: story.ID, UserID: viewer.ID, Role: storyRoleViewer, InvitedBy: owner.ID})
+	entries := make([]model.StoryEntry, 6)
+	for i := range entries {
+		entries[i] = model.StoryEntry{StoryID: story.ID, Type: "dialogue", Speaker: "Aldric", Content: fmt.Sprintf("line %d", i+1),
+			SortOrder: i + 1, Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		db.Create(&entries[i])
+	}
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	viewerToken := newTestToken(t, viewer)
+	path := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+	type chapterList struct {
+		Chapters []struct {
+			ID           uint   `json:"id"`
+			Title        string `json:"title"`
+			SortOrder    int    `json:"sort_order"`
+			EntryCount   int    `json:"entry_count"`
+			FirstEntryID *uint  `json:"first_entry_id"`
+		} `json:"chapters"`
+		UnassignedCount int `json:"unassigned_count"`
+	}
+	chapterRequest := func(method, url string, body interface{}, status int) chapterList {
+		resp := performRequest(server.router, method, url, body, ownerToken)
+		if resp.Code != status {
+			t.Fatalf("%s %s: %d %s", method, url, resp.Code, resp.Body.String())
+		}
+		var list chapterList
+		json.Unmarshal(resp.Body.Bytes(), &list)
+		return list
+	}
+	readingOrder := func(url, token string) ([]uint, []uint) {
+		resp := performRequest(server.router, http.MethodGet, url, nil, token)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("get %s: %d %s", url, resp.Code, resp.Body.String())
+		}
+		var result struct {
+			Entries  []model.StoryEntry   `json:"entries"`
+			Chapters []model.StoryChapter `json:"chapters"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		var entryIDs, chapterIDs []uint
+		for _, entry := range result.Entries {
+			entryIDs = append(entryIDs, entry.ID)
+		}
+		for _, chapter := range result.Chapters {
+			chapterIDs = append(chapterIDs, chapter.ID)
+		}
+		return entryIDs, chapterIDs
+	}
+	ids := func(indexes ...int) []uint {
+		result := make([]uint, 0, len(indexes))
+		for _, index := range indexes {
+			result = append(result, entries[index].ID)
+		}
+		return result
+	}
+
+	if resp := performRequest(server.router, http.MethodPost, path+"/chapters", map[string]interface{}{"title": "序章"}, viewerToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("viewer should not create chapters, got %d", resp.Code)
+	}
+	// 第一章包含前三条，其余条目暂未分章
+	list := chapterRequest(http.MethodPost, path+"/chapters", map[string]interface{}{"title": "第一章", "from_entry_id": entries[0].ID, "to_entry_id": entries[2].ID}, http.StatusCreated)
+	if len(list.Chapters) != 1 || list.Chapters[0].EntryCount != 3 || list.UnassignedCount != 3 {
+		t.Fatalf("unexpected chapters after create %+v", list)
+	}
+	first := list.Chapters[0].ID
+	// 第二章从第四条到结尾
+	list = chapterRequest(http.MethodPost, path+"/chapters", map[string]interface{}{"title": "第二章", "from_entry_id": entries[3].ID}, http.StatusCreated)
+	second := list.Chapters[1].ID
+	if list.Chapters[1].EntryCount != 3 || list.UnassignedCount != 0 || *list.Chapters[1].FirstEntryID != entries[3].ID {
+		t.Fatalf("unexpected chapters after second create %+v", list)
+	}
+	chapterRequest(http.MethodPost, path+"/chapters", map[string]interface{}{"title": "倒序", "from_entry_id": entries[4].ID, "to_entry_id": entries[3].ID}, http.StatusBadRequest)
+
+	// 拆分第二章：从第五条起成为紧随其后的新章节
+	list = chapterRequest(http.MethodPost, fmt.Sprintf("%s/chapters/%d/split", path, second), map[string]interface{}{"entry_id": entries[4].ID, "title": "第三章"}, http.StatusCreated)
+	if len(list.Chapters) != 3 || list.Chapters[2].Title != "第三章" || list.Chapters[1].EntryCount != 1 || list.Chapters[2].EntryCount != 2 {
+		t.Fatalf("unexpected chapters after split %+v", list)
+	}
+	third := list.Chapters[2].ID
+	chapterRequest(http.MethodPost, fmt.Sprintf("%s/chapters/%d/split", path, second), map[string]interface{}{"entry_id": entries[3].ID, "title": "空"}, http.StatusBadRequest)
+
+	// 调整顺序后阅读顺序随章节变化
+	list = chapterRequest(http.MethodPut, path+"/chapters/order", map[string]interface{}{"chapter_ids": []uint{third, first, second}}, http.StatusOK)
+	if list.Chapters[0].ID != third || list.Chapters[0].SortOrder != 1 {
+		t.Fatalf("unexpected order %+v", list)
+	}
+	chapterRequest(http.MethodPut, path+"/chapters/order", map[string]interface{}{"chapter_ids": []uint{third, first}}, http.StatusBadRequest)
+	order, chapterIDs := readingOrder(path, viewerToken)
+	if fmt.Sprint(order) != fmt.Sprint(ids(4, 5, 0, 1, 2, 3)) || fmt.Sprint(chapterIDs) != fmt.Sprint([]uint{third, first, second}) {
+		t.Fatalf("unexpected reading order %v chapters %v", order, chapterIDs)
+	}
+
+	// 按阅读顺序移动一段条目：第三章最后一条到第一章第一条移入第二章
+	chapterRequest(http.MethodPost, path+"/chapters/move-entries", map[string]interface{}{"from_entry_id": entries[5].ID, "to_entry_id": entries[0].ID, "chapter_id": second}, http.StatusOK)
+	order, _ = readingOrder(fmt.Sprintf("%s?chapter_id=%d", path, second), ownerToken)
+	if fmt.Sprint(order) != fmt.Sprint(ids(0, 3, 5)) {
+		t.Fatalf("unexpected second chapter entries %v", order)
+	}
+
+	// 合并与删除
+	list = chapterRequest(http.MethodPost, fmt.Sprintf("%s/chapters/%d/merge", path, first), map[string]interface{}{"source_id": third}, http.StatusOK)
+	if len(list.Chapters) != 2 || list.Chapters[0].ID != first || list.Chapters[0].SortOrder != 1 || list.Chapters[0].EntryCount != 3 {
+		t.Fatalf("unexpected chapters after merge %+v", list)
+	}
+	list = chapterRequest(http.MethodDelete, fmt.Sprintf("%s/chapters/%d", path, second), nil, http.StatusOK)
+	if len(list.Chapters) != 1 || list.Chapters[0].EntryCount != 6 {
+		t.Fatalf("deleted chapter entries should join the previous chapter %+v", list)
+	}
+	list = chapterRequest(http.MethodPost, fmt.Sprintf("%s/chapters/%d/split", path, first), map[string]interface{}{"entry_id": entries[3].ID, "title": "第二章"}, http.StatusCreated)
+	second = list.Chapters[1].ID
+
+	// 公开页面与书签
+	order, chapterIDs = readingOrder("/api/v1/public/stories/chapters", "")
+	if len(order) != 6 || fmt.Sprint(chapterIDs) != fmt.Sprint([]uint{first, second}) {
+		t.Fatalf("public story should include chapters: %v %v", order, chapterIDs)
+	}
+	resp := performRequest(server.router, http.MethodPost, path+"/bookmarks", map[string]interface{}{"chapter_id": second, "name": "第二章"}, viewerToken)
+	var bookmark model.StoryBookmark
+	json.Unmarshal(resp.Body.Bytes(), &bookmark)
+	if resp.Code != http.StatusCreated || bookmark.EntryID != entries[3].ID || bookmark.ChapterID == nil || *bookmark.ChapterID != second {
+		t.Fatalf("bookmark should jump to the chapter start: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodGet, path+"/bookmarks", nil, viewerToken)
+	var bookmarks struct {
+		Bookmarks []model.StoryBookmark `json:"bookmarks"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &bookmarks)
+	if len(bookmarks.Bookmarks) != 1 || bookmarks.Bookmarks[0].ChapterID == nil || *bookmarks.Bookmarks[0].ChapterID != second {
+		t.Fatalf("bookmarks should carry their chapter: %s", resp.Body.String())
+	}
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+// 剧情角色，权限从低到高
+const (
+	storyRoleViewer      = "viewer"
+	storyRoleContributor = "contributor"
+	storyRoleEditor      = "editor"
+	storyRoleOwner       = "owner"
+)
+
+var storyRoleLevels = map[string]int{
+	storyRoleViewer:      1,
+	storyRoleContributor: 2,
+	storyRoleEditor:      3,
+	storyRoleOwner:       4,
+}
+
+// 剧情变更记录的操作类型
+const (
+	storyActionEntryAdd           = "entry_add"
+	storyActionEntryUpdate        = "entry_update"
+	storyActionEntryDelete        = "entry_delete"
+	storyActionStoryUpdate        = "story_update"
+	storyActionTagAdd             = "tag_add"
+	storyActionTagRemove          = "tag_remove"
+	storyActionPublish            = "publish"
+	storyActionUnpublish          = "unpublish"
+	storyActionCollaboratorAdd    = "collaborator_add"
+	storyActionCollaboratorUpdate = "collaborator_update"
+	storyActionCollaboratorRemove = "collaborator_remove"
+	storyActionShareLinkCreate    = "share_link_create"
+	storyActionShareLinkUpdate    = "share_link_update"
+	storyActionShareLinkRevoke    = "share_link_revoke"
+	storyActionEntryRestore       = "entry_restore"
+	storyActionChapterUpdate      = "chapter_update"
+)
+
+// storyRoleAtLeast 判断 role 是否不低于 minRole
+func storyRoleAtLeast(role, minRole string) bool {
+	return role != "" && storyRoleLevels[role] >= storyRoleLevels[minRole]
+}
+
+// storyRole 返回用户在剧情中的角色：作者为 owner，协作者为其角色，无关用户为空
+func storyRole(story *model.Story, userID uint) string {
+	if userID == 0 {
+		return ""
+	}
+	if story.UserID == userID {
+		return storyRoleOwner
+	}
+	var collaborator model.StoryCollaborator
+	if err := database.DB.Where("story_id = ? AND user_id = ?", story.ID, userID).First(&collaborator).Error; err != nil {
+		return ""
+	}
+	return collaborator.Role
+}
+
+// canViewStory 作者、协作者以及已归档到公会的剧情可以查看
+func canViewStory(story *model.Story, userID uint) bool {
+	if storyRole(story, userID) != "" {
+		return true
+	}
+	var guildCount int64
+	database.DB.Model(&model.StoryGuild{}).Where("story_id = ?", story.ID).Count(&guildCount)
+	return guildCount > 0
+}
+
+// loadStoryWithRole 加载路由参数 id 对应的剧情，并要求当前用户至少具有 minRole。
+// 与剧情无关的用户返回 404，权限不足返回 403
+func loadStoryWithRole(c *gin.Context, minRole string) (*model.Story, string, bool) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.First(&story, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return nil, "", false
+	}
+	role := storyRole(&story, userID)
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return nil, "", false
+	}
+	if !storyRoleAtLeast(role, minRole) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权限执行此操作"})
+		return nil, "", false
+	}
+	return &story, role, true
+}
+
+// canModifyStoryEntry 编辑及以上可修改全部条目，贡献者只能修改自己添加的条目
+func canModifyStoryEntry(role string, userID uint, entry *model.StoryEntry) bool {
+	if storyRoleAtLeast(role, storyRoleEditor) {
+		return true
+	}
+	return role == storyRoleContributor && entry.CreatedBy != 0 && entry.CreatedBy == userID
+}
+
+// recordStoryActivity 记录剧情变更的操作者，写入失败只记录日志
+func recordStoryActivity(db *gorm.DB, storyID, userID uint, action string, targetID uint, count int) {
+	activity := model.StoryActivity{
+		StoryID:  storyID,
+		UserID:   userID,
+		Action:   action,
+		TargetID: targetID,
+		Count:    count,
+	}
+	if err := db.Create(&activity).Error; err != nil {
+		log.Printf("[Story] record activity error: story=%d user=%d action=%s err=%v", storyID, userID, action, err)
+	}
+}
+
+// AddStoryCollaboratorRequest 添加协作者请求，user_id 与 username 二选一
+type AddStoryCollaboratorRequest struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role" binding:"required,oneof=viewer contributor editor"`
+}
+
+// UpdateStoryCollaboratorRequest 修改协作者角色请求
+type UpdateStoryCollaboratorRequest struct {
+	Role string `json:"role" binding:"required,oneof=viewer contributor editor"`
+}
+
+// listStoryCollaborators 获取剧情协作者列表，协作者本人也可查看
+func (s *Server) listStoryCollaborators(c *gin.Context) {
+	story, role, ok := loadStoryWithRole(c, storyRoleViewer)
+	if !ok {
+		return
+	}
+
+	var collaborators []model.StoryCollaborator
+	database.DB.Where("story_id = ?", story.ID).Order("created_at ASC").Find(&collaborators)
+
+	userIDs := []uint{story.UserID}
+	for _, collaborator := range collaborators {
+		userIDs = append(userIDs, collaborator.UserID)
+	}
+	var users []model.User
+	database.DB.Select("id, username").Where("id IN ?", userIDs).Find(&users)
+	usernames := make(map[uint]string, len(users))
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+	}
+
+	result := make([]gin.H, 0, len(collaborators))
+	for _, collaborator := range collaborators {
+		result = append(result, gin.H{
+			"id":         collaborator.ID,
+			"user_id":    collaborator.UserID,
+			"username":   usernames[collaborator.UserID],
+			"role":       collaborator.Role,
+			"invited_by": collaborator.InvitedBy,
+			"created_at": collaborator.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owner":         gin.H{"user_id": story.UserID, "username": usernames[story.UserID]},
+		"my_role":       role,
+		"collaborators": result,
+	})
+}
+
+// addStoryCollaborator 作者邀请协作者，并通知对方
+func (s *Server) addStoryCollaborator(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleOwner)
+	if !ok {
+		return
+	}
+
+	var req AddStoryCollaboratorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	var target model.User
+	query := database.DB.Select("id, username")
+	switch {
+	case req.UserID != 0:
+		query = query.Where("id = ?", req.UserID)
+	case strings.TrimSpace(req.Username) != "":
+		query = query.Where("username = ?", strings.TrimSpace(req.Username))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定协作者"})
+		return
+	}
+	if err := query.First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if target.ID == story.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能添加作者为协作者"})
+		return
+	}
+
+	collaborator := model.StoryCollaborator{
+		StoryID:   story.ID,
+		UserID:    target.ID,
+		Role:      req.Role,
+		InvitedBy: userID,
+	}
+	if err := database.DB.Create(&collaborator).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该用户已是协作者"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionCollaboratorAdd, target.ID, 0)
+
+	actorID := userID
+	if err := service.CreateNotification(&model.Notification{
+		UserID:     target.ID,
+		Type:       "story_collaborator",
+		ActorID:    &actorID,
+		TargetType: "story",
+		TargetID:   story.ID,
+		Content:    fmt.Sprintf("邀请你协作剧情《%s》", story.Title),
+	}); err != nil {
+		log.Printf("[Story] collaborator notification error: story=%d user=%d err=%v", story.ID, target.ID, err)
+	}
+
+	c.JSON(http.StatusCreated, collaborator)
+}
+
+// updateStoryCollaborator 作者修改协作者角色
+func (s *Server) updateStoryCollaborator(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleOwner)
+	if !ok {
+		return
+	}
+	collaboratorUserID, _ := strconv.ParseUint(c.Param("userId"), 10, 32)
+
+	var req UpdateStoryCollaboratorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	var collaborator model.StoryCollaborator
+	if err := database.DB.Where("story_id = ? AND user_id = ?", story.ID, collaboratorUserID).First(&collaborator).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "协作者不存在"})
+		return
+	}
+	if err := database.DB.Model(&collaborator).Updates(map[string]interface{}{
+		"role":       req.Role,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionCollaboratorUpdate, collaborator.UserID, 0)
+
+	c.JSON(http.StatusOK, collaborator)
+}
+
+// removeStoryCollaborator 作者移除协作者，协作者也可以自行退出
+func (s *Server) removeStoryCollaborator(c *gin.Context) {
+	userID := c.GetUint("userID")
+	collaboratorUserID, _ := strconv.ParseUint(c.Param("userId"), 10, 32)
+
+	minRole := storyRoleOwner
+	if uint(collaboratorUserID) == userID {
+		minRole = storyRoleViewer
+	}
+	story, _, ok := loadStoryWithRole(c, minRole)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("story_id = ? AND user_id = ?", story.ID, collaboratorUserID).Delete(&model.StoryCollaborator{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "协作者不存在"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionCollaboratorRemove, uint(collaboratorUserID), 0)
+
+	c.JSON(http.StatusOK, gin.H{"message": "移除成功"})
+}
+
+// listStoryActivities 获取剧情变更记录，按时间倒序分页
+func (s *Server) listStoryActivities(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleViewer)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	query := database.DB.Model(&model.StoryActivity{}).Where("story_id = ?", story.ID)
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var activities []model.StoryActivity
+	if err := query.Order("created_at DESC, id DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&activities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"activities": activities,
+		"total":      total,
+		"page":       page,
+		"page_size":  pageSize,
+	})
+}
+
+// loadViewableStoryID 校验当前用户可以查看路由参数 id 对应的剧情（书签等只读附属功能使用）
+func loadViewableStoryID(c *gin.Context) (uint, bool) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.Select("id, user_id").First(&story, id).Error; err != nil || !canViewStory(&story, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return 0, false
+	}
+	return story.ID, true
+}
+
+// canManagePublicBookmarks 公共书签由作者、编辑或管理员维护
+func canManagePublicBookmarks(storyID, userID uint) bool {
+	var story model.Story
+	if err := database.DB.Select("id, user_id").First(&story, storyID).Error; err != nil {
+		return false
+	}
+	return storyRoleAtLeast(storyRole(&story, userID), storyRoleEditor) || checkModerator(userID)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryCollaboratorRoles(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryEntryRevision{}, &model.StoryCollaborator{}, &model.StoryActivity{},
+		&model.StoryGuild{}, &model.StoryTag{}, &model.Tag{}, &model.StoryBookmark{}, &model.StoryShareLink{}, &model.Notification{},
+		&model.UserDailyActivity{}, &model.UserActivityLog{}, &model.StoryAnnotation{}, &model.StoryChapter{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	viewer := model.User{Username: "viewer", Email: "viewer@example.com", PassHash: "hash"}
+	contributor := model.User{Username: "contributor", Email: "contributor@example.com", PassHash: "hash"}
+	editor := model.User{Username: "editor", Email: "editor@example.com", PassHash: "hash"}
+	stranger := model.User{Username: "stranger", Email: "stranger@example.com", PassHash: "hash"}
+	for _, user := range []*model.User{&owner, &viewer, &contributor, &editor, &stranger} {
+		db.Create(user)
+	}
+	story := model.Story{
+		UserID:    owner.ID,
+		Title:     "Campaign",
+		StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	db.Create(&story)
+	ownerEntry := model.StoryEntry{StoryID: story.ID, SourceID: "chat_owner", Type: "dialogue", Speaker: "Aldric", Content: "Halt!",
+		SortOrder: 1, CreatedBy: owner.ID}
+	db.Create(&ownerEntry)
+	tag := model.Tag{Name: "战役", Type: "preset"}
+	db.Create(&tag)
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	viewerToken := newTestToken(t, viewer)
+	contributorToken := newTestToken(t, contributor)
+	editorToken := newTestToken(t, editor)
+	strangerToken := newTestToken(t, stranger)
+	base := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+
+	for _, invite := range []map[string]interface{}{
+		{"username": "viewer", "role": "viewer"},
+		{"username": "contributor", "role": "contributor"},
+		{"user_id": editor.ID, "role": "editor"},
+	} {
+		resp := performRequest(server.router, http.MethodPost, base+"/collaborators", invite, ownerToken)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("add collaborator %v: %d body=%s", invite, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/collaborators", map[string]interface{}{"username": "stranger", "role": "editor"}, editorToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("only the owner may invite, got %d", resp.Code)
+	}
+	var notificationCount int64
+	db.Model(&model.Notification{}).Where("user_id = ? AND target_type = ? AND target_id = ?", contributor.ID, "story", story.ID).Count(&notificationCount)
+	if notificationCount != 1 {
+		t.Fatalf("expected invite notification, got %d", notificationCount)
+	}
+
+	// 查看权限
+	resp := performRequest(server.router, http.MethodGet, base, nil, viewerToken)
+	var detail struct {
+		Role string `json:"role"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &detail)
+	if resp.Code != http.StatusOK || detail.Role != "viewer" {
+		t.Fatalf("viewer should read the story: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := performRequest(server.router, http.MethodGet, base, nil, strangerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("stranger should not see the story, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/bookmarks", map[string]interface{}{"entry_id": ownerEntry.ID, "name": "mine"}, viewerToken); resp.Code != http.StatusCreated {
+		t.Fatalf("viewer should create personal bookmarks, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/bookmarks", map[string]interface{}{"entry_id": ownerEntry.ID, "name": "mine"}, strangerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("stranger should not bookmark the story, got %d", resp.Code)
+	}
+	entries := []CreateStoryEntryRequest{{Speaker: "Bryn", Content: "Who goes there?", Timestamp: "2024-01-01T20:00:00Z"}}
+	if resp := performRequest(server.router, http.MethodPost, base+"/entries", entries, viewerToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("viewer must not add entries, got %d", resp.Code)
+	}
+
+	// 贡献者只能修改自己的条目
+	if resp := performRequest(server.router, http.MethodPost, base+"/entries", entries, contributorToken); resp.Code != http.StatusCreated {
+		t.Fatalf("contributor add entries: %d %s", resp.Code, resp.Body.String())
+	}
+	var contributed model.StoryEntry
+	db.Where("story_id = ? AND speaker = ?", story.ID, "Bryn").First(&contributed)
+	if contributed.CreatedBy != contributor.ID {
+		t.Fatalf("entry should be attributed to the contributor, got %+v", contributed)
+	}
+	update := map[string]interface{}{"content": "Who goes there, stranger?"}
+	if resp := performRequest(server.router, http.MethodPut, fmt.Sprintf("%s/entries/%d", base, contributed.ID), update, contributorToken); resp.Code != http.StatusOK {
+		t.Fatalf("contributor should edit own entry, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPut, fmt.Sprintf("%s/entries/%d", base, ownerEntry.ID), update, contributorToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("contributor must not edit others' entries, got %d", resp.Code)
+	}
+	// 重传带他人条目来源ID的记录也不能改写该条目
+	reupload := []CreateStoryEntryRequest{{SourceID: "chat_owner", Speaker: "Aldric", Content: "Rewritten", Timestamp: "2024-01-01T20:00:00Z"}}
+	resp = performRequest(server.router, http.MethodPost, base+"/entries", reupload, contributorToken)
+	var ingest struct {
+		Updated int `json:"updated"`
+		Denied  int `json:"denied"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &ingest)
+	if resp.Code != http.StatusCreated || ingest.Updated != 0 || ingest.Denied != 1 {
+		t.Fatalf("contributor re-upload should not rewrite others' entries: %d %s", resp.Code, resp.Body.String())
+	}
+	var untouched model.StoryEntry
+	db.First(&untouched, ownerEntry.ID)
+	if untouched.Content != "Halt!" {
+		t.Fatalf("owner entry should be unchanged, got %q", untouched.Content)
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/publish", map[string]interface{}{"is_public": true}, contributorToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("contributor must not publish, got %d", resp.Code)
+	}
+
+	// 编辑可以修改全部内容、标签与发布
+	if resp := performRequest(server.router, http.MethodPut, fmt.Sprintf("%s/entries/%d", base, ownerEntry.ID), update, editorToken); resp.Code != http.StatusOK {
+		t.Fatalf("editor should edit any entry, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/tags", map[string]interface{}{"tag_id": tag.ID}, editorToken); resp.Code != http.StatusOK {
+		t.Fatalf("editor should add tags, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := performRequest(server.router, http.MethodPost, base+"/publish", map[string]interface{}{"is_public": true}, editorToken); resp.Code != http.StatusOK {
+		t.Fatalf("editor should publish, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodDelete, base, nil, editorToken); resp.Code == http.StatusOK {
+		t.Fatalf("editor must not delete the story")
+	}
+
+	var updated model.StoryEntry
+	db.First(&updated, ownerEntry.ID)
+	if updated.UpdatedBy != editor.ID {
+		t.Fatalf("edit should be attributed to the editor, got %+v", updated)
+	}
+
+	resp = performRequest(server.router, http.MethodGet, base+"/activities", nil, viewerToken)
+	var activity struct {
+		Activities []model.StoryActivity `json:"activities"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &activity)
+	actors := make(map[string]uint)
+	for _, item := range activity.Activities {
+		actors[item.Action] = item.UserID
+	}
+	if actors[storyActionEntryAdd] != contributor.ID || actors[storyActionPublish] != editor.ID || actors[storyActionTagAdd] != editor.ID {
+		t.Fatalf("unexpected activity attribution %+v", activity.Activities)
+	}
+
+	// 协作者可以自行退出
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/collaborators/%d", base, viewer.ID), nil, viewerToken); resp.Code != http.StatusOK {
+		t.Fatalf("collaborator should be able to leave, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodGet, base, nil, viewerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("removed collaborator should lose access, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,625 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 隐去方式与请求状态
+const (
+	storyRedactionRedact    = "redact"
+	storyRedactionAnonymize = "anonymize"
+
+	storyRedactionPending  = "pending"
+	storyRedactionApproved = "approved"
+	storyRedactionRejected = "rejected"
+
+	// storyRedactedContent 公开视图中替代被隐去发言的文字
+	storyRedactedContent = "［该发言已应参与者要求隐去］"
+)
+
+var errStoryRedactionReviewed = errors.New("story redaction request already reviewed")
+
+// CreateStoryRedactionRequest 参与者申请隐去自己角色的发言
+type CreateStoryRedactionRequest struct {
+	GameID string `json:"game_id" binding:"required,max=128"`
+	Mode   string `json:"mode" binding:"required,oneof=redact anonymize"`
+	Reason string `json:"reason" binding:"max=512"`
+}
+
+// ReviewStoryRedactionRequest 作者审核隐去请求，alias 为匿名时显示的名字（为空时自动生成）
+type ReviewStoryRedactionRequest struct {
+	Approve *bool  `json:"approve" binding:"required"`
+	Alias   string `json:"alias" binding:"max=64"`
+	Note    string `json:"note" binding:"max=512"`
+}
+
+// storyRedactionModeLabel 隐去方式的中文说明，用于通知
+func storyRedactionModeLabel(mode string) string {
+	if mode == storyRedactionAnonymize {
+		return "匿名"
+	}
+	return "隐去"
+}
+
+// notifyStoryParticipants 剧情公开或首次通过分享链接分享时按角色 GameID 找出其他 RPBox 用户参与者，登记并通知首次出现的参与者
+func notifyStoryParticipants(story *model.Story, actorID uint) {
+	var lines []struct {
+		GameID string
+		Count  int
+	}
+	if err := database.DB.Model(&model.StoryEntry{}).
+		Select("characters.game_id AS game_id, COUNT(*) AS count").
+		Joins("JOIN characters ON characters.id = story_entries.character_id").
+		Where("story_entries.story_id = ? AND characters.is_npc = ? AND characters.game_id <> ''", story.ID, false).
+		Group("characters.game_id").
+		Scan(&lines).Error; err != nil {
+		log.Printf("[Story] participant lookup error: story=%d err=%v", story.ID, err)
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+	lineCounts := make(map[string]int, len(lines))
+	gameIDs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		lineCounts[line.GameID] = line.Count
+		gameIDs = append(gameIDs, line.GameID)
+	}
+
+	// 其他用户名下同一 GameID 的玩家角色即为参与者
+	var matches []model.Character
+	database.DB.Select("user_id", "game_id").
+		Where("game_id IN ? AND is_npc = ? AND user_id <> ?", gameIDs, false, story.UserID).
+		Find(&matches)
+
+	var existing []model.StoryParticipant
+	database.DB.Where("story_id = ?", story.ID).Find(&existing)
+	known := make(map[string]model.StoryParticipant, len(existing))
+	for _, participant := range existing {
+		known[fmt.Sprintf("%d:%s", participant.UserID, participant.GameID)] = participant
+	}
+
+	now := time.Now()
+	newGameIDs := make(map[uint][]string)
+	var userOrder []uint
+	for _, match := range matches {
+		key := fmt.Sprintf("%d:%s", match.UserID, match.GameID)
+		if participant, ok := known[key]; ok {
+			if participant.LineCount != lineCounts[match.GameID] {
+				database.DB.Model(&participant).Update("line_count", lineCounts[match.GameID])
+			}
+			continue
+		}
+		participant := model.StoryParticipant{
+			StoryID:    story.ID,
+			UserID:     match.UserID,
+			GameID:     match.GameID,
+			LineCount:  lineCounts[match.GameID],
+			NotifiedAt: now,
+		}
+		if err := database.DB.Create(&participant).Error; err != nil {
+			log.Printf("[Story] participant create error: story=%d user=%d err=%v", story.ID, match.UserID, err)
+			continue
+		}
+		known[key] = participant
+		if _, ok := newGameIDs[match.UserID]; !ok {
+			userOrder = append(userOrder, match.UserID)
+		}
+		newGameIDs[match.UserID] = append(newGameIDs[match.UserID], match.GameID)
+	}
+
+	exposure := "已公开"
+	if !story.IsPublic {
+		exposure = "已通过分享链接分享"
+	}
+	for _, userID := range userOrder {
+		if err := service.CreateNotification(&model.Notification{
+			UserID:     userID,
+			Type:       "story_consent",
+			ActorID:    &actorID,
+			TargetType: "story",
+			TargetID:   story.ID,
+			Content:    fmt.Sprintf("剧情《%s》%s，其中包含你的角色 %s 的发言，你可以申请隐去或匿名", story.Title, exposure, strings.Join(newGameIDs[userID], "、")),
+		}); err != nil {
+			log.Printf("[Story] participant notification error: story=%d user=%d err=%v", story.ID, userID, err)
+		}
+	}
+}
+
+// storyRedactions 剧情已批准的隐去请求。byGameID 用于关联角色的条目，bySpeaker 用于未关联角色的条目与参与者列表
+type storyRedactions struct {
+	byGameID  map[string]model.StoryRedactionRequest
+	bySpeaker map[string]model.StoryRedactionRequest
+}
+
+// loadStoryRedactions 加载剧情已批准的隐去请求，没有时返回 nil
+func loadStoryRedactions(storyID uint) *storyRedactions {
+	var requests []model.StoryRedactionRequest
+	database.DB.Where("story_id = ? AND status = ?", storyID, storyRedactionApproved).Find(&requests)
+	if len(requests) == 0 {
+		return nil
+	}
+	redactions := &storyRedactions{byGameID: make(map[string]model.StoryRedactionRequest, len(requests))}
+	for _, request := range requests {
+		redactions.byGameID[request.GameID] = request
+	}
+	redactions.bySpeaker = storyRedactionSpeakers(storyID, redactions.byGameID)
+	return redactions
+}
+
+// applyStoryRedactions 在公开视图中对条目应用已批准的隐去请求，只修改内存中的条目，不改动原始记录。
+// 关联角色的条目按角色 GameID 匹配，未关联角色的条目（如纯文本导入）按说话者名称匹配
+func applyStoryRedactions(entries []model.StoryEntry, redactions *storyRedactions) {
+	if redactions == nil || len(entries) == 0 {
+		return
+	}
+	characterIDs := make([]uint, 0)
+	seen := make(map[uint]bool)
+	for _, entry := range entries {
+		if entry.CharacterID != nil && !seen[*entry.CharacterID] {
+			seen[*entry.CharacterID] = true
+			characterIDs = append(characterIDs, *entry.CharacterID)
+		}
+	}
+	gameIDs := make(map[uint]string)
+	if len(characterIDs) > 0 {
+		var characters []model.Character
+		database.DB.Select("id", "game_id").Where("id IN ? AND is_npc = ?", characterIDs, false).Find(&characters)
+		for _, character := range characters {
+			gameIDs[character.ID] = character.GameID
+		}
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		var redaction model.StoryRedactionRequest
+		var ok bool
+		if entry.CharacterID != nil {
+			redaction, ok = redactions.byGameID[gameIDs[*entry.CharacterID]]
+		} else {
+			redaction, ok = redactions.bySpeaker[strings.TrimSpace(entry.Speaker)]
+		}
+		if !ok {
+			continue
+		}
+		switch redaction.Mode {
+		case storyRedactionRedact:
+			entry.Content = storyRedactedContent
+			if entry.Type == "image" {
+				entry.Type = "narration"
+			}
+		case storyRedactionAnonymize:
+			entry.Speaker = redaction.Alias
+			entry.CharacterID = nil
+			entry.CharacterVersionID = nil
+		}
+	}
+}
+
+// storyRedactionSpeakers 按说话者名称索引隐去请求。完整的“角色名-服务器”总是匹配；
+// 只有角色名的说话者（“-”前的部分、角色名字或关联条目显示的名称）只在剧情中没有其他同名角色时匹配，
+// 避免隐去其他服务器的同名玩家
+func storyRedactionSpeakers(storyID uint, redactions map[string]model.StoryRedactionRequest) map[string]model.StoryRedactionRequest {
+	speakers := make(map[string]model.StoryRedactionRequest, len(redactions)*2)
+	// owners 记录每个角色名在剧情中对应的完整名称
+	owners := make(map[string]map[string]bool)
+	addName := func(name, fullName string) {
+		name = strings.TrimSpace(name)
+		if name == "" || fullName == "" {
+			return
+		}
+		if owners[name] == nil {
+			owners[name] = make(map[string]bool)
+		}
+		owners[name][fullName] = true
+	}
+	addFullName := func(fullName string) {
+		if name, _, found := strings.Cut(fullName, "-"); found {
+			addName(name, fullName)
+		}
+	}
+	for gameID, redaction := range redactions {
+		speakers[gameID] = redaction
+		addFullName(gameID)
+	}
+
+	var rows []struct {
+		GameID    string
+		FirstName string
+		Speaker   string
+	}
+	database.DB.Model(&model.StoryEntry{}).
+		Select("DISTINCT characters.game_id AS game_id, characters.first_name AS first_name, story_entries.speaker AS speaker").
+		Joins("LEFT JOIN characters ON characters.id = story_entries.character_id").
+		Where("story_entries.story_id = ?", storyID).
+		Scan(&rows)
+	for _, row := range rows {
+		if row.GameID != "" {
+			addFullName(row.GameID)
+			addName(row.FirstName, row.GameID)
+			addName(row.Speaker, row.GameID)
+		} else {
+			addFullName(strings.TrimSpace(row.Speaker))
+		}
+	}
+
+	for name, fullNames := range owners {
+		if len(fullNames) != 1 {
+			continue
+		}
+		if _, ok := speakers[name]; ok {
+			continue
+		}
+		for fullName := range fullNames {
+			if redaction, ok := redactions[fullName]; ok {
+				speakers[name] = redaction
+			}
+		}
+	}
+	return speakers
+}
+
+// redactStoryParticipants 对公开视图中剧情的参与者列表应用隐去请求：隐去的参与者移除，匿名的参与者替换为代称
+func redactStoryParticipants(story *model.Story, redactions *storyRedactions) {
+	if redactions == nil || story.Participants == "" {
+		return
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(story.Participants), &names); err != nil {
+		// 无法解析时不冒险公开原始列表
+		story.Participants = ""
+		return
+	}
+	result := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if redaction, ok := redactions.bySpeaker[strings.TrimSpace(name)]; ok {
+			if redaction.Mode != storyRedactionAnonymize {
+				continue
+			}
+			name = redaction.Alias
+		}
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	data, _ := json.Marshal(result)
+	story.Participants = string(data)
+}
+
+// copyStoryConsent 将参与者登记与已批准的隐去请求复制到拆分、合并或归档条目所进入的剧情，使参与者的意愿继续生效
+func copyStoryConsent(tx *gorm.DB, fromIDs []uint, toID uint) error {
+	var participants []model.StoryParticipant
+	if err := tx.Where("story_id IN ?", fromIDs).Find(&participants).Error; err != nil {
+		return err
+	}
+	for _, participant := range participants {
+		participant.ID = 0
+		participant.StoryID = toID
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&participant).Error; err != nil {
+			return err
+		}
+	}
+	var requests []model.StoryRedactionRequest
+	if err := tx.Where("story_id IN ? AND status = ?", fromIDs, storyRedactionApproved).Find(&requests).Error; err != nil {
+		return err
+	}
+	for _, request := range requests {
+		request.ID = 0
+		request.StoryID = toID
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&request).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listMyStoryParticipations 获取当前用户作为参与者出现的剧情及隐去请求状态
+func (s *Server) listMyStoryParticipations(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var participants []model.StoryParticipant
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&participants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	storyIDs := make([]uint, 0, len(participants))
+	for _, participant := range participants {
+		storyIDs = append(storyIDs, participant.StoryID)
+	}
+
+	stories := make(map[uint]model.Story)
+	authors := make(map[uint]string)
+	requests := make(map[string]model.StoryRedactionRequest)
+	sharedByLink := make(map[uint]bool)
+	if len(storyIDs) > 0 {
+		var storyList []model.Story
+		database.DB.Select("id", "user_id", "title", "is_public", "share_code").Where("id IN ?", storyIDs).Find(&storyList)
+		authorIDs := make([]uint, 0, len(storyList))
+		for _, story := range storyList {
+			stories[story.ID] = story
+			authorIDs = append(authorIDs, story.UserID)
+		}
+		var users []model.User
+		database.DB.Select("id", "username").Where("id IN ?", authorIDs).Find(&users)
+		for _, user := range users {
+			authors[user.ID] = user.Username
+		}
+		// 未公开的剧情也可能通过分享链接被他人看到
+		var linked []uint
+		database.DB.Model(&model.StoryShareLink{}).
+			Where("story_id IN ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", storyIDs, time.Now()).
+			Distinct().Pluck("story_id", &linked)
+		for _, id := range linked {
+			sharedByLink[id] = true
+		}
+		var requestList []model.StoryRedactionRequest
+		database.DB.Where("user_id = ? AND story_id IN ?", userID, storyIDs).Find(&requestList)
+		for _, request := range requestList {
+			requests[fmt.Sprintf("%d:%s", request.StoryID, request.GameID)] = request
+		}
+	}
+
+	items := make([]gin.H, 0, len(participants))
+	for _, participant := range participants {
+		story, ok := stories[participant.StoryID]
+		if !ok {
+			continue
+		}
+		item := gin.H{
+			"story_id":       story.ID,
+			"title":          story.Title,
+			"author":         authors[story.UserID],
+			"is_public":      story.IsPublic,
+			"shared_by_link": sharedByLink[story.ID],
+			"share_code":     "",
+			"game_id":        participant.GameID,
+			"line_count":     participant.LineCount,
+			"notified_at":    participant.NotifiedAt,
+			"request":        nil,
+		}
+		if story.IsPublic {
+			item["share_code"] = story.ShareCode
+		}
+		if request, ok := requests[fmt.Sprintf("%d:%s", story.ID, participant.GameID)]; ok {
+			item["request"] = request
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"participations": items})
+}
+
+// createStoryRedactionRequest 参与者申请隐去或匿名自己角色在公开视图中的发言；未处理或被拒绝的请求可重新提交
+func (s *Server) createStoryRedactionRequest(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.First(&story, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return
+	}
+
+	var req CreateStoryRedactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	var participant model.StoryParticipant
+	if err := database.DB.Where("story_id = ? AND user_id = ? AND game_id = ?", story.ID, userID, req.GameID).
+		First(&participant).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "你不是该角色在剧情中的参与者"})
+		return
+	}
+
+	var request model.StoryRedactionRequest
+	status := http.StatusOK
+	err := database.DB.Where("story_id = ? AND user_id = ? AND game_id = ?", story.ID, userID, req.GameID).First(&request).Error
+	switch {
+	case err == nil && request.Status == storyRedactionApproved:
+		c.JSON(http.StatusConflict, gin.H{"error": "请求已生效，如需修改请先撤回"})
+		return
+	case err == nil:
+		err = database.DB.Model(&request).Updates(map[string]interface{}{
+			"mode":        req.Mode,
+			"reason":      strings.TrimSpace(req.Reason),
+			"status":      storyRedactionPending,
+			"review_note": "",
+			"reviewed_by": 0,
+			"reviewed_at": nil,
+		}).Error
+	default:
+		request = model.StoryRedactionRequest{
+			StoryID: story.ID,
+			UserID:  userID,
+			GameID:  req.GameID,
+			Mode:    req.Mode,
+			Reason:  strings.TrimSpace(req.Reason),
+			Status:  storyRedactionPending,
+		}
+		err = database.DB.Create(&request).Error
+		status = http.StatusCreated
+	}
+	if err != nil {
+		log.Printf("[Story] redaction request error: story=%d user=%d err=%v", story.ID, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交失败"})
+		return
+	}
+
+	actorID := userID
+	if err := service.CreateNotification(&model.Notification{
+		UserID:     story.UserID,
+		Type:       "story_consent",
+		ActorID:    &actorID,
+		TargetType: "story",
+		TargetID:   story.ID,
+		Content:    fmt.Sprintf("申请在剧情《%s》的公开页面中%s角色 %s 的发言", story.Title, storyRedactionModeLabel(req.Mode), req.GameID),
+	}); err != nil {
+		log.Printf("[Story] redaction notification error: story=%d err=%v", story.ID, err)
+	}
+	c.JSON(status, request)
+}
+
+// withdrawStoryRedactionRequest 参与者撤回自己的隐去请求，已生效的隐去随之取消
+func (s *Server) withdrawStoryRedactionRequest(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	requestID, _ := strconv.ParseUint(c.Param("requestId"), 10, 32)
+
+	result := database.DB.Where("id = ? AND story_id = ? AND user_id = ?", requestID, id, userID).
+		Delete(&model.StoryRedactionRequest{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤回失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "请求不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已撤回"})
+}
+
+// listStoryRedactionRequests 作者查看剧情的隐去请求。可选参数：status
+func (s *Server) listStoryRedactionRequests(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleOwner)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("story_id = ?", story.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var requests []model.StoryRedactionRequest
+	if err := query.Order("created_at ASC, id ASC").Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	userIDs := make([]uint, 0, len(requests))
+	for _, request := range requests {
+		userIDs = append(userIDs, request.UserID)
+	}
+	usernames := make(map[uint]string)
+	lineCounts := make(map[string]int)
+	if len(userIDs) > 0 {
+		var users []model.User
+		database.DB.Select("id", "username").Where("id IN ?", userIDs).Find(&users)
+		for _, user := range users {
+			usernames[user.ID] = user.Username
+		}
+		var participants []model.StoryParticipant
+		database.DB.Where("story_id = ? AND user_id IN ?", story.ID, userIDs).Find(&participants)
+		for _, participant := range participants {
+			lineCounts[fmt.Sprintf("%d:%s", participant.UserID, participant.GameID)] = participant.LineCount
+		}
+	}
+
+	items := make([]gin.H, 0, len(requests))
+	for _, request := range requests {
+		items = append(items, gin.H{
+			"request":    request,
+			"username":   usernames[request.UserID],
+			"line_count": lineCounts[fmt.Sprintf("%d:%s", request.UserID, request.GameID)],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": items})
+}
+
+// reviewStoryRedactionRequest 作者批准或拒绝隐去请求，并通知参与者
+func (s *Server) reviewStoryRedactionRequest(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleOwner)
+	if !ok {
+		return
+	}
+	requestID, _ := strconv.ParseUint(c.Param("requestId"), 10, 32)
+
+	var req ReviewStoryRedactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	var request model.StoryRedactionRequest
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND story_id = ?", requestID, story.ID).First(&request).Error; err != nil {
+			return err
+		}
+		if request.Status != storyRedactionPending {
+			return errStoryRedactionReviewed
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":      storyRedactionRejected,
+			"review_note": strings.TrimSpace(req.Note),
+			"reviewed_by": userID,
+			"reviewed_at": now,
+		}
+		if *req.Approve {
+			updates["status"] = storyRedactionApproved
+			if request.Mode == storyRedactionAnonymize {
+				alias := strings.TrimSpace(req.Alias)
+				if alias == "" {
+					var count int64
+					if err := tx.Model(&model.StoryRedactionRequest{}).
+						Where("story_id = ? AND mode = ? AND status = ?", story.ID, storyRedactionAnonymize, storyRedactionApproved).
+						Count(&count).Error; err != nil {
+						return err
+					}
+					alias = fmt.Sprintf("匿名参与者%d", count+1)
+				}
+				updates["alias"] = alias
+			}
+		}
+		return tx.Model(&request).Updates(updates).Error
+	})
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "请求不存在"})
+		return
+	case err == errStoryRedactionReviewed:
+		c.JSON(http.StatusConflict, gin.H{"error": "该请求已处理"})
+		return
+	case err != nil:
+		log.Printf("[Story] redaction review error: story=%d request=%d err=%v", story.ID, requestID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "审核失败"})
+		return
+	}
+
+	result := "拒绝"
+	if request.Status == storyRedactionApproved {
+		result = "批准"
+	}
+	actorID := userID
+	if err := service.CreateNotification(&model.Notification{
+		UserID:     request.UserID,
+		Type:       "story_consent",
+		ActorID:    &actorID,
+		TargetType: "story",
+		TargetID:   story.ID,
+		Content:    fmt.Sprintf("%s了你在剧情《%s》中%s角色 %s 发言的请求", result, story.Title, storyRedactionModeLabel(request.Mode), request.GameID),
+	}); err != nil {
+		log.Printf("[Story] redaction notification error: story=%d err=%v", story.ID, err)
+	}
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryParticipantConsent(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryGuild{},
+		&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryChapter{}, &model.StoryShareLink{},
+		&model.StoryShareVisit{}, &model.StoryShareReferrer{}, &model.StoryParticipant{}, &model.StoryRedactionRequest{},
+		&model.Notification{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	bryn := model.User{Username: "bryn", Email: "bryn@example.com", PassHash: "hash"}
+	cara := model.User{Username: "cara", Email: "cara@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&bryn)
+	db.Create(&cara)
+
+	// 作者上传的角色；参与者在自己账号下有同一 GameID 的角色
+	aldric := model.Character{UserID: owner.ID, GameID: "Aldric-Realm", FirstName: "Aldric"}
+	brynChar := model.Character{UserID: owner.ID, GameID: "Bryn-Realm", FirstName: "Bryn"}
+	caraChar := model.Character{UserID: owner.ID, GameID: "Cara-Realm", FirstName: "Cara"}
+	guard := model.Character{UserID: owner.ID, GameID: "Guard-Realm", FirstName: "Guard", IsNPC: true}
+	for _, character := range []*model.Character{&aldric, &brynChar, &caraChar, &guard} {
+		db.Create(character)
+	}
+	db.Create(&model.Character{UserID: bryn.ID, GameID: "Bryn-Realm", FirstName: "Bryn"})
+	db.Create(&model.Character{UserID: cara.ID, GameID: "Cara-Realm", FirstName: "Cara"})
+	db.Create(&model.Character{UserID: cara.ID, GameID: "Guard-Realm", FirstName: "Guard"})
+
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	story := model.Story{UserID: owner.ID, Title: "守夜", StartTime: base, EndTime: base.Add(time.Hour),
+		Participants: `["Aldric","Bryn","Cara"]`}
+	db.Create(&story)
+	lines := []struct {
+		character *model.Character
+		content   string
+	}{
+		{&aldric, "Halt"},
+		{&brynChar, "Bryn secret"},
+		{&caraChar, "Cara speaks"},
+		{&guard, "Move along"},
+		{&brynChar, "Bryn again"},
+	}
+	for i, line := range lines {
+		db.Create(&model.StoryEntry{StoryID: story.ID, CharacterID: &line.character.ID, Speaker: line.character.FirstName,
+			Type: "dialogue", Content: line.content, SortOrder: i + 1, Timestamp: base.Add(time.Duration(i) * time.Minute)})
+	}
+	// 纯文本导入的条目没有关联角色，只有说话者名称
+	db.Create(&model.StoryEntry{StoryID: story.ID, Speaker: "Bryn", Type: "dialogue", Content: "Bryn unlinked",
+		SortOrder: len(lines) + 1, Timestamp: base.Add(time.Duration(len(lines)) * time.Minute)})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	brynToken := newTestToken(t, bryn)
+	caraToken := newTestToken(t, cara)
+	path := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+
+	// 公开时通知参与者，NPC 与作者自己的角色不算参与者；重复发布不重复通知
+	for i := 0; i < 2; i++ {
+		if resp := performRequest(server.router, http.MethodPost, path+"/publish", map[string]interface{}{"is_public": true}, ownerToken); resp.Code != http.StatusOK {
+			t.Fatalf("publish: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+	var participants []model.StoryParticipant
+	db.Order("user_id").Find(&participants)
+	if len(participants) != 2 || participants[0].UserID != bryn.ID || participants[0].LineCount != 2 || participants[1].GameID != "Cara-Realm" {
+		t.Fatalf("unexpected participants %+v", participants)
+	}
+	var notified int64
+	db.Model(&model.Notification{}).Where("type = ?", "story_consent").Count(&notified)
+	if notified != 2 {
+		t.Fatalf("expected 2 consent notifications, got %d", notified)
+	}
+	db.First(&story, story.ID)
+
+	resp := performRequest(server.router, http.MethodGet, "/api/v1/user/story-participations", nil, brynToken)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), story.ShareCode) {
+		t.Fatalf("participations: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 只能为自己参与的角色提出请求
+	if resp := performRequest(server.router, http.MethodPost, path+"/redaction-requests", map[string]interface{}{"game_id": "Cara-Realm", "mode": "redact"}, brynToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("non-participant request should 403, got %d", resp.Code)
+	}
+	createRequest := func(token, gameID, mode string) model.StoryRedactionRequest {
+		resp := performRequest(server.router, http.MethodPost, path+"/redaction-requests", map[string]interface{}{"game_id": gameID, "mode": mode, "reason": "隐私"}, token)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("create request: %d %s", resp.Code, resp.Body.String())
+		}
+		var request model.StoryRedactionRequest
+		json.Unmarshal(resp.Body.Bytes(), &request)
+		return request
+	}
+	brynRequest := createRequest(brynToken, "Bryn-Realm", storyRedactionRedact)
+	caraRequest := createRequest(caraToken, "Cara-Realm", storyRedactionAnonymize)
+
+	// 只有作者可以查看与审核
+	if resp := performRequest(server.router, http.MethodGet, path+"/redaction-requests", nil, brynToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("participant should not list requests, got %d", resp.Code)
+	}
+	resp = performRequest(server.router, http.MethodGet, path+"/redaction-requests?status=pending", nil, ownerToken)
+	var pending struct {
+		Requests []struct {
+			Username  string `json:"username"`
+			LineCount int    `json:"line_count"`
+		} `json:"requests"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &pending)
+	if len(pending.Requests) != 2 || pending.Requests[0].Username != "bryn" || pending.Requests[0].LineCount != 2 {
+		t.Fatalf("unexpected pending requests %s", resp.Body.String())
+	}
+
+	review := func(request model.StoryRedactionRequest, body map[string]interface{}, status int) {
+		url := fmt.Sprintf("%s/redaction-requests/%d/review", path, request.ID)
+		if resp := performRequest(server.router, http.MethodPost, url, body, ownerToken); resp.Code != status {
+			t.Fatalf("review: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+	review(brynRequest, map[string]interface{}{"approve": true}, http.StatusOK)
+	review(brynRequest, map[string]interface{}{"approve": false}, http.StatusConflict)
+	review(caraRequest, map[string]interface{}{"approve": true}, http.StatusOK)
+
+	// 公开视图应用隐去，原始条目与作者视图不受影响
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+story.ShareCode, nil, "")
+	var public struct {
+		Story   model.Story        `json:"story"`
+		Entries []model.StoryEntry `json:"entries"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &public)
+	if len(public.Entries) != 6 || public.Entries[1].Content != storyRedactedContent || public.Entries[4].Content != storyRedactedContent ||
+		public.Entries[5].Content != storyRedactedContent {
+		t.Fatalf("redacted lines should be hidden: %s", resp.Body.String())
+	}
+	if public.Entries[2].Speaker != "匿名参与者1" || public.Entries[2].CharacterID != nil || public.Entries[2].Content != "Cara speaks" {
+		t.Fatalf("anonymized line should hide the speaker: %+v", public.Entries[2])
+	}
+	if public.Entries[0].Content != "Halt" || public.Entries[3].Content != "Move along" {
+		t.Fatalf("other lines should be untouched: %+v", public.Entries)
+	}
+	if public.Story.Participants != `["Aldric","匿名参与者1"]` {
+		t.Fatalf("public participants should be scrubbed, got %s", public.Story.Participants)
+	}
+	resp = performRequest(server.router, http.MethodGet, path, nil, ownerToken)
+	if !strings.Contains(resp.Body.String(), "Bryn secret") {
+		t.Fatalf("owner view should keep the original: %s", resp.Body.String())
+	}
+	var original model.StoryEntry
+	db.Where("content = ?", "Bryn secret").First(&original)
+	if original.ID == 0 {
+		t.Fatal("original entry should be unchanged")
+	}
+
+	// 公开导出与 NDJSON 同样应用隐去
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+story.ShareCode+"/export?format=text", nil, "")
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), "Bryn secret") || strings.Contains(resp.Body.String(), "Bryn unlinked") ||
+		!strings.Contains(resp.Body.String(), "Cara speaks") {
+		t.Fatalf("public export should be redacted: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+story.ShareCode+"?format=ndjson", nil, "")
+	if strings.Contains(resp.Body.String(), "Bryn secret") || strings.Contains(resp.Body.String(), "Bryn unlinked") ||
+		strings.Contains(resp.Body.String(), `\"Cara\"`) || !strings.Contains(resp.Body.String(), "匿名参与者1") {
+		t.Fatalf("public stream should be redacted: %s", resp.Body.String())
+	}
+
+	// 已生效的请求不能覆盖，撤回后恢复原文
+	if resp := performRequest(server.router, http.MethodPost, path+"/redaction-requests", map[string]interface{}{"game_id": "Bryn-Realm", "mode": "anonymize"}, brynToken); resp.Code != http.StatusConflict {
+		t.Fatalf("approved request should not be overwritten, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/redaction-requests/%d", path, brynRequest.ID), nil, brynToken); resp.Code != http.StatusOK {
+		t.Fatalf("withdraw: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+story.ShareCode, nil, "")
+	if !strings.Contains(resp.Body.String(), "Bryn secret") {
+		t.Fatalf("withdrawn redaction should no longer apply: %s", resp.Body.String())
+	}
+}
+
+func TestStoryShareLinkNotifiesParticipants(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.Story{}, &model.StoryEntry{},
+		&model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryShareLink{}, &model.StoryParticipant{},
+		&model.StoryRedactionRequest{}, &model.Notification{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	bryn := model.User{Username: "bryn", Email: "bryn@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&bryn)
+	brynChar := model.Character{UserID: owner.ID, GameID: "Bryn-Realm", FirstName: "Bryn"}
+	db.Create(&brynChar)
+	db.Create(&model.Character{UserID: bryn.ID, GameID: "Bryn-Realm", FirstName: "Bryn"})
+
+	story := model.Story{UserID: owner.ID, Title: "密谈"}
+	db.Create(&story)
+	db.Create(&model.StoryEntry{StoryID: story.ID, CharacterID: &brynChar.ID, Speaker: "Bryn", Type: "dialogue", Content: "secret", SortOrder: 1})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	path := fmt.Sprintf("/api/v1/stories/%d/share-links", story.ID)
+
+	// 未公开的剧情创建第一条分享链接时通知参与者，之后的链接不重复通知
+	for i := 0; i < 2; i++ {
+		if resp := performRequest(server.router, http.MethodPost, path, map[string]interface{}{"label": "群聊"}, ownerToken); resp.Code != http.StatusCreated {
+			t.Fatalf("create share link: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+	var notifications []model.Notification
+	db.Where("type = ?", "story_consent").Find(&notifications)
+	if len(notifications) != 1 || notifications[0].UserID != bryn.ID || !strings.Contains(notifications[0].Content, "分享链接") {
+		t.Fatalf("unexpected consent notifications %+v", notifications)
+	}
+
+	resp := performRequest(server.router, http.MethodGet, "/api/v1/user/story-participations", nil, newTestToken(t, bryn))
+	var result struct {
+		Participations []struct {
+			IsPublic     bool   `json:"is_public"`
+			SharedByLink bool   `json:"shared_by_link"`
+			ShareCode    string `json:"share_code"`
+		} `json:"participations"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	if len(result.Participations) != 1 || result.Participations[0].IsPublic || !result.Participations[0].SharedByLink ||
+		result.Participations[0].ShareCode != "" {
+		t.Fatalf("participation should reflect the share link, got %s", resp.Body.String())
+	}
+}
+
+func TestStoryRedactionSameNameSpeakers(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.Story{}, &model.StoryEntry{}, &model.StoryRedactionRequest{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	brynRealm := model.Character{UserID: owner.ID, GameID: "Bryn-Realm", FirstName: "Bryn"}
+	brynOther := model.Character{UserID: owner.ID, GameID: "Bryn-Other", FirstName: "Bryn"}
+	db.Create(&brynRealm)
+	db.Create(&brynOther)
+
+	build := func(title string, withOther bool) []model.StoryEntry {
+		story := model.Story{UserID: owner.ID, Title: title, Participants: `["Bryn"]`}
+		db.Create(&story)
+		entries := []model.StoryEntry{
+			{StoryID: story.ID, CharacterID: &brynRealm.ID, Speaker: "Bryn", Content: "linked realm"},
+			{StoryID: story.ID, Speaker: "Bryn-Realm", Content: "full realm"},
+			{StoryID: story.ID, Speaker: "Bryn", Content: "bare"},
+		}
+		if withOther {
+			entries = append(entries,
+				model.StoryEntry{StoryID: story.ID, CharacterID: &brynOther.ID, Speaker: "Bryn", Content: "linked other"},
+				model.StoryEntry{StoryID: story.ID, Speaker: "Bryn-Other", Content: "full other"})
+		}
+		for i := range entries {
+			entries[i].Type = "dialogue"
+			entries[i].SortOrder = i + 1
+			db.Create(&entries[i])
+		}
+		db.Create(&model.StoryRedactionRequest{StoryID: story.ID, UserID: 99, GameID: "Bryn-Realm",
+			Mode: storyRedactionRedact, Status: storyRedactionApproved})
+		redactions := loadStoryRedactions(story.ID)
+		applyStoryRedactions(entries, redactions)
+		redactStoryParticipants(&story, redactions)
+		if withOther && story.Participants != `["Bryn"]` {
+			t.Fatalf("ambiguous participant name should be kept, got %s", story.Participants)
+		}
+		return entries
+	}
+
+	// 两个服务器各有一个 Bryn：只隐去完整名称匹配的发言，只写 Bryn 的条目无法判断归属
+	entries := build("同名", true)
+	expected := []bool{true, true, false, false, false}
+	for i, entry := range entries {
+		if (entry.Content == storyRedactedContent) != expected[i] {
+			t.Fatalf("unexpected redaction for entry %d: %+v", i, entry)
+		}
+	}
+
+	// 剧情中只有一个 Bryn 时，只写角色名的条目也能匹配
+	entries = build("单人", false)
+	for _, entry := range entries {
+		if entry.Content != storyRedactedContent {
+			t.Fatalf("entry should be redacted when the name is unambiguous: %+v", entry)
+		}
+	}
+}
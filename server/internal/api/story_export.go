@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+)
+
+// exportStory 导出剧情（?format=markdown|html|epub|bbcode|text），权限与查看剧情一致
+func (s *Server) exportStory(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.Where("id = ?", id).First(&story).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return
+	}
+
+	if !canViewStory(&story, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return
+	}
+
+	// 与书签列表一致：用户自己的书签 + 公共书签
+	var bookmarks []model.StoryBookmark
+	database.DB.Where("story_id = ? AND (user_id = ? OR is_public = ?)", story.ID, userID, true).
+		Order("created_at ASC").Find(&bookmarks)
+
+	s.sendStoryExport(c, story, bookmarks, nil)
+}
+
+// exportPublicStory 通过分享码导出公开剧情（无需登录），只包含公共书签
+func (s *Server) exportPublicStory(c *gin.Context) {
+	story, _, ok := s.loadPublicStory(c)
+	if !ok {
+		return
+	}
+
+	var bookmarks []model.StoryBookmark
+	database.DB.Where("story_id = ? AND is_public = ?", story.ID, true).
+		Order("created_at ASC").Find(&bookmarks)
+
+	s.sendStoryExport(c, *story, bookmarks, loadStoryRedactions(story.ID))
+}
+
+// sendStoryExport 按请求参数渲染剧情并作为附件返回。
+// 可选参数：bbcode=nga|discuz 指定 BBCode 方言，tz 指定时间显示的时区（如 Asia/Shanghai）。
+// redactions 为公开导出需要应用的隐去请求，私有导出传 nil
+func (s *Server) sendStoryExport(c *gin.Context, story model.Story, bookmarks []model.StoryBookmark, redactions *storyRedactions) {
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", service.StoryExportMarkdown)))
+	contentType, extension, ok := service.StoryExportFileType(format)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的导出格式"})
+		return
+	}
+	dialect := strings.ToLower(c.DefaultQuery("bbcode", service.BBCodeDiscuz))
+	if dialect != service.BBCodeDiscuz && dialect != service.BBCodeNGA {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的 BBCode 格式"})
+		return
+	}
+	var loc *time.Location
+	if tz := strings.TrimSpace(c.Query("tz")); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时区"})
+			return
+		}
+	}
+
+	// 与阅读视图相同的顺序：按章节排列，未分章的条目在最后
+	var entries []model.StoryEntry
+	database.DB.Where("story_id = ?", story.ID).Order("timestamp, sort_order").Find(&entries)
+	chapters, err := loadStoryChapters(database.DB, story.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		return
+	}
+	arrangeStoryChapters(entries, chapters)
+	applyStoryRedactions(entries, redactions)
+	redactStoryParticipants(&story, redactions)
+
+	// 说话者按条目时间点的角色版本显示
+	charactersMap, versionsMap := s.loadStoryCharacters(c, entries)
+	entryCharacters := make(map[uint]model.Character, len(entries))
+	for _, entry := range entries {
+		if entry.CharacterVersionID != nil {
+			if character, ok := versionsMap[*entry.CharacterVersionID]; ok {
+				entryCharacters[entry.ID] = character
+				continue
+			}
+		}
+		if entry.CharacterID != nil {
+			if character, ok := charactersMap[*entry.CharacterID]; ok {
+				entryCharacters[entry.ID] = character
+			}
+		}
+	}
+
+	var author model.User
+	database.DB.First(&author, story.UserID)
+
+	var buf bytes.Buffer
+	err = service.ExportStory(&buf, format, service.StoryExport{
+		Story:      story,
+		Author:     author.Username,
+		Entries:    entries,
+		Characters: entryCharacters,
+		Bookmarks:  bookmarks,
+		Chapters:   chapters,
+		BBCode:     dialect,
+		Location:   loc,
+		ImageURL: func(url string) string {
+			if strings.HasPrefix(url, "data:") {
+				return url
+			}
+			return buildAPIURL(s.cfg.Server.ApiHost, url)
+		},
+	})
+	if err != nil {
+		log.Printf("[Story] export error: story=%d format=%s err=%v", story.ID, format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		return
+	}
+
+	filename := storyExportFilename(story) + "." + extension
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// storyExportFilename 以剧情标题作为文件名，去掉文件系统不允许的字符
+func storyExportFilename(story model.Story) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(story.Title))
+	if name == "" {
+		return "story-" + strconv.FormatUint(uint64(story.ID), 10)
+	}
+	return name
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryExportOwnerAndShareCode(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryGuild{}, &model.StoryBookmark{}, &model.StoryShareLink{},
+		&model.StoryChapter{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	other := model.User{Username: "other", Email: "other@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&other)
+	character := model.Character{UserID: owner.ID, FirstName: "Aldric", CustomColor: "ff0000"}
+	db.Create(&character)
+	story := model.Story{UserID: owner.ID, Title: "Gate/Watch", IsPublic: true, ShareCode: "share123"}
+	db.Create(&story)
+	entry := model.StoryEntry{StoryID: story.ID, CharacterID: &character.ID, Speaker: "Aldric", Channel: "SAY",
+		Content: "Halt", Timestamp: time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)}
+	db.Create(&entry)
+	db.Create(&model.StoryBookmark{StoryID: story.ID, UserID: owner.ID, EntryID: entry.ID, Name: "Private mark"})
+	db.Create(&model.StoryBookmark{StoryID: story.ID, UserID: owner.ID, EntryID: entry.ID, Name: "Public mark", IsPublic: true})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	path := fmt.Sprintf("/api/v1/stories/%d/export", story.ID)
+
+	resp := performRequest(server.router, http.MethodGet, path+"?format=html&tz=UTC", nil, ownerToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	body := resp.Body.String()
+	if !strings.Contains(body, `style="color:#ff0000">Aldric</span>`) || !strings.Contains(body, "Private mark") {
+		t.Fatalf("unexpected html export: %s", body)
+	}
+	if disposition := resp.Header().Get("Content-Disposition"); !strings.Contains(disposition, "Gate_Watch.html") {
+		t.Fatalf("unexpected content disposition %q", disposition)
+	}
+
+	resp = performRequest(server.router, http.MethodGet, path+"?format=pdf", nil, ownerToken)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unsupported format to 400, got %d", resp.Code)
+	}
+	resp = performRequest(server.router, http.MethodGet, path, nil, newTestToken(t, other))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected personal story hidden from others, got %d", resp.Code)
+	}
+
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/share123/export?format=text", nil, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected public export 200, got %d body=%s", resp.Code, resp.Body.String())
+	}
+	body = resp.Body.String()
+	if !strings.Contains(body, "Public mark") || strings.Contains(body, "Private mark") {
+		t.Fatalf("public export should only include public bookmarks: %s", body)
+	}
+
+	// 与阅读视图一致按章节排列并输出章节标题
+	chapter := model.StoryChapter{StoryID: story.ID, Title: "Prologue", SortOrder: 1}
+	db.Create(&chapter)
+	db.Create(&model.StoryEntry{StoryID: story.ID, ChapterID: &chapter.ID, Speaker: "Bryn", Channel: "SAY",
+		Content: "Who goes there?", Timestamp: time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)})
+	resp = performRequest(server.router, http.MethodGet, path+"?format=text&tz=UTC", nil, ownerToken)
+	body = resp.Body.String()
+	prologue, unassigned := strings.Index(body, "【Prologue】"), strings.Index(body, "【未分章节】")
+	if resp.Code != http.StatusOK || prologue < 0 || unassigned < prologue ||
+		strings.Index(body, "Who goes there?") > unassigned || strings.Index(body, "Halt") < unassigned {
+		t.Fatalf("export should follow the chapter reading order: %s", body)
+	}
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"gorm.io/gorm"
+)
+
+// 条目修订类型
+const (
+	storyRevisionUpdate = "update"
+	storyRevisionDelete = "delete"
+	storyRevisionRevert = "revert"
+)
+
+var (
+	errStoryHistoryNothingToUndo = errors.New("nothing to undo")
+	errStoryHistoryNotOwnBatch   = errors.New("batch belongs to another user")
+)
+
+// newStoryHistoryBatchID 生成一次操作的批次号
+func newStoryHistoryBatchID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// recordStoryEntryRevisions 在修改或删除前保存条目快照，同一次操作的修订共享批次号
+func recordStoryEntryRevisions(tx *gorm.DB, userID uint, action, batchID string, entries []model.StoryEntry, fields []string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	revisions := make([]model.StoryEntryRevision, 0, len(entries))
+	for i := range entries {
+		snapshot, err := json.Marshal(&entries[i])
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, model.StoryEntryRevision{
+			StoryID:     entries[i].StoryID,
+			EntryID:     entries[i].ID,
+			BatchID:     batchID,
+			UserID:      userID,
+			Action:      action,
+			Fields:      strings.Join(fields, ","),
+			Snapshot:    string(snapshot),
+			ContentHash: entries[i].ContentHash,
+		})
+	}
+	return tx.CreateInBatches(revisions, storyEntryIDChunk).Error
+}
+
+// changedStoryEntryFields 比较修改前后的条目，返回变化的字段
+func changedStoryEntryFields(before, after *model.StoryEntry) []string {
+	var fields []string
+	if before.Content != after.Content {
+		fields = append(fields, "content")
+	}
+	if before.Speaker != after.Speaker {
+		fields = append(fields, "speaker")
+	}
+	if before.Channel != after.Channel {
+		fields = append(fields, "channel")
+	}
+	if before.Type != after.Type {
+		fields = append(fields, "type")
+	}
+	if (before.CharacterID == nil) != (after.CharacterID == nil) ||
+		(before.CharacterID != nil && *before.CharacterID != *after.CharacterID) {
+		fields = append(fields, "character_id")
+	}
+	if !before.Timestamp.Equal(after.Timestamp) {
+		fields = append(fields, "timestamp")
+	}
+	if before.BackgroundColor != after.BackgroundColor {
+		fields = append(fields, "background_color")
+	}
+	if before.GroupName != after.GroupName {
+		fields = append(fields, "group_name")
+	}
+	return fields
+}
+
+// revisionSnapshot 解析修订中保存的条目
+func revisionSnapshot(revision *model.StoryEntryRevision) (model.StoryEntry, error) {
+	var entry model.StoryEntry
+	if err := json.Unmarshal([]byte(revision.Snapshot), &entry); err != nil {
+		return entry, err
+	}
+	entry.ContentHash = revision.ContentHash
+	entry.SearchText = entry.SearchDocument()
+	return entry, nil
+}
+
+// applyStoryEntryRevision 把条目恢复为修订保存的状态：已删除的条目按原ID重新创建，
+// 仍存在的条目先保存当前状态（revert 修订）再覆盖。条目已移到其他剧情、
+// 或同一来源ID的条目已重新上传时跳过，返回 false
+func applyStoryEntryRevision(tx *gorm.DB, userID uint, batchID string, revision *model.StoryEntryRevision) (bool, error) {
+	entry, err := revisionSnapshot(revision)
+	if err != nil {
+		return false, err
+	}
+	entry.UpdatedBy = userID
+	// 剧情合并后修订随条目迁移，快照中的剧情ID可能已失效，以修订所在剧情为准
+	entry.StoryID = revision.StoryID
+
+	var current model.StoryEntry
+	err = tx.Where("id = ?", revision.EntryID).First(&current).Error
+	switch {
+	case err == nil:
+		if current.StoryID != revision.StoryID {
+			return false, nil
+		}
+		// 章节归属不属于条目内容，沿用当前章节
+		entry.ChapterID = current.ChapterID
+		if err := recordStoryEntryRevisions(tx, userID, storyRevisionRevert, batchID, []model.StoryEntry{current}, changedStoryEntryFields(&current, &entry)); err != nil {
+			return false, err
+		}
+		if err := tx.Save(&entry).Error; err != nil {
+			return false, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if entry.SourceID != "" {
+			var count int64
+			if err := tx.Model(&model.StoryEntry{}).
+				Where("story_id = ? AND source_id = ?", revision.StoryID, entry.SourceID).
+				Count(&count).Error; err != nil {
+				return false, err
+			}
+			if count > 0 {
+				return false, nil
+			}
+		}
+		if entry.ChapterID != nil {
+			var count int64
+			if err := tx.Model(&model.StoryChapter{}).
+				Where("id = ? AND story_id = ?", *entry.ChapterID, revision.StoryID).
+				Count(&count).Error; err != nil {
+				return false, err
+			}
+			if count == 0 {
+				entry.ChapterID = nil
+			}
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			return false, err
+		}
+	default:
+		return false, err
+	}
+
+	now := time.Now()
+	if err := tx.Model(revision).Updates(map[string]interface{}{"restored_at": now, "restored_by": userID}).Error; err != nil {
+		return false, err
+	}
+	revision.RestoredAt = &now
+	revision.RestoredBy = userID
+	return true, nil
+}
+
+// listStoryHistory 获取剧情条目的变更历史，按时间倒序。
+// 可选参数：entry_id、batch_id、action，deleted=true 只看尚未恢复的删除（回收站）
+func (s *Server) listStoryHistory(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleViewer)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	query := database.DB.Model(&model.StoryEntryRevision{}).Where("story_id = ?", story.ID)
+	if entryID, err := strconv.ParseUint(c.Query("entry_id"), 10, 32); err == nil {
+		query = query.Where("entry_id = ?", entryID)
+	}
+	if batchID := c.Query("batch_id"); batchID != "" {
+		query = query.Where("batch_id = ?", batchID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if c.Query("deleted") == "true" {
+		query = query.Where("action = ? AND restored_at IS NULL", storyRevisionDelete)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var revisions []model.StoryEntryRevision
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	items := make([]gin.H, 0, len(revisions))
+	for i := range revisions {
+		snapshot, err := revisionSnapshot(&revisions[i])
+		if err != nil {
+			log.Printf("[Story] decode revision error: revision=%d err=%v", revisions[i].ID, err)
+			continue
+		}
+		fields := []string{}
+		if revisions[i].Fields != "" {
+			fields = strings.Split(revisions[i].Fields, ",")
+		}
+		items = append(items, gin.H{
+			"id":          revisions[i].ID,
+			"entry_id":    revisions[i].EntryID,
+			"batch_id":    revisions[i].BatchID,
+			"user_id":     revisions[i].UserID,
+			"action":      revisions[i].Action,
+			"fields":      fields,
+			"before":      snapshot,
+			"restored_at": revisions[i].RestoredAt,
+			"restored_by": revisions[i].RestoredBy,
+			"created_at":  revisions[i].CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// restoreStoryRevision 把条目恢复为某条修订保存的状态：删除修订会重新创建条目，
+// 修改修订会把条目改回修改前的内容。贡献者只能恢复自己添加的条目
+func (s *Server) restoreStoryRevision(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
+		return
+	}
+	revisionID, _ := strconv.ParseUint(c.Param("revisionId"), 10, 32)
+
+	var revision model.StoryEntryRevision
+	if err := database.DB.Where("id = ? AND story_id = ?", revisionID, story.ID).First(&revision).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "修订不存在"})
+		return
+	}
+	if revision.Action == storyRevisionDelete && revision.RestoredAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "条目已恢复"})
+		return
+	}
+	snapshot, err := revisionSnapshot(&revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+		return
+	}
+	if !canModifyStoryEntry(role, userID, &snapshot) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能恢复自己添加的条目"})
+		return
+	}
+
+	var restored bool
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		restored, err = applyStoryEntryRevision(tx, userID, newStoryHistoryBatchID(), &revision)
+		return err
+	})
+	if err != nil {
+		log.Printf("[Story] restore revision error: story=%d revision=%d err=%v", story.ID, revision.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "恢复失败"})
+		return
+	}
+	if !restored {
+		c.JSON(http.StatusConflict, gin.H{"error": "条目已移至其他剧情或已重新上传，无法恢复"})
+		return
+	}
+
+	database.DB.Model(story).Update("updated_at", time.Now())
+	recordStoryActivity(database.DB, story.ID, userID, storyActionEntryRestore, revision.EntryID, 1)
+
+	var entry model.StoryEntry
+	database.DB.First(&entry, revision.EntryID)
+	c.JSON(http.StatusOK, gin.H{"message": "恢复成功", "entry": entry})
+}
+
+// undoStoryBatch 撤销剧情中最近一次尚未撤销的修改或删除操作（整批），可连续调用逐步回退。
+// 撤销产生的 revert 修订不会被再次撤销；贡献者只能撤销自己的操作
+func (s *Server) undoStoryBatch(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
+		return
+	}
+
+	var batchID string
+	restored, skipped := 0, 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var latest model.StoryEntryRevision
+		if err := tx.Where("story_id = ? AND action <> ? AND restored_at IS NULL", story.ID, storyRevisionRevert).
+			Order("id DESC").First(&latest).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errStoryHistoryNothingToUndo
+			}
+			return err
+		}
+		if !storyRoleAtLeast(role, storyRoleEditor) && latest.UserID != userID {
+			return errStoryHistoryNotOwnBatch
+		}
+		batchID = latest.BatchID
+
+		var revisions []model.StoryEntryRevision
+		if err := tx.Where("story_id = ? AND batch_id = ? AND restored_at IS NULL", story.ID, batchID).
+			Order("id DESC").Find(&revisions).Error; err != nil {
+			return err
+		}
+		undoBatch := newStoryHistoryBatchID()
+		for i := range revisions {
+			ok, err := applyStoryEntryRevision(tx, userID, undoBatch, &revisions[i])
+			if err != nil {
+				return err
+			}
+			if ok {
+				restored++
+			} else {
+				skipped++
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errStoryHistoryNothingToUndo):
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有可撤销的操作"})
+		return
+	case errors.Is(err, errStoryHistoryNotOwnBatch):
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能撤销自己的操作"})
+		return
+	case err != nil:
+		log.Printf("[Story] undo error: story=%d batch=%s err=%v", story.ID, batchID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销失败"})
+		return
+	}
+
+	database.DB.Model(story).Update("updated_at", time.Now())
+	recordStoryActivity(database.DB, story.ID, userID, storyActionEntryRestore, 0, restored)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "撤销成功",
+		"batch_id": batchID,
+		"restored": restored,
+		"skipped":  skipped,
+	})
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryEntryHistoryRestoreAndUndo(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{}, &model.StoryEntryRevision{},
+		&model.StoryCollaborator{}, &model.StoryActivity{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	contributor := model.User{Username: "contributor", Email: "contributor@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&contributor)
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	story := model.Story{UserID: owner.ID, Title: "夜巡", StartTime: base, EndTime: base.Add(time.Hour)}
+	db.Create(&story)
+	db.Create(&model.StoryCollaborator{StoryID: story.ID, UserID: contributor.ID, Role: storyRoleContributor, InvitedBy: owner.ID})
+
+	entries := []model.StoryEntry{
+		{Speaker: "Aldric", Content: "Halt!", SourceID: "src-1"},
+		{Speaker: "Bryn", Content: "Who goes there?", SourceID: "src-2"},
+		{Speaker: "Cyra", Content: "Over here!", SourceID: "src-3"},
+	}
+	for i := range entries {
+		entries[i].StoryID, entries[i].Type, entries[i].Channel, entries[i].SortOrder = story.ID, "dialogue", "SAY", i+1
+		entries[i].Timestamp = base.Add(time.Duration(i) * time.Minute)
+		entries[i].ContentHash = storyEntryContentHash(&entries[i])
+		db.Create(&entries[i])
+	}
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	contributorToken := newTestToken(t, contributor)
+	path := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+
+	resp := performRequest(server.router, http.MethodPut, fmt.Sprintf("%s/entries/%d", path, entries[0].ID), map[string]interface{}{"content": "Halt! Who's there?"}, ownerToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("update entry: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodPost, path+"/entries/batch-delete", map[string]interface{}{"entry_ids": []uint{entries[1].ID, entries[2].ID}}, ownerToken)
+	var deleted struct {
+		Count   int    `json:"count"`
+		BatchID string `json:"batch_id"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &deleted)
+	if resp.Code != http.StatusOK || deleted.Count != 2 || deleted.BatchID == "" {
+		t.Fatalf("batch delete: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 历史与回收站
+	resp = performRequest(server.router, http.MethodGet, path+"/history", nil, contributorToken)
+	var history struct {
+		Total     int `json:"total"`
+		Revisions []struct {
+			ID       uint             `json:"id"`
+			EntryID  uint             `json:"entry_id"`
+			BatchID  string           `json:"batch_id"`
+			Action   string           `json:"action"`
+			Fields   []string         `json:"fields"`
+			Before   model.StoryEntry `json:"before"`
+			Restored *time.Time       `json:"restored_at"`
+		} `json:"revisions"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &history)
+	if resp.Code != http.StatusOK || history.Total != 3 {
+		t.Fatalf("unexpected history: %d %s", resp.Code, resp.Body.String())
+	}
+	last := history.Revisions[2]
+	if last.Action != storyRevisionUpdate || last.EntryID != entries[0].ID || len(last.Fields) != 1 || last.Fields[0] != "content" || last.Before.Content != "Halt!" {
+		t.Fatalf("unexpected update revision %+v", last)
+	}
+	resp = performRequest(server.router, http.MethodGet, path+"/history?deleted=true", nil, ownerToken)
+	json.Unmarshal(resp.Body.Bytes(), &history)
+	if history.Total != 2 || history.Revisions[0].BatchID != deleted.BatchID {
+		t.Fatalf("expected two deleted entries in the trash, got %s", resp.Body.String())
+	}
+
+	// 贡献者不能撤销作者的操作
+	if resp := performRequest(server.router, http.MethodPost, path+"/history/undo", nil, contributorToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("contributor should not undo the owner's batch, got %d", resp.Code)
+	}
+
+	// 撤销批量删除：条目按原ID恢复，内容指纹保留
+	resp = performRequest(server.router, http.MethodPost, path+"/history/undo", nil, ownerToken)
+	var undo struct {
+		BatchID  string `json:"batch_id"`
+		Restored int    `json:"restored"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &undo)
+	if resp.Code != http.StatusOK || undo.BatchID != deleted.BatchID || undo.Restored != 2 {
+		t.Fatalf("undo batch delete: %d %s", resp.Code, resp.Body.String())
+	}
+	var restored model.StoryEntry
+	if err := db.First(&restored, entries[2].ID).Error; err != nil || restored.Content != "Over here!" || restored.ContentHash != entries[2].ContentHash || restored.SortOrder != 3 {
+		t.Fatalf("entry should be restored with its original id and fingerprint: %+v err=%v", restored, err)
+	}
+
+	// 再次撤销回退到修改之前
+	resp = performRequest(server.router, http.MethodPost, path+"/history/undo", nil, ownerToken)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("undo update: %d %s", resp.Code, resp.Body.String())
+	}
+	var reverted model.StoryEntry
+	db.First(&reverted, entries[0].ID)
+	if reverted.Content != "Halt!" {
+		t.Fatalf("update should be reverted, got %q", reverted.Content)
+	}
+	if resp := performRequest(server.router, http.MethodPost, path+"/history/undo", nil, ownerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("nothing left to undo, got %d", resp.Code)
+	}
+
+	// 单条删除后从历史恢复
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/entries/%d", path, entries[1].ID), nil, ownerToken); resp.Code != http.StatusOK {
+		t.Fatalf("delete entry: %d", resp.Code)
+	}
+	var revision model.StoryEntryRevision
+	db.Where("entry_id = ? AND action = ? AND restored_at IS NULL", entries[1].ID, storyRevisionDelete).First(&revision)
+	restorePath := fmt.Sprintf("%s/history/%d/restore", path, revision.ID)
+	if resp := performRequest(server.router, http.MethodPost, restorePath, nil, contributorToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("contributor should only restore own entries, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodPost, restorePath, nil, ownerToken); resp.Code != http.StatusOK {
+		t.Fatalf("restore entry: %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := performRequest(server.router, http.MethodPost, restorePath, nil, ownerToken); resp.Code != http.StatusConflict {
+		t.Fatalf("restoring twice should conflict, got %d", resp.Code)
+	}
+	var count int64
+	db.Model(&model.StoryEntry{}).Where("story_id = ?", story.ID).Count(&count)
+	if count != 3 {
+		t.Fatalf("expected all entries back, got %d", count)
+	}
+
+	// 重传覆盖条目同样记录修订，并可整批撤销
+	reupload := []CreateStoryEntryRequest{
+		{SourceID: "src-2", Speaker: "Bryn", Content: "Who goes there, friend?", Channel: "SAY", Timestamp: base.Add(time.Minute).Format(time.RFC3339)},
+		{SourceID: "src-3", Speaker: "Cyra", Content: "Over here, quickly!", Channel: "SAY", Timestamp: base.Add(2 * time.Minute).Format(time.RFC3339)},
+	}
+	resp = performRequest(server.router, http.MethodPost, path+"/entries", reupload, ownerToken)
+	var ingest struct {
+		Updated int    `json:"updated"`
+		BatchID string `json:"batch_id"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &ingest)
+	if resp.Code != http.StatusCreated || ingest.Updated != 2 || ingest.BatchID == "" {
+		t.Fatalf("re-upload: %d %s", resp.Code, resp.Body.String())
+	}
+	var ingestRevisions []model.StoryEntryRevision
+	db.Where("batch_id = ?", ingest.BatchID).Order("entry_id").Find(&ingestRevisions)
+	if len(ingestRevisions) != 2 || ingestRevisions[0].Action != storyRevisionUpdate || ingestRevisions[0].Fields != "content" {
+		t.Fatalf("re-upload should record one revision per overwritten entry, got %+v", ingestRevisions)
+	}
+	resp = performRequest(server.router, http.MethodPost, path+"/history/undo", nil, ownerToken)
+	json.Unmarshal(resp.Body.Bytes(), &undo)
+	if resp.Code != http.StatusOK || undo.BatchID != ingest.BatchID {
+		t.Fatalf("undo re-upload: %d %s", resp.Code, resp.Body.String())
+	}
+	db.First(&restored, entries[2].ID)
+	if restored.Content != "Over here!" {
+		t.Fatalf("re-upload should be undone, got %q", restored.Content)
+	}
+}
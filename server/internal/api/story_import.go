@@ -0,0 +1,237 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/chatlog"
+)
+
+const (
+	maxChatLogBytes int64 = 50 << 20
+	// 预览最多返回的条目数
+	defaultImportPreviewLimit = 200
+	maxImportPreviewLimit     = 2000
+)
+
+// previewStoryImport 解析上传的聊天记录并返回导入预览，不写入数据库
+func (s *Server) previewStoryImport(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
+		return
+	}
+	format, entries, ok := parseStoryImport(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultImportPreviewLimit
+	if value, err := strconv.Atoi(c.Query("limit")); err == nil && value > 0 {
+		limit = value
+	}
+	if limit > maxImportPreviewLimit {
+		limit = maxImportPreviewLimit
+	}
+
+	speakerCounts := make(map[string]int)
+	channelCounts := make(map[string]int)
+	for _, entry := range entries {
+		if entry.Speaker != "" {
+			speakerCounts[entry.Speaker]++
+		}
+		if entry.Channel != "" {
+			channelCounts[entry.Channel]++
+		}
+	}
+	speakers := make([]gin.H, 0, len(speakerCounts))
+	for name, count := range speakerCounts {
+		speakers = append(speakers, gin.H{"name": name, "count": count})
+	}
+	sort.Slice(speakers, func(i, j int) bool {
+		ci, cj := speakers[i]["count"].(int), speakers[j]["count"].(int)
+		if ci != cj {
+			return ci > cj
+		}
+		return speakers[i]["name"].(string) < speakers[j]["name"].(string)
+	})
+
+	resp := gin.H{
+		"story_id": story.ID,
+		"format":   format,
+		"total":    len(entries),
+		"speakers": speakers,
+		"channels": channelCounts,
+	}
+	if len(entries) > 0 {
+		resp["start_time"] = entries[0].Timestamp
+		resp["end_time"] = entries[len(entries)-1].Timestamp
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	resp["entries"] = entries
+	c.JSON(http.StatusOK, resp)
+}
+
+// importStoryEntries 解析上传的聊天记录并追加到剧情，参数与预览相同
+func (s *Server) importStoryEntries(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, role, ok := loadStoryWithRole(c, storyRoleContributor)
+	if !ok {
+		return
+	}
+	format, entries, ok := parseStoryImport(c)
+	if !ok {
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有可导入的记录"})
+		return
+	}
+
+	result, err := insertStoryEntries(userID, role, story, entries)
+	if err != nil {
+		log.Printf("[Story] import error: story=%d format=%s err=%v", story.ID, format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导入失败"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "导入成功",
+		"format":   format,
+		"count":    result.Inserted,
+		"inserted": result.Inserted,
+		"skipped":  result.Skipped,
+		"updated":  result.Updated,
+		"denied":   result.Denied,
+		"batch_id": result.BatchID,
+	})
+}
+
+// loadOwnedStory 加载只有作者才能操作的剧情
+func loadOwnedStory(c *gin.Context) (*model.Story, bool) {
+	story, _, ok := loadStoryWithRole(c, storyRoleOwner)
+	return story, ok
+}
+
+// parseStoryImport 读取 multipart 表单中的聊天记录文件（file）并转换为条目请求。
+// 可选字段：format（wowchatlog/elephant/listener，为空自动识别）、realm、character、year、tz，
+// 以及筛选条件 from/to（RFC3339）、channels、speakers（逗号分隔）
+func parseStoryImport(c *gin.Context) (string, []CreateStoryEntryRequest, bool) {
+	header, err := c.FormFile("file")
+	if err != nil || header == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请选择聊天记录文件"})
+		return "", nil, false
+	}
+	if header.Size > maxChatLogBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "聊天记录文件不能超过50MB"})
+		return "", nil, false
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return "", nil, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxChatLogBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取文件失败"})
+		return "", nil, false
+	}
+
+	opts := chatlog.Options{
+		Realm:     strings.TrimSpace(c.PostForm("realm")),
+		Character: strings.TrimSpace(c.PostForm("character")),
+	}
+	if value := strings.TrimSpace(c.PostForm("year")); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil || year < 2004 || year > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "年份格式无效"})
+			return "", nil, false
+		}
+		opts.Year = year
+	}
+	if tz := strings.TrimSpace(c.PostForm("tz")); tz != "" {
+		if opts.Location, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时区"})
+			return "", nil, false
+		}
+	}
+
+	var from, to time.Time
+	for _, field := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if value := strings.TrimSpace(c.PostForm(field.name)); value != "" {
+			if *field.target, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "时间格式无效"})
+				return "", nil, false
+			}
+		}
+	}
+	channels := formSet(c.PostForm("channels"), strings.ToUpper)
+	speakers := formSet(c.PostForm("speakers"), nil)
+
+	format, lines, err := chatlog.Parse(data, strings.ToLower(strings.TrimSpace(c.PostForm("format"))), opts)
+	if err != nil {
+		if errors.Is(err, chatlog.ErrUnknownFormat) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无法识别的聊天记录格式", "formats": chatlog.Formats()})
+			return "", nil, false
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "聊天记录解析失败"})
+		return "", nil, false
+	}
+
+	entries := make([]CreateStoryEntryRequest, 0, len(lines))
+	for _, line := range lines {
+		if (!from.IsZero() && line.Timestamp.Before(from)) || (!to.IsZero() && line.Timestamp.After(to)) {
+			continue
+		}
+		if channels != nil && !channels[line.Channel] {
+			continue
+		}
+		// 旁白没有说话者，按说话者筛选时保留
+		if speakers != nil && line.Speaker != "" && !speakers[line.Speaker] {
+			continue
+		}
+		entries = append(entries, CreateStoryEntryRequest{
+			SourceID:  line.SourceID,
+			Type:      line.Type,
+			Speaker:   line.Speaker,
+			Content:   line.Content,
+			Channel:   line.Channel,
+			Timestamp: line.Timestamp.Format(time.RFC3339Nano),
+			GameID:    line.GameID,
+			IsNPC:     line.IsNPC,
+		})
+	}
+	return format, entries, true
+}
+
+// formSet 把逗号分隔的表单值转为集合，值为空时返回 nil（不筛选）
+func formSet(value string, normalize func(string) string) map[string]bool {
+	var set map[string]bool
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if normalize != nil {
+			item = normalize(item)
+		}
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[item] = true
+	}
+	return set
+}
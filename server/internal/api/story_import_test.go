@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func chatLogUpload(t *testing.T, content string, fields map[string]string) ([]byte, map[string]string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "WoWChatLog.txt")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write([]byte(content))
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+	return body.Bytes(), map[string]string{"Content-Type": writer.FormDataContentType()}
+}
+
+func TestStoryImportPreviewAndCommit(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.UserDailyActivity{}, &model.UserActivityLog{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	db.Create(&user)
+	story := model.Story{
+		UserID:    user.ID,
+		Title:     "Scene",
+		StartTime: time.Date(2023, 10, 19, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2023, 10, 20, 0, 0, 0, 0, time.UTC),
+	}
+	db.Create(&story)
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+	log := "10/19 21:58:04.422  Aldric says: Halt!\n" +
+		"10/19 21:58:06.100  Aldric raises a lantern.\n" +
+		"10/19 21:58:07.000  [2. Trade] Seller: WTS\n"
+	fields := map[string]string{"year": "2023", "tz": "UTC", "realm": "RealmA", "channels": "say,emote"}
+
+	body, headers := chatLogUpload(t, log, fields)
+	resp := performRawRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/import/preview", story.ID), body, headers, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("preview: %d body=%s", resp.Code, resp.Body.String())
+	}
+	var preview struct {
+		Format  string                    `json:"format"`
+		Total   int                       `json:"total"`
+		Entries []CreateStoryEntryRequest `json:"entries"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &preview)
+	if preview.Format != "wowchatlog" || preview.Total != 2 || preview.Entries[1].Channel != "EMOTE" {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+	var count int64
+	db.Model(&model.StoryEntry{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("preview must not write entries, got %d", count)
+	}
+
+	body, headers = chatLogUpload(t, log, fields)
+	resp = performRawRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/import", story.ID), body, headers, token)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("import: %d body=%s", resp.Code, resp.Body.String())
+	}
+	var entries []model.StoryEntry
+	db.Where("story_id = ?", story.ID).Order("sort_order").Find(&entries)
+	if len(entries) != 2 || entries[0].Speaker != "Aldric" || entries[0].CharacterID == nil || entries[1].Channel != "EMOTE" {
+		t.Fatalf("unexpected imported entries %+v", entries)
+	}
+	var character model.Character
+	db.First(&character, *entries[0].CharacterID)
+	if character.GameID != "Aldric-RealmA" {
+		t.Fatalf("expected character linked by game id, got %+v", character)
+	}
+
+	body, headers = chatLogUpload(t, log, fields)
+	resp = performRawRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/import", story.ID), body, headers, token)
+	var reimport struct {
+		Inserted int `json:"inserted"`
+		Skipped  int `json:"skipped"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &reimport)
+	if resp.Code != http.StatusCreated || reimport.Inserted != 0 || reimport.Skipped != 2 {
+		t.Fatalf("re-import should skip existing lines: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	body, headers = chatLogUpload(t, "not a chat log", nil)
+	resp = performRawRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/import/preview", story.ID), body, headers, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown format to 400, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+)
+
+// MergeSceneStoriesRequest 合并多位玩家记录的同一场景
+type MergeSceneStoriesRequest struct {
+	SourceIDs      []uint `json:"source_ids" binding:"required,min=2,max=10"`
+	Title          string `json:"title" binding:"max=256"`
+	WindowSeconds  int    `json:"window_seconds" binding:"min=0,max=600"`     // 去重时间窗口，默认 5 秒
+	MaxSkewSeconds int    `json:"max_skew_seconds" binding:"min=0,max=86400"` // 允许的最大时钟偏差，默认 10 分钟
+	DisableAlign   bool   `json:"disable_align"`                              // 不对齐时钟，按原始时间合并
+	DryRun         bool   `json:"dry_run"`                                    // 只返回合并统计，不创建剧情
+}
+
+// mergeSceneStories 把多份同一场景的记录（可以来自不同玩家，需有查看权限）合并为一个新剧情。
+// 原剧情保持不变，新条目的 merge_sources 记录每一行来自哪些记录
+func (s *Server) mergeSceneStories(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req MergeSceneStoriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	seen := make(map[uint]struct{}, len(req.SourceIDs))
+	for _, id := range req.SourceIDs {
+		if _, dup := seen[id]; dup {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能重复选择同一剧情"})
+			return
+		}
+		seen[id] = struct{}{}
+	}
+
+	var stories []model.Story
+	database.DB.Where("id IN ?", req.SourceIDs).Find(&stories)
+	storyMap := make(map[uint]model.Story, len(stories))
+	for _, story := range stories {
+		if canViewStory(&story, userID) {
+			storyMap[story.ID] = story
+		}
+	}
+	if len(storyMap) != len(req.SourceIDs) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "部分剧情不存在或无权访问"})
+		return
+	}
+
+	sources := make([]service.SceneMergeSource, 0, len(req.SourceIDs))
+	for _, id := range req.SourceIDs {
+		var entries []model.StoryEntry
+		if err := database.DB.Where("story_id = ?", id).Order("sort_order ASC, id ASC").Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		sources = append(sources, service.SceneMergeSource{StoryID: id, Entries: entries})
+	}
+
+	merged := service.MergeSceneLogs(sources, service.SceneMergeOptions{
+		Window:       time.Duration(req.WindowSeconds) * time.Second,
+		MaxSkew:      time.Duration(req.MaxSkewSeconds) * time.Second,
+		DisableAlign: req.DisableAlign,
+	})
+
+	sourceSummary := make([]gin.H, 0, len(sources))
+	for i, source := range sources {
+		story := storyMap[source.StoryID]
+		sourceSummary = append(sourceSummary, gin.H{
+			"story_id":       story.ID,
+			"title":          story.Title,
+			"user_id":        story.UserID,
+			"entry_count":    len(source.Entries),
+			"offset_seconds": merged.Offsets[i].Seconds(),
+			"matched":        merged.Matched[i],
+		})
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"entry_count": len(merged.Lines),
+			"duplicates":  merged.Duplicates,
+			"sources":     sourceSummary,
+		})
+		return
+	}
+	if len(merged.Lines) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "所选剧情没有可合并的条目"})
+		return
+	}
+
+	entries := make([]model.StoryEntry, len(merged.Lines))
+	seenSourceIDs := make(map[string]bool, len(merged.Lines))
+	for i, line := range merged.Lines {
+		src := line.Entry
+		// 来源ID在剧情内唯一，不同记录中重复的来源ID只保留第一条
+		if seenSourceIDs[src.SourceID] {
+			src.SourceID = ""
+		} else if src.SourceID != "" {
+			seenSourceIDs[src.SourceID] = true
+		}
+		entry := model.StoryEntry{
+			SourceID:           src.SourceID,
+			Type:               src.Type,
+			CharacterID:        src.CharacterID,
+			CharacterVersionID: src.CharacterVersionID,
+			Speaker:            src.Speaker,
+			Content:            src.Content,
+			Channel:            src.Channel,
+			Timestamp:          line.Timestamp,
+			SortOrder:          i + 1,
+			BackgroundColor:    src.BackgroundColor,
+			GroupName:          src.GroupName,
+			CreatedBy:          userID,
+			UpdatedBy:          userID,
+		}
+		if src.Timestamp.IsZero() {
+			entry.Timestamp = time.Time{}
+		}
+		data, _ := json.Marshal(line.Sources)
+		entry.MergeSources = string(data)
+		entry.ContentHash = storyEntryContentHash(&entry)
+		entry.SearchText = entry.SearchDocument()
+		entries[i] = entry
+	}
+
+	// 以基准记录（第一个选择的剧情）的信息作为新剧情的默认值
+	base := storyMap[req.SourceIDs[0]]
+	summary := service.BuildStorySessions(entries, nil, nil, nil)[0]
+	story := model.Story{
+		UserID:    userID,
+		Title:     req.Title,
+		Region:    base.Region,
+		Address:   base.Address,
+		StartTime: summary.StartTime,
+		EndTime:   summary.EndTime,
+		Status:    "draft",
+	}
+	if story.Title == "" {
+		story.Title = fmt.Sprintf("%s（合并）", base.Title)
+	}
+	if len(summary.Participants) > 0 {
+		data, _ := json.Marshal(summary.Participants)
+		story.Participants = string(data)
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&story).Error; err != nil {
+			return err
+		}
+		for i := range entries {
+			entries[i].StoryID = story.ID
+		}
+		if err := tx.CreateInBatches(entries, storyEntryIDChunk).Error; err != nil {
+			return err
+		}
+		recordStoryActivity(tx, story.ID, userID, storyActionEntryAdd, 0, len(entries))
+		// 参与者已批准的隐去随条目进入合并后的剧情
+		return copyStoryConsent(tx, req.SourceIDs, story.ID)
+	})
+	if err != nil {
+		log.Printf("[Story] merge error: user=%d sources=%v err=%v", userID, req.SourceIDs, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并失败"})
+		return
+	}
+
+	story.EntryCount = len(entries)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "合并成功",
+		"story":       story,
+		"entry_count": len(entries),
+		"duplicates":  merged.Duplicates,
+		"sources":     sourceSummary,
+	})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestMergeSceneStories(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{},
+		&model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryGuild{}, &model.StoryParticipant{}, &model.StoryRedactionRequest{})
+	database.DB = db
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", PassHash: "hash"}
+	bob := model.User{Username: "bob", Email: "bob@example.com", PassHash: "hash"}
+	stranger := model.User{Username: "stranger", Email: "stranger@example.com", PassHash: "hash"}
+	for _, user := range []*model.User{&alice, &bob, &stranger} {
+		db.Create(user)
+	}
+
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	aliceStory := model.Story{UserID: alice.ID, Title: "夜巡", Region: "艾尔文森林", StartTime: base, EndTime: base.Add(time.Minute)}
+	bobStory := model.Story{UserID: bob.ID, Title: "Bob 的记录", StartTime: base, EndTime: base.Add(time.Minute)}
+	db.Create(&aliceStory)
+	db.Create(&bobStory)
+	db.Create(&model.StoryCollaborator{StoryID: bobStory.ID, UserID: alice.ID, Role: storyRoleViewer, InvitedBy: bob.ID})
+
+	for i, entry := range []model.StoryEntry{
+		{Speaker: "Aldric", Content: "Halt!", Timestamp: base},
+		{Speaker: "Bryn", Content: "Who goes there?", Timestamp: base.Add(3 * time.Second)},
+		{Speaker: "Cyra", Content: "Over here!", Timestamp: base.Add(10 * time.Second)},
+	} {
+		entry.StoryID, entry.Type, entry.Channel, entry.SortOrder = aliceStory.ID, "dialogue", "SAY", i+1
+		db.Create(&entry)
+	}
+	// Bob 的时钟快了 30 秒
+	for i, entry := range []model.StoryEntry{
+		{Speaker: "Aldric", Content: "Halt!", Timestamp: base.Add(30 * time.Second)},
+		{Speaker: "Bryn", Content: "Who goes there?", Timestamp: base.Add(33 * time.Second)},
+		{Speaker: "Bryn", Content: "psst", Timestamp: base.Add(35 * time.Second)},
+	} {
+		entry.StoryID, entry.Type, entry.Channel, entry.SortOrder = bobStory.ID, "dialogue", "SAY", i+1
+		db.Create(&entry)
+	}
+
+	server := newTestServer(t, db)
+	aliceToken := newTestToken(t, alice)
+	body := map[string]interface{}{"source_ids": []uint{aliceStory.ID, bobStory.ID}, "dry_run": true}
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/stories/merge", body, aliceToken)
+	var preview struct {
+		EntryCount int `json:"entry_count"`
+		Duplicates int `json:"duplicates"`
+		Sources    []struct {
+			StoryID       uint    `json:"story_id"`
+			OffsetSeconds float64 `json:"offset_seconds"`
+			Matched       int     `json:"matched"`
+		} `json:"sources"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &preview)
+	if resp.Code != http.StatusOK || preview.EntryCount != 4 || preview.Duplicates != 2 {
+		t.Fatalf("unexpected dry run: %d %s", resp.Code, resp.Body.String())
+	}
+	if len(preview.Sources) != 2 || preview.Sources[1].OffsetSeconds != 30 || preview.Sources[1].Matched != 2 {
+		t.Fatalf("unexpected source offsets: %+v", preview.Sources)
+	}
+	var storyCount int64
+	db.Model(&model.Story{}).Count(&storyCount)
+	if storyCount != 2 {
+		t.Fatalf("dry run should not create a story, got %d stories", storyCount)
+	}
+
+	delete(body, "dry_run")
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/stories/merge", body, aliceToken)
+	var created struct {
+		Story model.Story `json:"story"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &created)
+	if resp.Code != http.StatusCreated || created.Story.UserID != alice.ID || created.Story.Title != "夜巡（合并）" || created.Story.Region != "艾尔文森林" {
+		t.Fatalf("unexpected merge response: %d %s", resp.Code, resp.Body.String())
+	}
+
+	var entries []model.StoryEntry
+	db.Where("story_id = ?", created.Story.ID).Order("sort_order ASC").Find(&entries)
+	wantContent := []string{"Halt!", "Who goes there?", "psst", "Over here!"}
+	if len(entries) != len(wantContent) {
+		t.Fatalf("expected %d merged entries, got %d", len(wantContent), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Content != wantContent[i] || entry.CreatedBy != alice.ID || entry.ContentHash == "" {
+			t.Fatalf("entry %d: unexpected %+v", i, entry)
+		}
+	}
+	if !entries[2].Timestamp.Equal(base.Add(5 * time.Second)) {
+		t.Fatalf("bob's line should be aligned, got %v", entries[2].Timestamp)
+	}
+	var sources []service.SceneLineSource
+	json.Unmarshal([]byte(entries[0].MergeSources), &sources)
+	if len(sources) != 2 || sources[0].StoryID != aliceStory.ID || sources[1].StoryID != bobStory.ID {
+		t.Fatalf("unexpected merge sources %s", entries[0].MergeSources)
+	}
+	if !created.Story.StartTime.Equal(base) || !created.Story.EndTime.Equal(base.Add(10*time.Second)) {
+		t.Fatalf("unexpected story range %v - %v", created.Story.StartTime, created.Story.EndTime)
+	}
+
+	var originalCount int64
+	db.Model(&model.StoryEntry{}).Where("story_id = ?", bobStory.ID).Count(&originalCount)
+	if originalCount != 3 {
+		t.Fatalf("source stories must stay untouched, got %d entries", originalCount)
+	}
+
+	if resp := performRequest(server.router, http.MethodPost, "/api/v1/stories/merge", body, newTestToken(t, stranger)); resp.Code != http.StatusNotFound {
+		t.Fatalf("stranger should not merge others' stories, got %d", resp.Code)
+	}
+	body["source_ids"] = []uint{aliceStory.ID, aliceStory.ID}
+	if resp := performRequest(server.router, http.MethodPost, "/api/v1/stories/merge", body, aliceToken); resp.Code != http.StatusBadRequest {
+		t.Fatalf("duplicate sources should be rejected, got %d", resp.Code)
+	}
+}
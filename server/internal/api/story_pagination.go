@@ -0,0 +1,303 @@
+package api
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"gorm.io/gorm"
+)
+
+// 条目分页参数
+const (
+	storyEntryPageDefault  = 200
+	storyEntryPageMax      = 1000
+	storyEntryFormatNDJSON = "ndjson"
+)
+
+// storyEntryPageRequested 带 cursor、limit 或 direction 参数时按页返回条目
+func storyEntryPageRequested(c *gin.Context) bool {
+	return c.Query("cursor") != "" || c.Query("limit") != "" || c.Query("direction") != ""
+}
+
+// storyEntryFollowUpPage 是否为继续加载的后续页（有游标且不包含游标条目本身），用于避免重复计算浏览量
+func storyEntryFollowUpPage(c *gin.Context) bool {
+	return c.Query("cursor") != "" && c.Query("include_cursor") != "true"
+}
+
+// storyEntryReadingQuery 构造按阅读顺序分页查询条目所需的条件与排序键。
+// 阅读顺序为：章节位置（未分章或章节已不存在的条目在最后）、时间、排序号、ID，与 arrangeStoryChapters 一致，
+// 并以 ID 保证顺序唯一，可以按键翻页而不必加载整个剧情
+type storyEntryReadingQuery struct {
+	storyID   uint
+	chapterID *uint64
+	key       string // 排序键的列表达式，逗号分隔
+	asc       string
+	desc      string
+}
+
+// newStoryEntryReadingQuery 按剧情章节构造阅读顺序查询；可选参数 chapter_id 只查询该章节的条目
+func newStoryEntryReadingQuery(c *gin.Context, storyID uint, chapters []model.StoryChapter) storyEntryReadingQuery {
+	columns := []string{"timestamp", "sort_order", "id"}
+	if len(chapters) > 0 {
+		// 章节ID与位置都是整数，直接写入表达式，避免参数类型在不同数据库中推断不一致
+		var b strings.Builder
+		b.WriteString("CASE chapter_id")
+		for i, chapter := range chapters {
+			fmt.Fprintf(&b, " WHEN %d THEN %d", chapter.ID, i)
+		}
+		fmt.Fprintf(&b, " ELSE %d END", len(chapters))
+		columns = append([]string{b.String()}, columns...)
+	}
+	query := storyEntryReadingQuery{
+		storyID: storyID,
+		key:     strings.Join(columns, ", "),
+		asc:     strings.Join(columns, ", "),
+		desc:    strings.Join(columns, " DESC, ") + " DESC",
+	}
+	if chapterID, err := strconv.ParseUint(c.Query("chapter_id"), 10, 32); err == nil {
+		query.chapterID = &chapterID
+	}
+	return query
+}
+
+// base 返回剧情（或指定章节）条目的查询
+func (q storyEntryReadingQuery) base() *gorm.DB {
+	tx := database.DB.Model(&model.StoryEntry{}).Where("story_id = ?", q.storyID)
+	if q.chapterID != nil {
+		tx = tx.Where("chapter_id = ?", *q.chapterID)
+	}
+	return tx
+}
+
+// relativeTo 只保留排序键与条目 entryID 满足 op（<、<=、>、>=）的条目
+func (q storyEntryReadingQuery) relativeTo(tx *gorm.DB, op string, entryID uint) *gorm.DB {
+	return tx.Where(fmt.Sprintf("(%s) %s (SELECT %s FROM story_entries WHERE id = ?)", q.key, op, q.key), entryID)
+}
+
+// loadStoryEntries 按阅读顺序加载剧情条目。带分页参数时只加载一页并返回分页信息，否则 page 为 nil。
+// 分页参数：cursor 为条目ID（如书签的 entry_id），direction=forward|backward 从游标向后或向前加载，
+// include_cursor=true 时包含游标条目本身，limit 每页条数（默认 200，最多 1000）。
+// 不带游标时 forward 从第一条开始、backward 从最后一条开始
+func loadStoryEntries(c *gin.Context, storyID uint) ([]model.StoryChapter, []model.StoryEntry, gin.H, bool) {
+	if !storyEntryPageRequested(c) {
+		var entries []model.StoryEntry
+		database.DB.Where("story_id = ?", storyID).Order("timestamp, sort_order").Find(&entries)
+		chapters, entries := loadStoryChapterEntries(c, storyID, entries)
+		return chapters, entries, nil, true
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(storyEntryPageDefault)))
+	if limit < 1 || limit > storyEntryPageMax {
+		limit = storyEntryPageDefault
+	}
+	direction := strings.ToLower(c.DefaultQuery("direction", "forward"))
+	if direction != "forward" && direction != "backward" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的加载方向"})
+		return nil, nil, nil, false
+	}
+	includeCursor := c.Query("include_cursor") == "true"
+
+	chapters, err := loadStoryChapterStats(storyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return nil, nil, nil, false
+	}
+	query := newStoryEntryReadingQuery(c, storyID, chapters)
+
+	var total int64
+	if err := query.base().Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return nil, nil, nil, false
+	}
+
+	tx := query.base()
+	if cursorParam := c.Query("cursor"); cursorParam != "" {
+		cursor, _ := strconv.ParseUint(cursorParam, 10, 32)
+		var exists int64
+		query.base().Where("id = ?", cursor).Count(&exists)
+		if exists == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+			return nil, nil, nil, false
+		}
+		op := map[bool]string{true: ">", false: "<"}[direction == "forward"]
+		if includeCursor {
+			op += "="
+		}
+		tx = query.relativeTo(tx, op, uint(cursor))
+	}
+
+	entries := make([]model.StoryEntry, 0)
+	if direction == "forward" {
+		err = tx.Order(query.asc).Limit(limit).Find(&entries).Error
+	} else {
+		err = tx.Order(query.desc).Limit(limit).Find(&entries).Error
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return nil, nil, nil, false
+	}
+
+	// 本页之前的条目数；空页时向后加载已到末尾，向前加载已到开头
+	var offset int64
+	if len(entries) > 0 {
+		if err := query.relativeTo(query.base(), "<", entries[0].ID).Count(&offset).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return nil, nil, nil, false
+		}
+	} else if direction == "forward" {
+		offset = total
+	}
+	end := offset + int64(len(entries))
+	page := gin.H{
+		"total":           total,
+		"offset":          offset,
+		"limit":           limit,
+		"has_more_before": offset > 0,
+		"has_more_after":  end < total,
+		"prev_cursor":     nil,
+		"next_cursor":     nil,
+	}
+	if offset > 0 && len(entries) > 0 {
+		page["prev_cursor"] = entries[0].ID
+	}
+	if end < total && len(entries) > 0 {
+		page["next_cursor"] = entries[len(entries)-1].ID
+	}
+	return chapters, entries, page, true
+}
+
+// loadStoryChapterStats 按顺序加载剧情章节，并用聚合查询补全各章节的条目数与第一条条目
+func loadStoryChapterStats(storyID uint) ([]model.StoryChapter, error) {
+	chapters, err := loadStoryChapters(database.DB, storyID)
+	if err != nil || len(chapters) == 0 {
+		return chapters, err
+	}
+	// 一次窗口查询取出每个章节的条目数与按阅读顺序的第一条
+	var rows []struct {
+		ChapterID  uint
+		ID         uint
+		EntryCount int
+	}
+	if err := database.DB.Raw(`SELECT chapter_id, id, entry_count FROM (
+		SELECT chapter_id, id,
+			COUNT(*) OVER (PARTITION BY chapter_id) AS entry_count,
+			ROW_NUMBER() OVER (PARTITION BY chapter_id ORDER BY timestamp, sort_order, id) AS rn
+		FROM story_entries WHERE story_id = ? AND chapter_id IS NOT NULL
+	) ranked WHERE rn = 1`, storyID).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byChapter := make(map[uint]int, len(rows))
+	for i := range rows {
+		byChapter[rows[i].ChapterID] = i
+	}
+	for i := range chapters {
+		row, ok := byChapter[chapters[i].ID]
+		if !ok {
+			continue
+		}
+		chapters[i].EntryCount = rows[row].EntryCount
+		chapters[i].FirstEntryID = &rows[row].ID
+	}
+	return chapters, nil
+}
+
+// respondStoryWithETag 返回剧情详情并附带 ETag，客户端 If-None-Match 命中时返回 304。
+// etagBody 为计算 ETag 的内容，用于排除浏览次数等每次请求都会变化的字段
+func respondStoryWithETag(c *gin.Context, body, etagBody gin.H) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	etagData := data
+	if etagBody != nil {
+		if etagData, err = json.Marshal(etagBody); err != nil {
+			etagData = data
+		}
+	}
+
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(etagData))
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// streamStoryEntries 以 NDJSON 逐行输出剧情：第一行为剧情信息（type=story），随后按阅读顺序分批输出
+// 角色（type=characters）与条目（type=entry），最后一行为 type=end。可选参数：chapter_id。
+// redactions 为公开视图需要应用的隐去请求，私有视图传 nil
+func (s *Server) streamStoryEntries(c *gin.Context, storyID uint, header gin.H, redactions *storyRedactions) {
+	chapters, err := loadStoryChapterStats(storyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	query := newStoryEntryReadingQuery(c, storyID, chapters)
+	var total int64
+	if err := query.base().Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Cache-Control", "private, no-cache")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+
+	header["type"] = "story"
+	header["chapters"] = chapters
+	header["total"] = total
+	if err := encoder.Encode(header); err != nil {
+		return
+	}
+	count := 0
+	var last uint
+	for {
+		tx := query.base()
+		if last != 0 {
+			tx = query.relativeTo(tx, ">", last)
+		}
+		var entries []model.StoryEntry
+		if err := tx.Order(query.asc).Limit(storyEntryIDChunk).Find(&entries).Error; err != nil {
+			log.Printf("[Story] stream entries error: story=%d err=%v", storyID, err)
+			encoder.Encode(gin.H{"type": "error", "error": "读取失败"})
+			return
+		}
+		if len(entries) == 0 {
+			break
+		}
+		last = entries[len(entries)-1].ID
+		applyStoryRedactions(entries, redactions)
+		charactersMap, versionsMap := s.loadStoryCharacters(c, entries)
+		if len(charactersMap) > 0 || len(versionsMap) > 0 {
+			if err := encoder.Encode(gin.H{"type": "characters", "characters": charactersMap, "character_versions": versionsMap}); err != nil {
+				return
+			}
+		}
+		for i := range entries {
+			if err := encoder.Encode(gin.H{"type": "entry", "entry": &entries[i]}); err != nil {
+				return
+			}
+		}
+		count += len(entries)
+		c.Writer.Flush()
+		if len(entries) < storyEntryIDChunk {
+			break
+		}
+	}
+	encoder.Encode(gin.H{"type": "end", "count": count})
+}
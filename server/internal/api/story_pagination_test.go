@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryEntryPaginationAndStreaming(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryGuild{}, &model.StoryAnnotation{},
+		&model.StoryChapter{}, &model.StoryShareLink{}, &model.StoryShareVisit{}, &model.StoryShareReferrer{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	story := model.Story{UserID: owner.ID, Title: "长夜", StartTime: base, EndTime: base.Add(time.Hour), IsPublic: true, ShareCode: "longnight"}
+	db.Create(&story)
+	entries := make([]model.StoryEntry, 7)
+	for i := range entries {
+		entries[i] = model.StoryEntry{StoryID: story.ID, Type: "dialogue", Speaker: "Aldric", Content: fmt.Sprintf("line %d", i+1),
+			SortOrder: i + 1, Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		db.Create(&entries[i])
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, owner)
+	path := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+	type pageResult struct {
+		Entries []model.StoryEntry `json:"entries"`
+		Page    struct {
+			Total         int   `json:"total"`
+			HasMoreBefore bool  `json:"has_more_before"`
+			HasMoreAfter  bool  `json:"has_more_after"`
+			PrevCursor    *uint `json:"prev_cursor"`
+			NextCursor    *uint `json:"next_cursor"`
+		} `json:"page"`
+	}
+	getPage := func(url string) (pageResult, string) {
+		resp := performRequest(server.router, http.MethodGet, url, nil, token)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("get %s: %d %s", url, resp.Code, resp.Body.String())
+		}
+		var result pageResult
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result, resp.Header().Get("ETag")
+	}
+	contents := func(result pageResult) []string {
+		var lines []string
+		for _, entry := range result.Entries {
+			lines = append(lines, entry.Content)
+		}
+		return lines
+	}
+
+	// 向后翻页
+	first, etag := getPage(path + "?limit=3")
+	if fmt.Sprint(contents(first)) != "[line 1 line 2 line 3]" || first.Page.Total != 7 || first.Page.HasMoreBefore || !first.Page.HasMoreAfter || first.Page.NextCursor == nil {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	second, _ := getPage(fmt.Sprintf("%s?limit=3&cursor=%d", path, *first.Page.NextCursor))
+	if fmt.Sprint(contents(second)) != "[line 4 line 5 line 6]" || *second.Page.PrevCursor != entries[3].ID {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+
+	// 从书签条目向前加载
+	around, _ := getPage(fmt.Sprintf("%s?limit=3&cursor=%d&include_cursor=true&direction=backward", path, entries[4].ID))
+	if fmt.Sprint(contents(around)) != "[line 3 line 4 line 5]" || !around.Page.HasMoreBefore || !around.Page.HasMoreAfter {
+		t.Fatalf("unexpected backward page %+v", around)
+	}
+	last, _ := getPage(path + "?limit=3&direction=backward")
+	if fmt.Sprint(contents(last)) != "[line 5 line 6 line 7]" || last.Page.HasMoreAfter {
+		t.Fatalf("unexpected last page %+v", last)
+	}
+	if resp := performRequest(server.router, http.MethodGet, path+"?cursor=99999", nil, token); resp.Code != http.StatusNotFound {
+		t.Fatalf("unknown cursor should 404, got %d", resp.Code)
+	}
+
+	// ETag：未变化的页返回 304，条目修改后 ETag 变化
+	resp := performRawRequest(server.router, http.MethodGet, path+"?limit=3", nil, map[string]string{"If-None-Match": etag}, token)
+	if etag == "" || resp.Code != http.StatusNotModified {
+		t.Fatalf("unchanged page should return 304, got %d etag=%q", resp.Code, etag)
+	}
+	db.Model(&model.StoryEntry{}).Where("id = ?", entries[0].ID).Update("content", "line one")
+	if _, changed := getPage(path + "?limit=3"); changed == etag {
+		t.Fatal("etag should change after an entry is edited")
+	}
+
+	// NDJSON 流式下载
+	resp = performRequest(server.router, http.MethodGet, path+"?format=ndjson", nil, token)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson; charset=utf-8" {
+		t.Fatalf("stream: %d %s", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	var types []string
+	scanner := bufio.NewScanner(bytes.NewReader(resp.Body.Bytes()))
+	for scanner.Scan() {
+		var line struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid ndjson line %q", scanner.Text())
+		}
+		types = append(types, line.Type)
+	}
+	if len(types) != 9 || types[0] != "story" || types[1] != "entry" || types[8] != "end" {
+		t.Fatalf("unexpected stream %v", types)
+	}
+
+	// 公开剧情：继续加载的后续页不重复计算浏览量，ETag 不受浏览次数影响
+	publicPath := "/api/v1/public/stories/longnight?limit=3"
+	resp = performRequest(server.router, http.MethodGet, publicPath, nil, "")
+	publicETag := resp.Header().Get("ETag")
+	var publicPage pageResult
+	json.Unmarshal(resp.Body.Bytes(), &publicPage)
+	if resp.Code != http.StatusOK || len(publicPage.Entries) != 3 {
+		t.Fatalf("public page: %d %s", resp.Code, resp.Body.String())
+	}
+	performRequest(server.router, http.MethodGet, fmt.Sprintf("%s&cursor=%d", publicPath, *publicPage.Page.NextCursor), nil, "")
+	if resp := performRawRequest(server.router, http.MethodGet, publicPath, nil, map[string]string{"If-None-Match": publicETag}, ""); resp.Code != http.StatusNotModified {
+		t.Fatalf("public page etag should ignore view count, got %d", resp.Code)
+	}
+	var viewed model.Story
+	db.First(&viewed, story.ID)
+	if viewed.ViewCount != 2 {
+		t.Fatalf("follow-up pages should not count as views, got %d", viewed.ViewCount)
+	}
+
+	// 有章节时按章节顺序翻页，未分章的条目在最后
+	chapter := model.StoryChapter{StoryID: story.ID, Title: "序章", SortOrder: 1}
+	db.Create(&chapter)
+	db.Model(&model.StoryEntry{}).Where("id IN ?", []uint{entries[5].ID, entries[6].ID}).Update("chapter_id", chapter.ID)
+	resp = performRequest(server.router, http.MethodGet, path+"?limit=3", nil, token)
+	var chaptered struct {
+		pageResult
+		Chapters []model.StoryChapter `json:"chapters"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &chaptered)
+	if fmt.Sprint(contents(chaptered.pageResult)) != "[line 6 line 7 line one]" || len(chaptered.Chapters) != 1 ||
+		chaptered.Chapters[0].EntryCount != 2 || chaptered.Chapters[0].FirstEntryID == nil || *chaptered.Chapters[0].FirstEntryID != entries[5].ID {
+		t.Fatalf("unexpected chaptered page %s", resp.Body.String())
+	}
+	crossing, _ := getPage(fmt.Sprintf("%s?limit=2&cursor=%d", path, entries[6].ID))
+	if fmt.Sprint(contents(crossing)) != "[line one line 2]" || *crossing.Page.PrevCursor != entries[0].ID || !crossing.Page.HasMoreAfter {
+		t.Fatalf("paging should continue past the chapter boundary %+v", crossing)
+	}
+	backward, _ := getPage(fmt.Sprintf("%s?limit=2&cursor=%d&direction=backward", path, entries[1].ID))
+	if fmt.Sprint(contents(backward)) != "[line 7 line one]" || !backward.Page.HasMoreBefore {
+		t.Fatalf("backward paging should cross into the chapter %+v", backward)
+	}
+	onlyChapter, _ := getPage(fmt.Sprintf("%s?limit=5&chapter_id=%d", path, chapter.ID))
+	if fmt.Sprint(contents(onlyChapter)) != "[line 6 line 7]" || onlyChapter.Page.Total != 2 || onlyChapter.Page.HasMoreAfter {
+		t.Fatalf("unexpected chapter page %+v", onlyChapter)
+	}
+	if resp := performRequest(server.router, http.MethodGet, fmt.Sprintf("%s?chapter_id=%d&cursor=%d", path, chapter.ID, entries[0].ID), nil, token); resp.Code != http.StatusNotFound {
+		t.Fatalf("cursor outside the chapter should 404, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/textsearch"
+	"gorm.io/gorm"
+)
+
+const (
+	// 单次搜索最多的查询词数
+	maxStorySearchTerms = 32
+	// 摘要中命中前后保留的字符数
+	storySearchSnippetRadius = 40
+)
+
+// storySearchRow 检索结果行
+type storySearchRow struct {
+	EntryID    uint      `gorm:"column:entry_id"`
+	StoryID    uint      `gorm:"column:story_id"`
+	StoryTitle string    `gorm:"column:story_title"`
+	Type       string    `gorm:"column:type"`
+	Speaker    string    `gorm:"column:speaker"`
+	Content    string    `gorm:"column:content"`
+	Channel    string    `gorm:"column:channel"`
+	Timestamp  time.Time `gorm:"column:timestamp"`
+	SortOrder  int       `gorm:"column:sort_order"`
+}
+
+// searchStoryEntries 全文检索剧情条目的说话者与内容。
+// 范围为自己的剧情、参与协作的剧情和所在公会中有权查看的公会剧情；可用 guild_id 限定到某个公会，story_id 限定到某个剧情
+func (s *Server) searchStoryEntries(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	keyword := strings.TrimSpace(c.Query("q"))
+	terms := textsearch.Query(keyword)
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入搜索关键词"})
+		return
+	}
+	if len(terms) > maxStorySearchTerms {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "搜索关键词过长"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := database.DB.Table("story_entries").
+		Joins("JOIN stories ON stories.id = story_entries.story_id")
+
+	// 检索范围
+	if guildID := c.Query("guild_id"); guildID != "" {
+		guildIDNum, _ := strconv.ParseUint(guildID, 10, 32)
+		canAccess, _ := checkGuildContentAccess(uint(guildIDNum), userID, "story")
+		if !canAccess {
+			c.JSON(http.StatusForbidden, gin.H{"error": "无权查看公会内容"})
+			return
+		}
+		query = query.Where("story_entries.story_id IN (?)",
+			database.DB.Model(&model.StoryGuild{}).Select("story_id").Where("guild_id = ?", guildIDNum))
+	} else {
+		collaborating := database.DB.Model(&model.StoryCollaborator{}).Select("story_id").Where("user_id = ?", userID)
+		if guildIDs := searchableGuildIDs(userID); len(guildIDs) > 0 {
+			query = query.Where("(stories.user_id = ? OR story_entries.story_id IN (?) OR story_entries.story_id IN (?))", userID, collaborating,
+				database.DB.Model(&model.StoryGuild{}).Select("story_id").Where("guild_id IN ?", guildIDs))
+		} else {
+			query = query.Where("(stories.user_id = ? OR story_entries.story_id IN (?))", userID, collaborating)
+		}
+	}
+	if storyID := c.Query("story_id"); storyID != "" {
+		query = query.Where("story_entries.story_id = ?", storyID)
+	}
+
+	// 匹配条件：Postgres 使用 GIN 索引的 tsvector，其他数据库按索引词做 LIKE 匹配
+	var order interface{} = "story_entries.timestamp DESC, story_entries.id DESC"
+	if database.DB.Dialector.Name() == "postgres" {
+		tsquery := textsearch.TSQuery(terms)
+		query = query.Where("to_tsvector('simple', COALESCE(story_entries.search_text, '')) @@ to_tsquery('simple', ?)", tsquery)
+		order = gorm.Expr("ts_rank(to_tsvector('simple', COALESCE(story_entries.search_text, '')), to_tsquery('simple', ?)) DESC, story_entries.timestamp DESC, story_entries.id DESC", tsquery)
+	} else {
+		for _, term := range terms {
+			pattern := "% " + term.Token + " %"
+			if term.Prefix {
+				pattern = "% " + term.Token + "%"
+			}
+			query = query.Where("(' ' || COALESCE(story_entries.search_text, '') || ' ') LIKE ?", pattern)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("[Story] search count error: user=%d err=%v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败"})
+		return
+	}
+
+	var rows []storySearchRow
+	if err := query.
+		Select("story_entries.id AS entry_id, story_entries.story_id, stories.title AS story_title, story_entries.type, story_entries.speaker, story_entries.content, story_entries.channel, story_entries.timestamp, story_entries.sort_order").
+		Order(order).
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Scan(&rows).Error; err != nil {
+		log.Printf("[Story] search error: user=%d err=%v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败"})
+		return
+	}
+
+	results := make([]gin.H, 0, len(rows))
+	for _, row := range rows {
+		entry := model.StoryEntry{Type: row.Type, Content: row.Content}
+		results = append(results, gin.H{
+			"entry_id":          row.EntryID,
+			"story_id":          row.StoryID,
+			"story_title":       row.StoryTitle,
+			"type":              row.Type,
+			"speaker":           row.Speaker,
+			"channel":           row.Channel,
+			"timestamp":         row.Timestamp,
+			"sort_order":        row.SortOrder,
+			"snippet":           textsearch.Highlight(entry.SearchContent(), keyword, storySearchSnippetRadius),
+			"speaker_highlight": textsearch.Highlight(row.Speaker, keyword, 0),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// searchableGuildIDs 用户所在（或拥有）且有权查看剧情的公会
+func searchableGuildIDs(userID uint) []uint {
+	var memberGuildIDs []uint
+	database.DB.Model(&model.GuildMember{}).Where("user_id = ?", userID).Pluck("guild_id", &memberGuildIDs)
+	var ownedGuildIDs []uint
+	database.DB.Model(&model.Guild{}).Where("owner_id = ?", userID).Pluck("id", &ownedGuildIDs)
+
+	seen := make(map[uint]struct{})
+	var guildIDs []uint
+	for _, guildID := range append(memberGuildIDs, ownedGuildIDs...) {
+		if _, ok := seen[guildID]; ok {
+			continue
+		}
+		seen[guildID] = struct{}{}
+		if canAccess, _ := checkGuildContentAccess(guildID, userID, "story"); canAccess {
+			guildIDs = append(guildIDs, guildID)
+		}
+	}
+	return guildIDs
+}
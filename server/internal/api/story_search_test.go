@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestSearchStoryEntries(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Guild{}, &model.GuildMember{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryGuild{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	other := model.User{Username: "other", Email: "other@example.com", PassHash: "hash"}
+	db.Create(&user)
+	db.Create(&other)
+	guild := model.Guild{Name: "Guild", OwnerID: other.ID, MemberCount: 2}
+	db.Create(&guild)
+	db.Create(&model.GuildMember{GuildID: guild.ID, UserID: user.ID, Role: "member"})
+
+	own := model.Story{UserID: user.ID, Title: "Own"}
+	archived := model.Story{UserID: other.ID, Title: "Archived"}
+	private := model.Story{UserID: other.ID, Title: "Private"}
+	db.Create(&own)
+	db.Create(&archived)
+	db.Create(&private)
+	db.Create(&model.StoryGuild{StoryID: archived.ID, GuildID: guild.ID, AddedBy: other.ID})
+
+	at := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	entries := []model.StoryEntry{
+		{StoryID: own.ID, Type: "dialogue", Speaker: "Aldric", Content: "艾德里克在营地提到了黑色收获。", Timestamp: at, SortOrder: 1},
+		{StoryID: own.ID, Type: "dialogue", Speaker: "Aldric", Content: "今晚风很大。", Timestamp: at.Add(time.Minute), SortOrder: 2},
+		{StoryID: archived.ID, Type: "dialogue", Speaker: "Bryn", Content: "The Black Harvest is coming.", Timestamp: at.Add(2 * time.Minute), SortOrder: 1},
+		{StoryID: archived.ID, Type: "image", Content: `{"image":"https://example.com/a.png","description":"黑色收获的旗帜"}`, Timestamp: at.Add(3 * time.Minute), SortOrder: 2},
+		{StoryID: private.ID, Type: "dialogue", Speaker: "Cyra", Content: "黑色收获 black harvest", Timestamp: at, SortOrder: 1},
+	}
+	for i := range entries {
+		entries[i].SearchText = entries[i].SearchDocument()
+		db.Create(&entries[i])
+	}
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+	search := func(query string) (int, []map[string]interface{}) {
+		t.Helper()
+		resp := performRequest(server.router, http.MethodGet, "/api/v1/stories/search?"+query, nil, token)
+		var body struct {
+			Results []map[string]interface{} `json:"results"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		return resp.Code, body.Results
+	}
+
+	code, results := search("q=" + url.QueryEscape("黑色收获"))
+	if code != http.StatusOK || len(results) != 2 {
+		t.Fatalf("expected own and guild entries, got %d %+v", code, results)
+	}
+	if results[0]["entry_id"].(float64) != float64(entries[3].ID) || !strings.Contains(results[0]["snippet"].(string), "<mark>黑色收获</mark>") {
+		t.Fatalf("unexpected image result %+v", results[0])
+	}
+	if results[1]["entry_id"].(float64) != float64(entries[0].ID) || results[1]["story_title"] != "Own" {
+		t.Fatalf("unexpected own result %+v", results[1])
+	}
+
+	code, results = search(fmt.Sprintf("q=%s&guild_id=%d", url.QueryEscape("black harv"), guild.ID))
+	if code != http.StatusOK || len(results) != 1 || results[0]["snippet"] != "The <mark>Black</mark> <mark>Harv</mark>est is coming." {
+		t.Fatalf("expected prefix match in guild, got %d %+v", code, results)
+	}
+
+	code, results = search("q=aldric")
+	if code != http.StatusOK || len(results) != 2 || results[0]["speaker_highlight"] != "<mark>Aldric</mark>" {
+		t.Fatalf("expected speaker match, got %d %+v", code, results)
+	}
+
+	if code, _ := search("q=" + url.QueryEscape("！？")); code != http.StatusBadRequest {
+		t.Fatalf("expected empty query to be rejected, got %d", code)
+	}
+}
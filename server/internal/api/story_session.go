@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SplitStoryRequest 拆分剧情请求：每个分段从指定条目开始，第一个起点之前的条目留在原剧情
+type SplitStoryRequest struct {
+	Sessions []SplitStorySession `json:"sessions" binding:"required,min=1,dive"`
+}
+
+// SplitStorySession 拆出的新剧情
+type SplitStorySession struct {
+	StartEntryID uint   `json:"start_entry_id" binding:"required"`
+	Title        string `json:"title" binding:"max=256"`
+}
+
+var errInvalidSplitBoundary = errors.New("invalid split boundary")
+
+// proposeStorySessions 按时间间隔、参与者与频道变化给出剧情的分段建议，不修改数据。
+// 可选参数：gap、soft_gap（分钟）与 min_entries，对应 service.SessionSplitOptions
+func (s *Server) proposeStorySessions(c *gin.Context) {
+	story, ok := loadOwnedStory(c)
+	if !ok {
+		return
+	}
+
+	var opts service.SessionSplitOptions
+	if value, err := strconv.Atoi(c.Query("gap")); err == nil && value > 0 {
+		opts.Gap = time.Duration(value) * time.Minute
+	}
+	if value, err := strconv.Atoi(c.Query("soft_gap")); err == nil && value > 0 {
+		opts.SoftGap = time.Duration(value) * time.Minute
+	}
+	if value, err := strconv.Atoi(c.Query("min_entries")); err == nil && value > 0 {
+		opts.MinEntries = value
+	}
+
+	var entries []model.StoryEntry
+	if err := database.DB.Select("id", "type", "speaker", "channel", "timestamp", "sort_order").
+		Where("story_id = ?", story.ID).
+		Order("sort_order ASC, id ASC").
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	sessions := service.ProposeStorySessions(entries, opts)
+	c.JSON(http.StatusOK, gin.H{
+		"story_id": story.ID,
+		"total":    len(entries),
+		"sessions": storySessionsResponse(entries, sessions),
+	})
+}
+
+// splitStory 按用户确认的分段起点拆分剧情：在一个事务中创建新剧情、移动条目、书签与批注，
+// 并按各段内容重新计算起止时间与参与者
+func (s *Server) splitStory(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, ok := loadOwnedStory(c)
+	if !ok {
+		return
+	}
+
+	var req SplitStoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+
+	created := make([]model.Story, 0, len(req.Sessions))
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(story, story.ID).Error; err != nil {
+			return err
+		}
+
+		var entries []model.StoryEntry
+		if err := tx.Select("id", "type", "speaker", "channel", "timestamp", "sort_order").
+			Where("story_id = ?", story.ID).
+			Order("sort_order ASC, id ASC").
+			Find(&entries).Error; err != nil {
+			return err
+		}
+		indexByID := make(map[uint]int, len(entries))
+		for i, entry := range entries {
+			indexByID[entry.ID] = i
+		}
+
+		// 校验分段起点：必须属于该剧情、不能是第一条且不能重复
+		titles := make(map[int]string, len(req.Sessions))
+		starts := make([]int, 0, len(req.Sessions))
+		for _, session := range req.Sessions {
+			index, ok := indexByID[session.StartEntryID]
+			if !ok || index == 0 {
+				return errInvalidSplitBoundary
+			}
+			if _, dup := titles[index]; dup {
+				return errInvalidSplitBoundary
+			}
+			titles[index] = strings.TrimSpace(session.Title)
+			starts = append(starts, index)
+		}
+		sort.Ints(starts)
+
+		var tagIDs []uint
+		if err := tx.Model(&model.StoryTag{}).Where("story_id = ?", story.ID).Pluck("tag_id", &tagIDs).Error; err != nil {
+			return err
+		}
+
+		sessions := service.BuildStorySessions(entries, starts, nil, nil)
+		now := time.Now()
+		for n, session := range sessions {
+			participants := ""
+			if len(session.Participants) > 0 {
+				data, _ := json.Marshal(session.Participants)
+				participants = string(data)
+			}
+
+			if n == 0 {
+				if err := tx.Model(story).Updates(map[string]interface{}{
+					"start_time":   session.StartTime,
+					"end_time":     session.EndTime,
+					"participants": participants,
+					"updated_at":   now,
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			title := titles[session.Start]
+			if title == "" {
+				title = fmt.Sprintf("%s（%d）", story.Title, n+1)
+			}
+			newStory := model.Story{
+				UserID:          userID,
+				Title:           title,
+				Region:          story.Region,
+				Address:         story.Address,
+				Participants:    participants,
+				Tags:            story.Tags,
+				StartTime:       session.StartTime,
+				EndTime:         session.EndTime,
+				Status:          "draft",
+				BackgroundColor: story.BackgroundColor,
+			}
+			if err := tx.Create(&newStory).Error; err != nil {
+				return err
+			}
+			for _, tagID := range tagIDs {
+				if err := tx.Create(&model.StoryTag{StoryID: newStory.ID, TagID: tagID, AddedBy: userID}).Error; err != nil {
+					return err
+				}
+			}
+			if err := copyStoryConsent(tx, []uint{story.ID}, newStory.ID); err != nil {
+				return err
+			}
+
+			// 移动条目，排序号整体前移以从 1 开始，保持原有相对顺序
+			ids := make([]uint, 0, session.End-session.Start+1)
+			for _, entry := range entries[session.Start : session.End+1] {
+				ids = append(ids, entry.ID)
+			}
+			offset := entries[session.Start].SortOrder - 1
+			for start := 0; start < len(ids); start += storyEntryIDChunk {
+				chunk := ids[start:min(start+storyEntryIDChunk, len(ids))]
+				if err := tx.Model(&model.StoryEntry{}).Where("id IN ?", chunk).
+					Updates(map[string]interface{}{
+						"story_id":   newStory.ID,
+						"chapter_id": nil,
+						"sort_order": gorm.Expr("sort_order - ?", offset),
+					}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&model.StoryBookmark{}).
+					Where("story_id = ? AND entry_id IN ?", story.ID, chunk).
+					Update("story_id", newStory.ID).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&model.StoryAnnotation{}).
+					Where("story_id = ? AND entry_id IN ?", story.ID, chunk).
+					Update("story_id", newStory.ID).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&model.StoryEntryRevision{}).
+					Where("story_id = ? AND entry_id IN ?", story.ID, chunk).
+					Update("story_id", newStory.ID).Error; err != nil {
+					return err
+				}
+			}
+
+			newStory.EntryCount = len(ids)
+			created = append(created, newStory)
+		}
+		story.EntryCount = sessions[0].End + 1
+		return nil
+	})
+	if errors.Is(err, errInvalidSplitBoundary) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分段起点无效"})
+		return
+	}
+	if err != nil {
+		log.Printf("[Story] split error: story=%d err=%v", story.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "拆分失败"})
+		return
+	}
+
+	database.DB.First(story, story.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "拆分成功",
+		"story":   story,
+		"stories": created,
+	})
+}
+
+func storySessionsResponse(entries []model.StoryEntry, sessions []service.StorySession) []gin.H {
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"start_entry_id": entries[session.Start].ID,
+			"end_entry_id":   entries[session.End].ID,
+			"entry_count":    session.End - session.Start + 1,
+			"start_time":     session.StartTime,
+			"end_time":       session.EndTime,
+			"participants":   session.Participants,
+			"channels":       session.Channels,
+			"reason":         session.Reason,
+			"gap_seconds":    int64(session.Gap / time.Second),
+		})
+	}
+	return result
+}
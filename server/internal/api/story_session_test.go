@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestProposeAndSplitStory(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{},
+		&model.StoryBookmark{}, &model.StoryTag{}, &model.Tag{}, &model.StoryAnnotation{}, &model.StoryParticipant{}, &model.StoryRedactionRequest{},
+		&model.StoryEntryRevision{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	db.Create(&user)
+	story := model.Story{UserID: user.ID, Title: "Weekend", Region: "Stormwind"}
+	db.Create(&story)
+	db.Create(&model.StoryTag{StoryID: story.ID, TagID: 7, AddedBy: user.ID})
+
+	base := time.Date(2024, 3, 2, 20, 0, 0, 0, time.UTC)
+	var entries []model.StoryEntry
+	for i := 0; i < 10; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+		speaker := "Aldric"
+		if i >= 5 {
+			at = at.Add(24 * time.Hour)
+			speaker = "Bryn"
+		}
+		entry := model.StoryEntry{StoryID: story.ID, Type: "dialogue", Speaker: speaker, Content: "line", Channel: "SAY", Timestamp: at, SortOrder: i + 1}
+		db.Create(&entry)
+		entries = append(entries, entry)
+	}
+	db.Create(&model.StoryBookmark{StoryID: story.ID, UserID: user.ID, EntryID: entries[7].ID, Name: "mark"})
+	db.Create(&model.StoryEntryRevision{StoryID: story.ID, EntryID: entries[8].ID, BatchID: "b1", UserID: user.ID, Action: "update"})
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	resp := performRequest(server.router, http.MethodGet, fmt.Sprintf("/api/v1/stories/%d/sessions", story.ID), nil, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("propose: %d body=%s", resp.Code, resp.Body.String())
+	}
+	var proposal struct {
+		Sessions []struct {
+			StartEntryID uint     `json:"start_entry_id"`
+			EntryCount   int      `json:"entry_count"`
+			Participants []string `json:"participants"`
+			Reason       string   `json:"reason"`
+		} `json:"sessions"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &proposal)
+	if len(proposal.Sessions) != 2 || proposal.Sessions[1].StartEntryID != entries[5].ID || proposal.Sessions[1].Reason != "time_gap" {
+		t.Fatalf("unexpected proposal %+v", proposal)
+	}
+
+	// 用户把第二段起点后移一条
+	body := map[string]interface{}{"sessions": []map[string]interface{}{{"start_entry_id": entries[6].ID, "title": "Day 2"}}}
+	resp = performRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/split", story.ID), body, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("split: %d body=%s", resp.Code, resp.Body.String())
+	}
+
+	var created model.Story
+	if err := db.Where("title = ?", "Day 2").First(&created).Error; err != nil {
+		t.Fatalf("new story not created: %v", err)
+	}
+	if created.Region != "Stormwind" || created.Participants != `["Bryn"]` ||
+		!created.StartTime.Equal(entries[6].Timestamp) || !created.EndTime.Equal(entries[9].Timestamp) {
+		t.Fatalf("unexpected new story %+v", created)
+	}
+	var moved []model.StoryEntry
+	db.Where("story_id = ?", created.ID).Order("sort_order").Find(&moved)
+	if len(moved) != 4 || moved[0].ID != entries[6].ID || moved[0].SortOrder != 1 {
+		t.Fatalf("unexpected moved entries %+v", moved)
+	}
+	var original model.Story
+	db.First(&original, story.ID)
+	if original.Participants != `["Aldric","Bryn"]` || !original.EndTime.Equal(entries[5].Timestamp) {
+		t.Fatalf("original story not updated %+v", original)
+	}
+	var bookmark model.StoryBookmark
+	db.Where("entry_id = ?", entries[7].ID).First(&bookmark)
+	if bookmark.StoryID != created.ID {
+		t.Fatalf("bookmark should follow its entry, got %+v", bookmark)
+	}
+	var revision model.StoryEntryRevision
+	db.Where("entry_id = ?", entries[8].ID).First(&revision)
+	if revision.StoryID != created.ID {
+		t.Fatalf("revision should follow its entry, got %+v", revision)
+	}
+	var tagCount int64
+	db.Model(&model.StoryTag{}).Where("story_id = ?", created.ID).Count(&tagCount)
+	if tagCount != 1 {
+		t.Fatalf("expected tags copied, got %d", tagCount)
+	}
+
+	body = map[string]interface{}{"sessions": []map[string]interface{}{{"start_entry_id": entries[0].ID}}}
+	resp = performRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/split", story.ID), body, token)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected first entry boundary to be rejected, got %d", resp.Code)
+	}
+}
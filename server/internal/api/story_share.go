@@ -0,0 +1,507 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/pkg/auth"
+	"github.com/rpbox/server/pkg/validator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxActiveStoryShareLinks 每个剧情同时有效的分享链接上限
+const maxActiveStoryShareLinks = 20
+
+// storySharePasswordHeader 访问带密码的分享链接时传递密码的请求头。
+// 不接受查询参数，避免密码进入访问日志、浏览器历史与 Referer
+const storySharePasswordHeader = "X-Share-Password"
+
+// 同一链接、同一 IP 尝试访问密码的次数：最多连续 5 次，之后每分钟恢复 1 次；密码正确时清零
+const (
+	storySharePasswordRPS   = 1.0 / 60
+	storySharePasswordBurst = 5
+)
+
+// CreateStoryShareLinkRequest 创建分享链接请求
+type CreateStoryShareLinkRequest struct {
+	Label     string     `json:"label" binding:"max=64"`
+	Password  string     `json:"password" binding:"max=64"` // 为空表示无需密码
+	ExpiresAt *time.Time `json:"expires_at"`                // 为空表示不过期
+}
+
+// UpdateStoryShareLinkRequest 修改分享链接请求，未传的字段保持不变
+type UpdateStoryShareLinkRequest struct {
+	Label          *string    `json:"label" binding:"omitempty,max=64"`
+	Password       *string    `json:"password" binding:"omitempty,max=64"` // 传空字符串表示取消密码
+	ExpiresAt      *time.Time `json:"expires_at"`
+	ClearExpiresAt bool       `json:"clear_expires_at"` // 取消过期时间
+}
+
+// listStoryShareLinks 获取剧情的默认分享码与全部分享链接（含已撤销的）
+func (s *Server) listStoryShareLinks(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	var links []model.StoryShareLink
+	if err := database.DB.Where("story_id = ?", story.ID).Order("created_at DESC, id DESC").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	for i := range links {
+		links[i].HasPassword = links[i].PasswordHash != ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"is_public":  story.IsPublic,
+		"share_code": story.ShareCode,
+		"view_count": story.ViewCount,
+		"links":      links,
+	})
+}
+
+// createStoryShareLink 为剧情创建新的分享链接。链接不依赖剧情的公开状态，
+// 未公开的剧情也可以只通过带密码或有效期的链接分享
+func (s *Server) createStoryShareLink(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	var req CreateStoryShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间必须晚于当前时间"})
+		return
+	}
+
+	var active, usable int64
+	database.DB.Model(&model.StoryShareLink{}).Where("story_id = ? AND revoked_at IS NULL", story.ID).Count(&active)
+	if active >= maxActiveStoryShareLinks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分享链接数量已达上限"})
+		return
+	}
+	database.DB.Model(&model.StoryShareLink{}).Where("story_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", story.ID, time.Now()).Count(&usable)
+
+	link := model.StoryShareLink{
+		StoryID:   story.ID,
+		CreatedBy: userID,
+		Label:     strings.TrimSpace(req.Label),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+			return
+		}
+		link.PasswordHash = hash
+	}
+	code, err := generateStoryShareCode()
+	if err != nil {
+		log.Printf("[Story] share code error: story=%d err=%v", story.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	link.Code = code
+	if err := database.DB.Create(&link).Error; err != nil {
+		log.Printf("[Story] create share link error: story=%d err=%v", story.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionShareLinkCreate, link.ID, 0)
+	if !story.IsPublic && usable == 0 {
+		// 未公开的剧情第一次可以通过链接访问，与公开剧情一样通知其中出现的其他 RPBox 用户
+		notifyStoryParticipants(story, userID)
+	}
+
+	link.HasPassword = link.PasswordHash != ""
+	c.JSON(http.StatusCreated, link)
+}
+
+// updateStoryShareLink 修改分享链接的备注、密码与过期时间，已撤销的链接不能修改
+func (s *Server) updateStoryShareLink(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	link, ok := loadActiveStoryShareLink(c, story.ID)
+	if !ok {
+		return
+	}
+
+	var req UpdateStoryShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validator.TranslateError(err)})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间必须晚于当前时间"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Label != nil {
+		updates["label"] = strings.TrimSpace(*req.Label)
+	}
+	if req.Password != nil {
+		hash := ""
+		if *req.Password != "" {
+			var err error
+			if hash, err = auth.HashPassword(*req.Password); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+				return
+			}
+		}
+		updates["password_hash"] = hash
+	}
+	if req.ClearExpiresAt {
+		updates["expires_at"] = nil
+	} else if req.ExpiresAt != nil {
+		updates["expires_at"] = *req.ExpiresAt
+	}
+	if len(updates) > 0 {
+		if err := database.DB.Model(link).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失败"})
+			return
+		}
+		recordStoryActivity(database.DB, story.ID, userID, storyActionShareLinkUpdate, link.ID, 0)
+	}
+
+	database.DB.First(link, link.ID)
+	link.HasPassword = link.PasswordHash != ""
+	c.JSON(http.StatusOK, link)
+}
+
+// regenerateStoryShareLink 重新生成分享码，旧链接立即失效，设置与访问统计保留
+func (s *Server) regenerateStoryShareLink(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	link, ok := loadActiveStoryShareLink(c, story.ID)
+	if !ok {
+		return
+	}
+
+	code, err := generateStoryShareCode()
+	if err == nil {
+		err = database.DB.Model(link).Update("code", code).Error
+	}
+	if err != nil {
+		log.Printf("[Story] regenerate share link error: story=%d link=%d err=%v", story.ID, link.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新生成失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionShareLinkUpdate, link.ID, 0)
+
+	link.HasPassword = link.PasswordHash != ""
+	c.JSON(http.StatusOK, link)
+}
+
+// revokeStoryShareLink 撤销分享链接，不影响剧情的公开状态与其他链接
+func (s *Server) revokeStoryShareLink(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	link, ok := loadActiveStoryShareLink(c, story.ID)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Model(link).Update("revoked_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionShareLinkRevoke, link.ID, 0)
+	c.JSON(http.StatusOK, gin.H{"message": "已撤销分享链接"})
+}
+
+// regenerateStoryShareCode 重新生成剧情的默认分享码，旧码立即失效，剧情保持公开
+func (s *Server) regenerateStoryShareCode(c *gin.Context) {
+	userID := c.GetUint("userID")
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+	if story.ShareCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "剧情尚未发布"})
+		return
+	}
+
+	code, err := generateStoryShareCode()
+	if err == nil {
+		err = database.DB.Model(story).Update("share_code", code).Error
+	}
+	if err != nil {
+		log.Printf("[Story] regenerate share code error: story=%d err=%v", story.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新生成失败"})
+		return
+	}
+	recordStoryActivity(database.DB, story.ID, userID, storyActionShareLinkUpdate, 0, 0)
+	c.JSON(http.StatusOK, story)
+}
+
+// getStoryShareAnalytics 获取公开访问统计：每日浏览与独立访客、来源域名以及各链接的汇总。
+// 可选参数：days（默认 30，最多 365）、link_id（只看某个链接，0 表示默认分享码）
+func (s *Server) getStoryShareAnalytics(c *gin.Context) {
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
+		return
+	}
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+	since := service.DayStart(time.Now()).AddDate(0, 0, -(days - 1))
+
+	visits := database.DB.Model(&model.StoryShareVisit{}).Where("story_id = ? AND visit_date >= ?", story.ID, since)
+	referrers := database.DB.Model(&model.StoryShareReferrer{}).Where("story_id = ? AND visit_date >= ?", story.ID, since)
+	if value := c.Query("link_id"); value != "" {
+		linkID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的链接ID"})
+			return
+		}
+		visits = visits.Where("share_link_id = ?", linkID)
+		referrers = referrers.Where("share_link_id = ?", linkID)
+	}
+
+	var daily []struct {
+		VisitDate time.Time
+		Views     int
+		Visitors  int
+	}
+	if err := visits.Session(&gorm.Session{}).
+		Select("visit_date, SUM(views) AS views, COUNT(DISTINCT visitor_hash) AS visitors").
+		Group("visit_date").Order("visit_date ASC").
+		Scan(&daily).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	var perLink []struct {
+		ShareLinkID uint
+		Views       int
+		Visitors    int
+	}
+	if err := visits.Session(&gorm.Session{}).
+		Select("share_link_id, SUM(views) AS views, COUNT(DISTINCT visitor_hash) AS visitors").
+		Group("share_link_id").Order("share_link_id ASC").
+		Scan(&perLink).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	var sources []struct {
+		Host  string `json:"host"`
+		Count int    `json:"count"`
+	}
+	if err := referrers.
+		Select("host, SUM(count) AS count").
+		Group("host").Order("count DESC, host ASC").Limit(50).
+		Scan(&sources).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	dailyResult := make([]gin.H, 0, len(daily))
+	for _, day := range daily {
+		dailyResult = append(dailyResult, gin.H{
+			"date":           day.VisitDate.Format("2006-01-02"),
+			"views":          day.Views,
+			"unique_viewers": day.Visitors,
+		})
+	}
+	linkResult := make([]gin.H, 0, len(perLink))
+	for _, link := range perLink {
+		linkResult = append(linkResult, gin.H{
+			"link_id":        link.ShareLinkID,
+			"views":          link.Views,
+			"unique_viewers": link.Visitors,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"story_id":   story.ID,
+		"since":      since.Format("2006-01-02"),
+		"view_count": story.ViewCount,
+		"daily":      dailyResult,
+		"links":      linkResult,
+		"referrers":  sources,
+	})
+}
+
+// loadActiveStoryShareLink 加载路由参数 linkId 对应的、属于该剧情且未撤销的分享链接
+func loadActiveStoryShareLink(c *gin.Context, storyID uint) (*model.StoryShareLink, bool) {
+	linkID, _ := strconv.ParseUint(c.Param("linkId"), 10, 32)
+	var link model.StoryShareLink
+	if err := database.DB.Where("id = ? AND story_id = ? AND revoked_at IS NULL", linkID, storyID).
+		First(&link).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分享链接不存在"})
+		return nil, false
+	}
+	return &link, true
+}
+
+// loadPublicStory 按分享码加载剧情。分享码可以是公开剧情的默认分享码，也可以是分享链接；
+// 分享链接不要求剧情公开，但会校验撤销、过期与访问密码，失败时直接写入响应
+func (s *Server) loadPublicStory(c *gin.Context) (*model.Story, *model.StoryShareLink, bool) {
+	code := c.Param("code")
+	if code == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在或未公开"})
+		return nil, nil, false
+	}
+
+	var story model.Story
+	var link model.StoryShareLink
+	err := database.DB.Where("code = ?", code).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := database.DB.Where("share_code = ? AND is_public = ?", code, true).First(&story).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在或未公开"})
+			return nil, nil, false
+		}
+		return &story, nil, true
+	}
+	if err != nil || link.RevokedAt != nil || database.DB.First(&story, link.StoryID).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在或未公开"})
+		return nil, nil, false
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "分享链接已过期"})
+		return nil, nil, false
+	}
+	if link.PasswordHash != "" {
+		password := c.GetHeader(storySharePasswordHeader)
+		if password == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "需要访问密码", "password_required": true})
+			return nil, nil, false
+		}
+		// 先计入一次尝试再校验密码，避免对同一链接暴力尝试；密码正确后清零，不影响正常翻页阅读
+		attemptKey := strconv.FormatUint(uint64(link.ID), 10) + "|" + c.ClientIP()
+		if !s.sharePasswordLimiter.Allow(attemptKey) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "密码尝试次数过多，请稍后再试", "password_required": true})
+			return nil, nil, false
+		}
+		if !auth.CheckPassword(password, link.PasswordHash) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "访问密码错误", "password_required": true})
+			return nil, nil, false
+		}
+		s.sharePasswordLimiter.Reset(attemptKey)
+	}
+	return &story, &link, true
+}
+
+// recordStoryShareView 记录一次公开访问：累加浏览次数，并按日记录访客摘要与来源域名。
+// 请求带有 DNT 或 Sec-GPC 时只累加浏览次数
+func (s *Server) recordStoryShareView(c *gin.Context, story *model.Story, link *model.StoryShareLink) {
+	database.DB.Model(story).UpdateColumn("view_count", gorm.Expr("view_count + ?", 1))
+	var linkID uint
+	if link != nil {
+		linkID = link.ID
+		database.DB.Model(link).UpdateColumn("view_count", gorm.Expr("view_count + ?", 1))
+	}
+	if c.GetHeader("DNT") == "1" || c.GetHeader("Sec-GPC") == "1" {
+		return
+	}
+
+	now := time.Now()
+	date := service.DayStart(now)
+	visit := model.StoryShareVisit{
+		StoryID:     story.ID,
+		ShareLinkID: linkID,
+		VisitDate:   date,
+		VisitorHash: s.storyShareVisitorHash(c, date),
+		Views:       1,
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "story_id"}, {Name: "share_link_id"}, {Name: "visit_date"}, {Name: "visitor_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("story_share_visits.views + 1")}),
+	}).Create(&visit).Error; err != nil {
+		log.Printf("[Story] record share visit error: story=%d link=%d err=%v", story.ID, linkID, err)
+	}
+
+	referrer := model.StoryShareReferrer{
+		StoryID:     story.ID,
+		ShareLinkID: linkID,
+		VisitDate:   date,
+		Host:        storyShareReferrerHost(c),
+		Count:       1,
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "story_id"}, {Name: "share_link_id"}, {Name: "visit_date"}, {Name: "host"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("story_share_referrers.count + 1")}),
+	}).Create(&referrer).Error; err != nil {
+		log.Printf("[Story] record share referrer error: story=%d link=%d err=%v", story.ID, linkID, err)
+	}
+}
+
+// storyShareVisitorHash 用服务端密钥与日期作为盐值对 IP 与 UA 取摘要，只用于统计当日独立访客
+func (s *Server) storyShareVisitorHash(c *gin.Context, date time.Time) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		s.cfg.JWT.Secret, "story-share", date.Format("2006-01-02"), c.ClientIP(), c.Request.UserAgent(),
+	}, "\x1f")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// storyShareReferrerHost 只保留来源页面的域名，站内跳转与无法解析的来源视为直接访问
+func storyShareReferrerHost(c *gin.Context) string {
+	parsed, err := url.Parse(c.Request.Referer())
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	requestHost := c.Request.Host
+	if hostname, _, err := net.SplitHostPort(requestHost); err == nil {
+		requestHost = hostname
+	}
+	if host == strings.TrimPrefix(strings.ToLower(requestHost), "www.") {
+		return ""
+	}
+	if len(host) > 255 {
+		host = host[:255]
+	}
+	return host
+}
+
+// generateStoryShareCode 生成未被剧情或分享链接使用的分享码
+func generateStoryShareCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code := generateShareCode()
+		var count int64
+		if err := database.DB.Model(&model.StoryShareLink{}).Where("code = ?", code).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := database.DB.Model(&model.Story{}).Where("share_code = ?", code).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return code, nil
+		}
+	}
+	return "", errors.New("share code collision")
+}
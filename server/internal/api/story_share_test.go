@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryShareLinks(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{}, &model.StoryActivity{},
+		&model.StoryGuild{}, &model.StoryShareLink{}, &model.StoryShareVisit{}, &model.StoryShareReferrer{}, &model.StoryChapter{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	viewer := model.User{Username: "viewer", Email: "viewer@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&viewer)
+	story := model.Story{
+		UserID:    owner.ID,
+		Title:     "夜巡",
+		StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	db.Create(&story)
+	db.Create(&model.StoryEntry{StoryID: story.ID, Type: "dialogue", Speaker: "Aldric", Content: "Halt!", SortOrder: 1})
+	db.Create(&model.StoryCollaborator{StoryID: story.ID, UserID: viewer.ID, Role: storyRoleViewer, InvitedBy: owner.ID})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	base := fmt.Sprintf("/api/v1/stories/%d", story.ID)
+	visit := func(code string, headers map[string]string) int {
+		return performRawRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+code, nil, headers, "").Code
+	}
+
+	resp := performRequest(server.router, http.MethodPost, base+"/publish", map[string]interface{}{"is_public": true}, ownerToken)
+	var published model.Story
+	json.Unmarshal(resp.Body.Bytes(), &published)
+	if resp.Code != http.StatusOK || published.ShareCode == "" {
+		t.Fatalf("publish failed: %d %s", resp.Code, resp.Body.String())
+	}
+
+	if resp := performRequest(server.router, http.MethodPost, base+"/share-links", map[string]interface{}{"label": "论坛"}, newTestToken(t, viewer)); resp.Code != http.StatusForbidden {
+		t.Fatalf("viewer should not manage share links, got %d", resp.Code)
+	}
+	resp = performRequest(server.router, http.MethodPost, base+"/share-links", map[string]interface{}{"label": "论坛", "password": "secret"}, ownerToken)
+	var protected model.StoryShareLink
+	json.Unmarshal(resp.Body.Bytes(), &protected)
+	if resp.Code != http.StatusCreated || protected.Code == "" || !protected.HasPassword || protected.Code == published.ShareCode {
+		t.Fatalf("create share link failed: %d %s", resp.Code, resp.Body.String())
+	}
+	resp = performRequest(server.router, http.MethodPost, base+"/share-links", map[string]interface{}{"label": "临时"}, ownerToken)
+	var temporary model.StoryShareLink
+	json.Unmarshal(resp.Body.Bytes(), &temporary)
+	if past := time.Now().Add(-time.Hour).Format(time.RFC3339); performRequest(server.router, http.MethodPost, base+"/share-links", map[string]interface{}{"expires_at": past}, ownerToken).Code != http.StatusBadRequest {
+		t.Fatal("expiry in the past should be rejected")
+	}
+
+	// 密码校验
+	if code := visit(protected.Code, nil); code != http.StatusUnauthorized {
+		t.Fatalf("password link without password should return 401, got %d", code)
+	}
+	if code := visit(protected.Code, map[string]string{storySharePasswordHeader: "wrong"}); code != http.StatusForbidden {
+		t.Fatalf("wrong password should return 403, got %d", code)
+	}
+	if code := visit(protected.Code, map[string]string{storySharePasswordHeader: "secret", "Referer": "https://www.Forum.example.com/thread/1"}); code != http.StatusOK {
+		t.Fatalf("correct password should open the story, got %d", code)
+	}
+
+	// 默认分享码与不同访客
+	for _, headers := range []map[string]string{
+		{"User-Agent": "reader-a", "Referer": "https://forum.example.com/thread/2"},
+		{"User-Agent": "reader-a"},
+		{"User-Agent": "reader-b"},
+		{"User-Agent": "reader-c", "DNT": "1"},
+	} {
+		if code := visit(published.ShareCode, headers); code != http.StatusOK {
+			t.Fatalf("default share code should still work, got %d", code)
+		}
+	}
+
+	// 过期
+	db.Model(&model.StoryShareLink{}).Where("id = ?", temporary.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if code := visit(temporary.Code, nil); code != http.StatusGone {
+		t.Fatalf("expired link should return 410, got %d", code)
+	}
+	resp = performRequest(server.router, http.MethodPut, fmt.Sprintf("%s/share-links/%d", base, temporary.ID), map[string]interface{}{"clear_expires_at": true}, ownerToken)
+	if resp.Code != http.StatusOK || visit(temporary.Code, nil) != http.StatusOK {
+		t.Fatalf("clearing expiry should reopen the link: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 重新生成与撤销不影响剧情公开状态
+	resp = performRequest(server.router, http.MethodPost, fmt.Sprintf("%s/share-links/%d/regenerate", base, protected.ID), nil, ownerToken)
+	var regenerated model.StoryShareLink
+	json.Unmarshal(resp.Body.Bytes(), &regenerated)
+	if resp.Code != http.StatusOK || regenerated.Code == protected.Code {
+		t.Fatalf("regenerate failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if code := visit(protected.Code, map[string]string{storySharePasswordHeader: "secret"}); code != http.StatusNotFound {
+		t.Fatalf("old code should stop working, got %d", code)
+	}
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/share-links/%d", base, temporary.ID), nil, ownerToken); resp.Code != http.StatusOK {
+		t.Fatalf("revoke failed: %d", resp.Code)
+	}
+	if code := visit(temporary.Code, nil); code != http.StatusNotFound {
+		t.Fatalf("revoked link should return 404, got %d", code)
+	}
+	resp = performRequest(server.router, http.MethodPost, base+"/share-code/regenerate", nil, ownerToken)
+	var reshared model.Story
+	json.Unmarshal(resp.Body.Bytes(), &reshared)
+	if resp.Code != http.StatusOK || !reshared.IsPublic || reshared.ShareCode == published.ShareCode || visit(published.ShareCode, nil) != http.StatusNotFound {
+		t.Fatalf("regenerating the default code should keep the story public: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 访问统计：密码链接 1 次，默认分享码 4 次（其中一次带 DNT 不计访客），清除过期后 1 次
+	resp = performRequest(server.router, http.MethodGet, base+"/share-analytics", nil, ownerToken)
+	var analytics struct {
+		ViewCount int `json:"view_count"`
+		Daily     []struct {
+			Date          string `json:"date"`
+			Views         int    `json:"views"`
+			UniqueViewers int    `json:"unique_viewers"`
+		} `json:"daily"`
+		Links []struct {
+			LinkID        uint `json:"link_id"`
+			Views         int  `json:"views"`
+			UniqueViewers int  `json:"unique_viewers"`
+		} `json:"links"`
+		Referrers []struct {
+			Host  string `json:"host"`
+			Count int    `json:"count"`
+		} `json:"referrers"`
+	}
+	json.Unmarshal(resp.Body.Bytes(), &analytics)
+	if resp.Code != http.StatusOK || analytics.ViewCount != 6 {
+		t.Fatalf("unexpected analytics: %d %s", resp.Code, resp.Body.String())
+	}
+	if len(analytics.Daily) != 1 || analytics.Daily[0].Views != 5 || analytics.Daily[0].UniqueViewers != 3 {
+		t.Fatalf("unexpected daily stats %+v", analytics.Daily)
+	}
+	if len(analytics.Links) != 3 || analytics.Links[0].LinkID != 0 || analytics.Links[0].Views != 3 || analytics.Links[0].UniqueViewers != 2 {
+		t.Fatalf("unexpected per-link stats %+v", analytics.Links)
+	}
+	if len(analytics.Referrers) != 2 || analytics.Referrers[0].Host != "" || analytics.Referrers[0].Count != 3 ||
+		analytics.Referrers[1].Host != "forum.example.com" || analytics.Referrers[1].Count != 2 {
+		t.Fatalf("unexpected referrers %+v", analytics.Referrers)
+	}
+
+	// 取消公开后默认分享码失效，分享链接仍可访问且密码保护依旧生效
+	performRequest(server.router, http.MethodPost, base+"/publish", map[string]interface{}{"is_public": false}, ownerToken)
+	if code := visit(reshared.ShareCode, nil); code != http.StatusNotFound {
+		t.Fatalf("unpublished story should not be reachable through the default code, got %d", code)
+	}
+	if code := visit(regenerated.Code, nil); code != http.StatusUnauthorized {
+		t.Fatalf("protected link of a private story should still require the password, got %d", code)
+	}
+	resp = performRequest(server.router, http.MethodGet, "/api/v1/public/stories/"+regenerated.Code+"?password=secret", nil, "")
+	if resp.Code != http.StatusUnauthorized || strings.Contains(resp.Body.String(), "Halt!") {
+		t.Fatalf("password must not be accepted from the query string: %d %s", resp.Code, resp.Body.String())
+	}
+	if code := visit(regenerated.Code, map[string]string{storySharePasswordHeader: "secret"}); code != http.StatusOK {
+		t.Fatalf("protected link should open a private story with the password, got %d", code)
+	}
+
+	// 密码正确时清零尝试次数，反复阅读不会被限流
+	for i := 0; i < storySharePasswordBurst; i++ {
+		if code := visit(regenerated.Code, map[string]string{storySharePasswordHeader: "secret"}); code != http.StatusOK {
+			t.Fatalf("repeated visit %d with the password should succeed, got %d", i+1, code)
+		}
+	}
+
+	// 同一链接、同一 IP 连续输错后限流，正确密码也要等待
+	for i := 0; i < storySharePasswordBurst; i++ {
+		if code := visit(regenerated.Code, map[string]string{storySharePasswordHeader: "wrong"}); code != http.StatusForbidden {
+			t.Fatalf("wrong password attempt %d should return 403, got %d", i+1, code)
+		}
+	}
+	if code := visit(regenerated.Code, map[string]string{storySharePasswordHeader: "secret"}); code != http.StatusTooManyRequests {
+		t.Fatalf("password attempts should be throttled, got %d", code)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"gorm.io/gorm"
+)
+
+// storyStatsBatchSize 统计时每批读取的条目数
+const storyStatsBatchSize = 1000
+
+// storyStatsLocation 解析热力图时区参数 tz（如 Asia/Shanghai），未指定时使用 UTC
+func storyStatsLocation(c *gin.Context) (*time.Location, bool) {
+	tz := strings.TrimSpace(c.Query("tz"))
+	if tz == "" {
+		return time.UTC, true
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的时区"})
+		return nil, false
+	}
+	return loc, true
+}
+
+// buildStoryStats 汇总 storyQuery 选出的剧情及其条目
+func buildStoryStats(storyQuery *gorm.DB, loc *time.Location) (service.StoryStats, error) {
+	builder := service.NewStoryStatsBuilder(loc)
+
+	var stories []model.Story
+	if err := storyQuery.Select("id", "start_time", "end_time").Find(&stories).Error; err != nil {
+		return service.StoryStats{}, err
+	}
+	ids := make([]uint, 0, len(stories))
+	for _, story := range stories {
+		builder.AddStory(story)
+		ids = append(ids, story.ID)
+	}
+
+	for start := 0; start < len(ids); start += storyEntryIDChunk {
+		var batch []model.StoryEntry
+		err := database.DB.Select("id", "story_id", "type", "character_id", "speaker", "content", "channel", "timestamp").
+			Where("story_id IN ?", ids[start:min(start+storyEntryIDChunk, len(ids))]).
+			FindInBatches(&batch, storyStatsBatchSize, func(tx *gorm.DB, _ int) error {
+				for _, entry := range batch {
+					builder.AddEntry(entry)
+				}
+				return nil
+			}).Error
+		if err != nil {
+			return service.StoryStats{}, err
+		}
+	}
+	return builder.Result(), nil
+}
+
+// getStoryStats 获取剧情统计：说话者发言与字数、频道与类型分布、时长与活跃时段热力图。可选参数：tz
+func (s *Server) getStoryStats(c *gin.Context) {
+	userID := c.GetUint("userID")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	var story model.Story
+	if err := database.DB.First(&story, id).Error; err != nil || !canViewStory(&story, userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
+		return
+	}
+	loc, ok := storyStatsLocation(c)
+	if !ok {
+		return
+	}
+
+	stats, err := buildStoryStats(database.DB.Model(&model.Story{}).Where("id = ?", story.ID), loc)
+	if err != nil {
+		log.Printf("[Story] stats error: story=%d err=%v", story.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// getMyStoryStats 汇总当前用户创建的全部剧情。可选参数：tz
+func (s *Server) getMyStoryStats(c *gin.Context) {
+	userID := c.GetUint("userID")
+	loc, ok := storyStatsLocation(c)
+	if !ok {
+		return
+	}
+
+	stats, err := buildStoryStats(database.DB.Model(&model.Story{}).Where("user_id = ?", userID), loc)
+	if err != nil {
+		log.Printf("[Story] user stats error: user=%d err=%v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// getGuildStoryStats 汇总公会归档的全部剧情，权限与公会剧情列表一致。可选参数：tz
+func (s *Server) getGuildStoryStats(c *gin.Context) {
+	userID := c.GetUint("userID")
+	guildID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	if canAccess, _ := checkGuildContentAccess(uint(guildID), userID, "story"); !canAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看公会内容"})
+		return
+	}
+	loc, ok := storyStatsLocation(c)
+	if !ok {
+		return
+	}
+
+	archived := database.DB.Model(&model.StoryGuild{}).Select("story_id").Where("guild_id = ?", guildID)
+	stats, err := buildStoryStats(database.DB.Model(&model.Story{}).Where("id IN (?)", archived), loc)
+	if err != nil {
+		log.Printf("[Story] guild stats error: guild=%d err=%v", guildID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "统计失败"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
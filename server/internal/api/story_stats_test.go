@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/service"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestStoryStats(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{}, &model.StoryCollaborator{},
+		&model.StoryGuild{}, &model.Guild{}, &model.GuildMember{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	stranger := model.User{Username: "stranger", Email: "stranger@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&stranger)
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	first := model.Story{UserID: owner.ID, Title: "夜巡", StartTime: base, EndTime: base.Add(time.Hour)}
+	second := model.Story{UserID: owner.ID, Title: "黎明", StartTime: base.Add(24 * time.Hour), EndTime: base.Add(25 * time.Hour)}
+	db.Create(&first)
+	db.Create(&second)
+	for i, entry := range []model.StoryEntry{
+		{StoryID: first.ID, Type: "dialogue", Speaker: "Aldric", Content: "Halt!", Channel: "SAY", Timestamp: base},
+		{StoryID: first.ID, Type: "dialogue", Speaker: "Bryn", Content: "Who goes there?", Channel: "SAY", Timestamp: base.Add(time.Minute)},
+		{StoryID: first.ID, Type: "dialogue", Speaker: "Aldric", Content: "The watch!", Channel: "YELL", Timestamp: base.Add(2 * time.Minute)},
+		{StoryID: second.ID, Type: "narration", Content: "Dawn breaks.", Timestamp: base.Add(24 * time.Hour)},
+	} {
+		entry.SortOrder = i + 1
+		db.Create(&entry)
+	}
+	guild := model.Guild{Name: "守夜人", OwnerID: owner.ID}
+	db.Create(&guild)
+	db.Create(&model.StoryGuild{StoryID: second.ID, GuildID: guild.ID, AddedBy: owner.ID})
+
+	server := newTestServer(t, db)
+	ownerToken := newTestToken(t, owner)
+	strangerToken := newTestToken(t, stranger)
+	getStats := func(path, token string) service.StoryStats {
+		resp := performRequest(server.router, http.MethodGet, path, nil, token)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", path, resp.Code, resp.Body.String())
+		}
+		var stats service.StoryStats
+		json.Unmarshal(resp.Body.Bytes(), &stats)
+		return stats
+	}
+
+	stats := getStats(fmt.Sprintf("/api/v1/stories/%d/stats?tz=Asia/Shanghai", first.ID), ownerToken)
+	if stats.EntryCount != 3 || stats.DurationSeconds != 3600 || len(stats.Speakers) != 2 || stats.Speakers[0].Speaker != "Aldric" || stats.Speakers[0].Lines != 2 {
+		t.Fatalf("unexpected story stats %+v", stats)
+	}
+	// UTC 21 点即上海时间周四 5 点
+	if stats.Channels[0] != (service.StatsCount{Key: "SAY", Count: 2}) || stats.Heatmap[time.Thursday][5] != 3 {
+		t.Fatalf("unexpected distribution %+v %+v", stats.Channels, stats.Heatmap[time.Thursday])
+	}
+	if resp := performRequest(server.router, http.MethodGet, fmt.Sprintf("/api/v1/stories/%d/stats", first.ID), nil, strangerToken); resp.Code != http.StatusNotFound {
+		t.Fatalf("stranger should not see private stats, got %d", resp.Code)
+	}
+	if resp := performRequest(server.router, http.MethodGet, fmt.Sprintf("/api/v1/stories/%d/stats?tz=Mars/Olympus", first.ID), nil, ownerToken); resp.Code != http.StatusBadRequest {
+		t.Fatalf("invalid timezone should be rejected, got %d", resp.Code)
+	}
+
+	stats = getStats("/api/v1/stories/stats", ownerToken)
+	if stats.StoryCount != 2 || stats.EntryCount != 4 || stats.DurationSeconds != 7200 || len(stats.Types) != 2 {
+		t.Fatalf("unexpected user stats %+v", stats)
+	}
+	if stats = getStats("/api/v1/stories/stats", strangerToken); stats.StoryCount != 0 || stats.EntryCount != 0 {
+		t.Fatalf("stranger has no stories, got %+v", stats)
+	}
+
+	guildPath := fmt.Sprintf("/api/v1/guilds/%d/story-stats", guild.ID)
+	stats = getStats(guildPath, ownerToken)
+	if stats.StoryCount != 1 || stats.EntryCount != 1 || stats.Types[0].Key != "narration" {
+		t.Fatalf("unexpected guild stats %+v", stats)
+	}
+	if resp := performRequest(server.router, http.MethodGet, guildPath, nil, strangerToken); resp.Code != http.StatusForbidden {
+		t.Fatalf("visitors should not see guild stats, got %d", resp.Code)
+	}
+}
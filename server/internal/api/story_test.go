@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestAddStoryEntriesIdempotent(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Character{}, &model.CharacterVersion{},
+		&model.Story{}, &model.StoryEntry{}, &model.StoryEntryRevision{}, &model.StoryCollaborator{}, &model.StoryActivity{}, &model.UserDailyActivity{}, &model.UserActivityLog{})
+	database.DB = db
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	db.Create(&user)
+	story := model.Story{
+		UserID:    user.ID,
+		Title:     "Scene",
+		StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	db.Create(&story)
+	// 旧数据没有内容指纹
+	db.Create(&model.StoryEntry{StoryID: story.ID, Type: "dialogue", Speaker: "Aldric", Content: "Halt!",
+		Channel: "SAY", Timestamp: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC), SortOrder: 1})
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+	path := fmt.Sprintf("/api/v1/stories/%d/entries", story.ID)
+
+	type ingest struct {
+		Count    int `json:"count"`
+		Inserted int `json:"inserted"`
+		Skipped  int `json:"skipped"`
+		Updated  int `json:"updated"`
+	}
+	post := func(body []CreateStoryEntryRequest) ingest {
+		t.Helper()
+		resp := performRequest(server.router, http.MethodPost, path, body, token)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("add entries: %d body=%s", resp.Code, resp.Body.String())
+		}
+		var result ingest
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return result
+	}
+
+	batch := []CreateStoryEntryRequest{
+		{SourceID: "chat_1", Speaker: "Bryn", Content: "Who goes there?", Channel: "SAY", Timestamp: "2024-01-01T20:00:05Z"},
+		{SourceID: "chat_1", Speaker: "Bryn", Content: "Who goes there?", Channel: "SAY", Timestamp: "2024-01-01T20:00:05Z"},
+		{Speaker: "Aldric", Content: "Halt!", Channel: "SAY", Timestamp: "2024-01-01T20:00:00Z"},
+		{Speaker: "Aldric", Content: "raises a lantern.", Channel: "EMOTE", Timestamp: "2024-01-01T20:00:10Z"},
+	}
+	if got := post(batch); got != (ingest{Count: 2, Inserted: 2, Skipped: 2}) {
+		t.Fatalf("first upload: %+v", got)
+	}
+	// 客户端超时重传
+	if got := post(batch); got != (ingest{Skipped: 4}) {
+		t.Fatalf("retry should be skipped entirely: %+v", got)
+	}
+	// 同一来源ID内容变化时更新原条目
+	batch[0].Content = "Who goes there, stranger?"
+	if got := post(batch[:1]); got != (ingest{Updated: 1}) {
+		t.Fatalf("changed source entry should update: %+v", got)
+	}
+
+	var entries []model.StoryEntry
+	db.Where("story_id = ?", story.ID).Order("sort_order").Find(&entries)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	if entries[1].SourceID != "chat_1" || entries[1].Content != "Who goes there, stranger?" || entries[1].SortOrder != 2 {
+		t.Fatalf("unexpected updated entry %+v", entries[1])
+	}
+	if entries[0].ContentHash == "" {
+		t.Fatalf("legacy entry should be backfilled with a content hash")
+	}
+}
+
+func TestStoryEntrySourceIDsStayUniqueAcrossCopyAndMove(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{}, &model.StoryEntryRevision{}, &model.StoryCollaborator{},
+		&model.StoryActivity{}, &model.StoryTag{}, &model.StoryChapter{}, &model.StoryParticipant{}, &model.StoryRedactionRequest{},
+		&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryShareLink{}, &model.StoryShareVisit{}, &model.StoryShareReferrer{})
+	database.DB = db
+	// 与生产环境相同的部分唯一索引
+	if err := db.Exec("CREATE UNIQUE INDEX idx_story_entries_source_unique ON story_entries(story_id, source_id) WHERE source_id <> ''").Error; err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+
+	user := model.User{Username: "tester", Email: "tester@example.com", PassHash: "hash"}
+	db.Create(&user)
+	base := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	target := model.Story{UserID: user.ID, Title: "Target"}
+	first := model.Story{UserID: user.ID, Title: "First"}
+	second := model.Story{UserID: user.ID, Title: "Second"}
+	db.Create(&target)
+	db.Create(&first)
+	db.Create(&second)
+	newEntry := func(storyID uint, sourceID string, minute int) model.StoryEntry {
+		entry := model.StoryEntry{StoryID: storyID, SourceID: sourceID, Type: "dialogue", Speaker: "Aldric",
+			Content: sourceID, Timestamp: base.Add(time.Duration(minute) * time.Minute), SortOrder: minute + 1}
+		db.Create(&entry)
+		return entry
+	}
+	newEntry(target.ID, "chat_1", 0)
+	copied := []model.StoryEntry{newEntry(first.ID, "chat_1", 1), newEntry(first.ID, "chat_2", 2)}
+	newEntry(second.ID, "chat_2", 3)
+	newEntry(second.ID, "chat_3", 4)
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, user)
+
+	// 复制模式：目标剧情已有的来源ID不再带入
+	resp := performRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/entries/archive", first.ID),
+		map[string]interface{}{"entry_ids": []uint{copied[0].ID, copied[1].ID}, "target_id": target.ID, "mode": "copy"}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("archive copy: %d %s", resp.Code, resp.Body.String())
+	}
+	// 批量移动：与目标剧情重复的来源ID被清空
+	resp = performRequest(server.router, http.MethodPost, "/api/v1/stories/batch-move",
+		map[string]interface{}{"source_ids": []uint{second.ID}, "target_id": target.ID}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("batch move: %d %s", resp.Code, resp.Body.String())
+	}
+
+	var entries []model.StoryEntry
+	db.Where("story_id = ?", target.ID).Order("timestamp").Find(&entries)
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries in target, got %d", len(entries))
+	}
+	var sourceIDs []string
+	for _, entry := range entries {
+		sourceIDs = append(sourceIDs, entry.SourceID)
+	}
+	if fmt.Sprint(sourceIDs) != "[chat_1  chat_2  chat_3]" {
+		t.Fatalf("duplicated source ids should be released, got %q", sourceIDs)
+	}
+	if entries[1].Content != "chat_1" || entries[3].Content != "chat_2" {
+		t.Fatalf("entries with released source ids should keep their content: %+v", entries)
+	}
+}
+
+func TestBatchMoveStoriesCarriesHistoryAndCleansUp(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.User{}, &model.Story{}, &model.StoryEntry{}, &model.StoryEntryRevision{}, &model.StoryCollaborator{},
+		&model.StoryActivity{}, &model.StoryTag{}, &model.StoryChapter{}, &model.StoryParticipant{}, &model.StoryRedactionRequest{},
+		&model.StoryBookmark{}, &model.StoryAnnotation{}, &model.StoryShareLink{}, &model.StoryShareVisit{}, &model.StoryShareReferrer{})
+	database.DB = db
+
+	owner := model.User{Username: "owner", Email: "owner@example.com", PassHash: "hash"}
+	editor := model.User{Username: "editor", Email: "editor@example.com", PassHash: "hash"}
+	db.Create(&owner)
+	db.Create(&editor)
+	base := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	target := model.Story{UserID: owner.ID, Title: "Target"}
+	source := model.Story{UserID: owner.ID, Title: "Source"}
+	db.Create(&target)
+	db.Create(&source)
+	kept := model.StoryEntry{StoryID: source.ID, Type: "dialogue", Speaker: "Aldric", Content: "Halt!", Timestamp: base, SortOrder: 1}
+	removed := model.StoryEntry{StoryID: source.ID, Type: "dialogue", Speaker: "Bryn", Content: "Who goes there?", Timestamp: base.Add(time.Minute), SortOrder: 2}
+	db.Create(&kept)
+	db.Create(&removed)
+	db.Create(&model.StoryCollaborator{StoryID: source.ID, UserID: editor.ID, Role: storyRoleEditor, InvitedBy: owner.ID})
+	db.Create(&model.StoryShareLink{StoryID: source.ID, Code: "source-link", CreatedBy: owner.ID})
+	db.Create(&model.StoryShareVisit{StoryID: source.ID, VisitDate: base, VisitorHash: "visitor", Views: 1})
+	db.Create(&model.StoryShareReferrer{StoryID: source.ID, VisitDate: base, Host: "forum.example.com", Count: 1})
+	db.Create(&model.StoryAnnotation{StoryID: source.ID, EntryID: kept.ID, UserID: owner.ID, Visibility: "owner", Content: "设定核对"})
+
+	server := newTestServer(t, db)
+	token := newTestToken(t, owner)
+	sourcePath := fmt.Sprintf("/api/v1/stories/%d", source.ID)
+	if resp := performRequest(server.router, http.MethodDelete, fmt.Sprintf("%s/entries/%d", sourcePath, removed.ID), nil, token); resp.Code != http.StatusOK {
+		t.Fatalf("delete entry: %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp := performRequest(server.router, http.MethodPost, "/api/v1/stories/batch-move",
+		map[string]interface{}{"source_ids": []uint{source.ID}, "target_id": target.ID}, token)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("batch move: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// 批注与修订随条目进入目标剧情
+	var annotation model.StoryAnnotation
+	db.Where("entry_id = ?", kept.ID).First(&annotation)
+	if annotation.StoryID != target.ID {
+		t.Fatalf("annotation should follow its entry, got story %d", annotation.StoryID)
+	}
+	var revision model.StoryEntryRevision
+	db.Where("entry_id = ?", removed.ID).First(&revision)
+	if revision.StoryID != target.ID {
+		t.Fatalf("revision should follow the story, got story %d", revision.StoryID)
+	}
+	// 从目标剧情的回收站恢复，条目回到目标剧情
+	if resp := performRequest(server.router, http.MethodPost, fmt.Sprintf("/api/v1/stories/%d/history/undo", target.ID), nil, token); resp.Code != http.StatusOK {
+		t.Fatalf("undo delete in target: %d %s", resp.Code, resp.Body.String())
+	}
+	var restored model.StoryEntry
+	if err := db.First(&restored, removed.ID).Error; err != nil || restored.StoryID != target.ID {
+		t.Fatalf("restored entry should land in the target story: %+v err=%v", restored, err)
+	}
+
+	// 源剧情的协作者、分享与统计不再残留
+	for _, row := range []interface{}{&model.StoryCollaborator{}, &model.StoryActivity{}, &model.StoryShareLink{},
+		&model.StoryShareVisit{}, &model.StoryShareReferrer{}} {
+		var count int64
+		db.Model(row).Where("story_id = ?", source.ID).Count(&count)
+		if count != 0 {
+			t.Fatalf("%T rows of the source story should be removed, got %d", row, count)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errSyncConflict 条件更新时发现记录已被其他设备修改
+var errSyncConflict = errors.New("sync conflict")
+
+// 冲突解决策略
+const (
+	conflictKeepMine   = "mine"   // 以客户端数据覆盖服务器
+	conflictKeepTheirs = "theirs" // 保留服务器数据
+	conflictMerged     = "merged" // 写入客户端提交的合并结果
+)
+
+// isStaleBase 判断客户端编辑所基于的版本是否已落后于服务器（未提供基准时不检查）
+func isStaleBase(baseVersion int, baseChecksum string, version int, checksum string) bool {
+	if baseVersion != 0 && baseVersion != version {
+		return true
+	}
+	return baseChecksum != "" && baseChecksum != checksum
+}
+
+// syncRevisionMeta 冲突响应中描述某一方版本的元数据
+func syncRevisionMeta(version int, checksum string, updatedAt time.Time) gin.H {
+	return gin.H{
+		"version":    version,
+		"checksum":   checksum,
+		"updated_at": updatedAt,
+	}
+}
+
+// conflictBaseMeta 描述客户端提交时所基于的版本
+func conflictBaseMeta(baseVersion int, baseChecksum, checksum string) gin.H {
+	return gin.H{
+		"base_version":  baseVersion,
+		"base_checksum": baseChecksum,
+		"checksum":      checksum,
+	}
+}
@@ -234,18 +234,13 @@ type AddStoryTagRequest struct {
 // addStoryTag 为剧情添加标签
 func (s *Server) addStoryTag(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 
-	// 检查剧情所有权
-	var story model.Story
-	if err := database.DB.First(&story, storyID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
-		return
-	}
-	if story.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作"})
+	// 检查剧情权限（作者或编辑）
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
 	var req AddStoryTagRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -280,6 +275,7 @@ func (s *Server) addStoryTag(c *gin.Context) {
 
 	// 更新标签使用次数
 	database.DB.Model(&tag).Update("usage_count", tag.UsageCount+1)
+	recordStoryActivity(database.DB, storyID, userID, storyActionTagAdd, tag.ID, 0)
 
 	c.JSON(http.StatusOK, gin.H{"message": "添加成功"})
 }
@@ -287,19 +283,14 @@ func (s *Server) addStoryTag(c *gin.Context) {
 // removeStoryTag 移除剧情标签
 func (s *Server) removeStoryTag(c *gin.Context) {
 	userID := c.GetUint("userID")
-	storyID, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	tagID, _ := strconv.ParseUint(c.Param("tagId"), 10, 32)
 
-	// 检查剧情所有权
-	var story model.Story
-	if err := database.DB.First(&story, storyID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "剧情不存在"})
-		return
-	}
-	if story.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作"})
+	// 检查剧情权限（作者或编辑）
+	story, _, ok := loadStoryWithRole(c, storyRoleEditor)
+	if !ok {
 		return
 	}
+	storyID := story.ID
 
 	result := database.DB.Where("story_id = ? AND tag_id = ?", storyID, tagID).Delete(&model.StoryTag{})
 	if result.RowsAffected == 0 {
@@ -309,6 +300,7 @@ func (s *Server) removeStoryTag(c *gin.Context) {
 
 	// 更新标签使用次数
 	database.DB.Model(&model.Tag{}).Where("id = ?", tagID).Update("usage_count", database.DB.Raw("usage_count - 1"))
+	recordStoryActivity(database.DB, storyID, userID, storyActionTagRemove, uint(tagID), 0)
 
 	c.JSON(http.StatusOK, gin.H{"message": "移除成功"})
 }
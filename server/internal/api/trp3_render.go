@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/trp3markup"
+)
+
+// wantTRP3Rendered 请求是否需要附带 TRP3 标记的预渲染结果（?render=true）
+func wantTRP3Rendered(c *gin.Context) bool {
+	value := c.Query("render")
+	return value == "true" || value == "1"
+}
+
+// trp3MarkupOptions 渲染选项：图标统一走图标服务
+func (s *Server) trp3MarkupOptions() trp3markup.Options {
+	return trp3markup.Options{
+		IconURL: func(name string) string {
+			iconName, err := normalizeIconName(name)
+			if err != nil {
+				return ""
+			}
+			return buildAPIURL(s.cfg.Server.ApiHost, "/api/v1/icons/"+iconName)
+		},
+	}
+}
+
+// renderCharacterMarkup 渲染角色的 about、其他信息和性格特征，结果按字段名返回（空字段不包含）
+func (s *Server) renderCharacterMarkup(character *model.Character) map[string]trp3markup.Rendered {
+	opts := s.trp3MarkupOptions()
+	rendered := make(map[string]trp3markup.Rendered, 3)
+
+	var about map[string]interface{}
+	if json.Unmarshal([]byte(character.AboutText), &about) == nil {
+		if result := trp3markup.RenderAbout(about, opts); result.HTML != "" {
+			rendered["about"] = result
+		}
+	}
+	var misc interface{}
+	if json.Unmarshal([]byte(character.MiscInfo), &misc) == nil {
+		if result := trp3markup.RenderMiscInfo(misc, opts); result.HTML != "" {
+			rendered["misc_info"] = result
+		}
+	}
+	var psycho interface{}
+	if json.Unmarshal([]byte(character.Psycho), &psycho) == nil {
+		if result := trp3markup.RenderPsycho(psycho, opts); result.HTML != "" {
+			rendered["psycho"] = result
+		}
+	}
+	return rendered
+}
@@ -9,17 +9,18 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Database  DatabaseConfig  `mapstructure:"database"`
-	JWT       JWTConfig       `mapstructure:"jwt"`
-	Storage   StorageConfig   `mapstructure:"storage"`
-	OSS       OSSConfig       `mapstructure:"oss"`
-	Backup    BackupConfig    `mapstructure:"backup"`
-	Updater   UpdaterConfig   `mapstructure:"updater"`
-	Redis     RedisConfig     `mapstructure:"redis"`
-	SMTP      SMTPConfig      `mapstructure:"smtp"`
-	CORS      CORSConfig      `mapstructure:"cors"`
-	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Server        ServerConfig        `mapstructure:"server"`
+	Database      DatabaseConfig      `mapstructure:"database"`
+	JWT           JWTConfig           `mapstructure:"jwt"`
+	Storage       StorageConfig       `mapstructure:"storage"`
+	OSS           OSSConfig           `mapstructure:"oss"`
+	Backup        BackupConfig        `mapstructure:"backup"`
+	AccountBackup AccountBackupConfig `mapstructure:"account_backup"`
+	Updater       UpdaterConfig       `mapstructure:"updater"`
+	Redis         RedisConfig         `mapstructure:"redis"`
+	SMTP          SMTPConfig          `mapstructure:"smtp"`
+	CORS          CORSConfig          `mapstructure:"cors"`
+	RateLimit     RateLimitConfig     `mapstructure:"rate_limit"`
 }
 
 type UpdaterConfig struct {
@@ -76,6 +77,20 @@ type BackupConfig struct {
 	OSS             BackupOSSConfig `mapstructure:"oss"`
 }
 
+// AccountBackupConfig 用户账号备份（TRP3 数据云同步）配置
+type AccountBackupConfig struct {
+	Retention AccountBackupRetentionConfig `mapstructure:"retention"`
+}
+
+// AccountBackupRetentionConfig 历史版本的祖父-父-子保留策略
+type AccountBackupRetentionConfig struct {
+	KeepLast int `mapstructure:"keep_last"` // 始终保留最近的 N 个版本
+	Hourly   int `mapstructure:"hourly"`    // 最近 N 个小时各保留一个
+	Daily    int `mapstructure:"daily"`     // 最近 N 天各保留一个
+	Weekly   int `mapstructure:"weekly"`    // 最近 N 周各保留一个
+	Monthly  int `mapstructure:"monthly"`   // 最近 N 个月各保留一个
+}
+
 type BackupOSSConfig struct {
 	Enabled          bool   `mapstructure:"enabled"`
 	Endpoint         string `mapstructure:"endpoint"`
@@ -90,10 +105,11 @@ type BackupOSSConfig struct {
 }
 
 type ServerConfig struct {
-	Port          string `mapstructure:"port"`
-	Mode          string `mapstructure:"mode"`
-	MaxBodySizeMB int    `mapstructure:"max_body_size_mb"`
-	ApiHost       string `mapstructure:"api_host"` // API 基础 URL，如 https://api.rpbox.app
+	Port                  string `mapstructure:"port"`
+	Mode                  string `mapstructure:"mode"`
+	MaxBodySizeMB         int    `mapstructure:"max_body_size_mb"`
+	MaxDecompressedBodyMB int    `mapstructure:"max_decompressed_body_size_mb"` // 账号备份 gzip/zstd 请求体解压后的大小上限
+	ApiHost               string `mapstructure:"api_host"`                      // API 基础 URL，如 https://api.rpbox.app
 }
 
 type DatabaseConfig struct {
@@ -152,6 +168,7 @@ func Load() (*Config, error) {
 	viper.SetDefault("server.port", "8080")
 	viper.SetDefault("server.mode", "debug")
 	viper.SetDefault("server.max_body_size_mb", 200)
+	viper.SetDefault("server.max_decompressed_body_size_mb", 64)
 	viper.SetDefault("storage.path", "storage") // 改为相对路径，不带 ./
 	viper.SetDefault("database.sslmode", "require")
 	viper.SetDefault("database.sslrootcert", "")
@@ -170,6 +187,11 @@ func Load() (*Config, error) {
 	viper.SetDefault("backup.oss.use_https", true)
 	viper.SetDefault("backup.oss.use_cname", false)
 	viper.SetDefault("backup.oss.prefix", "db-backups")
+	viper.SetDefault("account_backup.retention.keep_last", 10)
+	viper.SetDefault("account_backup.retention.hourly", 24)
+	viper.SetDefault("account_backup.retention.daily", 7)
+	viper.SetDefault("account_backup.retention.weekly", 4)
+	viper.SetDefault("account_backup.retention.monthly", 12)
 	viper.SetDefault("cors.allowed_origins", []string{})
 	viper.SetDefault("cors.dev_origins", []string{})
 	viper.SetDefault("rate_limit.global.rps", 100)
@@ -38,9 +38,24 @@ func Init(cfg *config.DatabaseConfig) error {
 		&model.ProfileVersion{},
 		&model.AccountBackup{},
 		&model.AccountBackupVersion{},
+		&model.BackupBlob{},
+		&model.AccountBackupUpload{},
+		&model.AccountBackupUploadChunk{},
 		&model.Story{},
 		&model.StoryEntry{},
+		&model.StoryEntryRevision{},
+		&model.StoryAnnotation{},
+		&model.StoryChapter{},
+		&model.StoryParticipant{},
+		&model.StoryRedactionRequest{},
+		&model.StoryCollaborator{},
+		&model.StoryActivity{},
+		&model.StoryShareLink{},
+		&model.StoryShareVisit{},
+		&model.StoryShareReferrer{},
 		&model.Character{},
+		&model.CharacterVersion{},
+		&model.CharacterMerge{},
 		&model.Tag{},
 		&model.StoryTag{},
 		&model.Guild{},
@@ -128,6 +143,11 @@ func Init(cfg *config.DatabaseConfig) error {
 		"CREATE INDEX IF NOT EXISTS idx_posts_is_public ON posts(is_public)",
 		// guilds 表限制同一 owner 只能存在一个待审核公会
 		"CREATE UNIQUE INDEX IF NOT EXISTS idx_guilds_owner_pending_unique ON guilds(owner_id) WHERE status = 'pending'",
+		// story_entries 来源ID在剧情内唯一：先清空旧数据中重复的来源ID（保留最早的条目），再建部分唯一索引
+		"UPDATE story_entries SET source_id = '' WHERE source_id <> '' AND EXISTS (SELECT 1 FROM story_entries d WHERE d.story_id = story_entries.story_id AND d.source_id = story_entries.source_id AND d.id < story_entries.id)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_story_entries_source_unique ON story_entries(story_id, source_id) WHERE source_id <> ''",
+		// story_entries 全文检索索引（索引词已在应用层切分，使用 simple 配置）
+		"CREATE INDEX IF NOT EXISTS idx_story_entries_search ON story_entries USING GIN (to_tsvector('simple', COALESCE(search_text, '')))",
 	}
 	for _, sql := range indexMigrations {
 		if err := db.Exec(sql).Error; err != nil {
@@ -179,6 +199,9 @@ END $$;`,
 	// 修复旧预设标签的 category 字段
 	fixPresetTagCategories(db)
 
+	// 为旧剧情条目生成全文检索索引词
+	backfillStoryEntrySearchText(db)
+
 	DB = db
 
 	// 初始化预设标签
@@ -187,6 +210,31 @@ END $$;`,
 	return nil
 }
 
+// backfillStoryEntrySearchText 为新增 search_text 列之前的条目生成索引词（新列对旧数据为 NULL）
+func backfillStoryEntrySearchText(db *gorm.DB) {
+	var entries []model.StoryEntry
+	total := 0
+	result := db.Select("id", "type", "speaker", "content").
+		Where("search_text IS NULL").
+		FindInBatches(&entries, 500, func(tx *gorm.DB, batch int) error {
+			for i := range entries {
+				if err := tx.Model(&model.StoryEntry{}).Where("id = ?", entries[i].ID).
+					Update("search_text", entries[i].SearchDocument()).Error; err != nil {
+					return err
+				}
+			}
+			total += len(entries)
+			return nil
+		})
+	if result.Error != nil {
+		log.Printf("[DB Migration] backfill story entry search text - %v", result.Error)
+		return
+	}
+	if total > 0 {
+		log.Printf("[DB Migration] backfilled search text for %d story entries", total)
+	}
+}
+
 // fixPresetTagCategories 修复旧预设标签的 category 字段
 func fixPresetTagCategories(db *gorm.DB) {
 	// 道具标签名称列表
@@ -38,7 +38,7 @@ func CORS(cfg *config.Config) gin.HandlerFunc {
 			}
 		}
 		if allowed {
-			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Requested-With")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Requested-With, X-Share-Password")
 			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 			c.Header("Access-Control-Max-Age", "86400")
 		}
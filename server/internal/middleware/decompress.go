@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rpbox/server/pkg/codec"
+)
+
+// DecompressBody 按 Content-Encoding 透明解压 gzip/zstd 请求体，解压后的大小受 maxBytes 限制
+func DecompressBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := codec.NormalizeEncoding(c.GetHeader("Content-Encoding"))
+		if encoding == codec.EncodingIdentity || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if !codec.Supported(encoding) {
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+				"error": "Unsupported Content-Encoding",
+			})
+			return
+		}
+
+		reader, err := codec.NewReader(encoding, c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid compressed body",
+			})
+			return
+		}
+
+		c.Request.Body = &decompressedBody{
+			Reader:     http.MaxBytesReader(c.Writer, reader, maxBytes),
+			decoder:    reader,
+			compressed: c.Request.Body,
+		}
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Request.ContentLength = -1
+		c.Next()
+	}
+}
+
+// decompressedBody 关闭时同时释放解压器和原始请求体
+type decompressedBody struct {
+	io.Reader
+	decoder    io.Closer
+	compressed io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	_ = b.decoder.Close()
+	return b.compressed.Close()
+}
@@ -103,3 +103,30 @@ func rateLimitMiddleware(rps float64, burst int, logOnLimit bool) gin.HandlerFun
 		c.Next()
 	}
 }
+
+// KeyedRateLimiter applies a token bucket limiter per arbitrary key, for handlers
+// that need to throttle something narrower than the client IP. Unlike RateLimit it
+// lets the caller clear a key, e.g. after a successful password check.
+type KeyedRateLimiter struct {
+	limiter *ipRateLimiter
+}
+
+// NewKeyedRateLimiter creates a limiter that allows burst attempts per key and
+// refills at rps.
+func NewKeyedRateLimiter(rps float64, burst int) *KeyedRateLimiter {
+	return &KeyedRateLimiter{limiter: newIPRateLimiter(rps, burst, 10*time.Minute)}
+}
+
+// Allow consumes one attempt for key and reports whether it was within the limit.
+// The check and the consumption are a single atomic step, so concurrent callers
+// cannot all pass on the last remaining token.
+func (l *KeyedRateLimiter) Allow(key string) bool {
+	return l.limiter.getLimiter(key).Allow()
+}
+
+// Reset forgets the attempts recorded for key.
+func (l *KeyedRateLimiter) Reset(key string) {
+	l.limiter.mu.Lock()
+	delete(l.limiter.ips, key)
+	l.limiter.mu.Unlock()
+}
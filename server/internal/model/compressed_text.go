@@ -0,0 +1,63 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/rpbox/server/pkg/codec"
+)
+
+// compressedTextPrefix 落库时压缩数据的前缀，没有前缀的旧数据按明文读取
+const compressedTextPrefix = "zstd+base64:"
+
+// compressedTextThreshold 超过该长度的文本才压缩存储
+const compressedTextThreshold = 4 << 10
+
+// CompressedText 落库时自动 zstd 压缩、读取时自动解压的长文本字段
+type CompressedText string
+
+// Value 实现 driver.Valuer：较长的文本压缩后以 base64 写入 text 列
+func (t CompressedText) Value() (driver.Value, error) {
+	if len(t) < compressedTextThreshold {
+		return string(t), nil
+	}
+	compressed := codec.CompressZstd([]byte(t))
+	encoded := compressedTextPrefix + base64.StdEncoding.EncodeToString(compressed)
+	if len(encoded) >= len(t) {
+		return string(t), nil
+	}
+	return encoded, nil
+}
+
+// Scan 实现 sql.Scanner：带压缩前缀的数据解压，其余按明文读取
+func (t *CompressedText) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+		*t = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unsupported CompressedText source %T", value)
+	}
+
+	if !strings.HasPrefix(raw, compressedTextPrefix) {
+		*t = CompressedText(raw)
+		return nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(raw[len(compressedTextPrefix):])
+	if err != nil {
+		return fmt.Errorf("decode compressed text: %w", err)
+	}
+	data, err := codec.DecompressZstd(compressed)
+	if err != nil {
+		return fmt.Errorf("decompress text: %w", err)
+	}
+	*t = CompressedText(data)
+	return nil
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressedTextRoundTrip(t *testing.T) {
+	long := CompressedText(strings.Repeat(`["TRP3_Tools_DB"] = {},`, 1000))
+
+	value, err := long.Value()
+	if err != nil {
+		t.Fatalf("value: %v", err)
+	}
+	stored := value.(string)
+	if !strings.HasPrefix(stored, compressedTextPrefix) || len(stored) >= len(long) {
+		t.Fatalf("expected long text to be stored compressed, got %d bytes", len(stored))
+	}
+
+	var scanned CompressedText
+	if err := scanned.Scan(stored); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if scanned != long {
+		t.Fatal("round trip mismatch")
+	}
+
+	short := CompressedText(`{"a":1}`)
+	if value, _ := short.Value(); value != string(short) {
+		t.Fatalf("expected short text stored as-is, got %v", value)
+	}
+	// 旧的明文数据照常读取
+	if err := scanned.Scan([]byte(`{"legacy":true}`)); err != nil || scanned != `{"legacy":true}` {
+		t.Fatalf("expected legacy plain text, got %q err=%v", scanned, err)
+	}
+	if err := scanned.Scan(compressedTextPrefix + "!!"); err == nil {
+		t.Fatal("expected corrupted data to fail")
+	}
+}
@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"time"
+
+	"github.com/rpbox/server/pkg/trp3markup"
+)
 
 type User struct {
 	ID            uint   `gorm:"primarykey" json:"id"`
@@ -65,6 +69,22 @@ type Profile struct {
 	Version     int       `gorm:"default:1" json:"version"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	Parsed *ProfileCharacteristics `gorm:"-" json:"parsed,omitempty"` // 从 RawLua 解析出的概要
+}
+
+// ProfileCharacteristics 从人物卡原始数据解析出的概要（不入库）
+type ProfileCharacteristics struct {
+	Name      string `json:"name"`       // FN + LN，缺省时为人物卡名
+	FirstName string `json:"first_name"` // FN
+	LastName  string `json:"last_name"`  // LN
+	Title     string `json:"title"`      // TI
+	FullTitle string `json:"full_title"` // FT
+	Race      string `json:"race"`       // RA
+	Class     string `json:"class"`      // CL
+	Icon      string `json:"icon"`       // IC
+	Color     string `json:"color"`      // CH
+	About     string `json:"about"`      // about 模板中的原始文本（含 TRP3 标记）
 }
 
 type ProfileVersion struct {
@@ -77,44 +97,107 @@ type ProfileVersion struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// AccountBackup 账号备份（以账号为单位）
+// AccountBackup 账号备份（以账号为单位，大字段落库时压缩）
 type AccountBackup struct {
-	ID            uint      `gorm:"primarykey" json:"id"`
-	UserID        uint      `gorm:"index;not null" json:"user_id"`
-	AccountID     string    `gorm:"size:32;uniqueIndex:idx_user_account" json:"account_id"`
-	ProfilesData  string    `gorm:"type:text" json:"profiles_data,omitempty"` // JSON: 所有人物卡数据
-	ProfilesCount int       `json:"profiles_count"`
-	ToolsData     string    `gorm:"type:text" json:"tools_data,omitempty"` // JSON: TRP3 Extended 道具数据库
-	ToolsCount    int       `json:"tools_count"`
-	RuntimeData   string    `gorm:"type:text" json:"runtime_data,omitempty"` // JSON: TRP3 运行时数据
-	RuntimeSizeKB int       `json:"runtime_size_kb"`
-	ConfigData    string    `gorm:"type:text" json:"config_data,omitempty"` // JSON: TRP3 配置数据
-	ExtraData     string    `gorm:"type:text" json:"extra_data,omitempty"`  // JSON: TRP3 额外数据(角色绑定、伙伴等)
-	RawTrp3Lua    string    `gorm:"type:text" json:"raw_trp3_lua,omitempty"`
-	RawTrp3Data   string    `gorm:"type:text" json:"raw_trp3_data_lua,omitempty"`
-	RawTrp3Ext    string    `gorm:"type:text" json:"raw_trp3_extended_lua,omitempty"`
-	Checksum      string    `gorm:"type:text" json:"checksum"`
-	Version       int       `gorm:"default:1" json:"version"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            uint           `gorm:"primarykey" json:"id"`
+	UserID        uint           `gorm:"index;not null" json:"user_id"`
+	AccountID     string         `gorm:"size:32;uniqueIndex:idx_user_account" json:"account_id"`
+	ProfilesData  CompressedText `gorm:"type:text" json:"profiles_data,omitempty"` // JSON: 所有人物卡数据
+	ProfilesCount int            `json:"profiles_count"`
+	ToolsData     CompressedText `gorm:"type:text" json:"tools_data,omitempty"` // JSON: TRP3 Extended 道具数据库
+	ToolsCount    int            `json:"tools_count"`
+	RuntimeData   CompressedText `gorm:"type:text" json:"runtime_data,omitempty"` // JSON: TRP3 运行时数据
+	RuntimeSizeKB int            `json:"runtime_size_kb"`
+	ConfigData    CompressedText `gorm:"type:text" json:"config_data,omitempty"` // JSON: TRP3 配置数据
+	ExtraData     CompressedText `gorm:"type:text" json:"extra_data,omitempty"`  // JSON: TRP3 额外数据(角色绑定、伙伴等)
+	RawTrp3Lua    CompressedText `gorm:"type:text" json:"raw_trp3_lua,omitempty"`
+	RawTrp3Data   CompressedText `gorm:"type:text" json:"raw_trp3_data_lua,omitempty"`
+	RawTrp3Ext    CompressedText `gorm:"type:text" json:"raw_trp3_extended_lua,omitempty"`
+	Checksum      string         `gorm:"type:text" json:"checksum"`
+	Version       int            `gorm:"default:1" json:"version"`
+
+	// 加密模式：客户端端到端加密整份备份，服务器只保存密文和解密所需的元数据，上面的明文数据字段为空
+	Encrypted  bool           `gorm:"default:false" json:"encrypted"`
+	KeyID      string         `gorm:"size:128" json:"key_id,omitempty"` // 客户端密钥标识，服务器不持有密钥
+	Cipher     string         `gorm:"size:32" json:"cipher,omitempty"`
+	Nonce      string         `gorm:"size:128" json:"nonce,omitempty"`
+	Ciphertext CompressedText `gorm:"type:text" json:"ciphertext,omitempty"` // base64 密文
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
 }
 
 // AccountBackupVersion 账号备份版本历史
 type AccountBackupVersion struct {
-	ID           uint      `gorm:"primarykey" json:"id"`
-	BackupID     uint      `gorm:"index" json:"backup_id"`
-	Version      int       `json:"version"`
-	ProfilesData string    `gorm:"type:text" json:"profiles_data,omitempty"`
-	ToolsData    string    `gorm:"type:text" json:"tools_data,omitempty"`
-	RuntimeData  string    `gorm:"type:text" json:"runtime_data,omitempty"`
-	ConfigData   string    `gorm:"type:text" json:"config_data,omitempty"`
-	ExtraData    string    `gorm:"type:text" json:"extra_data,omitempty"`
-	RawTrp3Lua   string    `gorm:"type:text" json:"raw_trp3_lua,omitempty"`
-	RawTrp3Data  string    `gorm:"type:text" json:"raw_trp3_data_lua,omitempty"`
-	RawTrp3Ext   string    `gorm:"type:text" json:"raw_trp3_extended_lua,omitempty"`
-	Checksum     string    `gorm:"type:text" json:"checksum"`
-	ChangeLog    string    `gorm:"type:text" json:"change_log"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           uint           `gorm:"primarykey" json:"id"`
+	BackupID     uint           `gorm:"index" json:"backup_id"`
+	Version      int            `json:"version"`
+	ProfilesData CompressedText `gorm:"type:text" json:"profiles_data,omitempty"`
+	ToolsData    CompressedText `gorm:"type:text" json:"tools_data,omitempty"`
+	RuntimeData  CompressedText `gorm:"type:text" json:"runtime_data,omitempty"`
+	ConfigData   CompressedText `gorm:"type:text" json:"config_data,omitempty"`
+	ExtraData    CompressedText `gorm:"type:text" json:"extra_data,omitempty"`
+	RawTrp3Lua   CompressedText `gorm:"type:text" json:"raw_trp3_lua,omitempty"`
+	RawTrp3Data  CompressedText `gorm:"type:text" json:"raw_trp3_data_lua,omitempty"`
+	RawTrp3Ext   CompressedText `gorm:"type:text" json:"raw_trp3_extended_lua,omitempty"`
+	Checksum     string         `gorm:"type:text" json:"checksum"`
+	ChangeLog    string         `gorm:"type:text" json:"change_log"`
+	CreatedAt    time.Time      `gorm:"index" json:"created_at"`
+
+	// 版本生成时的统计信息（加密版本无法由服务器重新统计）
+	ProfilesCount int `json:"profiles_count"`
+	ToolsCount    int `json:"tools_count"`
+	RuntimeSizeKB int `json:"runtime_size_kb"`
+
+	// 加密模式的信封，含义同 AccountBackup
+	Encrypted  bool           `gorm:"default:false" json:"encrypted"`
+	KeyID      string         `gorm:"size:128" json:"key_id,omitempty"`
+	Cipher     string         `gorm:"size:32" json:"cipher,omitempty"`
+	Nonce      string         `gorm:"size:128" json:"nonce,omitempty"`
+	Ciphertext CompressedText `gorm:"type:text" json:"ciphertext,omitempty"`
+
+	// 各数据字段对应的 BackupBlob 哈希；新版本只存引用，上面的数据字段在读取时回填（旧版本仍是内联数据）
+	ProfilesHash    string `gorm:"size:64" json:"profiles_hash,omitempty"`
+	ToolsHash       string `gorm:"size:64" json:"tools_hash,omitempty"`
+	RuntimeHash     string `gorm:"size:64" json:"runtime_hash,omitempty"`
+	ConfigHash      string `gorm:"size:64" json:"config_hash,omitempty"`
+	ExtraHash       string `gorm:"size:64" json:"extra_hash,omitempty"`
+	RawTrp3LuaHash  string `gorm:"size:64" json:"raw_trp3_lua_hash,omitempty"`
+	RawTrp3DataHash string `gorm:"size:64" json:"raw_trp3_data_lua_hash,omitempty"`
+	RawTrp3ExtHash  string `gorm:"size:64" json:"raw_trp3_extended_lua_hash,omitempty"`
+	CiphertextHash  string `gorm:"size:64" json:"ciphertext_hash,omitempty"`
+}
+
+// BackupBlob 账号备份数据块（按内容的 SHA-256 寻址，内容相同的字段在各版本间共享）
+type BackupBlob struct {
+	Hash      string         `gorm:"primarykey;size:64" json:"hash"`
+	Size      int64          `json:"size"`
+	Data      CompressedText `gorm:"type:text" json:"-"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// AccountBackupUpload 账号备份分片上传会话（大备份断点续传）
+type AccountBackupUpload struct {
+	ID              string    `gorm:"primarykey;size:32" json:"upload_id"`
+	UserID          uint      `gorm:"index;not null" json:"user_id"`
+	AccountID       string    `gorm:"size:32" json:"account_id"`
+	TotalSize       int64     `json:"total_size"`
+	ChunkSize       int64     `json:"chunk_size"`
+	TotalChunks     int       `json:"total_chunks"`
+	ContentEncoding string    `gorm:"size:16" json:"content_encoding"` // 拼接后请求体的编码：identity/gzip/zstd
+	SHA256          string    `gorm:"size:64" json:"sha256"`           // 拼接后请求体的 SHA-256，可选
+	ExpiresAt       time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// AccountBackupUploadChunk 分片上传的单个分片
+type AccountBackupUploadChunk struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	UploadID   string    `gorm:"size:32;uniqueIndex:idx_upload_chunk" json:"upload_id"`
+	ChunkIndex int       `gorm:"uniqueIndex:idx_upload_chunk" json:"chunk_index"`
+	Data       []byte    `json:"-"`
+	Size       int       `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 // Story 剧情
@@ -147,19 +230,157 @@ type Story struct {
 
 // StoryEntry 剧情条目
 type StoryEntry struct {
-	ID              uint      `gorm:"primarykey" json:"id"`
-	StoryID         uint      `gorm:"index;not null" json:"story_id"`
-	SourceID        string    `gorm:"size:64" json:"source_id"`             // 来源聊天记录ID
-	Type            string    `gorm:"size:20;default:dialogue" json:"type"` // dialogue, narration, image
-	CharacterID     *uint     `gorm:"index" json:"character_id"`            // 关联角色ID（可空，旁白无角色）
-	Speaker         string    `gorm:"size:128" json:"speaker"`              // 说话者名字快照
-	Content         string    `gorm:"type:text" json:"content"`
-	Channel         string    `gorm:"size:32" json:"channel"`
-	Timestamp       time.Time `json:"timestamp"`
-	SortOrder       int       `gorm:"default:0" json:"sort_order"`
-	BackgroundColor string    `gorm:"size:7" json:"background_color"` // 背景色，如 #FF5733
-	GroupName       string    `gorm:"size:64" json:"group_name"`      // 编组名称
-	CreatedAt       time.Time `json:"created_at"`
+	ID                 uint      `gorm:"primarykey" json:"id"`
+	StoryID            uint      `gorm:"index;not null" json:"story_id"`
+	SourceID           string    `gorm:"size:64;index" json:"source_id"`       // 来源聊天记录ID，同一剧情内唯一（部分唯一索引 idx_story_entries_source_unique）
+	ContentHash        string    `gorm:"size:64;index" json:"-"`               // 写入时的内容指纹，无来源ID时用于去重（编辑条目不改变）
+	Type               string    `gorm:"size:20;default:dialogue" json:"type"` // dialogue, narration, image
+	CharacterID        *uint     `gorm:"index" json:"character_id"`            // 关联角色ID（可空，旁白无角色）
+	CharacterVersionID *uint     `gorm:"index" json:"character_version_id"`    // 条目时间点生效的角色版本（可空，旧数据沿用角色当前信息）
+	ChapterID          *uint     `gorm:"index" json:"chapter_id"`              // 所属章节（可空，未分章）
+	Speaker            string    `gorm:"size:128" json:"speaker"`              // 说话者名字快照
+	Content            string    `gorm:"type:text" json:"content"`
+	Channel            string    `gorm:"size:32" json:"channel"`
+	SearchText         string    `gorm:"type:text" json:"-"` // 全文检索索引词，见 SearchDocument
+	Timestamp          time.Time `json:"timestamp"`
+	SortOrder          int       `gorm:"default:0" json:"sort_order"`
+	BackgroundColor    string    `gorm:"size:7" json:"background_color"` // 背景色，如 #FF5733
+	GroupName          string    `gorm:"size:64" json:"group_name"`      // 编组名称
+	MergeSources       string    `gorm:"type:text" json:"merge_sources"` // 由多份记录合并而来时的来源，JSON数组 [{"story_id","entry_id"}]
+	CreatedBy          uint      `gorm:"index" json:"created_by"`        // 添加条目的用户（0 表示剧情作者，旧数据）
+	UpdatedBy          uint      `json:"updated_by"`                     // 最后修改条目的用户
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// StoryChapter 剧情章节。条目通过 StoryEntry.ChapterID 归属章节，阅读时先按章节顺序、章节内按条目时间排列，未分章的条目排在最后
+type StoryChapter struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	StoryID      uint      `gorm:"index;not null" json:"story_id"`
+	Title        string    `gorm:"size:128;not null" json:"title"`
+	Summary      string    `gorm:"type:text" json:"summary"`
+	SortOrder    int       `gorm:"default:0" json:"sort_order"` // 章节顺序，从 1 开始
+	CreatedBy    uint      `json:"created_by"`
+	EntryCount   int       `gorm:"-" json:"entry_count"`
+	FirstEntryID *uint     `gorm:"-" json:"first_entry_id"` // 章节第一条条目，用于跳转
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// StoryParticipant 剧情公开时按角色 GameID 识别出的 RPBox 用户参与者，每个角色一条，用于通知与隐去请求
+type StoryParticipant struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	StoryID    uint      `gorm:"uniqueIndex:idx_story_participant;not null" json:"story_id"`
+	UserID     uint      `gorm:"uniqueIndex:idx_story_participant;index;not null" json:"user_id"`
+	GameID     string    `gorm:"uniqueIndex:idx_story_participant;size:128;not null" json:"game_id"` // 游戏内ID (角色名-服务器)
+	LineCount  int       `json:"line_count"`                                                         // 识别时该角色的发言条数
+	NotifiedAt time.Time `json:"notified_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// StoryRedactionRequest 参与者对公开剧情中自己角色发言的隐去请求，批准后只作用于公开视图，不修改原始条目
+type StoryRedactionRequest struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	StoryID    uint       `gorm:"uniqueIndex:idx_story_redaction;not null" json:"story_id"`
+	UserID     uint       `gorm:"uniqueIndex:idx_story_redaction;index;not null" json:"user_id"` // 提出请求的参与者
+	GameID     string     `gorm:"uniqueIndex:idx_story_redaction;size:128;not null" json:"game_id"`
+	Mode       string     `gorm:"size:20;not null" json:"mode"` // redact（隐去发言内容）| anonymize（隐去角色身份）
+	Reason     string     `gorm:"size:512" json:"reason"`
+	Status     string     `gorm:"size:20;default:pending;index" json:"status"` // pending | approved | rejected
+	Alias      string     `gorm:"size:64" json:"alias"`                        // 匿名时公开视图显示的名字
+	ReviewNote string     `gorm:"size:512" json:"review_note"`
+	ReviewedBy uint       `json:"reviewed_by"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// StoryEntryRevision 剧情条目的变更历史，保存每次修改或删除前的完整条目，删除的条目可据此恢复。
+// 同一次操作（如批量删除）产生的修订共享 BatchID，可整批撤销
+type StoryEntryRevision struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	StoryID     uint       `gorm:"index;not null" json:"story_id"`
+	EntryID     uint       `gorm:"index;not null" json:"entry_id"`
+	BatchID     string     `gorm:"size:32;index;not null" json:"batch_id"`
+	UserID      uint       `gorm:"index" json:"user_id"`           // 操作者
+	Action      string     `gorm:"size:20;not null" json:"action"` // update, delete, revert（恢复或撤销覆盖前的状态）
+	Fields      string     `gorm:"size:256" json:"fields"`         // 修改的字段，逗号分隔，删除时为空
+	Snapshot    string     `gorm:"type:text" json:"-"`             // 变更前的条目 JSON
+	ContentHash string     `gorm:"size:64" json:"-"`               // 变更前的内容指纹（不在条目 JSON 中），恢复时写回
+	RestoredAt  *time.Time `json:"restored_at"`                    // 已恢复或已撤销的时间
+	RestoredBy  uint       `json:"restored_by"`                    // 执行恢复或撤销的用户
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+}
+
+// StoryAnnotation 剧情条目上的批注（场外备注，如“需要吃书”“设定核对”），回复挂在根批注下
+type StoryAnnotation struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	StoryID    uint      `gorm:"index;not null" json:"story_id"`
+	EntryID    uint      `gorm:"index;not null" json:"entry_id"`
+	ParentID   *uint     `gorm:"index" json:"parent_id"` // 根批注ID，根批注为空
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	Visibility string    `gorm:"size:20;not null" json:"visibility"` // owner（仅剧情作者）, collaborators（作者与协作者）, guild（另含剧情归档公会的成员）；回复沿用根批注
+	Content    string    `gorm:"type:text" json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// StoryCollaborator 剧情协作者
+type StoryCollaborator struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	StoryID   uint      `gorm:"uniqueIndex:idx_story_collaborator;not null" json:"story_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_story_collaborator;index;not null" json:"user_id"`
+	Role      string    `gorm:"size:20;not null" json:"role"` // viewer（只读）, contributor（添加条目、修改自己的条目）, editor（编辑全部内容、标签与发布）
+	InvitedBy uint      `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StoryActivity 剧情变更记录，记录协作剧情中每次修改的操作者
+type StoryActivity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	StoryID   uint      `gorm:"index;not null" json:"story_id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	Action    string    `gorm:"size:32;not null" json:"action"` // entry_add, entry_update, entry_delete, story_update, tag_add, tag_remove, publish, unpublish, collaborator_add, collaborator_update, collaborator_remove, share_link_create, share_link_update, share_link_revoke, entry_restore, chapter_update
+	TargetID  uint      `json:"target_id"`                      // 条目、标签或协作者用户ID，批量操作为 0
+	Count     int       `gorm:"default:0" json:"count"`         // 涉及的条目数
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// StoryShareLink 剧情分享链接。一个剧情可以同时有多个设置不同的链接，剧情取消公开后所有链接暂停访问
+type StoryShareLink struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	StoryID      uint       `gorm:"index;not null" json:"story_id"`
+	CreatedBy    uint       `json:"created_by"`
+	Code         string     `gorm:"size:16;uniqueIndex;not null" json:"code"` // 分享码，重新生成后旧码立即失效
+	Label        string     `gorm:"size:64" json:"label"`                     // 备注，如“论坛帖子”
+	PasswordHash string     `gorm:"size:128" json:"-"`                        // 访问密码（bcrypt），为空表示无需密码
+	HasPassword  bool       `gorm:"-" json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at"`                  // 过期时间，为空表示不过期
+	RevokedAt    *time.Time `json:"revoked_at"`                  // 撤销时间，撤销后链接失效但保留访问统计
+	ViewCount    int        `gorm:"default:0" json:"view_count"` // 浏览次数
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// StoryShareVisit 公开剧情的每日访客。只保存按日轮换盐值计算的访客摘要，不保存 IP 与 UA，
+// 不同日期的摘要无法关联
+type StoryShareVisit struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	StoryID     uint      `gorm:"uniqueIndex:idx_story_share_visit;not null" json:"story_id"`
+	ShareLinkID uint      `gorm:"uniqueIndex:idx_story_share_visit;not null" json:"share_link_id"` // 0 表示剧情默认分享码
+	VisitDate   time.Time `gorm:"type:date;uniqueIndex:idx_story_share_visit;not null" json:"visit_date"`
+	VisitorHash string    `gorm:"size:32;uniqueIndex:idx_story_share_visit;not null" json:"-"`
+	Views       int       `gorm:"default:1" json:"views"` // 当日浏览次数
+}
+
+// StoryShareReferrer 公开剧情的每日来源统计，只保存来源域名，直接访问的域名为空
+type StoryShareReferrer struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	StoryID     uint      `gorm:"uniqueIndex:idx_story_share_referrer;not null" json:"story_id"`
+	ShareLinkID uint      `gorm:"uniqueIndex:idx_story_share_referrer;not null" json:"share_link_id"`
+	VisitDate   time.Time `gorm:"type:date;uniqueIndex:idx_story_share_referrer;not null" json:"visit_date"`
+	Host        string    `gorm:"size:255;uniqueIndex:idx_story_share_referrer" json:"host"`
+	Count       int       `gorm:"default:1" json:"count"`
 }
 
 // StoryBookmark 剧情书签
@@ -173,6 +394,7 @@ type StoryBookmark struct {
 	IsFavorite bool      `gorm:"default:false" json:"is_favorite"` // 是否收藏
 	IsAuto     bool      `gorm:"default:false" json:"is_auto"`     // 是否自动书签
 	IsPublic   bool      `gorm:"default:false" json:"is_public"`   // 是否公共书签（作者/管理员创建，所有人可见）
+	ChapterID  *uint     `gorm:"-" json:"chapter_id"`              // 条目所在章节（查询时填充）
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
@@ -215,6 +437,59 @@ type Character struct {
 
 	// 原始TRP3数据备份
 	RawTRP3Data string `gorm:"type:text" json:"raw_trp3_data"` // 完整原始JSON备份
+
+	// 公开分享
+	IsPublic          bool       `gorm:"default:false" json:"is_public"`      // 是否公开分享
+	ShareCode         string     `gorm:"size:16;index" json:"share_code"`     // 分享码，撤销后清空
+	ShareExpiresAt    *time.Time `json:"share_expires_at"`                    // 分享过期时间，为空表示不过期
+	ShareHiddenFields string     `gorm:"size:512" json:"share_hidden_fields"` // 公开页隐藏的字段，逗号分隔
+
+	// 按请求附带的 TRP3 标记预渲染结果（about/misc_info/psycho），不落库
+	Rendered map[string]trp3markup.Rendered `gorm:"-" json:"rendered,omitempty"`
+}
+
+// CharacterVersion 角色 TRP3 数据的历史版本（导入的 TRP3 数据变化时生成）
+type CharacterVersion struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CharacterID uint      `gorm:"index;not null" json:"character_id"`
+	UserID      uint      `gorm:"index;not null" json:"user_id"`
+	Version     int       `json:"version"`
+	ValidFrom   time.Time `gorm:"index" json:"valid_from"`        // 该版本最早出现的时间（剧情条目时间）
+	DataHash    string    `gorm:"size:64;index" json:"data_hash"` // 原始 TRP3 数据的 SHA-256
+	CreatedAt   time.Time `json:"created_at"`
+
+	// TRP3 字段快照，含义同 Character
+	Race        string `gorm:"size:64" json:"race"`
+	Class       string `gorm:"size:64" json:"class"`
+	FirstName   string `gorm:"size:128" json:"first_name"`
+	LastName    string `gorm:"size:128" json:"last_name"`
+	FullTitle   string `gorm:"size:256" json:"full_title"`
+	Title       string `gorm:"size:128" json:"title"`
+	Icon        string `gorm:"size:128" json:"icon"`
+	Color       string `gorm:"size:8" json:"color"`
+	EyeColor    string `gorm:"size:64" json:"eye_color"`
+	Age         string `gorm:"size:64" json:"age"`
+	Height      string `gorm:"size:64" json:"height"`
+	Residence   string `gorm:"size:256" json:"residence"`
+	Birthplace  string `gorm:"size:256" json:"birthplace"`
+	MiscInfo    string `gorm:"type:text" json:"misc_info"`
+	Psycho      string `gorm:"type:text" json:"psycho"`
+	AboutText   string `gorm:"type:text" json:"about_text"`
+	RawTRP3Data string `gorm:"type:text" json:"raw_trp3_data"`
+}
+
+// CharacterMerge 角色合并记录（用于撤销合并）
+type CharacterMerge struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	UserID       uint       `gorm:"index;not null" json:"user_id"`
+	TargetID     uint       `gorm:"index;not null" json:"target_id"` // 保留的角色
+	SourceIDs    string     `gorm:"size:512" json:"source_ids"`      // 被合并的角色ID，逗号分隔
+	Sources      string     `gorm:"type:text" json:"-"`              // 被合并角色的完整快照 (JSON)
+	EntryMapping string     `gorm:"type:text" json:"-"`              // 原角色ID → 被改挂的剧情条目ID (JSON)
+	TargetBefore string     `gorm:"type:text" json:"-"`              // 合并前目标角色的自定义字段 (JSON)
+	EntryCount   int        `json:"entry_count"`                     // 改挂的剧情条目数
+	UndoneAt     *time.Time `json:"undone_at"`                       // 撤销时间
+	CreatedAt    time.Time  `json:"created_at"`
 }
 
 // Tag 标签
@@ -663,7 +938,7 @@ type DailyMetrics struct {
 type Notification struct {
 	ID         uint      `gorm:"primarykey" json:"id"`
 	UserID     uint      `gorm:"index;not null" json:"user_id"`      // 接收通知的用户ID
-	Type       string    `gorm:"size:20;index;not null" json:"type"` // 通知类型: post_like|post_comment|item_like|item_comment|mention|guild_application|guild_invite|system
+	Type       string    `gorm:"size:20;index;not null" json:"type"` // 通知类型: post_like|post_comment|item_like|item_comment|mention|guild_application|guild_invite|story_collaborator|story_annotation|story_consent|system
 	ActorID    *uint     `gorm:"index" json:"actor_id"`              // 触发通知的用户ID（可空，系统通知无actor）
 	TargetType string    `gorm:"size:20;index" json:"target_type"`   // 目标类型: post|item|comment|item_comment|guild
 	TargetID   uint      `gorm:"index" json:"target_id"`             // 目标ID
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/rpbox/server/pkg/textsearch"
+)
+
+// SearchContent 条目中参与全文检索的文本，图片条目只取图片描述
+func (e *StoryEntry) SearchContent() string {
+	if e.Type != "image" {
+		return e.Content
+	}
+	var image struct {
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(e.Content), &image); err != nil {
+		return ""
+	}
+	return image.Description
+}
+
+// SearchDocument 生成条目的全文检索索引词（说话者与内容）
+func (e *StoryEntry) SearchDocument() string {
+	return textsearch.Document(e.Speaker, e.SearchContent())
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/rpbox/server/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// backupVersionField 备份版本中的一个数据字段及其 blob 哈希
+type backupVersionField struct {
+	data *model.CompressedText
+	hash *string
+}
+
+func backupVersionFields(v *model.AccountBackupVersion) []backupVersionField {
+	return []backupVersionField{
+		{&v.ProfilesData, &v.ProfilesHash},
+		{&v.ToolsData, &v.ToolsHash},
+		{&v.RuntimeData, &v.RuntimeHash},
+		{&v.ConfigData, &v.ConfigHash},
+		{&v.ExtraData, &v.ExtraHash},
+		{&v.RawTrp3Lua, &v.RawTrp3LuaHash},
+		{&v.RawTrp3Data, &v.RawTrp3DataHash},
+		{&v.RawTrp3Ext, &v.RawTrp3ExtHash},
+		{&v.Ciphertext, &v.CiphertextHash},
+	}
+}
+
+// backupVersionHashColumns 版本表中引用 blob 的列
+var backupVersionHashColumns = []string{
+	"profiles_hash", "tools_hash", "runtime_hash", "config_hash",
+	"extra_hash", "raw_trp3_lua_hash", "raw_trp3_data_hash", "raw_trp3_ext_hash",
+	"ciphertext_hash",
+}
+
+// BackupBlobHash 计算数据块的内容哈希
+func BackupBlobHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateBackupVersion 把版本的数据字段写入内容寻址的 blob（已存在的内容不重复存储），版本记录只保存哈希引用。
+// blob 跨用户共享，复用已存在的 blob 时用空更新锁住该行直到事务提交，
+// 避免其他用户的清理事务在版本提交前判定其无引用而删除
+func CreateBackupVersion(tx *gorm.DB, version *model.AccountBackupVersion) error {
+	blobs := make([]model.BackupBlob, 0, 9)
+	seen := make(map[string]struct{})
+	for _, field := range backupVersionFields(version) {
+		if *field.data == "" {
+			*field.hash = ""
+			continue
+		}
+		hash := BackupBlobHash(string(*field.data))
+		*field.hash = hash
+		if _, ok := seen[hash]; !ok {
+			seen[hash] = struct{}{}
+			blobs = append(blobs, model.BackupBlob{Hash: hash, Size: int64(len(*field.data)), Data: *field.data})
+		}
+	}
+
+	if len(blobs) > 0 {
+		// 按哈希顺序加锁，与 DeleteUnreferencedBackupBlobs 一致，避免死锁
+		sort.Slice(blobs, func(i, j int) bool { return blobs[i].Hash < blobs[j].Hash })
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.AssignmentColumns([]string{"hash"}),
+		}).Create(&blobs).Error; err != nil {
+			return err
+		}
+	}
+
+	// 版本记录不再内联数据，写入后恢复内存中的字段供调用方继续使用
+	data := make([]model.CompressedText, 0, 9)
+	for _, field := range backupVersionFields(version) {
+		data = append(data, *field.data)
+		*field.data = ""
+	}
+	err := tx.Create(version).Error
+	for i, field := range backupVersionFields(version) {
+		*field.data = data[i]
+	}
+	return err
+}
+
+// LoadBackupVersionData 按哈希回填版本的数据字段
+func LoadBackupVersionData(db *gorm.DB, versions []model.AccountBackupVersion) error {
+	hashes := make([]string, 0)
+	seen := make(map[string]struct{})
+	for i := range versions {
+		for _, field := range backupVersionFields(&versions[i]) {
+			if *field.hash == "" {
+				continue
+			}
+			if _, ok := seen[*field.hash]; !ok {
+				seen[*field.hash] = struct{}{}
+				hashes = append(hashes, *field.hash)
+			}
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var blobs []model.BackupBlob
+	if err := db.Where("hash IN ?", hashes).Find(&blobs).Error; err != nil {
+		return err
+	}
+	byHash := make(map[string]model.CompressedText, len(blobs))
+	for _, blob := range blobs {
+		byHash[blob.Hash] = blob.Data
+	}
+	for i := range versions {
+		for _, field := range backupVersionFields(&versions[i]) {
+			if *field.hash != "" {
+				*field.data = byHash[*field.hash]
+			}
+		}
+	}
+	return nil
+}
+
+// BackupVersionHashes 返回版本引用的全部 blob 哈希
+func BackupVersionHashes(versions []model.AccountBackupVersion) []string {
+	hashes := make([]string, 0)
+	for i := range versions {
+		for _, field := range backupVersionFields(&versions[i]) {
+			if *field.hash != "" {
+				hashes = append(hashes, *field.hash)
+			}
+		}
+	}
+	return hashes
+}
+
+// LoadBackupVersionHashes 查询备份全部历史版本引用的 blob 哈希
+func LoadBackupVersionHashes(tx *gorm.DB, backupIDs []uint) ([]string, error) {
+	var versions []model.AccountBackupVersion
+	if err := tx.Select(backupVersionHashColumns).Where("backup_id IN ?", backupIDs).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return BackupVersionHashes(versions), nil
+}
+
+// DeleteUnreferencedBackupBlobs 删除给定哈希中已不被任何版本引用的 blob。
+// 先锁住候选 blob 再检查引用：正在复用这些 blob 的事务提交后才能拿到锁，届时能看到新版本的引用
+func DeleteUnreferencedBackupBlobs(tx *gorm.DB, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	candidates := make(map[string]struct{}, len(hashes))
+	list := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := candidates[hash]; !ok {
+			candidates[hash] = struct{}{}
+			list = append(list, hash)
+		}
+	}
+
+	sort.Strings(list)
+	var locked []string
+	if err := tx.Model(&model.BackupBlob{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("hash IN ?", list).Order("hash").Pluck("hash", &locked).Error; err != nil {
+		return err
+	}
+
+	for _, column := range backupVersionHashColumns {
+		var referenced []string
+		if err := tx.Model(&model.AccountBackupVersion{}).
+			Where(column+" IN ?", list).
+			Distinct().
+			Pluck(column, &referenced).Error; err != nil {
+			return err
+		}
+		for _, hash := range referenced {
+			delete(candidates, hash)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	orphans := make([]string, 0, len(candidates))
+	for hash := range candidates {
+		orphans = append(orphans, hash)
+	}
+	return tx.Where("hash IN ?", orphans).Delete(&model.BackupBlob{}).Error
+}
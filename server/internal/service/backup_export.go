@@ -0,0 +1,174 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rpbox/server/pkg/luatable"
+)
+
+// TRP3 SavedVariables 文件名
+const (
+	TRP3MainFile     = "totalRP3.lua"
+	TRP3DataFile     = "totalRP3_Data.lua"
+	TRP3ExtendedFile = "totalRP3_Extended.lua"
+)
+
+// extra_data 中各变量所属的文件（与桌面端写回时的划分一致）
+var (
+	trp3ExtraMainVars     = []string{"TRP3_Characters", "TRP3_Companions", "TRP3_Presets", "TRP3_Notes", "TRP3_Flyway", "TRP3_MatureFilter", "TRP3_Colors", "TRP3_SavedAutomation"}
+	trp3ExtraExtendedVars = []string{"TRP3_Exchange_DB", "TRP3_Stashes", "TRP3_Drop", "TRP3_Security", "TRP3_Extended_Flyway"}
+)
+
+// BackupContent 账号备份（或其历史版本）的数据字段
+type BackupContent struct {
+	ProfilesData string
+	ToolsData    string
+	RuntimeData  string
+	ConfigData   string
+	ExtraData    string
+	RawTrp3Lua   string
+	RawTrp3Data  string
+	RawTrp3Ext   string
+}
+
+// BackupStats 按桌面端扫描时的口径推算人物卡数、道具数和运行时数据大小
+func BackupStats(content BackupContent) (profilesCount, toolsCount, runtimeSizeKB int) {
+	runtime := content.RawTrp3Data
+	if runtime == "" {
+		runtime = content.RuntimeData
+	}
+	return jsonObjectLen(content.ProfilesData), jsonObjectLen(content.ToolsData), len(runtime) / 1024
+}
+
+// BuildSavedVariables 由备份内容重建 TRP3 的 SavedVariables 文件，返回 文件名 → 内容。
+// 上传时附带的原始文件优先，没有原始文件时由解析后的 JSON 数据重新生成；没有任何数据的文件不会输出。
+func BuildSavedVariables(content BackupContent) (map[string]string, error) {
+	extra, err := decodeJSONObject(content.ExtraData, "extra_data")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, 3)
+
+	if strings.TrimSpace(content.RawTrp3Lua) != "" {
+		files[TRP3MainFile] = content.RawTrp3Lua
+	} else {
+		vars := make(map[string]interface{})
+		if err := addJSONVariable(vars, "TRP3_Profiles", content.ProfilesData, "profiles_data"); err != nil {
+			return nil, err
+		}
+		if err := addJSONVariable(vars, "TRP3_Configuration", content.ConfigData, "config_data"); err != nil {
+			return nil, err
+		}
+		copyVariables(vars, extra, trp3ExtraMainVars)
+		if err := encodeSavedVariables(files, TRP3MainFile, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.TrimSpace(content.RawTrp3Data) != "" {
+		files[TRP3DataFile] = content.RawTrp3Data
+	} else {
+		vars := make(map[string]interface{})
+		if err := addJSONVariable(vars, "TRP3_Register", content.RuntimeData, "runtime_data"); err != nil {
+			return nil, err
+		}
+		if err := encodeSavedVariables(files, TRP3DataFile, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.TrimSpace(content.RawTrp3Ext) != "" {
+		files[TRP3ExtendedFile] = content.RawTrp3Ext
+	} else {
+		vars := make(map[string]interface{})
+		if err := addJSONVariable(vars, "TRP3_Tools_DB", content.ToolsData, "tools_data"); err != nil {
+			return nil, err
+		}
+		copyVariables(vars, extra, trp3ExtraExtendedVars)
+		if err := encodeSavedVariables(files, TRP3ExtendedFile, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// WriteSavedVariablesZip 把文件按文件名排序写入 zip
+func WriteSavedVariablesZip(w io.Writer, files map[string]string) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func encodeSavedVariables(files map[string]string, name string, vars map[string]interface{}) error {
+	if len(vars) == 0 {
+		return nil
+	}
+	encoded, err := luatable.EncodeFile(vars)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", name, err)
+	}
+	files[name] = encoded
+	return nil
+}
+
+func addJSONVariable(vars map[string]interface{}, name, raw, field string) error {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("decode %s: %w", field, err)
+	}
+	if value != nil {
+		vars[name] = value
+	}
+	return nil
+}
+
+func copyVariables(vars, extra map[string]interface{}, names []string) {
+	for _, name := range names {
+		if value, ok := extra[name]; ok && value != nil {
+			vars[name] = value
+		}
+	}
+}
+
+func decodeJSONObject(raw, field string) (map[string]interface{}, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", field, err)
+	}
+	return value, nil
+}
+
+func jsonObjectLen(raw string) int {
+	value, err := decodeJSONObject(raw, "")
+	if err != nil {
+		return 0
+	}
+	return len(value)
+}
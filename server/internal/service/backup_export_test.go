@@ -0,0 +1,104 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rpbox/server/pkg/luatable"
+)
+
+func TestBuildSavedVariablesFromJSON(t *testing.T) {
+	files, err := BuildSavedVariables(BackupContent{
+		ProfilesData: `{"profile-1":{"profileName":"Aldric"}}`,
+		ConfigData:   `{"AddonLocale":"zhCN"}`,
+		ToolsData:    `{"tool-1":{"TY":"IT"}}`,
+		ExtraData:    `{"TRP3_Characters":{"Aldric-Realm":{"profileID":"profile-1"}},"TRP3_Stashes":{"x":1}}`,
+	})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, ok := files[TRP3DataFile]; ok {
+		t.Fatalf("empty runtime data should not produce %s", TRP3DataFile)
+	}
+
+	main, err := luatable.ParseFile(files[TRP3MainFile])
+	if err != nil {
+		t.Fatalf("parse main file: %v", err)
+	}
+	for _, name := range []string{"TRP3_Profiles", "TRP3_Configuration", "TRP3_Characters"} {
+		if _, ok := main[name]; !ok {
+			t.Fatalf("main file missing %s: %v", name, main)
+		}
+	}
+	if _, ok := main["TRP3_Stashes"]; ok {
+		t.Fatalf("extended variable leaked into main file")
+	}
+
+	ext, err := luatable.ParseFile(files[TRP3ExtendedFile])
+	if err != nil {
+		t.Fatalf("parse extended file: %v", err)
+	}
+	if _, ok := ext["TRP3_Tools_DB"]; !ok {
+		t.Fatalf("extended file missing TRP3_Tools_DB")
+	}
+	if _, ok := ext["TRP3_Stashes"]; !ok {
+		t.Fatalf("extended file missing TRP3_Stashes")
+	}
+}
+
+func TestBuildSavedVariablesPrefersRawFiles(t *testing.T) {
+	files, err := BuildSavedVariables(BackupContent{
+		ProfilesData: `{"profile-1":{}}`,
+		RawTrp3Lua:   "TRP3_Profiles = {}\n",
+		RuntimeData:  `{"characters":{}}`,
+		RawTrp3Data:  "TRP3_Register = {}\n",
+	})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if files[TRP3MainFile] != "TRP3_Profiles = {}\n" || files[TRP3DataFile] != "TRP3_Register = {}\n" {
+		t.Fatalf("raw files not used: %v", files)
+	}
+}
+
+func TestBuildSavedVariablesRejectsInvalidJSON(t *testing.T) {
+	if _, err := BuildSavedVariables(BackupContent{ProfilesData: "{"}); err == nil {
+		t.Fatal("expected error for invalid profiles_data")
+	}
+}
+
+func TestWriteSavedVariablesZip(t *testing.T) {
+	var buf bytes.Buffer
+	files := map[string]string{TRP3DataFile: "b", TRP3MainFile: "a"}
+	if err := WriteSavedVariablesZip(&buf, files); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != TRP3MainFile {
+		t.Fatalf("unexpected zip entries: %v", zr.File)
+	}
+	rc, _ := zr.File[0].Open()
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "a" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
+
+func TestBackupStats(t *testing.T) {
+	profiles, tools, runtimeKB := BackupStats(BackupContent{
+		ProfilesData: `{"a":{},"b":{}}`,
+		ToolsData:    `{"t":{}}`,
+		RuntimeData:  strings.Repeat("x", 3000),
+	})
+	if profiles != 2 || tools != 1 || runtimeKB != 2 {
+		t.Fatalf("unexpected stats: %d %d %d", profiles, tools, runtimeKB)
+	}
+}
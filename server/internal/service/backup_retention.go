@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"gorm.io/gorm"
+)
+
+// BackupRetentionPolicy 账号备份历史版本的祖父-父-子保留策略。
+// Hourly/Daily/Weekly/Monthly 表示：在最近 N 个含有版本的小时/天/周/月中，每个时间段保留最新的一个版本。
+type BackupRetentionPolicy struct {
+	KeepLast int // 不论时间，始终保留最近的 N 个版本
+	Hourly   int
+	Daily    int
+	Weekly   int
+	Monthly  int
+}
+
+// DefaultBackupRetention 未配置时使用的保留策略
+var DefaultBackupRetention = BackupRetentionPolicy{
+	KeepLast: 10,
+	Hourly:   24,
+	Daily:    7,
+	Weekly:   4,
+	Monthly:  12,
+}
+
+// IsZero 策略是否完全未配置
+func (p BackupRetentionPolicy) IsZero() bool {
+	return p == BackupRetentionPolicy{}
+}
+
+// SelectRetainedBackupVersions 按策略挑选需要保留的版本 ID，versions 需按时间从新到旧排列
+func SelectRetainedBackupVersions(versions []model.AccountBackupVersion, policy BackupRetentionPolicy) map[uint]bool {
+	keep := make(map[uint]bool)
+	for i := 0; i < len(versions) && i < policy.KeepLast; i++ {
+		keep[versions[i].ID] = true
+	}
+
+	buckets := []struct {
+		limit int
+		key   func(time.Time) string
+	}{
+		{policy.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{policy.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{policy.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, bucket := range buckets {
+		if bucket.limit <= 0 {
+			continue
+		}
+		seen := make(map[string]struct{})
+		for _, v := range versions {
+			key := bucket.key(v.CreatedAt.UTC())
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			if len(seen) >= bucket.limit {
+				break
+			}
+			// 每个时间段内第一个遇到的即最新的版本
+			seen[key] = struct{}{}
+			keep[v.ID] = true
+		}
+	}
+	return keep
+}
+
+// ApplyBackupRetention 按保留策略清理备份的历史版本，并回收不再被引用的数据块
+func ApplyBackupRetention(backupID uint, policy BackupRetentionPolicy) error {
+	if policy.IsZero() {
+		policy = DefaultBackupRetention
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var versions []model.AccountBackupVersion
+		if err := tx.Select(append([]string{"id", "version", "created_at"}, backupVersionHashColumns...)).
+			Where("backup_id = ?", backupID).
+			Order("created_at DESC, version DESC").
+			Find(&versions).Error; err != nil {
+			return err
+		}
+
+		keep := SelectRetainedBackupVersions(versions, policy)
+		var expired []model.AccountBackupVersion
+		for _, v := range versions {
+			if !keep[v.ID] {
+				expired = append(expired, v)
+			}
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(expired))
+		for _, v := range expired {
+			ids = append(ids, v.ID)
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&model.AccountBackupVersion{}).Error; err != nil {
+			return err
+		}
+		return DeleteUnreferencedBackupBlobs(tx, BackupVersionHashes(expired))
+	})
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/database"
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/internal/testutil"
+)
+
+func TestSelectRetainedBackupVersionsGFS(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 30, 0, 0, time.UTC)
+	var versions []model.AccountBackupVersion
+	// 最近 3 小时每 20 分钟一个版本，随后 60 天每天两个版本，按时间从新到旧
+	id := uint(1)
+	for i := 0; i < 9; i++ {
+		versions = append(versions, model.AccountBackupVersion{ID: id, CreatedAt: now.Add(-time.Duration(i) * 20 * time.Minute)})
+		id++
+	}
+	for day := 1; day <= 60; day++ {
+		for _, hour := range []int{20, 8} {
+			created := time.Date(2026, 3, 15-day, hour, 0, 0, 0, time.UTC)
+			versions = append(versions, model.AccountBackupVersion{ID: id, CreatedAt: created})
+			id++
+		}
+	}
+
+	keep := SelectRetainedBackupVersions(versions, BackupRetentionPolicy{KeepLast: 2, Hourly: 3, Daily: 5, Weekly: 3, Monthly: 3})
+
+	expect := func(id uint, want bool) {
+		t.Helper()
+		if keep[id] != want {
+			t.Fatalf("version %d: expected keep=%v", id, want)
+		}
+	}
+	// keep_last
+	expect(1, true)
+	expect(2, true)
+	// 11:50 是上一小时最新的版本，同一小时内更早的 11:30 不保留
+	expect(3, true)
+	expect(4, false)
+	// 每天保留最新的（20 点）版本，较早的 8 点版本不保留
+	expect(10, true)
+	expect(11, false)
+	// 60 天前的版本超出所有时间段
+	expect(id-1, false)
+
+	if len(keep) >= len(versions)/2 {
+		t.Fatalf("expected most versions pruned, kept %d of %d", len(keep), len(versions))
+	}
+}
+
+func TestBackupVersionsShareBlobsAndRetentionCollectsGarbage(t *testing.T) {
+	db := testutil.NewTestDB(t, &model.AccountBackupVersion{}, &model.BackupBlob{})
+	database.DB = db
+
+	tools := model.CompressedText("shared tools database")
+	for i, config := range []string{"config-a", "config-b", "config-c"} {
+		version := model.AccountBackupVersion{
+			BackupID:   1,
+			Version:    i + 1,
+			ToolsData:  tools,
+			ConfigData: model.CompressedText(config),
+			CreatedAt:  time.Now().Add(time.Duration(i) * time.Minute),
+		}
+		if err := CreateBackupVersion(db, &version); err != nil {
+			t.Fatalf("create version: %v", err)
+		}
+		if version.ToolsData != tools {
+			t.Fatalf("expected in-memory data to be kept after create")
+		}
+	}
+
+	var blobCount int64
+	db.Model(&model.BackupBlob{}).Count(&blobCount)
+	if blobCount != 4 {
+		t.Fatalf("expected unchanged tools data to be stored once (4 blobs), got %d", blobCount)
+	}
+
+	var inline string
+	db.Raw("SELECT tools_data FROM account_backup_versions WHERE version = 1").Scan(&inline)
+	if inline != "" {
+		t.Fatalf("expected version rows to hold only hashes, got %q", inline)
+	}
+
+	var versions []model.AccountBackupVersion
+	db.Order("version ASC").Find(&versions)
+	if err := LoadBackupVersionData(db, versions); err != nil {
+		t.Fatalf("load data: %v", err)
+	}
+	if versions[0].ToolsData != tools || versions[2].ConfigData != "config-c" {
+		t.Fatalf("expected data to be hydrated from blobs, got %+v", versions[0])
+	}
+
+	if err := ApplyBackupRetention(1, BackupRetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("apply retention: %v", err)
+	}
+	var remaining int64
+	db.Model(&model.AccountBackupVersion{}).Count(&remaining)
+	db.Model(&model.BackupBlob{}).Count(&blobCount)
+	if remaining != 1 || blobCount != 2 {
+		t.Fatalf("expected 1 version and its 2 blobs to remain, got versions=%d blobs=%d", remaining, blobCount)
+	}
+}
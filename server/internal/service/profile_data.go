@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rpbox/server/internal/model"
+	"github.com/rpbox/server/pkg/luatable"
+)
+
+// ErrInvalidProfileData 人物卡原始数据无法解析
+var ErrInvalidProfileData = errors.New("invalid profile data")
+
+type profileFormat int
+
+const (
+	profileFormatJSON           profileFormat = iota // 桌面端上传的 JSON
+	profileFormatLuaTable                            // 单个 Lua 表字面量
+	profileFormatSavedVariables                      // 完整的 totalRP3.lua
+)
+
+// ProfileDocument 解析后的人物卡原始数据，记录原始格式以便修改后写回
+type ProfileDocument struct {
+	Profile map[string]interface{}
+
+	format profileFormat
+	key    string                 // 人物卡在 TRP3_Profiles 中的键
+	vars   map[string]interface{} // SavedVariables 的全部顶层变量
+}
+
+// ParseProfileDocument 解析人物卡原始数据。
+// 支持三种输入：桌面端上传的 JSON、单个 Lua 表字面量、完整的 totalRP3.lua SavedVariables。
+// raw 为空时返回 nil, nil。
+func ParseProfileDocument(raw, profileID string) (*ProfileDocument, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return nil, nil
+	}
+
+	doc := &ProfileDocument{}
+	var value interface{}
+	if strings.HasPrefix(trimmed, "{") {
+		if json.Valid([]byte(trimmed)) {
+			if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidProfileData, err)
+			}
+			doc.format = profileFormatJSON
+		} else {
+			parsed, err := luatable.ParseValue(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidProfileData, err)
+			}
+			value = parsed
+			doc.format = profileFormatLuaTable
+		}
+	} else {
+		vars, err := luatable.ParseFile(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProfileData, err)
+		}
+		profiles, ok := vars["TRP3_Profiles"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: TRP3_Profiles not found", ErrInvalidProfileData)
+		}
+		doc.key = profileID
+		value, ok = profiles[profileID]
+		if !ok {
+			if len(profiles) != 1 {
+				return nil, fmt.Errorf("%w: profile %q not found in TRP3_Profiles", ErrInvalidProfileData, profileID)
+			}
+			for key, only := range profiles {
+				doc.key = key
+				value = only
+			}
+		}
+		doc.format = profileFormatSavedVariables
+		doc.vars = vars
+	}
+
+	profile, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: profile must be a table", ErrInvalidProfileData)
+	}
+	doc.Profile = profile
+	return doc, nil
+}
+
+// Encode 按原始格式重新编码人物卡数据
+func (d *ProfileDocument) Encode() (string, error) {
+	switch d.format {
+	case profileFormatLuaTable:
+		return luatable.Encode(d.Profile)
+	case profileFormatSavedVariables:
+		profiles, _ := d.vars["TRP3_Profiles"].(map[string]interface{})
+		profiles[d.key] = d.Profile
+		return luatable.EncodeFile(d.vars)
+	}
+	data, err := json.Marshal(d.Profile)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ParseProfileData 解析人物卡原始数据，返回 TRP3 profile 表（raw 为空时返回 nil, nil）
+func ParseProfileData(raw, profileID string) (map[string]interface{}, error) {
+	doc, err := ParseProfileDocument(raw, profileID)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return doc.Profile, nil
+}
+
+// ProfileChecksum 计算人物卡数据的校验和（键排序后的 JSON 的 MD5，与桌面端 normalize_json 的算法保持一致）
+func ProfileChecksum(profile map[string]interface{}) string {
+	var sb strings.Builder
+	writeNormalizedJSON(&sb, profile)
+	sum := md5.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeNormalizedJSON(sb *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sb.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(`"` + k + `":`)
+			writeNormalizedJSON(sb, v[k])
+		}
+		sb.WriteByte('}')
+	case []interface{}:
+		sb.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeNormalizedJSON(sb, item)
+		}
+		sb.WriteByte(']')
+	case float64:
+		// 桌面端把 Lua 数字按浮点数序列化，整数也带 .0
+		text := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		sb.WriteString(text)
+	default:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err == nil {
+			sb.WriteString(strings.TrimSuffix(buf.String(), "\n"))
+		}
+	}
+}
+
+// ParseProfileCharacteristics 解析人物卡原始数据并提取概要
+func ParseProfileCharacteristics(raw, profileID string) (*model.ProfileCharacteristics, error) {
+	profile, err := ParseProfileData(raw, profileID)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return ProfileCharacteristicsFromData(profile), nil
+}
+
+// ProfileCharacteristicsFromData 从 TRP3 profile 表中提取名字、种族、职业、简介等概要
+func ProfileCharacteristicsFromData(profile map[string]interface{}) *model.ProfileCharacteristics {
+	player := tableValue(profile, "player")
+	if player == nil {
+		// 兼容直接上传 player 部分的情况
+		player = profile
+	}
+	characteristics := tableValue(player, "characteristics")
+
+	result := &model.ProfileCharacteristics{
+		FirstName: stringValue(characteristics, "FN"),
+		LastName:  stringValue(characteristics, "LN"),
+		Title:     stringValue(characteristics, "TI"),
+		FullTitle: stringValue(characteristics, "FT"),
+		Race:      stringValue(characteristics, "RA"),
+		Class:     stringValue(characteristics, "CL"),
+		Icon:      stringValue(characteristics, "IC"),
+		Color:     stringValue(characteristics, "CH"),
+		About:     AboutText(tableValue(player, "about")),
+	}
+	result.Name = strings.TrimSpace(result.FirstName + " " + result.LastName)
+	if result.Name == "" {
+		result.Name = stringValue(profile, "profileName")
+	}
+	return result
+}
+
+// AboutText 按 about 模板（TE=1/2/3）拼接原始文本
+func AboutText(about map[string]interface{}) string {
+	if about == nil {
+		return ""
+	}
+
+	var parts []string
+	switch numberValue(about, "TE") {
+	case 2:
+		if frames, ok := about["T2"].([]interface{}); ok {
+			for _, frame := range frames {
+				if m, ok := frame.(map[string]interface{}); ok {
+					parts = append(parts, stringValue(m, "TX"))
+				}
+			}
+		}
+	case 3:
+		t3 := tableValue(about, "T3")
+		for _, key := range []string{"PH", "PS", "HI"} {
+			parts = append(parts, stringValue(tableValue(t3, key), "TX"))
+		}
+	default:
+		parts = append(parts, stringValue(tableValue(about, "T1"), "TX"))
+	}
+
+	nonEmpty := parts[:0]
+	for _, part := range parts {
+		if strings.TrimSpace(part) != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, "\n\n")
+}
+
+func tableValue(m map[string]interface{}, key string) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	v, _ := m[key].(map[string]interface{})
+	return v
+}
+
+func stringValue(m map[string]interface{}, key string) string {
+	if m == nil {
+		return ""
+	}
+	v, _ := m[key].(string)
+	return v
+}
+
+func numberValue(m map[string]interface{}, key string) float64 {
+	if m == nil {
+		return 0
+	}
+	v, _ := m[key].(float64)
+	return v
+}
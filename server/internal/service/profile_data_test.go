@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProfileCharacteristicsFromSavedVariables(t *testing.T) {
+	raw := `TRP3_Profiles = {
+	["profile-1"] = {
+		["profileName"] = "Aldric",
+		["player"] = {
+			["characteristics"] = {
+				["FN"] = "Aldric",
+				["LN"] = "Voss",
+				["RA"] = "Human",
+				["CL"] = "Paladin",
+				["IC"] = "inv_misc_head_human_01",
+			},
+			["about"] = {
+				["TE"] = 3,
+				["T3"] = {
+					["PH"] = { ["TX"] = "Tall and scarred." },
+					["HI"] = { ["TX"] = "Born in Lordaeron." },
+				},
+			},
+		},
+	},
+}`
+
+	parsed, err := ParseProfileCharacteristics(raw, "profile-1")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed.Name != "Aldric Voss" || parsed.Race != "Human" || parsed.Class != "Paladin" {
+		t.Fatalf("unexpected characteristics: %+v", parsed)
+	}
+	if parsed.About != "Tall and scarred.\n\nBorn in Lordaeron." {
+		t.Fatalf("unexpected about text %q", parsed.About)
+	}
+}
+
+func TestParseProfileCharacteristicsFromJSON(t *testing.T) {
+	raw := `{"profileName":"Fallback","player":{"characteristics":{"RA":"Orc"},"about":{"TE":1,"T1":{"TX":"Lok'tar"}}}}`
+
+	parsed, err := ParseProfileCharacteristics(raw, "ignored")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed.Name != "Fallback" || parsed.Race != "Orc" || parsed.About != "Lok'tar" {
+		t.Fatalf("unexpected characteristics: %+v", parsed)
+	}
+}
+
+func TestParseProfileDataRejectsMalformedInput(t *testing.T) {
+	cases := map[string]string{
+		"broken lua":       `{ ["player"] = { ["characteristics"] = }`,
+		"not a table":      `TRP3_Profiles = "oops"`,
+		"missing variable": `TRP3_Configuration = {}`,
+		"unknown profile":  `TRP3_Profiles = { a = {}, b = {} }`,
+	}
+	for name, raw := range cases {
+		if _, err := ParseProfileData(raw, "profile-1"); !errors.Is(err, ErrInvalidProfileData) {
+			t.Fatalf("%s: expected ErrInvalidProfileData, got %v", name, err)
+		}
+	}
+
+	profile, err := ParseProfileData("   ", "profile-1")
+	if err != nil || profile != nil {
+		t.Fatalf("expected empty input to be accepted, got %v, %v", profile, err)
+	}
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProfileFieldChange 两个人物卡版本之间的单项差异
+type ProfileFieldChange struct {
+	Path  string      `json:"path"`  // 发生变化的完整路径，如 player.characteristics.EC
+	Field string      `json:"field"` // 所属字段（部分回滚的最小单位）
+	Label string      `json:"label"` // 字段的可读名称
+	Type  string      `json:"type"`  // added|removed|modified
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// profileFieldLabels TRP3 人物卡字段的可读名称
+var profileFieldLabels = map[string]string{
+	"profileName":               "人物卡名称",
+	"player.characteristics.FN": "名字",
+	"player.characteristics.LN": "姓氏",
+	"player.characteristics.TI": "称号",
+	"player.characteristics.FT": "全称",
+	"player.characteristics.RA": "种族",
+	"player.characteristics.CL": "职业",
+	"player.characteristics.IC": "图标",
+	"player.characteristics.CH": "名字颜色",
+	"player.characteristics.EC": "眼睛颜色",
+	"player.characteristics.EH": "眼睛颜色色值",
+	"player.characteristics.AG": "年龄",
+	"player.characteristics.HE": "身高",
+	"player.characteristics.WE": "体型",
+	"player.characteristics.RE": "住所",
+	"player.characteristics.BP": "出生地",
+	"player.characteristics.MI": "其他信息",
+	"player.characteristics.PS": "性格特征",
+	"player.characteristics.RS": "关系状态",
+	"player.characteristics.VO": "声音",
+	"player.about":              "关于",
+	"player.character.CU":       "当前状态",
+	"player.character.CO":       "OOC 信息",
+	"player.character.RP":       "角色扮演状态",
+	"player.character.XP":       "经验等级",
+	"player.misc.PE":            "第一印象",
+	"player.misc.ST":            "RP 风格",
+	"player.characteristics.PR": "代词",
+}
+
+// ProfileFieldOf 返回路径所属的字段：about 整体作为一个字段，其余取到具体属性一级
+func ProfileFieldOf(path []string) []string {
+	if len(path) >= 2 && path[0] == "player" && path[1] == "about" {
+		return path[:2]
+	}
+	if len(path) >= 3 && path[0] == "player" {
+		return path[:3]
+	}
+	if len(path) >= 2 {
+		return path[:2]
+	}
+	return path
+}
+
+// ProfileFieldLabel 返回字段路径的可读名称，未知字段返回路径本身
+func ProfileFieldLabel(field string) string {
+	if label, ok := profileFieldLabels[field]; ok {
+		return label
+	}
+	return field
+}
+
+// DiffProfileData 结构化比较两个人物卡数据，返回按路径排序的差异列表
+func DiffProfileData(from, to map[string]interface{}) []ProfileFieldChange {
+	changes := make([]ProfileFieldChange, 0)
+	diffValues(nil, from, to, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// SummarizeProfileChanges 汇总差异涉及的字段名称（去重，保持顺序）
+func SummarizeProfileChanges(changes []ProfileFieldChange) []string {
+	labels := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, change := range changes {
+		if _, ok := seen[change.Field]; ok {
+			continue
+		}
+		seen[change.Field] = struct{}{}
+		labels = append(labels, change.Label)
+	}
+	return labels
+}
+
+func diffValues(path []string, from, to interface{}, changes *[]ProfileFieldChange) {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		keys := make(map[string]struct{}, len(fromMap)+len(toMap))
+		for k := range fromMap {
+			keys[k] = struct{}{}
+		}
+		for k := range toMap {
+			keys[k] = struct{}{}
+		}
+		for k := range keys {
+			diffValues(appendPath(path, k), fromMap[k], toMap[k], changes)
+		}
+		return
+	}
+
+	fromList, fromIsList := from.([]interface{})
+	toList, toIsList := to.([]interface{})
+	if fromIsList && toIsList {
+		n := len(fromList)
+		if len(toList) > n {
+			n = len(toList)
+		}
+		for i := 0; i < n; i++ {
+			var a, b interface{}
+			if i < len(fromList) {
+				a = fromList[i]
+			}
+			if i < len(toList) {
+				b = toList[i]
+			}
+			diffValues(appendPath(path, strconv.Itoa(i+1)), a, b, changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return
+	}
+
+	change := ProfileFieldChange{Path: strings.Join(path, "."), Old: from, New: to}
+	switch {
+	case from == nil:
+		change.Type = "added"
+	case to == nil:
+		change.Type = "removed"
+	default:
+		change.Type = "modified"
+	}
+	change.Field = strings.Join(ProfileFieldOf(path), ".")
+	change.Label = ProfileFieldLabel(change.Field)
+	*changes = append(*changes, change)
+}
+
+func appendPath(path []string, key string) []string {
+	next := make([]string, len(path)+1)
+	copy(next, path)
+	next[len(path)] = key
+	return next
+}
+
+// ApplyProfileFields 把 src 中指定字段的值复制到 dst（src 中不存在的字段会从 dst 删除）
+func ApplyProfileFields(dst, src map[string]interface{}, fields []string) error {
+	for _, field := range fields {
+		path := strings.Split(strings.TrimSpace(field), ".")
+		for _, segment := range path {
+			if segment == "" {
+				return fmt.Errorf("invalid field path %q", field)
+			}
+		}
+		value, found := lookupPath(src, path)
+		if err := setPath(dst, path, value, found); err != nil {
+			return fmt.Errorf("field %q: %w", field, err)
+		}
+	}
+	return nil
+}
+
+func lookupPath(root interface{}, path []string) (interface{}, bool) {
+	current := root
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 1 || index > len(node) {
+				return nil, false
+			}
+			current = node[index-1]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setPath(root map[string]interface{}, path []string, value interface{}, found bool) error {
+	var current interface{} = root
+	for i, segment := range path {
+		last := i == len(path)-1
+		switch node := current.(type) {
+		case map[string]interface{}:
+			if last {
+				if found {
+					node[segment] = value
+				} else {
+					delete(node, segment)
+				}
+				return nil
+			}
+			next, ok := node[segment]
+			if !ok || next == nil {
+				if !found {
+					return nil
+				}
+				next = make(map[string]interface{})
+				node[segment] = next
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 1 || index > len(node) {
+				return fmt.Errorf("list index %q out of range", segment)
+			}
+			if last {
+				if !found {
+					return fmt.Errorf("cannot remove list element %q", segment)
+				}
+				node[index-1] = value
+				return nil
+			}
+			current = node[index-1]
+		default:
+			return fmt.Errorf("path segment %q is not a table", segment)
+		}
+	}
+	return nil
+}
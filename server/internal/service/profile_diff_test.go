@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffProfileDataReportsFieldChanges(t *testing.T) {
+	from, err := ParseProfileData(`{ ["player"] = { ["characteristics"] = { ["FN"] = "Aldric", ["EC"] = "Blue" }, ["about"] = { ["TE"] = 1, ["T1"] = { ["TX"] = "Old" } } } }`, "p")
+	if err != nil {
+		t.Fatalf("parse from: %v", err)
+	}
+	to, err := ParseProfileData(`{"player":{"characteristics":{"FN":"Aldric","EC":"Green","AG":"30"},"about":{"TE":1,"T1":{"TX":"New"}}}}`, "p")
+	if err != nil {
+		t.Fatalf("parse to: %v", err)
+	}
+
+	changes := DiffProfileData(from, to)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	if changes[0].Path != "player.about.T1.TX" || changes[0].Field != "player.about" || changes[0].Label != "关于" {
+		t.Fatalf("unexpected about change %+v", changes[0])
+	}
+	if changes[1].Path != "player.characteristics.AG" || changes[1].Type != "added" {
+		t.Fatalf("unexpected added change %+v", changes[1])
+	}
+	if changes[2].Label != "眼睛颜色" || changes[2].Type != "modified" || changes[2].Old != "Blue" || changes[2].New != "Green" {
+		t.Fatalf("unexpected modified change %+v", changes[2])
+	}
+
+	if got := SummarizeProfileChanges(changes); !reflect.DeepEqual(got, []string{"关于", "年龄", "眼睛颜色"}) {
+		t.Fatalf("unexpected summary %v", got)
+	}
+}
+
+func TestApplyProfileFieldsRestoresSelectedFields(t *testing.T) {
+	dst := map[string]interface{}{
+		"player": map[string]interface{}{
+			"characteristics": map[string]interface{}{"FN": "New", "EC": "Green", "AG": "30"},
+		},
+	}
+	src := map[string]interface{}{
+		"player": map[string]interface{}{
+			"characteristics": map[string]interface{}{"FN": "Old", "EC": "Blue"},
+			"about":           map[string]interface{}{"TE": float64(1)},
+		},
+	}
+
+	if err := ApplyProfileFields(dst, src, []string{"player.characteristics.EC", "player.characteristics.AG", "player.about"}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	player := dst["player"].(map[string]interface{})
+	characteristics := player["characteristics"].(map[string]interface{})
+	if characteristics["FN"] != "New" || characteristics["EC"] != "Blue" {
+		t.Fatalf("unexpected characteristics %v", characteristics)
+	}
+	if _, ok := characteristics["AG"]; ok {
+		t.Fatalf("expected field missing in source to be removed, got %v", characteristics)
+	}
+	if !reflect.DeepEqual(player["about"], src["player"].(map[string]interface{})["about"]) {
+		t.Fatalf("expected about to be restored, got %v", player["about"])
+	}
+
+	if err := ApplyProfileFields(dst, src, []string{"player..FN"}); err == nil {
+		t.Fatal("expected invalid path to fail")
+	}
+}
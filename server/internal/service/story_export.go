@@ -0,0 +1,781 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+// 剧情导出格式
+const (
+	StoryExportMarkdown = "markdown"
+	StoryExportHTML     = "html"
+	StoryExportEPUB     = "epub"
+	StoryExportBBCode   = "bbcode"
+	StoryExportText     = "text"
+)
+
+// BBCode 方言
+const (
+	BBCodeDiscuz = "discuz"
+	BBCodeNGA    = "nga"
+)
+
+var storyExportFileTypes = map[string]struct{ contentType, extension string }{
+	StoryExportMarkdown: {"text/markdown; charset=utf-8", "md"},
+	StoryExportHTML:     {"text/html; charset=utf-8", "html"},
+	StoryExportEPUB:     {"application/epub+zip", "epub"},
+	StoryExportBBCode:   {"text/plain; charset=utf-8", "txt"},
+	StoryExportText:     {"text/plain; charset=utf-8", "txt"},
+}
+
+// StoryExportFileType 返回导出格式的 Content-Type 与文件扩展名，格式不支持时 ok 为 false
+func StoryExportFileType(format string) (contentType, extension string, ok bool) {
+	fileType, ok := storyExportFileTypes[format]
+	return fileType.contentType, fileType.extension, ok
+}
+
+// StoryExport 导出一个剧情所需的数据
+type StoryExport struct {
+	Story   model.Story
+	Author  string
+	Entries []model.StoryEntry
+	// Characters 条目 ID → 条目时间点的发言角色
+	Characters map[uint]model.Character
+	// Bookmarks 需要输出的书签，按创建顺序排列；自动书签不输出
+	Bookmarks []model.StoryBookmark
+	// Chapters 剧情章节，按顺序排列；Entries 需已按阅读顺序排列，每章第一条记录前输出章节标题
+	Chapters []model.StoryChapter
+	// BBCode 方言（discuz/nga），为空时按 discuz 输出
+	BBCode string
+	// Location 时间显示所用时区，为空时使用服务器时区
+	Location *time.Location
+	// ImageURL 把图片条目中的地址转为可外部访问的地址，为空时原样输出
+	ImageURL func(string) string
+}
+
+// channelStyle 频道在导出文本中的样式，与插件聊天框的显示一致
+type channelStyle struct {
+	label  string // 频道标签，为空时不显示
+	verb   string // 说话者与内容之间的连接词
+	color  string // 内容颜色 rrggbb，为空时使用默认颜色
+	italic bool
+	bold   bool
+}
+
+var channelStyles = map[string]channelStyle{
+	"SAY":     {verb: "说："},
+	"EMOTE":   {verb: " ", color: "ff8c00", italic: true},
+	"YELL":    {verb: "大喊：", color: "ff3333", bold: true},
+	"WHISPER": {verb: "悄悄地说：", color: "b39ddb", italic: true},
+	"PARTY":   {label: "小队", verb: "说：", color: "aaaaff"},
+	"RAID":    {label: "团队", verb: "说：", color: "ff7f00"},
+	"GUILD":   {label: "公会", verb: "说：", color: "40ff40"},
+	"OFFICER": {label: "官员", verb: "说：", color: "40c040"},
+}
+
+// normalizeExportChannel 统一频道名称：去掉 CHAT_MSG_ 前缀，合并表情、密语、队长等变体
+func normalizeExportChannel(channel string) string {
+	channel = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(channel)), "CHAT_MSG_")
+	switch channel {
+	case "":
+		return "SAY"
+	case "TEXT_EMOTE":
+		return "EMOTE"
+	case "WHISPER_IN", "WHISPER_OUT", "WHISPER_INFORM":
+		return "WHISPER"
+	case "PARTY_LEADER":
+		return "PARTY"
+	case "RAID_LEADER", "RAID_WARNING":
+		return "RAID"
+	}
+	return channel
+}
+
+func exportChannelStyle(channel string) channelStyle {
+	if style, ok := channelStyles[channel]; ok {
+		return style
+	}
+	return channelStyle{label: channel, verb: "说："}
+}
+
+// exportChannelClass 频道对应的 HTML class，频道名来自上传的数据，只输出已知频道，其余统一为 channel-other
+func exportChannelClass(channel string) string {
+	if _, ok := channelStyles[channel]; ok {
+		return "channel-" + strings.ToLower(channel)
+	}
+	return "channel-other"
+}
+
+// exportURLEscaper 对图片地址中会截断 Markdown 链接或 BBCode 标签的字符做百分号编码
+var exportURLEscaper = strings.NewReplacer(
+	" ", "%20", "(", "%28", ")", "%29", "[", "%5B", "]", "%5D", "<", "%3C", ">", "%3E", `"`, "%22",
+)
+
+// exportUnassignedChapter 有章节时，排在最后的未分章条目使用的标题
+const exportUnassignedChapter = "未分章节"
+
+// exportEntry 预处理后的一条记录
+type exportEntry struct {
+	chapter     string // 以该记录开始的章节标题，不是章节第一条时为空
+	chapterID   uint   // 章节ID，未分章为 0，用于生成锚点
+	time        time.Time
+	narration   bool
+	image       string // 图片条目的地址
+	speaker     string
+	color       string // 说话者名字颜色 rrggbb
+	channel     string // 标准化后的频道
+	style       channelStyle
+	content     string
+	background  string // 条目背景色 rrggbb
+	bookmarks   []string
+	bookmarkIDs []uint
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]+>`)
+
+func prepareStoryExport(data StoryExport) []exportEntry {
+	bookmarks := make(map[uint][]model.StoryBookmark)
+	for _, bookmark := range data.Bookmarks {
+		if !bookmark.IsAuto {
+			bookmarks[bookmark.EntryID] = append(bookmarks[bookmark.EntryID], bookmark)
+		}
+	}
+	loc := data.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	chapters := make(map[uint]model.StoryChapter, len(data.Chapters))
+	for _, chapter := range data.Chapters {
+		chapters[chapter.ID] = chapter
+	}
+	// 上一条记录所在的章节，未分章为 0；未分章的条目只在前面有章节时才输出标题
+	var current uint
+
+	entries := make([]exportEntry, 0, len(data.Entries))
+	for _, entry := range data.Entries {
+		item := exportEntry{
+			time:       entry.Timestamp.In(loc),
+			narration:  entry.Type == "narration",
+			speaker:    strings.TrimSpace(entry.Speaker),
+			channel:    normalizeExportChannel(entry.Channel),
+			content:    strings.TrimSpace(html.UnescapeString(htmlTagPattern.ReplaceAllString(entry.Content, ""))),
+			background: exportColor(entry.BackgroundColor),
+		}
+		for _, bookmark := range bookmarks[entry.ID] {
+			item.bookmarks = append(item.bookmarks, bookmark.Name)
+			item.bookmarkIDs = append(item.bookmarkIDs, bookmark.ID)
+		}
+
+		item.style = exportChannelStyle(item.channel)
+
+		if entry.Type == "image" {
+			var image struct {
+				Image       string `json:"image"`
+				Description string `json:"description"`
+			}
+			if json.Unmarshal([]byte(entry.Content), &image) != nil || image.Image == "" {
+				continue
+			}
+			item.image = image.Image
+			if data.ImageURL != nil {
+				item.image = data.ImageURL(image.Image)
+			}
+			item.content = strings.TrimSpace(image.Description)
+		} else if item.content == "" {
+			continue
+		}
+
+		if character, ok := data.Characters[entry.ID]; ok && !item.narration {
+			if name := exportCharacterName(character); name != "" {
+				item.speaker = name
+			}
+			item.color = exportColor(character.CustomColor)
+			if item.color == "" {
+				item.color = exportColor(character.Color)
+			}
+		}
+		if item.speaker == "" && !item.narration && item.image == "" {
+			item.narration = true
+		}
+		if len(chapters) > 0 {
+			var chapterID uint
+			if entry.ChapterID != nil {
+				if _, ok := chapters[*entry.ChapterID]; ok {
+					chapterID = *entry.ChapterID
+				}
+			}
+			if chapterID != current {
+				item.chapterID = chapterID
+				item.chapter = exportUnassignedChapter
+				if chapterID != 0 {
+					item.chapter = strings.TrimSpace(chapters[chapterID].Title)
+				}
+				current = chapterID
+			}
+		}
+		entries = append(entries, item)
+	}
+	return entries
+}
+
+// exportCharacterName 与客户端一致：自定义名 > 名字 姓氏 > 游戏内角色名
+func exportCharacterName(character model.Character) string {
+	if name := strings.TrimSpace(character.CustomName); name != "" {
+		return name
+	}
+	if name := strings.TrimSpace(character.FirstName + " " + character.LastName); name != "" {
+		return name
+	}
+	name := character.GameID
+	if dash := strings.Index(name, "-"); dash >= 0 {
+		name = name[:dash]
+	}
+	return strings.TrimSpace(name)
+}
+
+// exportColor 把 #rrggbb、rrggbb、aarrggbb 统一为小写 rrggbb，无效颜色返回空字符串
+func exportColor(value string) string {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(value) == 8 {
+		value = value[2:]
+	}
+	if len(value) != 6 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(value, 16, 32); err != nil {
+		return ""
+	}
+	return strings.ToLower(value)
+}
+
+func exportTimeRange(story model.Story, loc *time.Location) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	if story.StartTime.IsZero() {
+		return ""
+	}
+	start := story.StartTime.In(loc).Format("2006-01-02 15:04")
+	if story.EndTime.IsZero() || story.EndTime.Equal(story.StartTime) {
+		return start
+	}
+	return start + " ~ " + story.EndTime.In(loc).Format("2006-01-02 15:04")
+}
+
+// ExportStory 按指定格式把剧情写入 w
+func ExportStory(w io.Writer, format string, data StoryExport) error {
+	entries := prepareStoryExport(data)
+	switch format {
+	case StoryExportMarkdown:
+		return writeStoryMarkdown(w, data, entries)
+	case StoryExportHTML:
+		return writeStoryHTML(w, data, entries)
+	case StoryExportEPUB:
+		return writeStoryEPUB(w, data, entries)
+	case StoryExportBBCode:
+		return writeStoryBBCode(w, data, entries)
+	case StoryExportText:
+		return writeStoryText(w, data, entries)
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+// ---- 纯文本 ----
+
+func writeStoryText(w io.Writer, data StoryExport, entries []exportEntry) error {
+	var sb strings.Builder
+	sb.WriteString(data.Story.Title + "\n")
+	if data.Author != "" {
+		sb.WriteString("作者：" + data.Author + "\n")
+	}
+	if timeRange := exportTimeRange(data.Story, data.Location); timeRange != "" {
+		sb.WriteString("时间：" + timeRange + "\n")
+	}
+	if desc := strings.TrimSpace(data.Story.Description); desc != "" {
+		sb.WriteString("\n" + desc + "\n")
+	}
+
+	for _, entry := range entries {
+		if entry.chapter != "" {
+			sb.WriteString("\n【" + entry.chapter + "】\n")
+		}
+		for _, name := range entry.bookmarks {
+			sb.WriteString("\n== " + name + " ==\n")
+		}
+		sb.WriteString("\n[" + entry.time.Format("15:04") + "] ")
+		switch {
+		case entry.image != "":
+			sb.WriteString("[图片] " + entry.image)
+			if entry.content != "" {
+				sb.WriteString(" " + entry.content)
+			}
+		case entry.narration:
+			sb.WriteString(entry.content)
+		default:
+			if entry.style.label != "" {
+				sb.WriteString("[" + entry.style.label + "]")
+			}
+			sb.WriteString(entry.speaker + entry.style.verb + entry.content)
+		}
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ---- Markdown ----
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "#", `\#`, "|", `\|`,
+)
+
+// markdownText 转义 Markdown 特殊字符，多行内容用行尾两个空格保留换行
+func markdownText(s string) string {
+	return strings.ReplaceAll(markdownEscaper.Replace(s), "\n", "  \n")
+}
+
+func markdownColored(text, color string) string {
+	if color == "" {
+		return text
+	}
+	return `<span style="color:#` + color + `">` + text + `</span>`
+}
+
+func writeStoryMarkdown(w io.Writer, data StoryExport, entries []exportEntry) error {
+	var sb strings.Builder
+	sb.WriteString("# " + markdownText(data.Story.Title) + "\n\n")
+	if data.Author != "" {
+		sb.WriteString("- 作者：" + markdownText(data.Author) + "\n")
+	}
+	if timeRange := exportTimeRange(data.Story, data.Location); timeRange != "" {
+		sb.WriteString("- 时间：" + timeRange + "\n")
+	}
+	if desc := strings.TrimSpace(data.Story.Description); desc != "" {
+		sb.WriteString("\n> " + strings.ReplaceAll(markdownText(desc), "\n", "\n> ") + "\n")
+	}
+	sb.WriteString("\n---\n")
+
+	// 有章节时章节为二级标题，书签降为三级标题
+	bookmarkHeading := "## "
+	if len(data.Chapters) > 0 {
+		bookmarkHeading = "### "
+	}
+	for _, entry := range entries {
+		if entry.chapter != "" {
+			sb.WriteString("\n## " + markdownText(entry.chapter) + "\n")
+		}
+		for _, name := range entry.bookmarks {
+			sb.WriteString("\n" + bookmarkHeading + markdownText(name) + "\n")
+		}
+
+		var line string
+		switch {
+		case entry.image != "":
+			line = "![" + markdownText(entry.content) + "](" + exportURLEscaper.Replace(entry.image) + ")"
+		case entry.narration:
+			line = "*" + markdownText(entry.content) + "*"
+		default:
+			content := markdownText(entry.content)
+			if entry.style.italic {
+				content = "*" + content + "*"
+			}
+			if entry.style.bold {
+				content = "**" + content + "**"
+			}
+			line = "**" + markdownColored(markdownText(entry.speaker), entry.color) + "**" +
+				markdownText(entry.style.verb) + markdownColored(content, entry.style.color)
+			if entry.style.label != "" {
+				line = "\\[" + markdownText(entry.style.label) + "\\] " + line
+			}
+		}
+		line = "`" + entry.time.Format("15:04") + "` " + line
+		if entry.background != "" {
+			line = `<span style="background-color:#` + entry.background + `">` + line + `</span>`
+		}
+		sb.WriteString("\n" + line + "\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ---- HTML / EPUB ----
+
+const storyExportCSS = `body{font-family:"Noto Serif SC","Source Han Serif SC",serif;line-height:1.7;max-width:48em;margin:0 auto;padding:1em;color:#222}
+h1{margin-bottom:.2em}
+.meta{color:#666;font-size:.9em;margin:0}
+.description{border-left:3px solid #ccc;padding-left:1em;color:#444;white-space:pre-wrap}
+.toc ol{padding-left:1.5em}
+.chapter{margin-top:2em}
+.bookmark{border-bottom:1px solid #ddd;padding-bottom:.2em;margin-top:1.5em}
+.entry{margin:.3em 0;padding:.1em .4em;border-radius:3px}
+.time{color:#888;font-size:.85em;margin-right:.4em;font-family:monospace}
+.speaker{font-weight:bold}
+.channel-label{color:#888;margin-right:.2em}
+.narration .content{font-style:italic;color:#555}
+.channel-emote .content,.channel-whisper .content{font-style:italic}
+.channel-yell .content{font-weight:bold}
+.entry-image img{max-width:100%}
+.entry-image figcaption{color:#666;font-size:.9em}
+`
+
+func bookmarkAnchor(id uint) string {
+	return "bookmark-" + strconv.FormatUint(uint64(id), 10)
+}
+
+func chapterAnchor(id uint) string {
+	return "chapter-" + strconv.FormatUint(uint64(id), 10)
+}
+
+// entryHTML 输出一条记录；EPUB 不引用外部图片，只保留链接。
+// withChapter 为 false 时不输出章节标题（EPUB 中章节标题即分页标题）
+func entryHTML(sb *strings.Builder, entry exportEntry, embedImages, withChapter bool) {
+	if withChapter && entry.chapter != "" {
+		sb.WriteString(`<h2 class="chapter" id="` + chapterAnchor(entry.chapterID) + `">` + html.EscapeString(entry.chapter) + "</h2>\n")
+	}
+	for i, name := range entry.bookmarks {
+		sb.WriteString(`<h2 class="bookmark" id="` + bookmarkAnchor(entry.bookmarkIDs[i]) + `">` + html.EscapeString(name) + "</h2>\n")
+	}
+
+	class := "entry"
+	switch {
+	case entry.image != "":
+		class += " entry-image"
+	case entry.narration:
+		class += " narration"
+	default:
+		class += " " + exportChannelClass(entry.channel)
+	}
+	sb.WriteString(`<div class="` + class + `"`)
+	if entry.background != "" {
+		sb.WriteString(` style="background-color:#` + entry.background + `"`)
+	}
+	sb.WriteString(">")
+	sb.WriteString(`<span class="time">` + entry.time.Format("15:04") + "</span>")
+
+	content := strings.ReplaceAll(html.EscapeString(entry.content), "\n", "<br/>")
+	switch {
+	case entry.image != "":
+		src := html.EscapeString(entry.image)
+		if embedImages {
+			sb.WriteString(`<figure><img src="` + src + `" alt="` + html.EscapeString(entry.content) + `"/>`)
+			if content != "" {
+				sb.WriteString("<figcaption>" + content + "</figcaption>")
+			}
+			sb.WriteString("</figure>")
+		} else {
+			sb.WriteString(`<a href="` + src + `">[图片]</a> ` + content)
+		}
+	case entry.narration:
+		sb.WriteString(`<span class="content">` + content + "</span>")
+	default:
+		if entry.style.label != "" {
+			sb.WriteString(`<span class="channel-label">[` + html.EscapeString(entry.style.label) + "]</span>")
+		}
+		sb.WriteString(`<span class="speaker"`)
+		if entry.color != "" {
+			sb.WriteString(` style="color:#` + entry.color + `"`)
+		}
+		sb.WriteString(">" + html.EscapeString(entry.speaker) + "</span>")
+		sb.WriteString(html.EscapeString(entry.style.verb))
+		sb.WriteString(`<span class="content"`)
+		if entry.style.color != "" {
+			sb.WriteString(` style="color:#` + entry.style.color + `"`)
+		}
+		sb.WriteString(">" + content + "</span>")
+	}
+	sb.WriteString("</div>\n")
+}
+
+func storyHeaderHTML(sb *strings.Builder, data StoryExport) {
+	sb.WriteString("<h1>" + html.EscapeString(data.Story.Title) + "</h1>\n")
+	if data.Author != "" {
+		sb.WriteString(`<p class="meta">作者：` + html.EscapeString(data.Author) + "</p>\n")
+	}
+	if timeRange := exportTimeRange(data.Story, data.Location); timeRange != "" {
+		sb.WriteString(`<p class="meta">时间：` + timeRange + "</p>\n")
+	}
+	if desc := strings.TrimSpace(data.Story.Description); desc != "" {
+		sb.WriteString(`<p class="description">` + html.EscapeString(desc) + "</p>\n")
+	}
+}
+
+func writeStoryHTML(w io.Writer, data StoryExport, entries []exportEntry) error {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"zh-CN\">\n<head>\n<meta charset=\"utf-8\"/>\n")
+	sb.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"/>\n")
+	sb.WriteString("<title>" + html.EscapeString(data.Story.Title) + "</title>\n")
+	sb.WriteString("<style>\n" + storyExportCSS)
+	if background := exportColor(data.Story.BackgroundColor); background != "" {
+		sb.WriteString("body{background-color:#" + background + "}\n")
+	}
+	sb.WriteString("</style>\n</head>\n<body>\n")
+	storyHeaderHTML(&sb, data)
+
+	var toc strings.Builder
+	for _, entry := range entries {
+		if entry.chapter != "" {
+			toc.WriteString(`<li class="toc-chapter"><a href="#` + chapterAnchor(entry.chapterID) + `">` + html.EscapeString(entry.chapter) + "</a></li>\n")
+		}
+		for i, name := range entry.bookmarks {
+			toc.WriteString(`<li><a href="#` + bookmarkAnchor(entry.bookmarkIDs[i]) + `">` + html.EscapeString(name) + "</a></li>\n")
+		}
+	}
+	if toc.Len() > 0 {
+		sb.WriteString("<nav class=\"toc\"><ol>\n" + toc.String() + "</ol></nav>\n")
+	}
+
+	sb.WriteString("<main>\n")
+	for _, entry := range entries {
+		entryHTML(&sb, entry, true, true)
+	}
+	sb.WriteString("</main>\n</body>\n</html>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// epubChapter EPUB 的一章：剧情有章节时按章节分章，书签作为章内标题；
+// 否则以书签为分界，第一个书签之前的记录单独成章
+type epubChapter struct {
+	title   string
+	entries []exportEntry
+}
+
+func splitEPUBChapters(title string, entries []exportEntry, byChapter bool) []epubChapter {
+	chapters := []epubChapter{{title: title}}
+	for _, entry := range entries {
+		switch {
+		case byChapter && entry.chapter != "":
+			chapters = append(chapters, epubChapter{title: entry.chapter})
+		case !byChapter && len(entry.bookmarks) > 0:
+			chapters = append(chapters, epubChapter{title: strings.Join(entry.bookmarks, " / ")})
+			// 章节标题已包含书签名，不再在正文重复
+			entry.bookmarks, entry.bookmarkIDs = nil, nil
+		}
+		last := &chapters[len(chapters)-1]
+		last.entries = append(last.entries, entry)
+	}
+	if len(chapters) > 1 && len(chapters[0].entries) == 0 {
+		chapters = chapters[1:]
+	}
+	return chapters
+}
+
+func xhtmlDocument(title, body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="zh-CN" lang="zh-CN">
+<head><meta charset="utf-8"/><title>` + html.EscapeString(title) + `</title><link rel="stylesheet" type="text/css" href="style.css"/></head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+func writeStoryEPUB(w io.Writer, data StoryExport, entries []exportEntry) error {
+	zw := zip.NewWriter(w)
+
+	// mimetype 必须是第一个文件且不压缩
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []struct{ name, content string }{
+		{"META-INF/container.xml", `<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`},
+	}
+
+	css := storyExportCSS
+	if background := exportColor(data.Story.BackgroundColor); background != "" {
+		css += "body{background-color:#" + background + "}\n"
+	}
+	files = append(files, struct{ name, content string }{"OEBPS/style.css", css})
+
+	var title strings.Builder
+	storyHeaderHTML(&title, data)
+	files = append(files, struct{ name, content string }{"OEBPS/title.xhtml", xhtmlDocument(data.Story.Title, title.String())})
+
+	var manifest, spine, nav strings.Builder
+	manifest.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	manifest.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	manifest.WriteString(`<item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+	spine.WriteString(`<itemref idref="title"/>` + "\n")
+
+	for i, chapter := range splitEPUBChapters(data.Story.Title, entries, len(data.Chapters) > 0) {
+		id := fmt.Sprintf("chapter-%d", i+1)
+		var body strings.Builder
+		body.WriteString("<h2>" + html.EscapeString(chapter.title) + "</h2>\n")
+		for _, entry := range chapter.entries {
+			entryHTML(&body, entry, false, false)
+		}
+		files = append(files, struct{ name, content string }{"OEBPS/" + id + ".xhtml", xhtmlDocument(chapter.title, body.String())})
+		manifest.WriteString(`<item id="` + id + `" href="` + id + `.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+		spine.WriteString(`<itemref idref="` + id + `"/>` + "\n")
+		nav.WriteString(`<li><a href="` + id + `.xhtml">` + html.EscapeString(chapter.title) + "</a></li>\n")
+	}
+
+	files = append(files, struct{ name, content string }{"OEBPS/nav.xhtml", xhtmlDocument("目录",
+		"<nav epub:type=\"toc\" id=\"toc\"><h1>目录</h1><ol>\n"+nav.String()+"</ol></nav>\n")})
+
+	modified := data.Story.UpdatedAt
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	var meta strings.Builder
+	meta.WriteString(`<dc:identifier id="story-id">urn:rpbox:story:` + strconv.FormatUint(uint64(data.Story.ID), 10) + "</dc:identifier>\n")
+	meta.WriteString("<dc:title>" + html.EscapeString(data.Story.Title) + "</dc:title>\n")
+	meta.WriteString("<dc:language>zh-CN</dc:language>\n")
+	if data.Author != "" {
+		meta.WriteString("<dc:creator>" + html.EscapeString(data.Author) + "</dc:creator>\n")
+	}
+	if desc := strings.TrimSpace(data.Story.Description); desc != "" {
+		meta.WriteString("<dc:description>" + html.EscapeString(desc) + "</dc:description>\n")
+	}
+	meta.WriteString(`<meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+
+	files = append(files, struct{ name, content string }{"OEBPS/content.opf", `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="story-id" xml:lang="zh-CN">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+` + meta.String() + `</metadata>
+<manifest>
+` + manifest.String() + `</manifest>
+<spine>
+` + spine.String() + `</spine>
+</package>
+`})
+
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, file.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ---- BBCode ----
+
+// ngaColors NGA 的 [color] 只支持固定的颜色名，按 RGB 距离取最接近的一个
+var ngaColors = map[string]string{
+	"skyblue": "87ceeb", "royalblue": "4169e1", "blue": "0000ff", "darkblue": "00008b",
+	"orange": "ffa500", "orangered": "ff4500", "crimson": "dc143c", "red": "ff0000",
+	"firebrick": "b22222", "darkred": "8b0000", "green": "008000", "limegreen": "32cd32",
+	"seagreen": "2e8b57", "teal": "008080", "deeppink": "ff1493", "tomato": "ff6347",
+	"coral": "ff7f50", "purple": "800080", "indigo": "4b0082", "burlywood": "deb887",
+	"sandybrown": "f4a460", "sienna": "a0522d", "chocolate": "d2691e", "silver": "c0c0c0",
+}
+
+func nearestNGAColor(color string) string {
+	r, g, b := splitRGB(color)
+	best, bestDistance := "", -1
+	for name, value := range ngaColors {
+		nr, ng, nb := splitRGB(value)
+		distance := (r-nr)*(r-nr) + (g-ng)*(g-ng) + (b-nb)*(b-nb)
+		if bestDistance < 0 || distance < bestDistance || (distance == bestDistance && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+func splitRGB(color string) (int, int, int) {
+	value, _ := strconv.ParseUint(color, 16, 32)
+	return int(value >> 16 & 0xff), int(value >> 8 & 0xff), int(value & 0xff)
+}
+
+// bbcodeEscaper 避免内容中的方括号被论坛当作标签解析
+var bbcodeEscaper = strings.NewReplacer("[", "［", "]", "］")
+
+func writeStoryBBCode(w io.Writer, data StoryExport, entries []exportEntry) error {
+	nga := data.BBCode == BBCodeNGA
+	colored := func(text, color string) string {
+		if color == "" {
+			return text
+		}
+		if nga {
+			return "[color=" + nearestNGAColor(color) + "]" + text + "[/color]"
+		}
+		return "[color=#" + color + "]" + text + "[/color]"
+	}
+	heading := func(text string) string {
+		if nga {
+			return "[h]" + text + "[/h]"
+		}
+		return "[size=4][b]" + text + "[/b][/size]"
+	}
+
+	var sb strings.Builder
+	if nga {
+		sb.WriteString("[size=150%][b]" + bbcodeEscaper.Replace(data.Story.Title) + "[/b][/size]\n")
+	} else {
+		sb.WriteString("[size=5][b]" + bbcodeEscaper.Replace(data.Story.Title) + "[/b][/size]\n")
+	}
+	if data.Author != "" {
+		sb.WriteString("作者：" + bbcodeEscaper.Replace(data.Author) + "\n")
+	}
+	if timeRange := exportTimeRange(data.Story, data.Location); timeRange != "" {
+		sb.WriteString("时间：" + timeRange + "\n")
+	}
+	if desc := strings.TrimSpace(data.Story.Description); desc != "" {
+		sb.WriteString("[quote]" + bbcodeEscaper.Replace(desc) + "[/quote]\n")
+	}
+
+	for _, entry := range entries {
+		if entry.chapter != "" {
+			sb.WriteString("\n" + heading(bbcodeEscaper.Replace(entry.chapter)) + "\n")
+		}
+		for _, name := range entry.bookmarks {
+			sb.WriteString("\n" + heading(bbcodeEscaper.Replace(name)) + "\n")
+		}
+
+		line := colored(entry.time.Format("15:04"), "888888") + " "
+		content := bbcodeEscaper.Replace(entry.content)
+		switch {
+		case entry.image != "":
+			line += "[img]" + exportURLEscaper.Replace(entry.image) + "[/img]"
+			if content != "" {
+				line += "\n" + content
+			}
+		case entry.narration:
+			line += "[i]" + content + "[/i]"
+		default:
+			if entry.style.italic {
+				content = "[i]" + content + "[/i]"
+			}
+			if entry.style.bold {
+				content = "[b]" + content + "[/b]"
+			}
+			if entry.style.label != "" {
+				line += "［" + bbcodeEscaper.Replace(entry.style.label) + "］"
+			}
+			line += "[b]" + colored(bbcodeEscaper.Replace(entry.speaker), entry.color) + "[/b]" +
+				entry.style.verb + colored(content, entry.style.color)
+		}
+		// NGA 不支持背景色
+		if entry.background != "" && !nga {
+			line = "[backcolor=#" + entry.background + "]" + line + "[/backcolor]"
+		}
+		sb.WriteString(line + "\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+func storyExportFixture() StoryExport {
+	at := func(minute int) time.Time { return time.Date(2024, 5, 1, 20, minute, 0, 0, time.UTC) }
+	return StoryExport{
+		Story:  model.Story{ID: 7, Title: "Night Watch", Description: "At the gate", StartTime: at(0), EndTime: at(30)},
+		Author: "tester",
+		Entries: []model.StoryEntry{
+			{ID: 1, Type: "narration", Content: "Rain falls.", Timestamp: at(0)},
+			{ID: 2, Speaker: "Aldric", Channel: "CHAT_MSG_SAY", Content: "Halt <b>there</b> [x]", Timestamp: at(1), BackgroundColor: "#FFEEDD"},
+			{ID: 3, Speaker: "Bryn", Channel: "TEXT_EMOTE", Content: "raises a lantern.", Timestamp: at(2)},
+			{ID: 4, Speaker: "Bryn", Channel: "YELL", Content: "Intruder!", Timestamp: at(3)},
+			{ID: 5, Type: "image", Content: `{"image":"/uploads/gate.png","description":"The gate"}`, Timestamp: at(4)},
+		},
+		Characters: map[uint]model.Character{
+			2: {FirstName: "Aldric", LastName: "Voss", Color: "ff0000", CustomColor: ""},
+			3: {CustomName: "Bryn the Bold", Color: "00ff00", CustomColor: "#0000FF"},
+		},
+		Bookmarks: []model.StoryBookmark{
+			{ID: 11, EntryID: 3, Name: "Alarm"},
+			{ID: 12, EntryID: 2, Name: "last", IsAuto: true},
+		},
+		Location: time.UTC,
+		ImageURL: func(url string) string { return "https://api.example.com" + url },
+	}
+}
+
+func exportString(t *testing.T, format string, data StoryExport) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := ExportStory(&buf, format, data); err != nil {
+		t.Fatalf("export %s: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestExportStoryText(t *testing.T) {
+	out := exportString(t, StoryExportText, storyExportFixture())
+	for _, want := range []string{
+		"Night Watch\n作者：tester\n时间：2024-05-01 20:00 ~ 2024-05-01 20:30",
+		"[20:00] Rain falls.",
+		"[20:01] Aldric Voss说：Halt there [x]",
+		"== Alarm ==\n\n[20:02] Bryn the Bold raises a lantern.",
+		"[20:03] Bryn大喊：Intruder!",
+		"[20:04] [图片] https://api.example.com/uploads/gate.png The gate",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("text export missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "== last ==") {
+		t.Fatalf("auto bookmark should not be exported:\n%s", out)
+	}
+}
+
+func TestExportStoryHTMLAndMarkdown(t *testing.T) {
+	out := exportString(t, StoryExportHTML, storyExportFixture())
+	for _, want := range []string{
+		`<a href="#bookmark-11">Alarm</a>`,
+		`<h2 class="bookmark" id="bookmark-11">Alarm</h2>`,
+		`<div class="entry channel-say" style="background-color:#ffeedd">`,
+		`<span class="speaker" style="color:#ff0000">Aldric Voss</span>`,
+		`Halt there [x]`,
+		`<span class="speaker" style="color:#0000ff">Bryn the Bold</span>`,
+		`<div class="entry channel-yell">`,
+		`<img src="https://api.example.com/uploads/gate.png" alt="The gate"/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("html export missing %q:\n%s", want, out)
+		}
+	}
+
+	out = exportString(t, StoryExportMarkdown, storyExportFixture())
+	for _, want := range []string{
+		"# Night Watch",
+		"## Alarm",
+		`<span style="background-color:#ffeedd">`,
+		`**<span style="color:#ff0000">Aldric Voss</span>**说：Halt there \[x\]`,
+		`**Bryn**大喊：<span style="color:#ff3333">**Intruder!**</span>`,
+		"![The gate](https://api.example.com/uploads/gate.png)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("markdown export missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportStoryBBCodeDialects(t *testing.T) {
+	data := storyExportFixture()
+	out := exportString(t, StoryExportBBCode, data)
+	for _, want := range []string{
+		"[backcolor=#ffeedd]",
+		"[b][color=#ff0000]Aldric Voss[/color][/b]说：Halt there ［x］",
+		"[size=4][b]Alarm[/b][/size]",
+		"[img]https://api.example.com/uploads/gate.png[/img]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("discuz export missing %q:\n%s", want, out)
+		}
+	}
+
+	data.BBCode = BBCodeNGA
+	out = exportString(t, StoryExportBBCode, data)
+	if strings.Contains(out, "backcolor") || strings.Contains(out, "[color=#") {
+		t.Fatalf("nga export should use named colors only:\n%s", out)
+	}
+	for _, want := range []string{"[color=red]Aldric Voss[/color]", "[color=blue]Bryn the Bold[/color]", "[h]Alarm[/h]"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("nga export missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportStoryEscapesUntrustedFields(t *testing.T) {
+	at := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	data := StoryExport{
+		Story: model.Story{Title: "Escape"},
+		Entries: []model.StoryEntry{
+			{ID: 1, Speaker: "Aldric", Channel: `x" onmouseover="alert(1)`, Content: "Halt", Timestamp: at},
+			{ID: 2, Speaker: "Bryn", Channel: "[url=evil]", Content: "Hi", Timestamp: at},
+			{ID: 3, Type: "image", Content: `{"image":"https://a.example.com/x.png) [/img][url=evil]","description":"pic"}`, Timestamp: at},
+		},
+	}
+
+	out := exportString(t, StoryExportHTML, data)
+	if strings.Contains(out, `onmouseover="`) || !strings.Contains(out, `<div class="entry channel-other">`) {
+		t.Fatalf("unknown channel should use a fixed class:\n%s", out)
+	}
+
+	out = exportString(t, StoryExportMarkdown, data)
+	if !strings.Contains(out, "![pic](https://a.example.com/x.png%29%20%5B/img%5D%5Burl=evil%5D)") {
+		t.Fatalf("markdown image url should be escaped:\n%s", out)
+	}
+
+	out = exportString(t, StoryExportBBCode, data)
+	if strings.Contains(out, "[url=evil]") || strings.Contains(out, "x.png) [/img]") {
+		t.Fatalf("bbcode should escape labels and image urls:\n%s", out)
+	}
+}
+
+func TestExportStoryEPUB(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ExportStory(&buf, StoryExportEPUB, storyExportFixture()); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open epub: %v", err)
+	}
+	if first := reader.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("mimetype must be the first stored entry, got %s method=%d", first.Name, first.Method)
+	}
+
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[file.Name] = string(content)
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/chapter-1.xhtml", "OEBPS/chapter-2.xhtml"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("epub missing %s", name)
+		}
+	}
+	if !strings.Contains(files["OEBPS/nav.xhtml"], `<a href="chapter-2.xhtml">Alarm</a>`) {
+		t.Fatalf("bookmark should start a chapter: %s", files["OEBPS/nav.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/chapter-2.xhtml"], `<a href="https://api.example.com/uploads/gate.png">[图片]</a>`) {
+		t.Fatalf("epub should link images instead of embedding them: %s", files["OEBPS/chapter-2.xhtml"])
+	}
+}
+
+func TestExportStoryChapters(t *testing.T) {
+	data := storyExportFixture()
+	prologue, watch := uint(21), uint(22)
+	data.Chapters = []model.StoryChapter{{ID: prologue, Title: "Prologue", SortOrder: 1}, {ID: watch, Title: "The Watch", SortOrder: 2}}
+	// 条目已按阅读顺序排列：两个章节，最后一条未分章
+	data.Entries[0].ChapterID = &prologue
+	data.Entries[1].ChapterID = &watch
+	data.Entries[2].ChapterID = &watch
+	data.Entries[3].ChapterID = &watch
+
+	markdown := exportString(t, StoryExportMarkdown, data)
+	for _, want := range []string{"\n## Prologue\n", "\n## The Watch\n", "\n### Alarm\n", "\n## 未分章节\n"} {
+		if !strings.Contains(markdown, want) {
+			t.Fatalf("markdown export missing %q:\n%s", want, markdown)
+		}
+	}
+	if strings.Count(markdown, "## The Watch") != 1 || strings.Index(markdown, "The Watch") > strings.Index(markdown, "Alarm") {
+		t.Fatalf("chapter heading should appear once before its entries:\n%s", markdown)
+	}
+	page := exportString(t, StoryExportHTML, data)
+	if !strings.Contains(page, `<h2 class="chapter" id="chapter-22">The Watch</h2>`) || !strings.Contains(page, `<a href="#chapter-21">Prologue</a>`) {
+		t.Fatalf("html export should link chapters: %s", page)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportStory(&buf, StoryExportEPUB, data); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open epub: %v", err)
+	}
+	for _, file := range reader.File {
+		if file.Name != "OEBPS/nav.xhtml" {
+			continue
+		}
+		rc, _ := file.Open()
+		nav, _ := io.ReadAll(rc)
+		rc.Close()
+		if !strings.Contains(string(nav), `<a href="chapter-2.xhtml">The Watch</a>`) || !strings.Contains(string(nav), `<a href="chapter-3.xhtml">未分章节</a>`) ||
+			strings.Contains(string(nav), ">Alarm<") {
+			t.Fatalf("epub should split by story chapters: %s", nav)
+		}
+	}
+}
+
+func TestExportStoryRejectsUnknownFormat(t *testing.T) {
+	if _, _, ok := StoryExportFileType("pdf"); ok {
+		t.Fatalf("pdf should not be supported")
+	}
+	if err := ExportStory(io.Discard, "pdf", storyExportFixture()); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+// SceneMergeOptions 合并多份同场景记录的参数，零值使用默认值
+type SceneMergeOptions struct {
+	Window       time.Duration // 对齐后说话者与内容相同、时间相差不超过该值视为同一条消息，默认 5 秒
+	MaxSkew      time.Duration // 估计时钟偏差时允许的最大偏差，默认 10 分钟
+	DisableAlign bool          // 不估计时钟偏差，直接按原始时间合并
+}
+
+func (o SceneMergeOptions) withDefaults() SceneMergeOptions {
+	if o.Window <= 0 {
+		o.Window = 5 * time.Second
+	}
+	if o.MaxSkew <= 0 {
+		o.MaxSkew = 10 * time.Minute
+	}
+	return o
+}
+
+// SceneMergeSource 一份参与合并的记录（通常是某位玩家上传的剧情），条目需按原顺序排列
+type SceneMergeSource struct {
+	StoryID uint
+	Entries []model.StoryEntry
+}
+
+// SceneLineSource 合并后条目的一个来源
+type SceneLineSource struct {
+	StoryID uint `json:"story_id"`
+	EntryID uint `json:"entry_id"`
+}
+
+// MergedSceneLine 合并后的一条记录。Entry 取最先出现的来源，Timestamp 为对齐后的时间
+type MergedSceneLine struct {
+	Entry     model.StoryEntry
+	Timestamp time.Time
+	Sources   []SceneLineSource
+}
+
+// SceneMergeResult 合并结果
+type SceneMergeResult struct {
+	Lines      []MergedSceneLine
+	Offsets    []time.Duration // 每份记录相对基准记录的时钟偏差（与输入顺序一致），对齐时从原始时间中减去
+	Matched    []int           // 每份记录中用于估计偏差的相同消息数
+	Duplicates int             // 被去重合并的条目数
+}
+
+type sceneItem struct {
+	source int
+	order  int
+	key    string
+	at     time.Time
+	entry  model.StoryEntry
+}
+
+// MergeSceneLogs 把多份同一场景的记录合并为一份：先以条目最多的记录为基准，
+// 依次用相同消息的时间差中位数估计其余记录的时钟偏差并对齐，再按说话者、内容与时间窗口去重。
+// 同一份记录内重复的消息不会被合并
+func MergeSceneLogs(sources []SceneMergeSource, opts SceneMergeOptions) SceneMergeResult {
+	opts = opts.withDefaults()
+	result := SceneMergeResult{
+		Offsets: make([]time.Duration, len(sources)),
+		Matched: make([]int, len(sources)),
+	}
+
+	items := make([][]sceneItem, len(sources))
+	for i, source := range sources {
+		items[i] = sceneItems(i, source.Entries)
+	}
+
+	// 条目多的记录先对齐，作为后续记录的参照
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(items[order[a]]) > len(items[order[b]]) })
+
+	pool := make(map[string][]time.Time)
+	var all []sceneItem
+	for n, index := range order {
+		if n > 0 && !opts.DisableAlign {
+			offset, matched := estimateSceneOffset(items[index], pool, opts.MaxSkew)
+			result.Offsets[index], result.Matched[index] = offset, matched
+			for i := range items[index] {
+				items[index][i].at = items[index][i].at.Add(-offset)
+			}
+		}
+		for _, item := range items[index] {
+			pool[item.key] = append(pool[item.key], item.at)
+		}
+		all = append(all, items[index]...)
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if !all[i].at.Equal(all[j].at) {
+			return all[i].at.Before(all[j].at)
+		}
+		if all[i].source != all[j].source {
+			return all[i].source < all[j].source
+		}
+		return all[i].order < all[j].order
+	})
+
+	kept := make(map[string][]int)
+	for _, item := range all {
+		source := SceneLineSource{StoryID: sources[item.source].StoryID, EntryID: item.entry.ID}
+		if index, ok := findSceneDuplicate(result.Lines, kept[item.key], item, sources, opts.Window); ok {
+			result.Lines[index].Sources = append(result.Lines[index].Sources, source)
+			result.Duplicates++
+			continue
+		}
+		kept[item.key] = append(kept[item.key], len(result.Lines))
+		result.Lines = append(result.Lines, MergedSceneLine{
+			Entry:     item.entry,
+			Timestamp: item.at,
+			Sources:   []SceneLineSource{source},
+		})
+	}
+	return result
+}
+
+// sceneItems 没有时间戳的条目沿用同一记录中上一条的时间，保持相对位置
+func sceneItems(source int, entries []model.StoryEntry) []sceneItem {
+	items := make([]sceneItem, len(entries))
+	var last time.Time
+	for i, entry := range entries {
+		at := entry.Timestamp
+		if at.IsZero() {
+			at = last
+		} else {
+			last = at
+		}
+		items[i] = sceneItem{source: source, order: i, key: sceneMessageKey(&entry), at: at, entry: entry}
+	}
+	return items
+}
+
+// sceneMessageKey 判断是否为同一条消息的依据：类型、说话者与内容（忽略大小写与多余空白）。
+// 不同玩家记录的频道可能不同（如密语的收发两端），因此不比较频道
+func sceneMessageKey(entry *model.StoryEntry) string {
+	entryType := entry.Type
+	if entryType == "" {
+		entryType = "dialogue"
+	}
+	content := strings.ToLower(strings.Join(strings.Fields(entry.Content), " "))
+	return entryType + "\x1f" + strings.ToLower(strings.TrimSpace(entry.Speaker)) + "\x1f" + content
+}
+
+// estimateSceneOffset 用与已对齐记录相同消息的时间差中位数估计时钟偏差
+func estimateSceneOffset(items []sceneItem, pool map[string][]time.Time, maxSkew time.Duration) (time.Duration, int) {
+	var diffs []time.Duration
+	for _, item := range items {
+		if item.entry.Timestamp.IsZero() {
+			continue
+		}
+		best, found := time.Duration(0), false
+		for _, at := range pool[item.key] {
+			diff := item.at.Sub(at)
+			if diff < -maxSkew || diff > maxSkew {
+				continue
+			}
+			if !found || absDuration(diff) < absDuration(best) {
+				best, found = diff, true
+			}
+		}
+		if found {
+			diffs = append(diffs, best)
+		}
+	}
+	if len(diffs) == 0 {
+		return 0, 0
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i] < diffs[j] })
+	median := diffs[len(diffs)/2]
+	if len(diffs)%2 == 0 {
+		median = (diffs[len(diffs)/2-1] + diffs[len(diffs)/2]) / 2
+	}
+	return median, len(diffs)
+}
+
+// findSceneDuplicate 在已保留的同内容条目中查找时间窗口内、且尚未包含该来源的条目
+func findSceneDuplicate(lines []MergedSceneLine, candidates []int, item sceneItem, sources []SceneMergeSource, window time.Duration) (int, bool) {
+	storyID := sources[item.source].StoryID
+	for i := len(candidates) - 1; i >= 0; i-- {
+		line := lines[candidates[i]]
+		if absDuration(item.at.Sub(line.Timestamp)) > window {
+			continue
+		}
+		fromSameSource := false
+		for _, source := range line.Sources {
+			if source.StoryID == storyID {
+				fromSameSource = true
+				break
+			}
+		}
+		if !fromSameSource {
+			return candidates[i], true
+		}
+	}
+	return 0, false
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
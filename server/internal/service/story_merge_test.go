@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+func TestMergeSceneLogs(t *testing.T) {
+	base := time.Date(2024, 5, 1, 21, 0, 0, 0, time.UTC)
+	line := func(id uint, offset time.Duration, speaker, content string) model.StoryEntry {
+		return model.StoryEntry{ID: id, Type: "dialogue", Speaker: speaker, Content: content, Channel: "SAY", Timestamp: base.Add(offset)}
+	}
+
+	// Aldric 的记录最完整；Bryn 的时钟快了 42 秒，并且漏了一条远处的说话，多了一条只有她听到的悄悄话
+	aldric := SceneMergeSource{StoryID: 1, Entries: []model.StoryEntry{
+		line(1, 0, "Aldric", "Halt!"),
+		line(2, 3*time.Second, "Bryn", "Who goes there?"),
+		line(3, 10*time.Second, "Cyra", "Over here!"),
+		line(4, 20*time.Second, "Aldric", "Yes."),
+		line(5, 22*time.Second, "Aldric", "Yes."),
+	}}
+	bryn := SceneMergeSource{StoryID: 2, Entries: []model.StoryEntry{
+		line(11, 42*time.Second, "Aldric", "halt!  "),
+		line(12, 45*time.Second, "bryn", "Who goes there?"),
+		line(13, 55*time.Second, "Bryn", "psst"),
+		line(14, 62*time.Second, "Aldric", "Yes."),
+	}}
+
+	result := MergeSceneLogs([]SceneMergeSource{aldric, bryn}, SceneMergeOptions{})
+	if result.Offsets[0] != 0 || result.Offsets[1] != 42*time.Second || result.Matched[1] != 3 {
+		t.Fatalf("unexpected offsets %v matched %v", result.Offsets, result.Matched)
+	}
+	if len(result.Lines) != 6 || result.Duplicates != 3 {
+		t.Fatalf("expected 6 lines with 3 duplicates, got %d/%d: %+v", len(result.Lines), result.Duplicates, result.Lines)
+	}
+
+	wantSpeakers := []string{"Aldric", "Bryn", "Cyra", "Bryn", "Aldric", "Aldric"}
+	wantSources := []int{2, 2, 1, 1, 2, 1}
+	for i, merged := range result.Lines {
+		if merged.Entry.Speaker != wantSpeakers[i] || len(merged.Sources) != wantSources[i] {
+			t.Fatalf("line %d: got %s with %d sources, want %s with %d", i, merged.Entry.Speaker, len(merged.Sources), wantSpeakers[i], wantSources[i])
+		}
+	}
+	if merged := result.Lines[3]; merged.Entry.Content != "psst" || !merged.Timestamp.Equal(base.Add(13*time.Second)) {
+		t.Fatalf("bryn-only line should be aligned to the reference clock, got %+v", merged)
+	}
+	// 基准记录中连续两条相同的 "Yes." 都保留，Bryn 的那条与第一条合并
+	if result.Lines[4].Sources[0].EntryID != 4 || result.Lines[4].Sources[1].EntryID != 14 || result.Lines[5].Sources[0].EntryID != 5 {
+		t.Fatalf("unexpected repeated line sources %+v %+v", result.Lines[4], result.Lines[5])
+	}
+
+	unaligned := MergeSceneLogs([]SceneMergeSource{aldric, bryn}, SceneMergeOptions{DisableAlign: true})
+	if unaligned.Duplicates != 0 || len(unaligned.Lines) != 9 {
+		t.Fatalf("without alignment the skewed copies should not match, got %d lines", len(unaligned.Lines))
+	}
+}
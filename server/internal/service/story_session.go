@@ -0,0 +1,208 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+// 分段原因
+const (
+	SessionSplitTimeGap      = "time_gap"     // 两条记录间隔超过阈值
+	SessionSplitParticipants = "participants" // 参与者明显变化
+	SessionSplitChannel      = "channel"      // 主要频道变化（如从说话切到小队）
+)
+
+// SessionSplitOptions 剧情分段参数，零值使用默认值
+type SessionSplitOptions struct {
+	Gap        time.Duration // 超过该间隔必定分段，默认 30 分钟
+	SoftGap    time.Duration // 参与者或频道变化时，超过该间隔才分段，默认 5 分钟
+	Window     int           // 比较参与者与频道时前后各取的条目数，默认 20
+	MinEntries int           // 因参与者或频道变化分段时，每段至少的条目数，默认 5
+}
+
+func (o SessionSplitOptions) withDefaults() SessionSplitOptions {
+	if o.Gap <= 0 {
+		o.Gap = 30 * time.Minute
+	}
+	if o.SoftGap <= 0 {
+		o.SoftGap = 5 * time.Minute
+	}
+	if o.SoftGap > o.Gap {
+		o.SoftGap = o.Gap
+	}
+	if o.Window <= 0 {
+		o.Window = 20
+	}
+	if o.MinEntries <= 0 {
+		o.MinEntries = 5
+	}
+	return o
+}
+
+// StorySession 一个分段，Start/End 为条目在输入切片中的下标（含两端）
+type StorySession struct {
+	Start        int
+	End          int
+	StartTime    time.Time
+	EndTime      time.Time
+	Participants []string
+	Channels     []string
+	Reason       string        // 该段与上一段分开的原因，第一段为空
+	Gap          time.Duration // 与上一段最后一条记录的间隔
+}
+
+// ProposeStorySessions 按时间间隔、参与者与频道变化为已按顺序排列的条目提出分段建议。
+// 没有时间戳的条目视为与上一条连续
+func ProposeStorySessions(entries []model.StoryEntry, opts SessionSplitOptions) []StorySession {
+	if len(entries) == 0 {
+		return nil
+	}
+	opts = opts.withDefaults()
+
+	var starts []int
+	reasons := map[int]string{}
+	gaps := map[int]time.Duration{}
+	lastStart := 0
+	var prevTime time.Time
+	for i, entry := range entries {
+		if entry.Timestamp.IsZero() {
+			continue
+		}
+		if prevTime.IsZero() {
+			prevTime = entry.Timestamp
+			continue
+		}
+		gap := entry.Timestamp.Sub(prevTime)
+		prevTime = entry.Timestamp
+
+		reason := ""
+		switch {
+		case gap >= opts.Gap:
+			reason = SessionSplitTimeGap
+		case gap >= opts.SoftGap && i-lastStart >= opts.MinEntries && len(entries)-i >= opts.MinEntries:
+			reason = sessionWindowChange(entries, i, opts.Window)
+		}
+		if reason == "" {
+			continue
+		}
+		starts = append(starts, i)
+		reasons[i] = reason
+		gaps[i] = gap
+		lastStart = i
+	}
+
+	return BuildStorySessions(entries, starts, reasons, gaps)
+}
+
+// BuildStorySessions 按给定的分段起点（条目下标，升序）切分条目并统计每段的时间、参与者与频道
+func BuildStorySessions(entries []model.StoryEntry, starts []int, reasons map[int]string, gaps map[int]time.Duration) []StorySession {
+	bounds := append([]int{0}, starts...)
+	sessions := make([]StorySession, 0, len(bounds))
+	for n, start := range bounds {
+		end := len(entries) - 1
+		if n+1 < len(bounds) {
+			end = bounds[n+1] - 1
+		}
+		session := StorySession{Start: start, End: end}
+		if n > 0 {
+			session.Reason = reasons[start]
+			session.Gap = gaps[start]
+		}
+		speakers := make(map[string]int)
+		channels := make(map[string]int)
+		for _, entry := range entries[start : end+1] {
+			if !entry.Timestamp.IsZero() {
+				if session.StartTime.IsZero() || entry.Timestamp.Before(session.StartTime) {
+					session.StartTime = entry.Timestamp
+				}
+				if entry.Timestamp.After(session.EndTime) {
+					session.EndTime = entry.Timestamp
+				}
+			}
+			if entry.Speaker != "" {
+				speakers[entry.Speaker]++
+			}
+			if entry.Type != "image" {
+				channels[normalizeExportChannel(entry.Channel)]++
+			}
+		}
+		session.Participants = rankedKeys(speakers)
+		session.Channels = rankedKeys(channels)
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// sessionWindowChange 比较下标 i 前后窗口内的说话者与频道，变化明显时返回分段原因
+func sessionWindowChange(entries []model.StoryEntry, i, window int) string {
+	before := entries[max(i-window, 0):i]
+	after := entries[i:min(i+window, len(entries))]
+
+	speakersBefore, speakersAfter := windowSpeakers(before), windowSpeakers(after)
+	if len(speakersBefore) > 0 && len(speakersAfter) > 0 {
+		shared := 0
+		for name := range speakersAfter {
+			if _, ok := speakersBefore[name]; ok {
+				shared++
+			}
+		}
+		union := len(speakersBefore) + len(speakersAfter) - shared
+		// 前后共有的说话者不到三分之一，视为换了一批人
+		if shared*3 < union {
+			return SessionSplitParticipants
+		}
+	}
+
+	if channelBefore, channelAfter := dominantChannelGroup(before), dominantChannelGroup(after); channelBefore != "" && channelAfter != "" && channelBefore != channelAfter {
+		return SessionSplitChannel
+	}
+	return ""
+}
+
+func windowSpeakers(entries []model.StoryEntry) map[string]struct{} {
+	speakers := make(map[string]struct{})
+	for _, entry := range entries {
+		if entry.Speaker != "" {
+			speakers[entry.Speaker] = struct{}{}
+		}
+	}
+	return speakers
+}
+
+// dominantChannelGroup 窗口内最多的频道类别。说话、表情与大喊同属附近频道，不因此分段
+func dominantChannelGroup(entries []model.StoryEntry) string {
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		if entry.Type == "image" {
+			continue
+		}
+		channel := normalizeExportChannel(entry.Channel)
+		switch channel {
+		case "SAY", "EMOTE", "YELL", "MONSTER_SAY", "MONSTER_EMOTE", "MONSTER_YELL":
+			channel = "NEARBY"
+		}
+		counts[channel]++
+	}
+	ranked := rankedKeys(counts)
+	if len(ranked) == 0 {
+		return ""
+	}
+	return ranked[0]
+}
+
+// rankedKeys 按出现次数降序、同次数按名称排序
+func rankedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
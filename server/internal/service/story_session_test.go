@@ -0,0 +1,59 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+func TestProposeStorySessions(t *testing.T) {
+	base := time.Date(2024, 3, 2, 20, 0, 0, 0, time.UTC)
+	var entries []model.StoryEntry
+	add := func(offset time.Duration, speaker, channel string) {
+		entries = append(entries, model.StoryEntry{
+			ID: uint(len(entries) + 1), Type: "dialogue", Speaker: speaker, Channel: channel, Timestamp: base.Add(offset),
+		})
+	}
+	// 第一幕：酒馆里 Aldric 与 Bryn 说话
+	for i := 0; i < 6; i++ {
+		add(time.Duration(i)*time.Minute, []string{"Aldric", "Bryn"}[i%2], "SAY")
+	}
+	// 间隔 8 分钟，换成 Cyra 与 Dane 在小队频道
+	for i := 0; i < 6; i++ {
+		add(13*time.Minute+time.Duration(i)*time.Minute, []string{"Cyra", "Dane"}[i%2], "PARTY")
+	}
+	// 间隔 2 小时，同一批人继续
+	for i := 0; i < 3; i++ {
+		add(3*time.Hour+time.Duration(i)*time.Minute, "Cyra", "PARTY")
+	}
+	// 没有时间戳的旁白跟随上一段
+	entries = append(entries, model.StoryEntry{ID: 99, Type: "narration", Content: "夜深了"})
+
+	sessions := ProposeStorySessions(entries, SessionSplitOptions{})
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 sessions, got %+v", sessions)
+	}
+	if sessions[1].Start != 6 || sessions[1].Reason != SessionSplitParticipants || sessions[1].Gap != 8*time.Minute {
+		t.Fatalf("unexpected second session %+v", sessions[1])
+	}
+	if sessions[2].Start != 12 || sessions[2].End != 15 || sessions[2].Reason != SessionSplitTimeGap {
+		t.Fatalf("unexpected third session %+v", sessions[2])
+	}
+	if !reflect.DeepEqual(sessions[0].Participants, []string{"Aldric", "Bryn"}) || !reflect.DeepEqual(sessions[1].Channels, []string{"PARTY"}) {
+		t.Fatalf("unexpected session summary %+v", sessions[0])
+	}
+	if !sessions[2].StartTime.Equal(base.Add(3*time.Hour)) || !sessions[2].EndTime.Equal(base.Add(3*time.Hour+2*time.Minute)) {
+		t.Fatalf("unexpected session times %+v", sessions[2])
+	}
+
+	// 同样的间隔，但说话者与频道没有变化，不分段
+	for i := 6; i < 12; i++ {
+		entries[i].Speaker = entries[i-6].Speaker
+		entries[i].Channel = "EMOTE"
+	}
+	if sessions := ProposeStorySessions(entries, SessionSplitOptions{}); len(sessions) != 2 {
+		t.Fatalf("expected only the time gap split, got %+v", sessions)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+// StoryStats 剧情统计，可汇总单个剧情、用户的全部剧情或公会归档
+type StoryStats struct {
+	StoryCount      int            `json:"story_count"`
+	EntryCount      int            `json:"entry_count"`
+	CharCount       int            `json:"char_count"`       // 正文字数（按字符计，不含图片条目）
+	DurationSeconds int64          `json:"duration_seconds"` // 各剧情时长之和
+	FirstEntryAt    *time.Time     `json:"first_entry_at"`
+	LastEntryAt     *time.Time     `json:"last_entry_at"`
+	Speakers        []SpeakerStats `json:"speakers"`
+	Channels        []StatsCount   `json:"channels"`
+	Types           []StatsCount   `json:"types"`
+	Heatmap         [7][24]int     `json:"heatmap"` // 按星期（0 为周日）与小时统计的条目数
+}
+
+// SpeakerStats 单个说话者的发言统计，关联角色时按角色合并
+type SpeakerStats struct {
+	Speaker     string `json:"speaker"`
+	CharacterID *uint  `json:"character_id"`
+	Lines       int    `json:"lines"`
+	Chars       int    `json:"chars"`
+}
+
+// StatsCount 分类计数
+type StatsCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// storySpan 剧情时长的计算依据
+type storySpan struct {
+	start, end  time.Time // 剧情设置的起止时间
+	first, last time.Time // 条目时间戳范围，剧情未设置起止时间时使用
+}
+
+// StoryStatsBuilder 逐条累加剧情与条目，最后由 Result 生成统计
+type StoryStatsBuilder struct {
+	loc      *time.Location
+	stats    StoryStats
+	speakers map[string]*SpeakerStats
+	channels map[string]int
+	types    map[string]int
+	spans    map[uint]*storySpan
+}
+
+// NewStoryStatsBuilder 创建统计器，loc 为热力图使用的时区，为空时使用 UTC
+func NewStoryStatsBuilder(loc *time.Location) *StoryStatsBuilder {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &StoryStatsBuilder{
+		loc:      loc,
+		speakers: map[string]*SpeakerStats{},
+		channels: map[string]int{},
+		types:    map[string]int{},
+		spans:    map[uint]*storySpan{},
+	}
+}
+
+// AddStory 记录一个剧情，用于计数与时长
+func (b *StoryStatsBuilder) AddStory(story model.Story) {
+	if _, ok := b.spans[story.ID]; ok {
+		return
+	}
+	b.stats.StoryCount++
+	b.spans[story.ID] = &storySpan{start: story.StartTime, end: story.EndTime}
+}
+
+// AddEntry 累加一条剧情条目
+func (b *StoryStatsBuilder) AddEntry(entry model.StoryEntry) {
+	b.stats.EntryCount++
+	chars := 0
+	if entry.Type != "image" {
+		chars = utf8.RuneCountInString(strings.TrimSpace(entry.Content))
+	}
+	b.stats.CharCount += chars
+	b.channels[entry.Channel]++
+	b.types[entry.Type]++
+
+	speaker := strings.TrimSpace(entry.Speaker)
+	if key := speakerStatsKey(entry.CharacterID, speaker); key != "" {
+		stat, ok := b.speakers[key]
+		if !ok {
+			stat = &SpeakerStats{Speaker: speaker, CharacterID: entry.CharacterID}
+			b.speakers[key] = stat
+		}
+		if stat.Speaker == "" {
+			stat.Speaker = speaker
+		}
+		stat.Lines++
+		stat.Chars += chars
+	}
+
+	if entry.Timestamp.IsZero() {
+		return
+	}
+	local := entry.Timestamp.In(b.loc)
+	b.stats.Heatmap[local.Weekday()][local.Hour()]++
+	if b.stats.FirstEntryAt == nil || entry.Timestamp.Before(*b.stats.FirstEntryAt) {
+		first := entry.Timestamp
+		b.stats.FirstEntryAt = &first
+	}
+	if b.stats.LastEntryAt == nil || entry.Timestamp.After(*b.stats.LastEntryAt) {
+		last := entry.Timestamp
+		b.stats.LastEntryAt = &last
+	}
+	if span, ok := b.spans[entry.StoryID]; ok {
+		if span.first.IsZero() || entry.Timestamp.Before(span.first) {
+			span.first = entry.Timestamp
+		}
+		if entry.Timestamp.After(span.last) {
+			span.last = entry.Timestamp
+		}
+	}
+}
+
+// Result 生成统计结果，说话者按发言数、分类按数量从多到少排列
+func (b *StoryStatsBuilder) Result() StoryStats {
+	stats := b.stats
+	stats.DurationSeconds = 0
+	for _, span := range b.spans {
+		start, end := span.start, span.end
+		if start.IsZero() || end.IsZero() || !end.After(start) {
+			start, end = span.first, span.last
+		}
+		if !start.IsZero() && end.After(start) {
+			stats.DurationSeconds += int64(end.Sub(start) / time.Second)
+		}
+	}
+
+	stats.Speakers = make([]SpeakerStats, 0, len(b.speakers))
+	for _, stat := range b.speakers {
+		stats.Speakers = append(stats.Speakers, *stat)
+	}
+	sort.Slice(stats.Speakers, func(i, j int) bool {
+		a, c := stats.Speakers[i], stats.Speakers[j]
+		if a.Lines != c.Lines {
+			return a.Lines > c.Lines
+		}
+		if a.Chars != c.Chars {
+			return a.Chars > c.Chars
+		}
+		return a.Speaker < c.Speaker
+	})
+	stats.Channels = sortedStatsCounts(b.channels)
+	stats.Types = sortedStatsCounts(b.types)
+	return stats
+}
+
+// speakerStatsKey 关联角色的条目按角色合并，否则按说话者名字；旁白等无说话者的条目不计入
+func speakerStatsKey(characterID *uint, speaker string) string {
+	if characterID != nil {
+		return fmt.Sprintf("character:%d", *characterID)
+	}
+	if speaker == "" {
+		return ""
+	}
+	return "speaker:" + speaker
+}
+
+func sortedStatsCounts(counts map[string]int) []StatsCount {
+	result := make([]StatsCount, 0, len(counts))
+	for key, count := range counts {
+		result = append(result, StatsCount{Key: key, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rpbox/server/internal/model"
+)
+
+func TestStoryStatsBuilder(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	// 2024-03-02 是周六，UTC 20 点即上海时间周日 4 点
+	base := time.Date(2024, 3, 2, 20, 0, 0, 0, time.UTC)
+	aldric := uint(7)
+
+	builder := NewStoryStatsBuilder(shanghai)
+	builder.AddStory(model.Story{ID: 1, StartTime: base, EndTime: base.Add(90 * time.Minute)})
+	builder.AddStory(model.Story{ID: 2}) // 未设置起止时间，按条目时间戳计算
+	builder.AddStory(model.Story{ID: 1})
+	for _, entry := range []model.StoryEntry{
+		{StoryID: 1, Type: "dialogue", CharacterID: &aldric, Speaker: "Aldric", Content: "站住！", Channel: "SAY", Timestamp: base},
+		{StoryID: 1, Type: "dialogue", CharacterID: &aldric, Speaker: "Aldric the Bold", Content: " 谁在那里？ ", Channel: "YELL", Timestamp: base.Add(time.Minute)},
+		{StoryID: 1, Type: "dialogue", Speaker: "Bryn", Content: "是我", Channel: "SAY", Timestamp: base.Add(2 * time.Minute)},
+		{StoryID: 2, Type: "narration", Content: "夜深了", Timestamp: base.Add(24 * time.Hour)},
+		{StoryID: 2, Type: "image", Speaker: "Bryn", Content: "https://example.com/map.png", Channel: "SAY", Timestamp: base.Add(24*time.Hour + 20*time.Minute)},
+	} {
+		builder.AddEntry(entry)
+	}
+
+	stats := builder.Result()
+	if stats.StoryCount != 2 || stats.EntryCount != 5 || stats.CharCount != 3+5+2+3 {
+		t.Fatalf("unexpected totals %+v", stats)
+	}
+	if stats.DurationSeconds != int64((90*time.Minute+20*time.Minute)/time.Second) {
+		t.Fatalf("unexpected duration %d", stats.DurationSeconds)
+	}
+	if len(stats.Speakers) != 2 || stats.Speakers[0].Speaker != "Aldric" || stats.Speakers[0].Lines != 2 || stats.Speakers[0].Chars != 8 ||
+		stats.Speakers[1].Speaker != "Bryn" || stats.Speakers[1].Lines != 2 || stats.Speakers[1].Chars != 2 {
+		t.Fatalf("unexpected speakers %+v", stats.Speakers)
+	}
+	if len(stats.Channels) != 3 || stats.Channels[0] != (StatsCount{Key: "SAY", Count: 3}) || stats.Channels[1].Key != "" {
+		t.Fatalf("unexpected channels %+v", stats.Channels)
+	}
+	if len(stats.Types) != 3 || stats.Types[0] != (StatsCount{Key: "dialogue", Count: 3}) {
+		t.Fatalf("unexpected types %+v", stats.Types)
+	}
+	if stats.Heatmap[time.Sunday][4] != 3 || stats.Heatmap[time.Monday][4] != 2 {
+		t.Fatalf("unexpected heatmap %+v", stats.Heatmap)
+	}
+	if !stats.FirstEntryAt.Equal(base) || !stats.LastEntryAt.Equal(base.Add(24*time.Hour+20*time.Minute)) {
+		t.Fatalf("unexpected entry range %v %v", stats.FirstEntryAt, stats.LastEntryAt)
+	}
+}
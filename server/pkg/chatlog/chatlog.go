@@ -0,0 +1,256 @@
+// Package chatlog 解析其他 WoW 聊天记录格式（WoWChatLog.txt、Elephant、Listener 的 SavedVariables），
+// 统一输出为按时间排序的聊天行。各格式的解析器通过 Register 注册，可按名称选择或根据内容自动识别。
+package chatlog
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 条目类型，与剧情条目的 Type 一致
+const (
+	TypeDialogue  = "dialogue"
+	TypeNarration = "narration"
+)
+
+// ErrUnknownFormat 无法识别的聊天记录格式
+var ErrUnknownFormat = errors.New("unknown chat log format")
+
+// Line 解析得到的一行聊天记录
+type Line struct {
+	SourceID  string    `json:"source_id"` // 原记录自带的唯一 ID，没有时为空
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Channel   string    `json:"channel"` // SAY、EMOTE、YELL、WHISPER、PARTY、RAID、GUILD 等
+	Speaker   string    `json:"speaker"`
+	GameID    string    `json:"game_id"` // 角色名-服务器，无法确定时为空
+	IsNPC     bool      `json:"is_npc"`
+	Content   string    `json:"content"`
+}
+
+// Options 解析选项
+type Options struct {
+	// Year WoWChatLog.txt 旧格式的时间不带年份时使用的年份，为 0 时取当前年份
+	Year int
+	// Location 不带时区的时间所在时区，为空时使用服务器时区
+	Location *time.Location
+	// Realm 说话者不带服务器名时补全 GameID 所用的服务器
+	Realm string
+	// Character 记录所属角色名，用于自己发出的密语；为空时从记录中推断
+	Character string
+}
+
+func (o Options) location() *time.Location {
+	if o.Location != nil {
+		return o.Location
+	}
+	return time.Local
+}
+
+// Parser 一种聊天记录格式的解析器
+type Parser interface {
+	// Name 格式名称，用于请求参数
+	Name() string
+	// Detect 根据文件内容判断是否是该格式
+	Detect(data []byte) bool
+	// Parse 解析文件内容
+	Parse(data []byte, opts Options) ([]Line, error)
+}
+
+var parsers []Parser
+
+// Register 注册解析器，自动识别时按注册顺序尝试
+func Register(p Parser) {
+	parsers = append(parsers, p)
+}
+
+// Formats 已注册的格式名称
+func Formats() []string {
+	names := make([]string, len(parsers))
+	for i, p := range parsers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// Lookup 按名称查找解析器
+func Lookup(name string) (Parser, bool) {
+	for _, p := range parsers {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Detect 根据文件内容识别格式
+func Detect(data []byte) (Parser, bool) {
+	for _, p := range parsers {
+		if p.Detect(data) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Parse 解析聊天记录；format 为空时自动识别。结果按时间排序（时间相同保持原顺序）
+func Parse(data []byte, format string, opts Options) (string, []Line, error) {
+	var p Parser
+	var ok bool
+	if format == "" {
+		p, ok = Detect(data)
+	} else {
+		p, ok = Lookup(format)
+	}
+	if !ok {
+		return "", nil, ErrUnknownFormat
+	}
+
+	lines, err := p.Parse(data, opts)
+	if err != nil {
+		return p.Name(), nil, err
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Timestamp.Before(lines[j].Timestamp) })
+	return p.Name(), lines, nil
+}
+
+func init() {
+	Register(wowChatLogParser{})
+	Register(elephantParser{})
+	Register(listenerParser{})
+}
+
+var (
+	colorPattern     = regexp.MustCompile(`\|c[0-9a-fA-F]{8}|\|r`)
+	texturePattern   = regexp.MustCompile(`\|T[^|]*\|t|\|A[^|]*\|a`)
+	hyperlinkPattern = regexp.MustCompile(`\|H[^|]*\|h(.*?)\|h`)
+)
+
+// StripMarkup 去掉 WoW 颜色、材质和超链接转义（超链接保留显示文字）以及控制字符
+func StripMarkup(s string) string {
+	s = hyperlinkPattern.ReplaceAllString(s, "$1")
+	s = texturePattern.ReplaceAllString(s, "")
+	s = colorPattern.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "||", "|")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' {
+			return -1
+		}
+		if r >= 0xE000 && r <= 0xF8FF || r == 0xFFFD {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// normalizeChannel 把聊天事件名统一为频道名（与插件记录的频道一致）
+func normalizeChannel(event string) string {
+	event = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(event)), "CHAT_MSG_")
+	switch event {
+	case "TEXT_EMOTE", "MONSTER_EMOTE", "RAID_BOSS_EMOTE":
+		return "EMOTE"
+	case "WHISPER_INFORM", "MONSTER_WHISPER", "RAID_BOSS_WHISPER", "BN_WHISPER", "BN_WHISPER_INFORM":
+		return "WHISPER"
+	case "MONSTER_SAY":
+		return "SAY"
+	case "MONSTER_YELL":
+		return "YELL"
+	case "MONSTER_PARTY", "PARTY_LEADER":
+		return "PARTY"
+	case "RAID_LEADER", "RAID_WARNING":
+		return "RAID"
+	case "INSTANCE_CHAT", "INSTANCE_CHAT_LEADER":
+		return "INSTANCE"
+	}
+	return event
+}
+
+// narrationEvents 没有说话者的系统类消息，作为旁白导入
+var narrationEvents = map[string]bool{
+	"SYSTEM": true, "LOOT": true, "MONEY": true, "SKILL": true, "ACHIEVEMENT": true,
+	"GUILD_ACHIEVEMENT": true, "COMBAT_XP_GAIN": true, "COMBAT_FACTION_CHANGE": true,
+}
+
+// buildLine 由事件名、说话者和内容组装一行，处理 NPC 消息、系统消息和 TRP3 的 NPC 发言（以 "|| " 开头的表情）
+func buildLine(at time.Time, event, speaker, content string, opts Options) (Line, bool) {
+	event = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(event)), "CHAT_MSG_")
+	content = StripMarkup(content)
+	speaker = StripMarkup(speaker)
+	if content == "" {
+		return Line{}, false
+	}
+
+	line := Line{
+		Timestamp: at,
+		Type:      TypeDialogue,
+		Channel:   normalizeChannel(event),
+		Speaker:   speaker,
+		Content:   content,
+	}
+	switch {
+	case narrationEvents[event]:
+		line.Type, line.Channel, line.Speaker = TypeNarration, "", ""
+		return line, true
+	case speaker == "":
+		line.Type = TypeNarration
+		return line, true
+	case strings.HasPrefix(event, "MONSTER_") || strings.HasPrefix(event, "RAID_BOSS_"):
+		line.IsNPC = true
+		line.GameID = speaker
+		return line, true
+	case line.Channel == "EMOTE" && strings.HasPrefix(content, "| "):
+		return npcSpeech(line), true
+	}
+	line.GameID = gameID(speaker, opts.Realm)
+	line.Speaker = displayName(speaker)
+	return line, true
+}
+
+var npcSpeechPattern = regexp.MustCompile(`^(.+?) (says|yells|whispers|说|大喊|低语)[:：]\s*(.*)$`)
+
+var npcSpeechChannels = map[string]string{
+	"says": "SAY", "说": "SAY", "yells": "YELL", "大喊": "YELL", "whispers": "WHISPER", "低语": "WHISPER",
+}
+
+// npcSpeech TRP3 的 NPC 发言以表情频道发送、内容以 "|| " 开头（去掉转义后为 "| "）；
+// 形如 "|| 守卫 说：站住" 的作为 NPC 对话，其余作为旁白
+func npcSpeech(line Line) Line {
+	content := strings.TrimSpace(strings.TrimPrefix(line.Content, "|"))
+	if m := npcSpeechPattern.FindStringSubmatch(content); m != nil {
+		line.Speaker = strings.TrimSpace(m[1])
+		line.GameID = line.Speaker
+		line.Channel = npcSpeechChannels[m[2]]
+		line.Content = strings.TrimSpace(m[3])
+		line.IsNPC = true
+		return line
+	}
+	line.Type, line.Speaker, line.Content = TypeNarration, "", content
+	return line
+}
+
+// gameID 玩家说话者的 角色名-服务器；名字中没有服务器时使用 realm 补全，仍无法确定时返回空字符串
+func gameID(speaker, realm string) string {
+	speaker = strings.TrimSpace(speaker)
+	if speaker == "" || strings.ContainsAny(speaker, " \t") {
+		return ""
+	}
+	if strings.Contains(speaker, "-") {
+		return speaker
+	}
+	if realm = strings.TrimSpace(realm); realm != "" {
+		return speaker + "-" + realm
+	}
+	return ""
+}
+
+// displayName 去掉 角色名-服务器 中的服务器部分
+func displayName(speaker string) string {
+	if dash := strings.Index(speaker, "-"); dash > 0 {
+		return speaker[:dash]
+	}
+	return speaker
+}
@@ -0,0 +1,135 @@
+package chatlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWoWChatLog(t *testing.T) {
+	data := []byte("10/19 21:58:04.422  Aldric says: Halt!\r\n" +
+		"10/19 21:58:05.000  Bryn-RealmB yells: |cffff0000Intruder|r!\n" +
+		"10/19 21:58:06.100  Aldric raises a lantern.\n" +
+		"10/19 21:58:07.000  [Party] Bryn: Regroup\n" +
+		"10/19 21:58:08.000  [2. Trade] Seller: WTS\n" +
+		"10/19 21:58:09.000  To Bryn: meet me\n" +
+		"10/19 21:58:10.000  Aldric || Guard says: Move along\n" +
+		"10/20/2023 08:00:00.000  [艾德里克]说：你好\n" +
+		"10/20/2023 08:00:01.000  艾德里克举起灯笼。\n" +
+		"10/20/2023 08:00:02.000  你悄悄地对[布林]说：快走\n" +
+		"garbage line\n")
+
+	format, lines, err := Parse(data, "", Options{Year: 2023, Location: time.UTC, Realm: "RealmA", Character: "Aldric"})
+	if err != nil || format != "wowchatlog" {
+		t.Fatalf("parse: format=%s err=%v", format, err)
+	}
+
+	want := []Line{
+		{Type: TypeDialogue, Channel: "SAY", Speaker: "Aldric", GameID: "Aldric-RealmA", Content: "Halt!"},
+		{Type: TypeDialogue, Channel: "YELL", Speaker: "Bryn", GameID: "Bryn-RealmB", Content: "Intruder!"},
+		{Type: TypeDialogue, Channel: "EMOTE", Speaker: "Aldric", GameID: "Aldric-RealmA", Content: "raises a lantern."},
+		{Type: TypeDialogue, Channel: "PARTY", Speaker: "Bryn", GameID: "Bryn-RealmA", Content: "Regroup"},
+		{Type: TypeDialogue, Channel: "CHANNEL", Speaker: "Seller", GameID: "Seller-RealmA", Content: "WTS"},
+		{Type: TypeDialogue, Channel: "WHISPER", Speaker: "Aldric", GameID: "Aldric-RealmA", Content: "meet me"},
+		{Type: TypeDialogue, Channel: "SAY", Speaker: "Guard", GameID: "Guard", IsNPC: true, Content: "Move along"},
+		{Type: TypeDialogue, Channel: "SAY", Speaker: "艾德里克", GameID: "艾德里克-RealmA", Content: "你好"},
+		{Type: TypeDialogue, Channel: "EMOTE", Speaker: "艾德里克", GameID: "艾德里克-RealmA", Content: "举起灯笼。"},
+		{Type: TypeDialogue, Channel: "WHISPER", Speaker: "Aldric", GameID: "Aldric-RealmA", Content: "快走"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %d: %+v", len(want), len(lines), lines)
+	}
+	for i, line := range lines {
+		got := line
+		got.Timestamp = time.Time{}
+		if got != want[i] {
+			t.Fatalf("line %d: got %+v want %+v", i, got, want[i])
+		}
+	}
+	if !lines[0].Timestamp.Equal(time.Date(2023, 10, 19, 21, 58, 4, 422*int(time.Millisecond), time.UTC)) {
+		t.Fatalf("unexpected timestamp %v", lines[0].Timestamp)
+	}
+}
+
+func TestParseElephant(t *testing.T) {
+	data := []byte(`
+ElephantDBPerChar = {
+	["char"] = {
+		["Aldric - Realm A"] = {
+			["logs"] = {
+				["say"] = {
+					["logs"] = {
+						{ ["time"] = 1700000000, ["type"] = "SAY", ["arg1"] = "Halt!", ["arg2"] = "Aldric-RealmA" },
+						{ ["time"] = 1700000005, ["type"] = "WHISPER_INFORM", ["arg1"] = "psst", ["arg2"] = "Bryn-RealmA" },
+						{ ["time"] = 1700000006, ["type"] = "SYSTEM", ["arg1"] = "Bryn has come online." },
+					},
+				},
+				["custom"] = {
+					["logs"] = {
+						{ ["time"] = 1700000000, ["type"] = "SAY", ["arg1"] = "Halt!", ["arg2"] = "Aldric-RealmA" },
+						{ ["time"] = 1700000003, ["type"] = "MONSTER_YELL", ["arg1"] = "You dare?", ["arg2"] = "Ragnaros" },
+					},
+				},
+			},
+		},
+	},
+}
+`)
+	format, lines, err := Parse(data, "", Options{})
+	if err != nil || format != "elephant" {
+		t.Fatalf("parse: format=%s err=%v", format, err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected duplicates removed, got %+v", lines)
+	}
+	if lines[0].Speaker != "Aldric" || lines[0].Channel != "SAY" || lines[0].GameID != "Aldric-RealmA" {
+		t.Fatalf("unexpected say line %+v", lines[0])
+	}
+	if !lines[1].IsNPC || lines[1].Channel != "YELL" || lines[1].Speaker != "Ragnaros" {
+		t.Fatalf("unexpected npc line %+v", lines[1])
+	}
+	if lines[2].Speaker != "Aldric" || lines[2].Channel != "WHISPER" {
+		t.Fatalf("outgoing whisper should be spoken by the log owner, got %+v", lines[2])
+	}
+	if lines[3].Type != TypeNarration || lines[3].Speaker != "" {
+		t.Fatalf("system message should be narration, got %+v", lines[3])
+	}
+}
+
+func TestParseListener(t *testing.T) {
+	data := []byte(`
+ListenerAddonSaved = {
+	["chat_history"] = {
+		["Bryn-RealmA"] = {
+			{ 1, 1700000010, "TEXT_EMOTE", "Bryn-RealmA", "waves." },
+			{ 2, 1700000000, "PARTY_LEADER", "Bryn-RealmA", "Ready?" },
+			{ ["id"] = 3, ["t"] = 1700000020, ["e"] = "EMOTE", ["s"] = "Aldric-RealmA", ["m"] = "|| The door creaks open." },
+		},
+	},
+}
+`)
+	format, lines, err := Parse(data, "listener", Options{})
+	if err != nil || format != "listener" {
+		t.Fatalf("parse: format=%s err=%v", format, err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %+v", lines)
+	}
+	if lines[0].Channel != "PARTY" || lines[0].Content != "Ready?" || lines[0].SourceID != "listener::2" {
+		t.Fatalf("unexpected first line %+v", lines[0])
+	}
+	if lines[1].Channel != "EMOTE" || lines[1].Speaker != "Bryn" {
+		t.Fatalf("unexpected emote %+v", lines[1])
+	}
+	if lines[2].Type != TypeNarration || lines[2].Content != "The door creaks open." {
+		t.Fatalf("TRP3 narration should be imported as narration, got %+v", lines[2])
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	if _, _, err := Parse([]byte("hello"), "", Options{}); err != ErrUnknownFormat {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+	if _, _, err := Parse([]byte("hello"), "nope", Options{}); err != ErrUnknownFormat {
+		t.Fatalf("expected ErrUnknownFormat for unknown name, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package chatlog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpbox/server/pkg/luatable"
+)
+
+// elephantParser 解析 Elephant 插件的 SavedVariables（Elephant.lua）。
+// 每条记录形如 {time=时间戳, type="SAY", arg1=内容, arg2="角色名-服务器"}，同一消息可能同时出现在多个日志中，按内容去重。
+type elephantParser struct{}
+
+func (elephantParser) Name() string { return "elephant" }
+
+var elephantVarPattern = regexp.MustCompile(`(?m)^\s*ElephantDB\w*\s*=`)
+
+func (elephantParser) Detect(data []byte) bool {
+	return elephantVarPattern.Match(data)
+}
+
+func (elephantParser) Parse(data []byte, opts Options) ([]Line, error) {
+	vars, err := luatable.ParseFile(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []Line
+	seen := make(map[string]struct{})
+	walkSavedVariables(vars, "", func(record map[string]interface{}, owner string) {
+		at, ok := recordTime(record["time"])
+		if !ok {
+			return
+		}
+		event, _ := record["type"].(string)
+		content, _ := record["arg1"].(string)
+		if event == "" || content == "" {
+			return
+		}
+		sender, _ := record["arg2"].(string)
+
+		key := fmt.Sprintf("%d|%s|%s|%s", at.Unix(), event, sender, content)
+		if _, dup := seen[key]; dup {
+			return
+		}
+		seen[key] = struct{}{}
+
+		if line, ok := buildLine(at, event, savedVariablesSpeaker(event, sender, owner, opts), content, opts); ok {
+			lines = append(lines, line)
+		}
+	})
+	return lines, nil
+}
+
+// listenerParser 解析 Listener 插件的 SavedVariables（Listener.lua）。
+// 兼容两种记录格式：{id, 时间戳, 事件, 发送者, 内容} 数组，以及 {id=, t=, e=, s=, m=} 表。
+type listenerParser struct{}
+
+func (listenerParser) Name() string { return "listener" }
+
+var listenerVarPattern = regexp.MustCompile(`(?m)^\s*Listener\w*\s*=`)
+
+func (listenerParser) Detect(data []byte) bool {
+	return listenerVarPattern.Match(data)
+}
+
+func (listenerParser) Parse(data []byte, opts Options) ([]Line, error) {
+	vars, err := luatable.ParseFile(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []Line
+	seen := make(map[string]struct{})
+	add := func(id interface{}, ts interface{}, event, sender, content, owner string) {
+		at, ok := recordTime(ts)
+		if !ok || event == "" || content == "" {
+			return
+		}
+		sourceID := ""
+		if n, ok := id.(float64); ok && n > 0 {
+			sourceID = "listener:" + owner + ":" + strconv.FormatInt(int64(n), 10)
+		}
+		key := sourceID
+		if key == "" {
+			key = fmt.Sprintf("%d|%s|%s|%s", at.Unix(), event, sender, content)
+		}
+		if _, dup := seen[key]; dup {
+			return
+		}
+		seen[key] = struct{}{}
+
+		if line, ok := buildLine(at, event, savedVariablesSpeaker(event, sender, owner, opts), content, opts); ok {
+			line.SourceID = sourceID
+			lines = append(lines, line)
+		}
+	}
+
+	var walkLists func(value interface{}, owner string)
+	walkLists = func(value interface{}, owner string) {
+		switch v := value.(type) {
+		case []interface{}:
+			if len(v) >= 5 {
+				event, _ := v[2].(string)
+				sender, _ := v[3].(string)
+				content, _ := v[4].(string)
+				if _, isNumber := v[1].(float64); isNumber && event != "" {
+					add(v[0], v[1], event, sender, content, owner)
+					return
+				}
+			}
+			for _, item := range v {
+				walkLists(item, owner)
+			}
+		case map[string]interface{}:
+			if event, ok := v["e"].(string); ok {
+				sender, _ := v["s"].(string)
+				content, _ := v["m"].(string)
+				add(v["id"], v["t"], event, sender, content, owner)
+				return
+			}
+			for _, key := range sortedKeys(v) {
+				walkLists(v[key], ownerFromKey(key, owner))
+			}
+		}
+	}
+	walkLists(vars, "")
+	return lines, nil
+}
+
+// walkSavedVariables 深度优先遍历所有表，对每个表调用 visit；owner 为路径上最近的 "角色名 - 服务器" 键
+func walkSavedVariables(value interface{}, owner string, visit func(map[string]interface{}, string)) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			walkSavedVariables(item, owner, visit)
+		}
+	case map[string]interface{}:
+		visit(v, owner)
+		for _, key := range sortedKeys(v) {
+			walkSavedVariables(v[key], ownerFromKey(key, owner), visit)
+		}
+	}
+}
+
+var characterKeyPattern = regexp.MustCompile(`^(\S+) - (\S.*)$`)
+
+// ownerFromKey AceDB 按 "角色名 - 服务器" 存放每个角色的数据，记下来用于自己发出的密语
+func ownerFromKey(key, owner string) string {
+	if m := characterKeyPattern.FindStringSubmatch(key); m != nil {
+		return m[1] + "-" + strings.ReplaceAll(m[2], " ", "")
+	}
+	return owner
+}
+
+// savedVariablesSpeaker 自己发出的密语记录的是对方的名字，说话者改为记录所属角色
+func savedVariablesSpeaker(event, sender, owner string, opts Options) string {
+	switch strings.TrimPrefix(strings.ToUpper(event), "CHAT_MSG_") {
+	case "WHISPER_INFORM", "BN_WHISPER_INFORM":
+		if opts.Character != "" {
+			return opts.Character
+		}
+		return owner
+	}
+	return sender
+}
+
+// recordTime 解析 Unix 时间戳（秒）
+func recordTime(value interface{}) (time.Time, bool) {
+	n, ok := value.(float64)
+	if !ok || n <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(n), 0), true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
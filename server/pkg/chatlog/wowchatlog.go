@@ -0,0 +1,174 @@
+package chatlog
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wowChatLogParser 解析游戏内 /chatlog 生成的 WoWChatLog.txt。
+// 每行形如 "10/19 21:58:04.422  Aldric says: Hello"，新版客户端在日期中带年份（10/19/2023）。
+// 文件只记录显示文本，频道与说话者由英文或中文客户端的显示格式推断。
+type wowChatLogParser struct{}
+
+func (wowChatLogParser) Name() string { return "wowchatlog" }
+
+func (wowChatLogParser) Detect(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	checked, matched := 0, 0
+	for scanner.Scan() && checked < 20 {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		checked++
+		if chatLogLinePattern.MatchString(text) {
+			matched++
+		}
+	}
+	return checked > 0 && matched*2 > checked
+}
+
+var chatLogLinePattern = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})(?:/(\d{2,4}))?\s+(\d{1,2}):(\d{2}):(\d{2})(?:\.(\d{1,3}))?(?:-\d+)?\s+(.*)$`)
+
+var (
+	// [Party] Aldric: Hi / [公会] [Aldric]：你好 / [2. Trade] Aldric: WTS
+	chatLogBracketPattern = regexp.MustCompile(`^\[([^\]]+)\]\s*\[?([^\s\]:：]+)\]?\s*[:：]\s?(.*)$`)
+	// Aldric says: Hi / [Aldric]说：你好（名字取最短匹配，以便“悄悄地说”优先于“说”）
+	chatLogSpeechPattern = regexp.MustCompile(`^\[?([^\s\]:：]+?)\]?\s?(says|yells|whispers|悄悄地说|说|大喊)[:：]\s?(.*)$`)
+	// To Aldric: Hi / 你悄悄地对[Aldric]说：你好
+	chatLogWhisperToPattern = regexp.MustCompile(`^(?:To |你悄悄地对)\[?([^\s\]:：]+?)\]?\s?说?[:：]\s?(.*)$`)
+	// Aldric raises a lantern.
+	chatLogEmotePattern   = regexp.MustCompile(`^\[?([^\s\]]+?)\]?\s(.+)$`)
+	chatLogChannelPattern = regexp.MustCompile(`^\d+\.\s*`)
+)
+
+var chatLogBracketChannels = map[string]string{
+	"party": "PARTY", "party leader": "PARTY", "小队": "PARTY", "队长": "PARTY",
+	"raid": "RAID", "raid leader": "RAID", "raid warning": "RAID", "团队": "RAID", "团队领袖": "RAID", "团队通知": "RAID",
+	"guild": "GUILD", "公会": "GUILD", "officer": "OFFICER", "官员": "OFFICER",
+	"instance": "INSTANCE", "instance leader": "INSTANCE", "副本": "INSTANCE", "副本向导": "INSTANCE",
+}
+
+var chatLogSpeechEvents = map[string]string{
+	"says": "SAY", "说": "SAY", "yells": "YELL", "大喊": "YELL", "whispers": "WHISPER", "悄悄地说": "WHISPER",
+}
+
+type chatLogRecord struct {
+	at   time.Time
+	text string
+}
+
+func (wowChatLogParser) Parse(data []byte, opts Options) ([]Line, error) {
+	year := opts.Year
+	if year == 0 {
+		year = time.Now().In(opts.location()).Year()
+	}
+
+	var records []chatLogRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := chatLogLinePattern.FindStringSubmatch(strings.TrimRight(scanner.Text(), "\r"))
+		if m == nil {
+			continue
+		}
+		records = append(records, chatLogRecord{at: chatLogTime(m, year, opts.location()), text: m[8]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// 第一遍：收集有明确格式的发言，记下出现过的说话者
+	lines := make([]*Line, len(records))
+	speakers := make(map[string]struct{})
+	for i, record := range records {
+		if line, ok := parseChatLogMessage(record, opts); ok {
+			lines[i] = &line
+			if line.Type == TypeDialogue && !line.IsNPC {
+				speakers[line.Speaker] = struct{}{}
+			}
+		}
+	}
+
+	// 第二遍：其余行按表情处理。中文客户端的表情名字后没有空格，按已知说话者的最长前缀拆分
+	result := make([]Line, 0, len(records))
+	for i, record := range records {
+		if lines[i] != nil {
+			result = append(result, *lines[i])
+			continue
+		}
+		text := StripMarkup(record.text)
+		speaker, content := "", text
+		if name := longestSpeakerPrefix(text, speakers); name != "" {
+			speaker, content = name, strings.TrimSpace(strings.TrimPrefix(text, name))
+		} else if m := chatLogEmotePattern.FindStringSubmatch(text); m != nil && !strings.HasPrefix(text, "|") {
+			speaker, content = m[1], m[2]
+		}
+		if speaker == "" {
+			if line, ok := buildLine(record.at, "SYSTEM", "", text, opts); ok {
+				result = append(result, line)
+			}
+			continue
+		}
+		if line, ok := buildLine(record.at, "EMOTE", speaker, content, opts); ok {
+			result = append(result, line)
+		}
+	}
+	return result, nil
+}
+
+func parseChatLogMessage(record chatLogRecord, opts Options) (Line, bool) {
+	text := StripMarkup(record.text)
+
+	if m := chatLogBracketPattern.FindStringSubmatch(text); m != nil {
+		label := strings.ToLower(strings.TrimSpace(m[1]))
+		if channel, ok := chatLogBracketChannels[label]; ok {
+			return buildLine(record.at, channel, m[2], m[3], opts)
+		}
+		if chatLogChannelPattern.MatchString(label) {
+			return buildLine(record.at, "CHANNEL", m[2], m[3], opts)
+		}
+	}
+	if m := chatLogWhisperToPattern.FindStringSubmatch(text); m != nil {
+		return buildLine(record.at, "WHISPER_INFORM", opts.Character, m[2], opts)
+	}
+	if m := chatLogSpeechPattern.FindStringSubmatch(text); m != nil {
+		return buildLine(record.at, chatLogSpeechEvents[m[2]], m[1], m[3], opts)
+	}
+	return Line{}, false
+}
+
+func longestSpeakerPrefix(text string, speakers map[string]struct{}) string {
+	best := ""
+	for name := range speakers {
+		if len(name) > len(best) && strings.HasPrefix(text, name) && len(text) > len(name) {
+			best = name
+		}
+	}
+	return best
+}
+
+func chatLogTime(m []string, defaultYear int, loc *time.Location) time.Time {
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	year := defaultYear
+	if m[3] != "" {
+		year = atoi(m[3])
+		if year < 100 {
+			year += 2000
+		}
+	}
+	millis := m[7]
+	for len(millis) > 0 && len(millis) < 3 {
+		millis += "0"
+	}
+	return time.Date(year, time.Month(atoi(m[1])), atoi(m[2]), atoi(m[4]), atoi(m[5]), atoi(m[6]),
+		atoi(millis)*int(time.Millisecond), loc)
+}
@@ -0,0 +1,77 @@
+// Package codec 提供请求体与落库数据的压缩编解码（gzip / zstd）。
+package codec
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 支持的内容编码
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+)
+
+// ErrUnsupportedEncoding 不支持的内容编码
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// zstd 编解码器可以并发使用，EncodeAll/DecodeAll 不需要每次新建
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// NormalizeEncoding 规范化 Content-Encoding 取值，空值视为 identity
+func NormalizeEncoding(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" {
+		return EncodingIdentity
+	}
+	return encoding
+}
+
+// Supported 判断是否支持该内容编码
+func Supported(encoding string) bool {
+	switch NormalizeEncoding(encoding) {
+	case EncodingIdentity, EncodingGzip, EncodingZstd:
+		return true
+	}
+	return false
+}
+
+// NewReader 按内容编码包装解压读取器，identity 时原样返回
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch NormalizeEncoding(encoding) {
+	case EncodingIdentity:
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, nil
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+}
+
+// CompressZstd 使用 zstd 压缩数据
+func CompressZstd(data []byte) []byte {
+	return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/4))
+}
+
+// DecompressZstd 解压 zstd 数据
+func DecompressZstd(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
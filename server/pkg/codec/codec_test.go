@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewReaderDecodesSupportedEncodings(t *testing.T) {
+	payload := strings.Repeat(`{"profiles_data":"TRP3"}`, 100)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte(payload))
+	_ = w.Close()
+
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	if err != nil {
+		t.Fatalf("zstd writer: %v", err)
+	}
+	_, _ = zw.Write([]byte(payload))
+	_ = zw.Close()
+
+	cases := map[string][]byte{
+		"":     []byte(payload),
+		"gzip": gz.Bytes(),
+		"ZSTD": zs.Bytes(),
+	}
+	for encoding, body := range cases {
+		r, err := NewReader(encoding, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%q: new reader: %v", encoding, err)
+		}
+		got, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatalf("%q: read: %v", encoding, err)
+		}
+		if string(got) != payload {
+			t.Fatalf("%q: unexpected payload %q", encoding, got)
+		}
+	}
+
+	if _, err := NewReader("br", bytes.NewReader(nil)); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Fatalf("expected unsupported encoding error, got %v", err)
+	}
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("TRP3_Extended_Tools_Database", 1000))
+	compressed := CompressZstd(data)
+	if len(compressed) >= len(data) {
+		t.Fatalf("expected compression, got %d >= %d", len(compressed), len(data))
+	}
+	decompressed, err := DecompressZstd(compressed)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("round trip mismatch")
+	}
+}
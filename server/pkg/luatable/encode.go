@@ -0,0 +1,177 @@
+package luatable
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encode 把解析得到的 Go 值重新编码为 Lua 表字面量（WoW SavedVariables 风格）。
+// map 中形如 "1" 的整数键会写成数字键 [1]，以便与解析结果往返一致。
+func Encode(value interface{}) (string, error) {
+	var sb strings.Builder
+	if err := encodeValue(&sb, value, 0); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// EncodeFile 把多个顶层变量编码为 SavedVariables 文件内容（按变量名排序）
+func EncodeFile(vars map[string]interface{}) (string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !isIdentifier(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString(" = ")
+		if err := encodeValue(&sb, vars[name], 0); err != nil {
+			return "", err
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func encodeValue(sb *strings.Builder, value interface{}, indent int) error {
+	switch v := value.(type) {
+	case nil:
+		sb.WriteString("nil")
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	case string:
+		writeQuoted(sb, v)
+	case float64:
+		return writeNumber(sb, v)
+	case float32:
+		return writeNumber(sb, float64(v))
+	case int:
+		sb.WriteString(strconv.Itoa(v))
+	case int64:
+		sb.WriteString(strconv.FormatInt(v, 10))
+	case uint:
+		sb.WriteString(strconv.FormatUint(uint64(v), 10))
+	case []interface{}:
+		if len(v) == 0 {
+			sb.WriteString("{}")
+			return nil
+		}
+		sb.WriteString("{\n")
+		for i, item := range v {
+			writeIndent(sb, indent+1)
+			if err := encodeValue(sb, item, indent+1); err != nil {
+				return err
+			}
+			sb.WriteString(", -- [")
+			sb.WriteString(strconv.Itoa(i + 1))
+			sb.WriteString("]\n")
+		}
+		writeIndent(sb, indent)
+		sb.WriteString("}")
+	case map[string]interface{}:
+		if len(v) == 0 {
+			sb.WriteString("{}")
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		sb.WriteString("{\n")
+		for _, k := range keys {
+			writeIndent(sb, indent+1)
+			sb.WriteString("[")
+			if n, ok := integerKey(k); ok {
+				sb.WriteString(strconv.FormatInt(n, 10))
+			} else {
+				writeQuoted(sb, k)
+			}
+			sb.WriteString("] = ")
+			if err := encodeValue(sb, v[k], indent+1); err != nil {
+				return err
+			}
+			sb.WriteString(",\n")
+		}
+		writeIndent(sb, indent)
+		sb.WriteString("}")
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+	return nil
+}
+
+func writeNumber(sb *strings.Builder, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("unsupported number %v", v)
+	}
+	sb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	return nil
+}
+
+func writeIndent(sb *strings.Builder, indent int) {
+	for i := 0; i < indent; i++ {
+		sb.WriteByte('\t')
+	}
+}
+
+func writeQuoted(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				// 使用三位十进制转义，避免与后续数字字符连在一起
+				fmt.Fprintf(sb, "\\%03d", c)
+				continue
+			}
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// integerKey 判断 map 键是否是由 formatKey 生成的整数键
+func integerKey(k string) (int64, bool) {
+	n, err := strconv.ParseInt(k, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != k {
+		return 0, false
+	}
+	return n, true
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentPart(s[i]) {
+			return false
+		}
+	}
+	switch s {
+	case "and", "break", "do", "else", "elseif", "end", "false", "for", "function", "goto", "if",
+		"in", "local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package luatable
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	vars, err := ParseFile(sampleSavedVariables)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	encoded, err := EncodeFile(vars)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "TRP3_Configuration = {") {
+		t.Fatalf("expected variables sorted by name, got %q", encoded[:40])
+	}
+
+	reparsed, err := ParseFile(encoded)
+	if err != nil {
+		t.Fatalf("reparse encoded output: %v\n%s", err, encoded)
+	}
+	if !reflect.DeepEqual(vars, reparsed) {
+		t.Fatalf("round trip mismatch:\nwant %#v\ngot  %#v", vars, reparsed)
+	}
+}
+
+func TestEncodeKeepsSparseNumericKeys(t *testing.T) {
+	value, err := ParseValue(`{[1] = "a", [3] = "c", ["name"] = "x\0y"}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	encoded, err := Encode(value)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !strings.Contains(encoded, `[3] = "c"`) || !strings.Contains(encoded, `"x\000y"`) {
+		t.Fatalf("unexpected encoding %s", encoded)
+	}
+
+	reparsed, err := ParseValue(encoded)
+	if err != nil {
+		t.Fatalf("reparse: %v", err)
+	}
+	if !reflect.DeepEqual(value, reparsed) {
+		t.Fatalf("round trip mismatch: %#v vs %#v", value, reparsed)
+	}
+}
+
+func TestEncodeRejectsUnsupportedValues(t *testing.T) {
+	if _, err := Encode(map[string]interface{}{"f": func() {}}); err == nil {
+		t.Fatal("expected unsupported type to fail")
+	}
+	if _, err := EncodeFile(map[string]interface{}{"not valid": 1}); err == nil {
+		t.Fatal("expected invalid variable name to fail")
+	}
+}
@@ -0,0 +1,420 @@
+package luatable
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokString
+	tokNumber
+	tokTrue
+	tokFalse
+	tokNil
+	tokIdent
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokEquals
+	tokComma
+	tokSemicolon
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number"
+	case tokTrue:
+		return "true"
+	case tokFalse:
+		return "false"
+	case tokNil:
+		return "nil"
+	case tokIdent:
+		return "identifier"
+	case tokLBrace:
+		return "'{'"
+	case tokRBrace:
+		return "'}'"
+	case tokLBracket:
+		return "'['"
+	case tokRBracket:
+		return "']'"
+	case tokEquals:
+		return "'='"
+	case tokComma:
+		return "','"
+	case tokSemicolon:
+		return "';'"
+	}
+	return "unknown token"
+}
+
+type token struct {
+	kind tokenKind
+	str  string
+	num  float64
+	line int
+}
+
+// SyntaxError 描述解析失败的位置和原因
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("lua syntax error at line %d: %s", e.Line, e.Msg)
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func newLexer(src string) *lexer {
+	src = strings.TrimPrefix(src, "\ufeff")
+	return &lexer{src: src, line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) peekByte(offset int) byte {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			l.pos += 2
+			if l.peekByte(0) == '[' {
+				if level, ok := l.longBracketLevel(); ok {
+					if _, err := l.readLongBracket(level); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// longBracketLevel 判断当前位置是否为长括号开头（[[ 或 [==[），返回等号数量
+func (l *lexer) longBracketLevel() (int, bool) {
+	if l.peekByte(0) != '[' {
+		return 0, false
+	}
+	level := 0
+	for l.peekByte(level+1) == '=' {
+		level++
+	}
+	if l.peekByte(level+1) != '[' {
+		return 0, false
+	}
+	return level, true
+}
+
+func (l *lexer) readLongBracket(level int) (string, error) {
+	startLine := l.line
+	l.pos += level + 2
+	// 紧跟开括号的换行不计入内容
+	if l.peekByte(0) == '\r' {
+		l.pos++
+	}
+	if l.peekByte(0) == '\n' {
+		l.pos++
+		l.line++
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		return "", &SyntaxError{Line: startLine, Msg: "unfinished long string"}
+	}
+	content := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(content, "\n")
+	l.pos += end + len(closing)
+	return content, nil
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}, nil
+	}
+
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case c == '{':
+		l.pos++
+		return token{kind: tokLBrace, line: line}, nil
+	case c == '}':
+		l.pos++
+		return token{kind: tokRBrace, line: line}, nil
+	case c == '[':
+		if level, ok := l.longBracketLevel(); ok {
+			s, err := l.readLongBracket(level)
+			if err != nil {
+				return token{}, err
+			}
+			return token{kind: tokString, str: s, line: line}, nil
+		}
+		l.pos++
+		return token{kind: tokLBracket, line: line}, nil
+	case c == ']':
+		l.pos++
+		return token{kind: tokRBracket, line: line}, nil
+	case c == '=':
+		l.pos++
+		return token{kind: tokEquals, line: line}, nil
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, line: line}, nil
+	case c == ';':
+		l.pos++
+		return token{kind: tokSemicolon, line: line}, nil
+	case c == '"' || c == '\'':
+		s, err := l.readString(c)
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokString, str: s, line: line}, nil
+	case isDigit(c) || c == '-' || (c == '.' && isDigit(l.peekByte(1))):
+		n, err := l.readNumber()
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokNumber, num: n, line: line}, nil
+	case isIdentStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		switch word {
+		case "true":
+			return token{kind: tokTrue, line: line}, nil
+		case "false":
+			return token{kind: tokFalse, line: line}, nil
+		case "nil":
+			return token{kind: tokNil, line: line}, nil
+		}
+		return token{kind: tokIdent, str: word, line: line}, nil
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, l.errorf("unexpected character %q", r)
+}
+
+func (l *lexer) readString(quote byte) (string, error) {
+	startLine := l.line
+	l.pos++
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return "", &SyntaxError{Line: startLine, Msg: "unfinished string"}
+		}
+		c := l.src[l.pos]
+		switch c {
+		case quote:
+			l.pos++
+			return sb.String(), nil
+		case '\n':
+			return "", &SyntaxError{Line: startLine, Msg: "unfinished string"}
+		case '\\':
+			l.pos++
+			if err := l.readEscape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) readEscape(sb *strings.Builder) error {
+	if l.pos >= len(l.src) {
+		return l.errorf("unfinished escape sequence")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '"', '\'':
+		sb.WriteByte(c)
+	case '\n':
+		l.line++
+		sb.WriteByte('\n')
+	case '\r':
+		if l.peekByte(0) == '\n' {
+			l.pos++
+		}
+		l.line++
+		sb.WriteByte('\n')
+	case 'z':
+		for l.pos < len(l.src) {
+			ch := l.src[l.pos]
+			if ch == '\n' {
+				l.line++
+			} else if ch != ' ' && ch != '\t' && ch != '\r' && ch != '\f' && ch != '\v' {
+				break
+			}
+			l.pos++
+		}
+	case 'x':
+		if l.pos+2 > len(l.src) {
+			return l.errorf("invalid hexadecimal escape")
+		}
+		v, err := strconv.ParseUint(l.src[l.pos:l.pos+2], 16, 8)
+		if err != nil {
+			return l.errorf("invalid hexadecimal escape")
+		}
+		sb.WriteByte(byte(v))
+		l.pos += 2
+	case 'u':
+		if l.peekByte(0) != '{' {
+			return l.errorf("missing '{' in \\u{xxxx}")
+		}
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if end < 0 {
+			return l.errorf("missing '}' in \\u{xxxx}")
+		}
+		v, err := strconv.ParseUint(l.src[l.pos+1:l.pos+end], 16, 32)
+		if err != nil || v > utf8.MaxRune {
+			return l.errorf("invalid unicode escape")
+		}
+		sb.WriteRune(rune(v))
+		l.pos += end + 1
+	default:
+		if !isDigit(c) {
+			return l.errorf("invalid escape sequence '\\%c'", c)
+		}
+		start := l.pos - 1
+		for l.pos < len(l.src) && l.pos-start < 3 && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		v, _ := strconv.Atoi(l.src[start:l.pos])
+		if v > 255 {
+			return l.errorf("decimal escape too large")
+		}
+		sb.WriteByte(byte(v))
+	}
+	return nil
+}
+
+func (l *lexer) readNumber() (float64, error) {
+	start := l.pos
+	negative := false
+	if l.src[l.pos] == '-' {
+		negative = true
+		l.pos++
+		for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+			l.pos++
+		}
+	}
+
+	numStart := l.pos
+	if l.peekByte(0) == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.pos += 2
+		for l.pos < len(l.src) && isHexDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		v, err := strconv.ParseUint(l.src[numStart+2:l.pos], 16, 64)
+		if err != nil {
+			return 0, l.errorf("malformed number near %q", l.src[start:l.pos])
+		}
+		if negative {
+			return -float64(v), nil
+		}
+		return float64(v), nil
+	}
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if isDigit(c) || c == '.' {
+			l.pos++
+			continue
+		}
+		if (c == 'e' || c == 'E') && l.pos > numStart {
+			l.pos++
+			if l.peekByte(0) == '+' || l.peekByte(0) == '-' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+	text := l.src[numStart:l.pos]
+	if text == "" {
+		return 0, l.errorf("malformed number near '-'")
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, l.errorf("malformed number near %q", l.src[start:l.pos])
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
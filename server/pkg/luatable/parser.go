@@ -0,0 +1,289 @@
+// Package luatable 解析 WoW SavedVariables 中的 Lua 表字面量（不依赖 Lua 运行时）。
+//
+// 解析结果使用与 encoding/json 相同的通用类型：
+// 表 -> map[string]interface{} 或 []interface{}（键为连续的 1..n 时），
+// 数字 -> float64，字符串 -> string，布尔 -> bool，nil -> nil。
+package luatable
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ErrVariableNotFound 指定的全局变量不存在
+var ErrVariableNotFound = errors.New("variable not found")
+
+// maxDepth 限制表嵌套深度，防止恶意输入导致栈溢出
+const maxDepth = 200
+
+type parser struct {
+	lex   *lexer
+	tok   token
+	depth int
+}
+
+func newParser(src string) (*parser, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.tok.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return p.errorf("expected %s, got %s", kind, p.tok.kind)
+	}
+	return p.advance()
+}
+
+// ParseFile 解析 SavedVariables 文件，返回所有顶层变量
+func ParseFile(src string) (map[string]interface{}, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]interface{})
+	for p.tok.kind != tokEOF {
+		if p.tok.kind == tokSemicolon {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("expected variable name, got %s", p.tok.kind)
+		}
+		name := p.tok.str
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokEquals); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			delete(vars, name)
+			continue
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// ParseVariable 解析 SavedVariables 文件并返回指定变量
+func ParseVariable(src, name string) (interface{}, error) {
+	vars, err := ParseFile(src)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := vars[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVariableNotFound, name)
+	}
+	return value, nil
+}
+
+// ParseValue 解析单个 Lua 值（通常是一个表字面量）
+func ParseValue(src string) (interface{}, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s after value", p.tok.kind)
+	}
+	return value, nil
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		return tok.str, p.advance()
+	case tokNumber:
+		return tok.num, p.advance()
+	case tokTrue:
+		return true, p.advance()
+	case tokFalse:
+		return false, p.advance()
+	case tokNil:
+		return nil, p.advance()
+	case tokLBrace:
+		return p.parseTable()
+	}
+	return nil, p.errorf("expected value, got %s", tok.kind)
+}
+
+type tableField struct {
+	key   interface{} // string 或 float64
+	value interface{}
+}
+
+func (p *parser) parseTable() (interface{}, error) {
+	p.depth++
+	if p.depth > maxDepth {
+		return nil, p.errorf("table nesting too deep")
+	}
+	defer func() { p.depth-- }()
+
+	if err := p.expect(tokLBrace); err != nil {
+		return nil, err
+	}
+
+	var fields []tableField
+	nextIndex := 1
+	for p.tok.kind != tokRBrace {
+		field, positional, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if positional {
+			field.key = float64(nextIndex)
+			nextIndex++
+		}
+		fields = append(fields, field)
+
+		if p.tok.kind == tokComma || p.tok.kind == tokSemicolon {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tok.kind != tokRBrace {
+			return nil, p.errorf("expected '}' to close table, got %s", p.tok.kind)
+		}
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return buildTable(fields), nil
+}
+
+func (p *parser) parseField() (tableField, bool, error) {
+	switch p.tok.kind {
+	case tokLBracket:
+		if err := p.advance(); err != nil {
+			return tableField{}, false, err
+		}
+		var key interface{}
+		switch p.tok.kind {
+		case tokString:
+			key = p.tok.str
+		case tokNumber:
+			if math.IsNaN(p.tok.num) {
+				return tableField{}, false, p.errorf("table index is NaN")
+			}
+			key = p.tok.num
+		case tokTrue:
+			key = "true"
+		case tokFalse:
+			key = "false"
+		default:
+			return tableField{}, false, p.errorf("table key must be a string or number, got %s", p.tok.kind)
+		}
+		if err := p.advance(); err != nil {
+			return tableField{}, false, err
+		}
+		if err := p.expect(tokRBracket); err != nil {
+			return tableField{}, false, err
+		}
+		if err := p.expect(tokEquals); err != nil {
+			return tableField{}, false, err
+		}
+		value, err := p.parseValue()
+		return tableField{key: key, value: value}, false, err
+	case tokIdent:
+		key := p.tok.str
+		if err := p.advance(); err != nil {
+			return tableField{}, false, err
+		}
+		if err := p.expect(tokEquals); err != nil {
+			return tableField{}, false, err
+		}
+		value, err := p.parseValue()
+		return tableField{key: key, value: value}, false, err
+	}
+	value, err := p.parseValue()
+	return tableField{value: value}, true, err
+}
+
+// buildTable 把字段列表转换为 Go 值：键恰好为 1..n 时返回切片，否则返回 map
+func buildTable(fields []tableField) interface{} {
+	// 后出现的键覆盖先出现的键，值为 nil 的键视为不存在（与 Lua 语义一致）
+	byKey := make(map[string]interface{}, len(fields))
+	numeric := make(map[int]interface{}, len(fields))
+	allNumeric := true
+	for _, f := range fields {
+		var key string
+		switch k := f.key.(type) {
+		case string:
+			key = k
+			allNumeric = false
+		case float64:
+			key = formatKey(k)
+			if k == math.Trunc(k) && k >= 1 && k <= math.MaxInt32 {
+				if f.value == nil {
+					delete(numeric, int(k))
+				} else {
+					numeric[int(k)] = f.value
+				}
+			} else {
+				allNumeric = false
+			}
+		}
+		if f.value == nil {
+			delete(byKey, key)
+			continue
+		}
+		byKey[key] = f.value
+	}
+
+	if allNumeric && len(numeric) > 0 {
+		indexes := make([]int, 0, len(numeric))
+		for i := range numeric {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		if indexes[len(indexes)-1] == len(indexes) {
+			list := make([]interface{}, len(indexes))
+			for _, i := range indexes {
+				list[i-1] = numeric[i]
+			}
+			return list
+		}
+	}
+	return byKey
+}
+
+// formatKey 把数字键格式化为字符串（整数不带小数部分）
+func formatKey(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
@@ -0,0 +1,157 @@
+package luatable
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const sampleSavedVariables = `
+TRP3_Profiles = {
+	["0211141526BrCyA"] = {
+		["profileName"] = "Aldric - 暴风城",
+		["player"] = {
+			["characteristics"] = {
+				["FN"] = "Aldric",
+				["LN"] = "Voss",
+				["RA"] = "人类",
+				["CL"] = "圣骑士",
+				["v"] = 12,
+				["MI"] = {
+					{
+						["NA"] = "Nickname",
+						["VA"] = "Al",
+					}, -- [1]
+				},
+			},
+			["about"] = {
+				["TE"] = 1,
+				["T1"] = {
+					["TX"] = "{h1}Hello{/h1}\nLine \"two\"",
+				},
+			},
+		},
+	},
+}
+TRP3_Configuration = {
+	["AddonLocale"] = "zhCN",
+	["tooltip_char_HideOriginal"] = true,
+	["register_mature_filter"] = false,
+	["ratio"] = -0.5,
+	["hex"] = 0x1F,
+}
+`
+
+func TestParseFile(t *testing.T) {
+	vars, err := ParseFile(sampleSavedVariables)
+	if err != nil {
+		t.Fatalf("parse file: %v", err)
+	}
+
+	profiles, ok := vars["TRP3_Profiles"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected TRP3_Profiles table, got %T", vars["TRP3_Profiles"])
+	}
+	profile := profiles["0211141526BrCyA"].(map[string]interface{})
+	if profile["profileName"] != "Aldric - 暴风城" {
+		t.Fatalf("unexpected profile name %v", profile["profileName"])
+	}
+	characteristics := profile["player"].(map[string]interface{})["characteristics"].(map[string]interface{})
+	if characteristics["v"] != float64(12) {
+		t.Fatalf("expected numeric version 12, got %v", characteristics["v"])
+	}
+	misc, ok := characteristics["MI"].([]interface{})
+	if !ok || len(misc) != 1 {
+		t.Fatalf("expected MI to decode as a one-item list, got %#v", characteristics["MI"])
+	}
+	about := profile["player"].(map[string]interface{})["about"].(map[string]interface{})
+	text := about["T1"].(map[string]interface{})["TX"]
+	if text != "{h1}Hello{/h1}\nLine \"two\"" {
+		t.Fatalf("unexpected about text %q", text)
+	}
+
+	config := vars["TRP3_Configuration"].(map[string]interface{})
+	if config["tooltip_char_HideOriginal"] != true || config["register_mature_filter"] != false {
+		t.Fatalf("unexpected booleans: %#v", config)
+	}
+	if config["ratio"] != -0.5 || config["hex"] != float64(31) {
+		t.Fatalf("unexpected numbers: ratio=%v hex=%v", config["ratio"], config["hex"])
+	}
+}
+
+func TestParseValueTableShapes(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want interface{}
+	}{
+		{"positional list", `{"a", "b"; "c"}`, []interface{}{"a", "b", "c"}},
+		{"explicit indexes", `{[2] = "b", [1] = "a"}`, []interface{}{"a", "b"}},
+		{"sparse indexes", `{[1] = "a", [3] = "c"}`, map[string]interface{}{"1": "a", "3": "c"}},
+		{"mixed keys", `{"a", key = "v"}`, map[string]interface{}{"1": "a", "key": "v"}},
+		{"nil fields dropped", `{a = nil, b = 1}`, map[string]interface{}{"b": float64(1)}},
+		{"empty table", `{}`, map[string]interface{}{}},
+		{"long string", "{[[line1\nline2]], [==[a]]b]==]}", []interface{}{"line1\nline2", "a]]b"}},
+		{"escapes", `{"\65\066\x43\u{4E2D}", 'it\'s'}`, []interface{}{"ABC中", "it's"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseValue(tc.src)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tc.src, err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSkipsComments(t *testing.T) {
+	src := "-- header\n--[[ block\ncomment ]]\nVar = { 1, -- [1]\n 2, -- [2]\n}\n"
+	value, err := ParseVariable(src, "Var")
+	if err != nil {
+		t.Fatalf("parse variable: %v", err)
+	}
+	if !reflect.DeepEqual(value, []interface{}{float64(1), float64(2)}) {
+		t.Fatalf("unexpected value %#v", value)
+	}
+}
+
+func TestParseVariableNotFound(t *testing.T) {
+	_, err := ParseVariable(`A = 1`, "B")
+	if !errors.Is(err, ErrVariableNotFound) {
+		t.Fatalf("expected ErrVariableNotFound, got %v", err)
+	}
+}
+
+func TestParseRejectsMalformedInput(t *testing.T) {
+	cases := []string{
+		`{ ["a"] = }`,
+		`{ "unterminated }`,
+		`{ a = 1 b = 2 }`,
+		`{ [{}] = 1 }`,
+		`{ a = print("x") }`,
+		`{ 1, 2 } trailing`,
+		`{`,
+	}
+	for _, src := range cases {
+		_, err := ParseValue(src)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("expected syntax error for %q, got %v", src, err)
+		}
+	}
+}
+
+func TestParseReportsLine(t *testing.T) {
+	_, err := ParseFile("A = {\n\tb = 1,\n\tc = ?,\n}")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+	if syntaxErr.Line != 3 {
+		t.Fatalf("expected error on line 3, got %d", syntaxErr.Line)
+	}
+}
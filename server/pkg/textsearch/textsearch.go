@@ -0,0 +1,233 @@
+// Package textsearch 提供不依赖词典的全文检索分词与摘要高亮。
+// 拉丁字母与数字按单词切分并转小写；中日韩文字没有空格分词，索引时切成单字与相邻二元组，
+// 查询时按二元组匹配，这样不需要数据库安装中文分词扩展也能检索。
+package textsearch
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Document 把多段文本切分为去重后的索引词，以空格连接，适合存入文本列并用 to_tsvector('simple', ...) 建索引
+func Document(texts ...string) string {
+	seen := make(map[string]struct{})
+	var tokens []string
+	add := func(token string) {
+		if _, ok := seen[token]; ok {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	for _, text := range texts {
+		for _, run := range segment(text) {
+			if !run.cjk {
+				add(string(run.runes))
+				continue
+			}
+			for i := range run.runes {
+				add(string(run.runes[i]))
+				if i+1 < len(run.runes) {
+					add(string(run.runes[i : i+2]))
+				}
+			}
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Term 查询词。Prefix 为 true 时按前缀匹配（拉丁单词），否则需完整匹配索引词
+type Term struct {
+	Token  string
+	Prefix bool
+}
+
+// Query 把用户输入切分为查询词：单词按前缀匹配，中日韩文字单字直接匹配、多字按相邻二元组匹配
+func Query(query string) []Term {
+	seen := make(map[string]struct{})
+	var terms []Term
+	add := func(term Term) {
+		if _, ok := seen[term.Token]; ok {
+			return
+		}
+		seen[term.Token] = struct{}{}
+		terms = append(terms, term)
+	}
+	for _, run := range segment(query) {
+		if !run.cjk {
+			add(Term{Token: string(run.runes), Prefix: true})
+			continue
+		}
+		if len(run.runes) == 1 {
+			add(Term{Token: string(run.runes)})
+			continue
+		}
+		for i := 0; i+1 < len(run.runes); i++ {
+			add(Term{Token: string(run.runes[i : i+2])})
+		}
+	}
+	return terms
+}
+
+// TSQuery 把查询词拼成 to_tsquery('simple', ...) 的表达式，各词之间为 AND
+func TSQuery(terms []Term) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		// 索引词只含字母、数字与中日韩文字，引号包裹后无需再转义
+		parts[i] = "'" + term.Token + "'"
+		if term.Prefix {
+			parts[i] += ":*"
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Highlight 截取 text 中第一个命中附近的片段，命中部分用 <mark> 包裹，其余内容做 HTML 转义。
+// radius 为命中前后保留的字符数；没有命中时返回开头的片段
+func Highlight(text, query string, radius int) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches [][2]int
+	for _, needle := range highlightNeedles(query) {
+		for start := 0; start+len(needle) <= len(lower); {
+			if !hasPrefix(lower[start:], needle) {
+				start++
+				continue
+			}
+			matches = append(matches, [2]int{start, start + len(needle)})
+			start += len(needle)
+		}
+	}
+	matches = mergeRanges(matches)
+
+	from, to := 0, len(runes)
+	if radius > 0 {
+		anchor := 0
+		if len(matches) > 0 {
+			anchor = matches[0][0]
+		}
+		from = max(anchor-radius, 0)
+		to = min(anchor+radius*2, len(runes))
+		if len(matches) > 0 && matches[0][1] > to {
+			to = matches[0][1]
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range matches {
+		if m[1] <= from || m[0] >= to {
+			continue
+		}
+		start, end := max(m[0], from), min(m[1], to)
+		b.WriteString(html.EscapeString(string(runes[pos:start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[start:end])))
+		b.WriteString("</mark>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// highlightNeedles 高亮时优先匹配完整的中文词组，词组未连续出现时退回到查询用的二元组
+func highlightNeedles(query string) [][]rune {
+	var needles [][]rune
+	for _, run := range segment(query) {
+		needles = append(needles, run.runes)
+		if run.cjk && len(run.runes) > 2 {
+			for i := 0; i+1 < len(run.runes); i++ {
+				needles = append(needles, run.runes[i:i+2])
+			}
+		}
+	}
+	// 长的先匹配，避免短词把长词切碎
+	sort.SliceStable(needles, func(i, j int) bool { return len(needles[i]) > len(needles[j]) })
+	return needles
+}
+
+type textRun struct {
+	runes []rune
+	cjk   bool
+}
+
+// segment 把文本切成连续的单词或中日韩文字片段，并统一转为小写
+func segment(text string) []textRun {
+	var runs []textRun
+	var current []rune
+	currentCJK := false
+	flush := func() {
+		if len(current) > 0 {
+			runs = append(runs, textRun{runes: current, cjk: currentCJK})
+			current = nil
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !currentCJK {
+				flush()
+			}
+			currentCJK = true
+			current = append(current, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if currentCJK {
+				flush()
+			}
+			currentCJK = false
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return runs
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func hasPrefix(s, prefix []rune) bool {
+	if len(prefix) == 0 || len(s) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if s[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeRanges(ranges [][2]int) [][2]int {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := [][2]int{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
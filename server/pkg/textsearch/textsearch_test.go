@@ -0,0 +1,43 @@
+package textsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	got := Document("Aldric", "提到了黑色收获, the Black Harvest!")
+	want := "aldric 提 提到 到 到了 了 了黑 黑 黑色 色 色收 收 收获 获 the black harvest"
+	if got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	got := Query("黑色收获 Harv 灯")
+	want := []Term{
+		{Token: "黑色"}, {Token: "色收"}, {Token: "收获"},
+		{Token: "harv", Prefix: true},
+		{Token: "灯"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+	if q := TSQuery(got); q != "'黑色' & '色收' & '收获' & 'harv':* & '灯'" {
+		t.Fatalf("unexpected tsquery %q", q)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := Highlight("Aldric mentioned the <Black Harvest> again", "black harvest", 0)
+	want := "Aldric mentioned the &lt;<mark>Black</mark> <mark>Harvest</mark>&gt; again"
+	if got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	got = Highlight("很久以前，艾德里克在营地提到了黑色收获，然后离开了。", "黑色收获", 4)
+	want = "…地提到了<mark>黑色收获</mark>，然后离…"
+	if got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
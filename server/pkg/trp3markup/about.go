@@ -0,0 +1,203 @@
+package trp3markup
+
+import (
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// about 模板 3 的三个段落
+var aboutSections = []struct {
+	key   string
+	label string
+}{
+	{"PH", "外貌"},
+	{"PS", "性格"},
+	{"HI", "历史"},
+}
+
+// RenderAbout 渲染 TRP3 about 数据（TE=1 单页文本、TE=2 多个框架、TE=3 外貌/性格/历史三段）
+func RenderAbout(about map[string]interface{}, opts Options) Rendered {
+	if about == nil {
+		return Rendered{}
+	}
+
+	var out blocks
+	switch number(about["TE"]) {
+	case 2:
+		for _, frame := range list(about["T2"]) {
+			m, _ := frame.(map[string]interface{})
+			out.add("trp3-about-frame", "", str(m["IC"]), Render(str(m["TX"]), opts), opts)
+		}
+	case 3:
+		t3, _ := about["T3"].(map[string]interface{})
+		for _, section := range aboutSections {
+			m, _ := t3[section.key].(map[string]interface{})
+			out.add("trp3-about-section", section.label, str(m["IC"]), Render(str(m["TX"]), opts), opts)
+		}
+	default:
+		m, _ := about["T1"].(map[string]interface{})
+		out.add("trp3-about-text", "", "", Render(str(m["TX"]), opts), opts)
+	}
+
+	return out.result("trp3-about trp3-about-" + strconv.Itoa(template(about)))
+}
+
+// RenderMiscInfo 渲染 characteristics.MI（[{NA=名称, VA=值, IC=图标}, ...]）
+func RenderMiscInfo(value interface{}, opts Options) Rendered {
+	var htmlOut, textOut strings.Builder
+	for _, item := range list(value) {
+		m, _ := item.(map[string]interface{})
+		name, val := str(m["NA"]), Render(str(m["VA"]), opts)
+		if strings.TrimSpace(name) == "" && val.Text == "" {
+			continue
+		}
+		htmlOut.WriteString("<dt>")
+		writeIcon(&htmlOut, str(m["IC"]), opts)
+		htmlOut.WriteString(html.EscapeString(name))
+		htmlOut.WriteString("</dt><dd>")
+		htmlOut.WriteString(val.HTML)
+		htmlOut.WriteString("</dd>")
+		textOut.WriteString(name + ": " + val.Text + "\n")
+	}
+	if htmlOut.Len() == 0 {
+		return Rendered{}
+	}
+	return Rendered{
+		HTML: `<dl class="trp3-misc">` + htmlOut.String() + "</dl>",
+		Text: strings.TrimSpace(textOut.String()),
+	}
+}
+
+// RenderPsycho 渲染 characteristics.PS 中的自定义性格特征（LT/RT 两端名称，V2 取值 0-6）；
+// 只有预设编号、没有名称的特征不输出
+func RenderPsycho(value interface{}, opts Options) Rendered {
+	var htmlOut, textOut strings.Builder
+	for _, item := range list(value) {
+		m, _ := item.(map[string]interface{})
+		left, right := str(m["LT"]), str(m["RT"])
+		if left == "" && right == "" {
+			continue
+		}
+		v := int(number(m["V2"]))
+		if v < 0 || v > 6 {
+			v = 3
+		}
+
+		htmlOut.WriteString(`<li class="trp3-psycho-trait" data-value="` + strconv.Itoa(v) + `">`)
+		writeIcon(&htmlOut, str(m["LI"]), opts)
+		htmlOut.WriteString(`<span class="trp3-psycho-left">` + html.EscapeString(left) + "</span>")
+		htmlOut.WriteString(`<meter min="0" max="6" value="` + strconv.Itoa(v) + `"></meter>`)
+		htmlOut.WriteString(`<span class="trp3-psycho-right">` + html.EscapeString(right) + "</span>")
+		writeIcon(&htmlOut, str(m["RI"]), opts)
+		htmlOut.WriteString("</li>")
+		textOut.WriteString(left + " " + strings.Repeat("●", v) + strings.Repeat("○", 6-v) + " " + right + "\n")
+	}
+	if htmlOut.Len() == 0 {
+		return Rendered{}
+	}
+	return Rendered{
+		HTML: `<ul class="trp3-psycho">` + htmlOut.String() + "</ul>",
+		Text: strings.TrimSpace(textOut.String()),
+	}
+}
+
+// blocks 按顺序拼接 about 的各个段落，跳过空段落
+type blocks struct {
+	html []string
+	text []string
+}
+
+func (b *blocks) add(class, label, icon string, content Rendered, opts Options) {
+	if content.Text == "" && !strings.Contains(content.HTML, "<img") {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(`<div class="` + class + `">`)
+	if label != "" || icon != "" {
+		sb.WriteString(`<div class="trp3-about-title">`)
+		writeIcon(&sb, icon, opts)
+		sb.WriteString(html.EscapeString(label))
+		sb.WriteString("</div>")
+	}
+	sb.WriteString(content.HTML)
+	sb.WriteString("</div>")
+	b.html = append(b.html, sb.String())
+
+	if label != "" {
+		b.text = append(b.text, label+"\n"+content.Text)
+	} else if content.Text != "" {
+		b.text = append(b.text, content.Text)
+	}
+}
+
+func (b *blocks) result(class string) Rendered {
+	if len(b.html) == 0 {
+		return Rendered{}
+	}
+	return Rendered{
+		HTML: `<div class="` + class + `">` + strings.Join(b.html, "") + "</div>",
+		Text: strings.Join(b.text, "\n\n"),
+	}
+}
+
+func writeIcon(sb *strings.Builder, name string, opts Options) {
+	if name == "" || opts.IconURL == nil {
+		return
+	}
+	if url := opts.IconURL(name); url != "" {
+		sb.WriteString(`<img class="trp3-icon" src="` + html.EscapeString(url) + `" alt="" width="` +
+			strconv.Itoa(defaultIconSize) + `" height="` + strconv.Itoa(defaultIconSize) + `">`)
+	}
+}
+
+func template(about map[string]interface{}) int {
+	switch te := int(number(about["TE"])); te {
+	case 2, 3:
+		return te
+	}
+	return 1
+}
+
+// list 兼容 JSON 数组和以数字为键的 Lua 表
+func list(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := make([]int, 0, len(v))
+		for key := range v {
+			if n, err := strconv.Atoi(key); err == nil {
+				keys = append(keys, n)
+			}
+		}
+		sort.Ints(keys)
+		items := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			items = append(items, v[strconv.Itoa(key)])
+		}
+		return items
+	}
+	return nil
+}
+
+func str(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func number(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
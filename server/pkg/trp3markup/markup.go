@@ -0,0 +1,316 @@
+// Package trp3markup 把 TRP3 文本标记（{h1}、{col:xxxxxx}、{icon:...}、{img:...}、{link*...*...} 以及 WoW 颜色转义）
+// 渲染为经过转义的 HTML 和纯文本。输出中的标签全部由渲染器生成，原文中的 HTML 一律按文本转义。
+package trp3markup
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Rendered 渲染结果
+type Rendered struct {
+	HTML string `json:"html"`
+	Text string `json:"text"`
+}
+
+// Options 渲染选项
+type Options struct {
+	// IconURL 把 TRP3 图标名解析为图片地址，返回空字符串表示无法解析（该图标不输出）
+	IconURL func(name string) string
+}
+
+const (
+	defaultIconSize = 15
+	maxImageSize    = 512
+)
+
+// Render 渲染一段 TRP3 标记文本
+func Render(text string, opts Options) Rendered {
+	r := &renderer{opts: opts}
+	r.run(text)
+	return Rendered{HTML: r.html.String(), Text: collapseBlankLines(r.text.String())}
+}
+
+// collapseBlankLines 标题前后补的换行与原文换行叠加时，最多保留一个空行
+func collapseBlankLines(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	out := lines[:0]
+	blank := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+type renderer struct {
+	opts  Options
+	html  strings.Builder
+	text  strings.Builder
+	stack []string // 当前打开的标签：h1/h2/h3/p/col
+	// skipNewline 块级标签结束后紧跟的一个换行不再输出 <br>
+	skipNewline bool
+}
+
+func (r *renderer) run(src string) {
+	for i := 0; i < len(src); {
+		switch src[i] {
+		case '{':
+			if end := strings.IndexByte(src[i+1:], '}'); end >= 0 {
+				if r.tag(src[i+1 : i+1+end]) {
+					i += end + 2
+					continue
+				}
+			}
+		case '|':
+			if n := r.escape(src[i:]); n > 0 {
+				i += n
+				continue
+			}
+		case '\n':
+			if r.skipNewline {
+				r.skipNewline = false
+			} else {
+				r.html.WriteString("<br>")
+			}
+			r.text.WriteByte('\n')
+			i++
+			continue
+		case '\r':
+			i++
+			continue
+		}
+
+		// 普通文本：写到下一个可能的标记为止
+		j := i + 1
+		for j < len(src) && src[j] != '{' && src[j] != '|' && src[j] != '\n' && src[j] != '\r' {
+			j++
+		}
+		r.writeText(src[i:j])
+		i = j
+	}
+	r.closeAll()
+}
+
+func (r *renderer) writeText(s string) {
+	if s == "" {
+		return
+	}
+	r.skipNewline = false
+	r.html.WriteString(html.EscapeString(s))
+	r.text.WriteString(s)
+}
+
+// tag 处理 {…} 标记，返回 false 表示不是已知标记（按原文输出）
+func (r *renderer) tag(content string) bool {
+	if strings.HasPrefix(content, "link*") || strings.HasPrefix(content, "twitter*") {
+		return r.link(content)
+	}
+
+	name, args := content, ""
+	if colon := strings.IndexByte(content, ':'); colon >= 0 {
+		name, args = content[:colon], content[colon+1:]
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "h1", "h2", "h3", "p":
+		if name == "p" && args == "" {
+			return false
+		}
+		r.open(name, alignStyle(args))
+		if name != "p" {
+			r.text.WriteByte('\n')
+		}
+		return true
+	case "/h1", "/h2", "/h3", "/p":
+		r.close(name[1:])
+		if name != "/p" {
+			r.text.WriteByte('\n')
+		}
+		return true
+	case "col":
+		color, ok := hexColor(args)
+		if !ok {
+			return false
+		}
+		r.open("col", "color:#"+color)
+		return true
+	case "/col":
+		r.close("col")
+		return true
+	case "icon":
+		parts := strings.Split(args, ":")
+		r.icon(parts[0], sizeArg(parts, 1, defaultIconSize), sizeArg(parts, 1, defaultIconSize))
+		return true
+	case "img":
+		parts := strings.Split(args, ":")
+		r.icon(parts[0], sizeArg(parts, 1, 128), sizeArg(parts, 2, 128))
+		return true
+	}
+	return false
+}
+
+// escape 处理 WoW 转义序列（|cffrrggbb、|r、|n、||、|T…|t），返回消耗的字节数
+func (r *renderer) escape(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	switch s[1] {
+	case '|':
+		r.writeText("|")
+		return 2
+	case 'n':
+		r.html.WriteString("<br>")
+		r.text.WriteByte('\n')
+		return 2
+	case 'r':
+		r.close("col")
+		return 2
+	case 'c':
+		if len(s) >= 10 {
+			if color, ok := hexColor(s[4:10]); ok {
+				r.open("col", "color:#"+color)
+				return 10
+			}
+		}
+	case 'T':
+		if end := strings.Index(s, "|t"); end > 2 {
+			parts := strings.Split(s[2:end], ":")
+			r.icon(parts[0], sizeArg(parts, 1, defaultIconSize), sizeArg(parts, 1, defaultIconSize))
+			return end + 2
+		}
+	}
+	return 0
+}
+
+func (r *renderer) open(tag, style string) {
+	element := htmlElement(tag)
+	r.html.WriteString("<" + element)
+	if style != "" {
+		r.html.WriteString(` style="` + style + `"`)
+	}
+	r.html.WriteString(">")
+	r.stack = append(r.stack, tag)
+	r.skipNewline = false
+}
+
+// close 关闭最近一个同名标签（以及其中尚未关闭的标签），没有打开的同名标签时忽略
+func (r *renderer) close(tag string) {
+	index := -1
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		if r.stack[i] == tag {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+	for i := len(r.stack) - 1; i >= index; i-- {
+		r.html.WriteString("</" + htmlElement(r.stack[i]) + ">")
+	}
+	r.stack = r.stack[:index]
+	r.skipNewline = tag != "col"
+}
+
+func (r *renderer) closeAll() {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		r.html.WriteString("</" + htmlElement(r.stack[i]) + ">")
+	}
+	r.stack = nil
+}
+
+func (r *renderer) icon(name string, width, height int) {
+	if r.opts.IconURL == nil {
+		return
+	}
+	url := r.opts.IconURL(strings.TrimSpace(name))
+	if url == "" {
+		return
+	}
+	r.html.WriteString(`<img class="trp3-icon" src="` + html.EscapeString(url) + `" alt="" width="` +
+		strconv.Itoa(width) + `" height="` + strconv.Itoa(height) + `">`)
+	r.skipNewline = false
+}
+
+// link 处理 {link*url*text} 与 {twitter*handle*text}，只允许 http/https 链接
+func (r *renderer) link(content string) bool {
+	parts := strings.SplitN(content, "*", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	target, label := strings.TrimSpace(parts[1]), parts[2]
+	if parts[0] == "twitter" {
+		target = "https://twitter.com/" + strings.TrimPrefix(target, "@")
+	}
+	if label == "" {
+		label = target
+	}
+
+	lower := strings.ToLower(target)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		r.writeText(label)
+		return true
+	}
+	r.html.WriteString(`<a href="` + html.EscapeString(target) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+	r.writeText(label)
+	r.html.WriteString("</a>")
+	return true
+}
+
+func htmlElement(tag string) string {
+	if tag == "col" {
+		return "span"
+	}
+	return tag
+}
+
+func alignStyle(arg string) string {
+	switch strings.ToLower(strings.TrimSpace(arg)) {
+	case "c":
+		return "text-align:center"
+	case "r":
+		return "text-align:right"
+	}
+	return ""
+}
+
+func hexColor(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) == 8 {
+		// 带透明度的 aarrggbb
+		s = s[2:]
+	}
+	if len(s) != 6 {
+		return "", false
+	}
+	for _, c := range s {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
+			return "", false
+		}
+	}
+	return strings.ToLower(s), true
+}
+
+func sizeArg(parts []string, index, fallback int) int {
+	if index >= len(parts) {
+		return fallback
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(parts[index]))
+	if err != nil || size <= 0 {
+		return fallback
+	}
+	if size > maxImageSize {
+		return maxImageSize
+	}
+	return size
+}
@@ -0,0 +1,93 @@
+package trp3markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func iconURL(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "/api/v1/icons/" + strings.ToLower(name)
+}
+
+func TestRenderMarkup(t *testing.T) {
+	got := Render("{h1:c}Aldric{/h1}\n{col:ff0000}Red{/col} text {icon:Ability_Warrior:20}\nline", Options{IconURL: iconURL})
+	wantHTML := `<h1 style="text-align:center">Aldric</h1><span style="color:#ff0000">Red</span> text ` +
+		`<img class="trp3-icon" src="/api/v1/icons/ability_warrior" alt="" width="20" height="20"><br>line`
+	if got.HTML != wantHTML {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", got.HTML, wantHTML)
+	}
+	if got.Text != "Aldric\n\nRed text \nline" {
+		t.Fatalf("unexpected text %q", got.Text)
+	}
+}
+
+func TestRenderEscapesHTMLAndUnsafeLinks(t *testing.T) {
+	got := Render(`<script>alert(1)</script>{link*javascript:alert(1)*click}{link*https://example.com/?a="b"*site}`, Options{})
+	if strings.Contains(got.HTML, "<script>") || strings.Contains(got.HTML, "javascript:") {
+		t.Fatalf("unsafe html: %s", got.HTML)
+	}
+	if !strings.Contains(got.HTML, `<a href="https://example.com/?a=&#34;b&#34;" rel="nofollow noopener noreferrer" target="_blank">site</a>`) {
+		t.Fatalf("expected escaped link: %s", got.HTML)
+	}
+	if got.Text != "<script>alert(1)</script>clicksite" {
+		t.Fatalf("unexpected text %q", got.Text)
+	}
+}
+
+func TestRenderBalancesTagsAndWoWEscapes(t *testing.T) {
+	got := Render("{h2}{col:00ff00}open{/h2}{/col}|cffffff00gold|r || {unknown}", Options{})
+	want := `<h2><span style="color:#00ff00">open</span></h2><span style="color:#ffff00">gold</span> | {unknown}`
+	if got.HTML != want {
+		t.Fatalf("unexpected html:\n%s\nwant:\n%s", got.HTML, want)
+	}
+}
+
+func TestRenderAboutTemplates(t *testing.T) {
+	t1 := RenderAbout(map[string]interface{}{"TE": float64(1), "T1": map[string]interface{}{"TX": "Hello"}}, Options{})
+	if t1.Text != "Hello" || !strings.HasPrefix(t1.HTML, `<div class="trp3-about trp3-about-1">`) {
+		t.Fatalf("unexpected template 1: %+v", t1)
+	}
+
+	t2 := RenderAbout(map[string]interface{}{
+		"TE": float64(2),
+		"T2": []interface{}{
+			map[string]interface{}{"TX": "One", "IC": "inv_misc_book_01"},
+			map[string]interface{}{"TX": ""},
+			map[string]interface{}{"TX": "Two"},
+		},
+	}, Options{IconURL: iconURL})
+	if t2.Text != "One\n\nTwo" || strings.Count(t2.HTML, `class="trp3-about-frame"`) != 2 || !strings.Contains(t2.HTML, "inv_misc_book_01") {
+		t.Fatalf("unexpected template 2: %+v", t2)
+	}
+
+	t3 := RenderAbout(map[string]interface{}{
+		"TE": float64(3),
+		"T3": map[string]interface{}{
+			"PH": map[string]interface{}{"TX": "Tall"},
+			"HI": map[string]interface{}{"TX": "Born"},
+		},
+	}, Options{})
+	if t3.Text != "外貌\nTall\n\n历史\nBorn" {
+		t.Fatalf("unexpected template 3 text %q", t3.Text)
+	}
+}
+
+func TestRenderMiscInfoAndPsycho(t *testing.T) {
+	misc := RenderMiscInfo([]interface{}{
+		map[string]interface{}{"NA": "Motto", "VA": "{col:ff0000}Never<yield>{/col}"},
+	}, Options{})
+	if misc.Text != "Motto: Never<yield>" || !strings.Contains(misc.HTML, "Never&lt;yield&gt;") {
+		t.Fatalf("unexpected misc: %+v", misc)
+	}
+
+	psycho := RenderPsycho(map[string]interface{}{
+		"1": map[string]interface{}{"LT": "Brave", "RT": "Coward", "V2": float64(5)},
+		"2": map[string]interface{}{"ID": float64(1), "V2": float64(2)},
+	}, Options{})
+	if psycho.Text != "Brave ●●●●●○ Coward" || strings.Count(psycho.HTML, "<li") != 1 {
+		t.Fatalf("unexpected psycho: %+v", psycho)
+	}
+}